@@ -0,0 +1,443 @@
+package artifact
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Amendment is a modification of the metadata of an Artifact.
+// Amendments are applied in bulk through Artifact.Amend.
+type Amendment interface {
+	Apply(a *Artifact) error
+}
+
+// NameAmendment sets the name of the Artifact
+type NameAmendment struct {
+	Name string
+}
+
+func (n NameAmendment) Apply(a *Artifact) error {
+	if n.Name == "" {
+		return errors.New("NameAmendment: The Artifact name cannot be empty")
+	}
+	a.HeaderTar.HeaderInfo.ArtifactProvides.ArtifactName = n.Name
+	a.HeaderTar.dirty = true
+	return nil
+}
+
+// DeviceTypeAmendment adds and removes compatible device types
+type DeviceTypeAmendment struct {
+	Add    []string
+	Remove []string
+}
+
+func (d DeviceTypeAmendment) Apply(a *Artifact) error {
+	depends := &a.HeaderTar.HeaderInfo.ArtifactDepends
+	for _, deviceType := range d.Remove {
+		depends.DeviceType = removeString(depends.DeviceType, deviceType)
+	}
+	for _, deviceType := range d.Add {
+		if !containsString(depends.DeviceType, deviceType) {
+			depends.DeviceType = append(depends.DeviceType, deviceType)
+		}
+	}
+	if len(depends.DeviceType) == 0 {
+		return errors.New("DeviceTypeAmendment: The Artifact needs at least one device type")
+	}
+	a.HeaderTar.dirty = true
+	return nil
+}
+
+// ScriptAmendment replaces the content of a state script, or adds it if the
+// Artifact does not already have it.
+type ScriptAmendment struct {
+	Name    string
+	Content []byte
+}
+
+func (s ScriptAmendment) Apply(a *Artifact) error {
+	if s.Name == "" || filepath.Base(s.Name) != s.Name {
+		return fmt.Errorf("ScriptAmendment: Invalid script name: %q", s.Name)
+	}
+	// The copy made by Amend has a script directory of its own, so the
+	// scripts of the original are left untouched
+	scripts := a.scripts()
+	if err := scripts.Next(s.Name); err != nil {
+		return errors.Wrap(err, "ScriptAmendment")
+	}
+	if _, err := scripts.Write(s.Content); err != nil {
+		scripts.closeFile()
+		return errors.Wrap(err, "ScriptAmendment")
+	}
+	if err := scripts.closeFile(); err != nil {
+		return errors.Wrap(err, "ScriptAmendment")
+	}
+	a.HeaderTar.setScript(s.Name, s.Content)
+	return nil
+}
+
+// Amend applies all the amendments to a copy of the Artifact, and recomputes
+// the manifest once they are all applied. The original Artifact is left
+// untouched.
+func (a *Artifact) Amend(amendments []Amendment) (_ *Artifact, err error) {
+	if a.HeaderTar == nil || a.HeaderTar.HeaderInfo == nil {
+		return nil, errors.New("Amend: The Artifact has not been parsed")
+	}
+	amended := a.copyMetadata()
+	defer func() {
+		if err != nil {
+			amended.closeScripts()
+		}
+	}()
+	for _, amendment := range amendments {
+		if err := amendment.Apply(amended); err != nil {
+			return nil, errors.Wrap(err, "Amend")
+		}
+	}
+	if err := amended.RecomputeManifest(); err != nil {
+		return nil, errors.Wrap(err, "Amend")
+	}
+	return amended, nil
+}
+
+// ErrDeviceNotFound is returned when renaming, or removing, a device type
+// which the Artifact is not compatible with
+var ErrDeviceNotFound = errors.New("Device type not found")
+
+// ErrDuplicateDevice is returned when adding a device type which the Artifact
+// is already compatible with
+var ErrDuplicateDevice = errors.New("Device type already compatible")
+
+// RenameDevice replaces the compatible device type oldType with newType, and
+// regenerates the header, and its manifest entry, to match. On error the
+// Artifact is left untouched.
+func (a *Artifact) RenameDevice(oldType, newType string) error {
+	if newType == "" {
+		return errors.New("RenameDevice: The device type cannot be empty")
+	}
+	return a.updateHeaderInfo("RenameDevice", func(info *HeaderInfo) error {
+		depends := &info.ArtifactDepends
+		if !containsString(depends.DeviceType, oldType) {
+			return errors.Wrap(ErrDeviceNotFound, oldType)
+		}
+		var deviceTypes []string
+		for _, deviceType := range depends.DeviceType {
+			if deviceType == oldType {
+				deviceType = newType
+			}
+			if !containsString(deviceTypes, deviceType) {
+				deviceTypes = append(deviceTypes, deviceType)
+			}
+		}
+		depends.DeviceType = deviceTypes
+		return nil
+	})
+}
+
+// AddCompatibleDevice adds deviceType to the device types the Artifact is
+// compatible with, and regenerates the header, and the manifest, to match.
+// On error the Artifact is left untouched.
+func (a *Artifact) AddCompatibleDevice(deviceType string) error {
+	if deviceType == "" {
+		return errors.New("AddCompatibleDevice: The device type cannot be empty")
+	}
+	return a.updateHeaderInfo("AddCompatibleDevice", func(info *HeaderInfo) error {
+		depends := &info.ArtifactDepends
+		if containsString(depends.DeviceType, deviceType) {
+			return errors.Wrap(ErrDuplicateDevice, deviceType)
+		}
+		depends.DeviceType = append(depends.DeviceType, deviceType)
+		return nil
+	})
+}
+
+// RemoveCompatibleDevice removes deviceType from the device types the
+// Artifact is compatible with, like AddCompatibleDevice. The last device type
+// can not be removed, as an Artifact has to be compatible with a device.
+func (a *Artifact) RemoveCompatibleDevice(deviceType string) error {
+	return a.updateHeaderInfo("RemoveCompatibleDevice", func(info *HeaderInfo) error {
+		depends := &info.ArtifactDepends
+		if !containsString(depends.DeviceType, deviceType) {
+			return errors.Wrap(ErrDeviceNotFound, deviceType)
+		}
+		if len(depends.DeviceType) == 1 {
+			return errors.Errorf("%s is the only compatible device type", deviceType)
+		}
+		var deviceTypes []string
+		for _, dt := range depends.DeviceType {
+			if dt != deviceType {
+				deviceTypes = append(deviceTypes, dt)
+			}
+		}
+		depends.DeviceType = deviceTypes
+		return nil
+	})
+}
+
+// SetArtifactName sets the name the Artifact provides, and regenerates the
+// header, and the manifest, to match. On error the Artifact is left
+// untouched.
+func (a *Artifact) SetArtifactName(name string) error {
+	if name == "" {
+		return errors.New("SetArtifactName: The Artifact name cannot be empty")
+	}
+	return a.updateHeaderInfo("SetArtifactName", func(info *HeaderInfo) error {
+		info.ArtifactProvides.ArtifactName = name
+		return nil
+	})
+}
+
+// SetArtifactGroup sets the group the Artifact provides, or removes it if
+// group is empty, like SetArtifactName. Version 2 Artifacts have no group.
+func (a *Artifact) SetArtifactGroup(group string) error {
+	if a.Version != nil && a.Version.Version == FormatVersion2 {
+		return errors.New("SetArtifactGroup: Version 2 Artifacts have no group")
+	}
+	return a.updateHeaderInfo("SetArtifactGroup", func(info *HeaderInfo) error {
+		info.ArtifactProvides.ArtifactGroup = group
+		return nil
+	})
+}
+
+// updateHeaderInfo applies update to the header-info of a copy of the
+// Artifact, and regenerates its header, and manifest. The Artifact is only
+// replaced by the copy once all of it has succeeded.
+func (a *Artifact) updateHeaderInfo(op string, update func(info *HeaderInfo) error) error {
+	if a.Manifest == nil || a.HeaderTar == nil || a.HeaderTar.HeaderInfo == nil {
+		return fmt.Errorf("%s: The Artifact has not been parsed", op)
+	}
+	updated := a.copyMetadata()
+	if err := update(updated.HeaderTar.HeaderInfo); err != nil {
+		updated.closeScripts()
+		return errors.Wrap(err, op)
+	}
+	updated.HeaderTar.dirty = true
+	if err := updated.Manifest.Regenerate(updated); err != nil {
+		updated.closeScripts()
+		return errors.Wrap(err, op)
+	}
+	// Only the header-info changed, so the Artifact keeps its scripts, and
+	// the script directory it owns
+	updated.takeScripts(a)
+	*a = *updated
+	return nil
+}
+
+// takeScripts hands the scripts of a over to the copy c, made by
+// copyMetadata, in place of its own
+func (c *Artifact) takeScripts(a *Artifact) {
+	c.closeScripts()
+	if c.HeaderTar != nil && a.HeaderTar != nil {
+		c.HeaderTar.Scripts = a.HeaderTar.Scripts
+	}
+	if c.HeaderSigned != nil && a.HeaderSigned != nil {
+		c.HeaderSigned.scripts = a.HeaderSigned.scripts
+	}
+}
+
+// closeScripts removes the scripts of a copy, which is thrown away, without
+// touching the payloads it shares with the original
+func (c *Artifact) closeScripts() {
+	if c.HeaderTar != nil {
+		c.HeaderTar.Scripts.Close()
+	}
+	if c.HeaderSigned != nil {
+		c.HeaderSigned.scripts.Close()
+	}
+}
+
+// copyMetadata returns a copy of the Artifact where all the metadata which can
+// be modified is copied, and the payloads are shared.
+func (a *Artifact) copyMetadata() *Artifact {
+	c := *a
+	if a.Manifest != nil {
+		c.Manifest = &Manifest{
+			Data: append([]ManifestData(nil), a.Manifest.Data...),
+			raw:  a.Manifest.raw,
+		}
+	}
+	if a.ManifestAugment != nil {
+		augment := *a.ManifestAugment
+		augment.rd = serialized{}
+		augment.augData = append([]ManifestData(nil), augment.augData...)
+		c.ManifestAugment = &augment
+	}
+	if a.HeaderTar != nil {
+		header := *a.HeaderTar
+		header.rd = serialized{}
+		if a.HeaderTar.HeaderInfo != nil {
+			info := *a.HeaderTar.HeaderInfo
+			info.rd = serialized{}
+			info.Payloads = append([]Payload(nil), info.Payloads...)
+			if info.ArtifactProvides.Extra != nil {
+				extra := map[string]interface{}{}
+				for k, v := range info.ArtifactProvides.Extra {
+					extra[k] = v
+				}
+				info.ArtifactProvides.Extra = extra
+			}
+			info.ArtifactDepends.ArtifactName = append([]string(nil), info.ArtifactDepends.ArtifactName...)
+			info.ArtifactDepends.DeviceType = append([]string(nil), info.ArtifactDepends.DeviceType...)
+			info.ArtifactDepends.ArtifactGroup = append([]string(nil), info.ArtifactDepends.ArtifactGroup...)
+			if info.ArtifactDepends.Extra != nil {
+				extra := map[string]interface{}{}
+				for k, v := range info.ArtifactDepends.Extra {
+					extra[k] = v
+				}
+				info.ArtifactDepends.Extra = extra
+			}
+			header.HeaderInfo = &info
+		}
+		if a.HeaderTar.Scripts != nil {
+			header.Scripts = a.HeaderTar.Scripts.clone(a.logger())
+		}
+		header.scriptUpdates = map[string][]byte{}
+		for name, content := range a.HeaderTar.scriptUpdates {
+			header.scriptUpdates[name] = content
+		}
+		header.checksumUpdates = map[int]string{}
+		for index, sum := range a.HeaderTar.checksumUpdates {
+			header.checksumUpdates[index] = sum
+		}
+		header.dependsUpdates = map[int]string{}
+		for index, sum := range a.HeaderTar.dependsUpdates {
+			header.dependsUpdates[index] = sum
+		}
+		header.Headers = make([]SubHeader, len(a.HeaderTar.Headers))
+		for i, sh := range a.HeaderTar.Headers {
+			if sh.typeInfo != nil {
+				typeInfo := *sh.typeInfo
+				sh.typeInfo = &typeInfo
+			}
+			header.Headers[i] = sh
+		}
+		c.HeaderTar = &header
+	}
+	if a.HeaderSigned != nil {
+		signed := *a.HeaderSigned
+		signed.manifest, signed.sig = c.Manifest, c.ManifestSig
+		if a.HeaderSigned.scripts != nil {
+			signed.scripts = a.HeaderSigned.scripts.clone(a.logger())
+		}
+		c.HeaderSigned = &signed
+	}
+	return &c
+}
+
+// Clone returns a deep copy of the Artifact, which can be modified, and
+// closed, independently of the Artifact. The scripts are copied to a script
+// directory of the clone's own, and the payloads are copied in memory.
+// Payloads read from an io.Reader, ie, by NewPayloadData, share the reader.
+func (a *Artifact) Clone() *Artifact {
+	c := a.copyMetadata()
+	if a.Version != nil {
+		version := *a.Version
+		version.rd = serialized{}
+		c.Version = &version
+	}
+	if a.Manifest != nil {
+		c.Manifest.reindex()
+	}
+	if a.ManifestSig != nil {
+		sig := *a.ManifestSig
+		sig.rd = serialized{}
+		c.ManifestSig = &sig
+	}
+	if a.HeaderTar != nil {
+		for i, sh := range c.HeaderTar.Headers {
+			if sh.metaData != nil {
+				c.HeaderTar.Headers[i].metaData = sh.metaData.clone()
+			}
+		}
+	}
+	if a.HeaderSigned != nil {
+		c.HeaderSigned.manifest, c.HeaderSigned.sig = c.Manifest, c.ManifestSig
+	}
+	if a.HeaderAugment != nil {
+		augment := *a.HeaderAugment
+		augment.rd = serialized{}
+		if augment.headerInfo != nil {
+			info := *augment.headerInfo
+			info.Payloads = append([]Payload(nil), info.Payloads...)
+			augment.headerInfo = &info
+		}
+		augment.subHeaders = append([]SubHeader(nil), augment.subHeaders...)
+		c.HeaderAugment = &augment
+	}
+	if a.Data != nil {
+		c.Data = &Data{payloads: make([]PayLoadData, len(a.Data.payloads))}
+		for i, payload := range a.Data.payloads {
+			pl := PayLoadData{Name: payload.Name, OutData: payload.OutData, Update: payload.Update}
+			pl.Data.Write(payload.Data.Bytes())
+			c.Data.payloads[i] = pl
+		}
+	}
+	c.sectionSizes = map[string]int64{}
+	for name, size := range a.sectionSizes {
+		c.sectionSizes[name] = size
+	}
+	c.sectionNames = append([]string(nil), a.sectionNames...)
+	c.checksums = map[string][]byte{}
+	for name, sum := range a.checksums {
+		c.checksums[name] = sum
+	}
+	return c
+}
+
+func (m *MetaData) clone() *MetaData {
+	c := &MetaData{}
+	if m.Data != nil {
+		c.Data = make(map[string]json.RawMessage, len(m.Data))
+		for k, v := range m.Data {
+			c.Data[k] = v
+		}
+	}
+	return c
+}
+
+// clone copies the scripts to a new temporary directory. Should that fail,
+// the clone shares the script directory, without owning it.
+func (s *Scripts) clone(l log.FieldLogger) *Scripts {
+	c := &Scripts{tempDir: s.tempDir, annotations: map[string]map[string]string{}}
+	for name, values := range s.annotations {
+		c.annotations[name] = map[string]string{}
+		for k, v := range values {
+			c.annotations[name][k] = v
+		}
+	}
+	err := func() error {
+		for _, path := range s.paths() {
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if err = c.Next(filepath.Base(path)); err != nil {
+				return err
+			}
+			if _, err = c.Write(content); err != nil {
+				return err
+			}
+		}
+		return c.closeFile()
+	}()
+	if err != nil {
+		l.Warnf("Failed to copy the scripts, sharing them instead: %v", err)
+		c.Close()
+		c.scriptDir, c.ownsDir, c.names = s.scriptDir, false, s.paths()
+	}
+	return c
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package artifact_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+)
+
+func scriptedArtifact(t *testing.T) []byte {
+	return testutil.MakeArtifact(t, testutil.ArtifactOptions{
+		ArtifactName: "release-1",
+		DeviceType:   "beaglebone",
+		Scripts:      map[string]string{"ArtifactInstall_Enter_00": "#!/bin/sh\necho enter\n"},
+	})
+}
+
+func TestSetArtifactName(t *testing.T) {
+	a := parse(t, scriptedArtifact(t))
+	defer a.Close()
+	dir := scriptDir(t, a)
+	if err := a.SetArtifactName("release-2"); err != nil {
+		t.Fatalf("SetArtifactName: %v", err)
+	}
+	b := serialize(t, a)
+
+	if name := parseInfo(t, b).Name; name != "release-2" {
+		t.Errorf("Name = %q, want release-2", name)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	assertRemoved(t, dir)
+}
+
+func TestRenameDevice(t *testing.T) {
+	a := parse(t, scriptedArtifact(t))
+	defer a.Close()
+	dir := scriptDir(t, a)
+	if err := a.RenameDevice("beaglebone", "raspberrypi4"); err != nil {
+		t.Fatalf("RenameDevice: %v", err)
+	}
+	b := serialize(t, a)
+
+	devices := parseInfo(t, b).CompatibleDevices
+	if !reflect.DeepEqual(devices, []string{"raspberrypi4"}) {
+		t.Errorf("CompatibleDevices = %v, want [raspberrypi4]", devices)
+	}
+	a.Close()
+	assertRemoved(t, dir)
+}
+
+func TestSetArtifactGroupKeepsScripts(t *testing.T) {
+	a := parse(t, scriptedArtifact(t))
+	defer a.Close()
+	if err := a.SetArtifactGroup("stable"); err != nil {
+		t.Fatalf("SetArtifactGroup: %v", err)
+	}
+	b := serialize(t, a)
+
+	scripts := parseInfo(t, b).Scripts
+	if !reflect.DeepEqual(scripts, []string{"ArtifactInstall_Enter_00"}) {
+		t.Errorf("Scripts = %v, want [ArtifactInstall_Enter_00]", scripts)
+	}
+}
+
+func TestAddRemoveCompatibleDevice(t *testing.T) {
+	a := parse(t, scriptedArtifact(t))
+	defer a.Close()
+	dir := scriptDir(t, a)
+	if err := a.AddCompatibleDevice("raspberrypi4"); err != nil {
+		t.Fatalf("AddCompatibleDevice: %v", err)
+	}
+	if err := a.RemoveCompatibleDevice("beaglebone"); err != nil {
+		t.Fatalf("RemoveCompatibleDevice: %v", err)
+	}
+	if err := a.RemoveCompatibleDevice("raspberrypi4"); err == nil {
+		t.Error("Removing the last device type succeeded")
+	}
+	b := serialize(t, a)
+
+	devices := parseInfo(t, b).CompatibleDevices
+	if !reflect.DeepEqual(devices, []string{"raspberrypi4"}) {
+		t.Errorf("CompatibleDevices = %v, want [raspberrypi4]", devices)
+	}
+	a.Close()
+	assertRemoved(t, dir)
+}
+
+// readScripts returns the content of the scripts of a, by name
+func readScripts(t *testing.T, a *artifact.Artifact) map[string]string {
+	t.Helper()
+	scripts := map[string]string{}
+	for _, path := range a.HeaderTar.Scripts.Names() {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read the script: %v", err)
+		}
+		scripts[filepath.Base(path)] = string(content)
+	}
+	return scripts
+}
+
+func TestAmendScripts(t *testing.T) {
+	a := parse(t, scriptedArtifact(t))
+	defer a.Close()
+	amended, err := a.Amend([]artifact.Amendment{
+		artifact.ScriptAmendment{Name: "ArtifactInstall_Enter_00", Content: []byte("#!/bin/sh\necho replaced\n")},
+		artifact.ScriptAmendment{Name: "ArtifactCommit_Leave_00", Content: []byte("#!/bin/sh\necho added\n")},
+	})
+	if err != nil {
+		t.Fatalf("Amend: %v", err)
+	}
+	defer amended.Close()
+	want := map[string]string{
+		"ArtifactInstall_Enter_00": "#!/bin/sh\necho replaced\n",
+		"ArtifactCommit_Leave_00":  "#!/bin/sh\necho added\n",
+	}
+	if scripts := readScripts(t, amended); !reflect.DeepEqual(scripts, want) {
+		t.Errorf("Amended scripts = %v, want %v", scripts, want)
+	}
+	original := map[string]string{"ArtifactInstall_Enter_00": "#!/bin/sh\necho enter\n"}
+	if scripts := readScripts(t, a); !reflect.DeepEqual(scripts, original) {
+		t.Errorf("Original scripts = %v, want %v", scripts, original)
+	}
+	reparsed := parse(t, serialize(t, amended))
+	defer reparsed.Close()
+	if scripts := readScripts(t, reparsed); !reflect.DeepEqual(scripts, want) {
+		t.Errorf("Re-parsed scripts = %v, want %v", scripts, want)
+	}
+
+	// The copy owns its script directory
+	dir := scriptDir(t, amended)
+	if dir == scriptDir(t, a) {
+		t.Fatal("The copy shares the script directory of the original")
+	}
+	a.Close()
+	if scripts := readScripts(t, amended); !reflect.DeepEqual(scripts, want) {
+		t.Errorf("Scripts after closing the original = %v, want %v", scripts, want)
+	}
+	amended.Close()
+	assertRemoved(t, dir)
+}
+
+// manifestProbe is an Amendment recording the checksum of the header in the
+// manifest, as it is when the probe is applied
+type manifestProbe struct {
+	sums *[]string
+}
+
+func (m manifestProbe) Apply(a *artifact.Artifact) error {
+	sum, _ := a.Manifest.Lookup("header.tar.gz")
+	*m.sums = append(*m.sums, sum)
+	return nil
+}
+
+func TestAmendRecomputesOnce(t *testing.T) {
+	a := parse(t, scriptedArtifact(t))
+	defer a.Close()
+	original, _ := a.Manifest.Lookup("header.tar.gz")
+	var sums []string
+	amended, err := a.Amend([]artifact.Amendment{
+		artifact.NameAmendment{Name: "release-2"},
+		manifestProbe{&sums},
+		artifact.DeviceTypeAmendment{Add: []string{"raspberrypi4"}, Remove: []string{"beaglebone"}},
+		manifestProbe{&sums},
+		artifact.ScriptAmendment{Name: "ArtifactInstall_Enter_00", Content: []byte("#!/bin/sh\necho replaced\n")},
+		manifestProbe{&sums},
+	})
+	if err != nil {
+		t.Fatalf("Amend: %v", err)
+	}
+	defer amended.Close()
+	// The manifest is only recomputed after the last amendment
+	if want := []string{original, original, original}; !reflect.DeepEqual(sums, want) {
+		t.Errorf("The manifest changed between the amendments: %v", sums)
+	}
+	if sum, _ := amended.Manifest.Lookup("header.tar.gz"); sum == original {
+		t.Error("The manifest was not recomputed")
+	}
+
+	reparsed := parse(t, serialize(t, amended))
+	defer reparsed.Close()
+	info := reparsed.Info()
+	if info.Name != "release-2" || !reflect.DeepEqual(info.CompatibleDevices, []string{"raspberrypi4"}) {
+		t.Errorf("Parsed the amended %s, for %v", info.Name, info.CompatibleDevices)
+	}
+	if scripts := readScripts(t, reparsed); scripts["ArtifactInstall_Enter_00"] != "#!/bin/sh\necho replaced\n" {
+		t.Errorf("Parsed the amended scripts %v", scripts)
+	}
+}
@@ -0,0 +1,57 @@
+package artifact
+
+import (
+	"encoding/json"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// scriptAnnotationFile holds the annotations of the scripts, in the scripts
+// directory of the header. It maps the script names to their annotations.
+const scriptAnnotationFile = "annotation-info"
+
+// AnnotateScripts sets the annotations, like environment, timeout_seconds or
+// retry_count, on all the scripts of the Artifact. Existing annotations with
+// other keys are kept. The scripts themselves are not changed.
+func (a *Artifact) AnnotateScripts(annotations map[string]string) error {
+	if a.HeaderTar == nil || a.HeaderTar.Scripts == nil {
+		return errors.New("AnnotateScripts: The Artifact has not been parsed")
+	}
+	scripts := a.HeaderTar.Scripts
+	names := scripts.List()
+	if len(names) == 0 {
+		return errors.New("AnnotateScripts: The Artifact has no scripts")
+	}
+	updated := map[string]map[string]string{}
+	for script, values := range scripts.annotations {
+		updated[script] = map[string]string{}
+		for k, v := range values {
+			updated[script][k] = v
+		}
+	}
+	for _, name := range names {
+		if updated[name] == nil {
+			updated[name] = map[string]string{}
+		}
+		for k, v := range annotations {
+			updated[name][k] = v
+		}
+	}
+	content, err := json.Marshal(updated)
+	if err != nil {
+		return errors.Wrap(err, "AnnotateScripts: Failed to marshal the annotations")
+	}
+	scripts.annotations = updated
+	a.HeaderTar.setScript(scriptAnnotationFile, content)
+	return nil
+}
+
+// ScriptAnnotation returns the annotation key of the script name
+func (a *Artifact) ScriptAnnotation(name, key string) (string, bool) {
+	if a.HeaderTar == nil || a.HeaderTar.Scripts == nil {
+		return "", false
+	}
+	value, ok := a.HeaderTar.Scripts.annotations[filepath.Base(name)][key]
+	return value, ok
+}
@@ -0,0 +1,48 @@
+package artifact_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+)
+
+func TestAnnotateScripts(t *testing.T) {
+	scripts := map[string]string{
+		"ArtifactInstall_Enter_00": "#!/bin/sh\necho enter\n",
+		"ArtifactInstall_Leave_00": "#!/bin/sh\necho leave\n",
+	}
+	a := parse(t, testutil.MakeArtifact(t, testutil.ArtifactOptions{Scripts: scripts}))
+	defer a.Close()
+	if err := a.AnnotateScripts(map[string]string{"environment": "production", "timeout_seconds": "30"}); err != nil {
+		t.Fatalf("AnnotateScripts: %v", err)
+	}
+	if err := a.AnnotateScripts(map[string]string{"timeout_seconds": "60", "retry_count": "3"}); err != nil {
+		t.Fatalf("AnnotateScripts: %v", err)
+	}
+	if err := a.RecomputeManifest(); err != nil {
+		t.Fatalf("RecomputeManifest: %v", err)
+	}
+
+	parsed := parse(t, serialize(t, a))
+	defer parsed.Close()
+	for name := range scripts {
+		for key, want := range map[string]string{"environment": "production", "timeout_seconds": "60", "retry_count": "3"} {
+			if value, ok := parsed.ScriptAnnotation(name, key); !ok || value != want {
+				t.Errorf("ScriptAnnotation(%s, %s) = %q, %v, want %q", name, key, value, ok, want)
+			}
+		}
+	}
+	if value, ok := parsed.ScriptAnnotation("ArtifactInstall_Enter_00", "owner"); ok {
+		t.Errorf("ScriptAnnotation of an unset key returned %q", value)
+	}
+	if got := readScripts(t, parsed); !reflect.DeepEqual(got, scripts) {
+		t.Errorf("The annotated scripts are %v, want %v", got, scripts)
+	}
+
+	unscripted := parse(t, testutil.MakeArtifact(t, testutil.ArtifactOptions{}))
+	defer unscripted.Close()
+	if err := unscripted.AnnotateScripts(map[string]string{"retry_count": "3"}); err == nil {
+		t.Error("AnnotateScripts of an Artifact without scripts succeeded")
+	}
+}
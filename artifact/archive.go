@@ -0,0 +1,118 @@
+package artifact
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/ulikunitz/xz"
+)
+
+// ArchiveFormat is the container format an Artifact is exported in
+type ArchiveFormat int
+
+const (
+	// ArchiveFormatMender is the native mender-artifact tar
+	ArchiveFormatMender ArchiveFormat = iota
+	// ArchiveFormatZip holds every section of the Artifact as a zip entry
+	ArchiveFormatZip
+	// ArchiveFormatTarXZ is the native mender-artifact tar, xz compressed
+	ArchiveFormatTarXZ
+)
+
+func (f ArchiveFormat) String() string {
+	switch f {
+	case ArchiveFormatMender:
+		return "mender"
+	case ArchiveFormatZip:
+		return "zip"
+	case ArchiveFormatTarXZ:
+		return "tar.xz"
+	default:
+		return fmt.Sprintf("ArchiveFormat(%d)", int(f))
+	}
+}
+
+// ToArchive exports the Artifact to w in the given format
+func (a *Artifact) ToArchive(w io.Writer, format ArchiveFormat) error {
+	switch format {
+	case ArchiveFormatMender:
+		return errors.Wrap(a.writeTar(w), "ToArchive")
+	case ArchiveFormatZip:
+		return errors.Wrap(a.writeZip(w), "ToArchive")
+	case ArchiveFormatTarXZ:
+		xw, err := xz.NewWriter(w)
+		if err != nil {
+			return errors.Wrap(err, "ToArchive")
+		}
+		if err = a.writeTar(xw); err != nil {
+			return errors.Wrap(err, "ToArchive")
+		}
+		return errors.Wrap(xw.Close(), "ToArchive")
+	default:
+		return fmt.Errorf("ToArchive: Unsupported format: %s", format)
+	}
+}
+
+func (a *Artifact) writeZip(w io.Writer) error {
+	sections, err := a.sections()
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(w)
+	for _, section := range sections {
+		f, err := zw.Create(section.Name)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to create the zip entry %s", section.Name)
+		}
+		if _, err = io.Copy(f, section.Data); err != nil {
+			return errors.Wrapf(err, "Failed to write the zip entry %s", section.Name)
+		}
+	}
+	return zw.Close()
+}
+
+// WriteParts writes the Artifact split into parts of partSize bytes, for
+// multi-part uploads. The last part may be shorter. w is called for the
+// writer of each part, in order.
+func (a *Artifact) WriteParts(partSize int64, w func(partIndex int) io.Writer) error {
+	if partSize <= 0 {
+		return fmt.Errorf("WriteParts: Invalid part size: %d", partSize)
+	}
+	return errors.Wrap(a.writeTar(&partWriter{size: partSize, next: w}), "WriteParts")
+}
+
+// partWriter splits the stream written to it into parts of size bytes
+type partWriter struct {
+	size    int64
+	next    func(partIndex int) io.Writer
+	index   int
+	w       io.Writer
+	written int64
+}
+
+func (p *partWriter) Write(b []byte) (int, error) {
+	n := 0
+	for len(b) > 0 {
+		if p.w == nil || p.written == p.size {
+			if p.w = p.next(p.index); p.w == nil {
+				return n, fmt.Errorf("No writer for part %d", p.index)
+			}
+			p.index++
+			p.written = 0
+		}
+		chunk := b
+		if rem := p.size - p.written; int64(len(chunk)) > rem {
+			chunk = chunk[:rem]
+		}
+		m, err := p.w.Write(chunk)
+		n += m
+		p.written += int64(m)
+		b = b[m:]
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
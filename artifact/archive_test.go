@@ -0,0 +1,121 @@
+package artifact_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+	"github.com/ulikunitz/xz"
+)
+
+func TestToArchive(t *testing.T) {
+	b := testutil.MakeArtifact(t, testutil.ArtifactOptions{
+		Payloads: []testutil.Payload{
+			{Filename: "rootfs.ext4", Content: []byte("rootfs")},
+			{Filename: "bootloader.img", Content: []byte("bootloader")},
+		},
+		Signed: true,
+	})
+	a := parse(t, b)
+	defer a.Close()
+
+	var buf bytes.Buffer
+	if err := a.ToArchive(&buf, artifact.ArchiveFormatZip); err != nil {
+		t.Fatalf("ToArchive: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Failed to open the zip archive: %v", err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("Failed to open the zip entry %s: %v", f.Name, err)
+		}
+		content, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("Failed to read the zip entry %s: %v", f.Name, err)
+		}
+		if !bytes.Equal(content, readEntry(t, b, f.Name)) {
+			t.Errorf("The zip entry %s differs from the Artifact section", f.Name)
+		}
+	}
+	if want := entryNames(t, b); !reflect.DeepEqual(names, want) {
+		t.Errorf("The zip archive holds %v, want %v", names, want)
+	}
+
+	buf.Reset()
+	if err := a.ToArchive(&buf, artifact.ArchiveFormatMender); err != nil {
+		t.Fatalf("ToArchive: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), b) {
+		t.Error("The native archive differs from the Artifact")
+	}
+
+	buf.Reset()
+	if err := a.ToArchive(&buf, artifact.ArchiveFormatTarXZ); err != nil {
+		t.Fatalf("ToArchive: %v", err)
+	}
+	xr, err := xz.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("Failed to open the xz archive: %v", err)
+	}
+	if content, err := ioutil.ReadAll(xr); err != nil || !bytes.Equal(content, b) {
+		t.Errorf("The tar.xz archive does not decompress to the Artifact: %v", err)
+	}
+
+	if err := a.ToArchive(ioutil.Discard, artifact.ArchiveFormat(7)); err == nil {
+		t.Error("ToArchive in an unknown format succeeded")
+	}
+}
+
+func TestWriteParts(t *testing.T) {
+	const partSize = 256 << 10
+	// Random content does not compress, so that the Artifact is ~1 MiB
+	content := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(content)
+	b := testutil.MakeArtifact(t, testutil.ArtifactOptions{PayloadContent: content})
+	a := parse(t, b)
+	defer a.Close()
+
+	var parts []*bytes.Buffer
+	err := a.WriteParts(partSize, func(partIndex int) io.Writer {
+		if partIndex != len(parts) {
+			t.Errorf("Requested part %d after %d parts", partIndex, len(parts))
+		}
+		parts = append(parts, &bytes.Buffer{})
+		return parts[len(parts)-1]
+	})
+	if err != nil {
+		t.Fatalf("WriteParts: %v", err)
+	}
+	if want := (len(b) + partSize - 1) / partSize; len(parts) != want {
+		t.Fatalf("Wrote %d parts of the %d byte Artifact, want %d", len(parts), len(b), want)
+	}
+	var joined []byte
+	for i, part := range parts {
+		if i < len(parts)-1 && part.Len() != partSize {
+			t.Errorf("Part %d is %d bytes, want %d", i, part.Len(), partSize)
+		}
+		joined = append(joined, part.Bytes()...)
+	}
+	if !bytes.Equal(joined, b) {
+		t.Error("The joined parts differ from the Artifact")
+	}
+	if info := parseInfo(t, joined); !reflect.DeepEqual(info, a.Info()) {
+		t.Errorf("Parsed %+v from the parts, want %+v", info, a.Info())
+	}
+
+	if err := a.WriteParts(0, nil); err == nil {
+		t.Error("WriteParts with a zero part size succeeded")
+	}
+}
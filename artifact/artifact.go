@@ -10,7 +10,6 @@ package artifact
 
 import (
 	"archive/tar"
-	"bufio"
 	"bytes"
 	"compress/gzip"
 	"encoding/json"
@@ -18,7 +17,11 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"crypto/sha256"
 	"github.com/pkg/errors"
@@ -51,27 +54,44 @@ func init() {
 type Version struct {
 	Format  string `json:"format"`
 	Version int    `json:"version"`
-	shaSum  []byte
+
+	raw []byte // The version as read from the Artifact
+	rd  serialized
+}
+
+// bytes returns the version as it is written to the Artifact
+func (v *Version) bytes() ([]byte, error) {
+	if v.raw != nil {
+		return v.raw, nil
+	}
+	return json.Marshal(v)
 }
 
 func (v Version) String() string {
+	var sum []byte
+	if v.raw != nil {
+		s := sha256.Sum256(v.raw)
+		sum = s[:]
+	}
 	return fmt.Sprintf("Format:\n\t%s\n"+
 		"Version:\n\t%d\nsha:%x\n",
 		v.Format,
 		v.Version,
-		v.shaSum)
+		sum)
 }
 
 func (v *Version) Parse(r io.Reader) error {
 	if v == nil {
 		v = &Version{}
 	}
-	sha := sha256.New()
-	mw := io.MultiWriter(v, sha)
-	if _, err := io.Copy(mw, r); err != nil {
+	// The version is read whole, as Write expects the complete json
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return errors.Wrap(err, "Parser: Write: Failed to read version")
+	}
+	if _, err = v.Write(b); err != nil {
 		return errors.Wrap(err, "Parser: Write: Failed to read version")
 	}
-	v.shaSum = sha.Sum(nil)
 	return nil
 }
 
@@ -84,12 +104,22 @@ func (v *Version) Write(b []byte) (n int, err error) {
 	return len(b), nil
 }
 
-// Read Creates an artifact Version
+// Read reads the version, as it is written to the Artifact
 func (v *Version) Read(b []byte) (n int, err error) {
-	if b, err = json.Marshal(v); err != nil {
-		return 0, errors.Wrap(err, "Version: Read: Failed to marshal json")
+	return v.rd.read(b, func() ([]byte, error) {
+		data, err := v.bytes()
+		return data, errors.Wrap(err, "Version: Read: Failed to marshal json")
+	})
+}
+
+// WriteTo writes the whole version, as it is written to the Artifact, to w
+func (v *Version) WriteTo(w io.Writer) (int64, error) {
+	data, err := v.bytes()
+	if err != nil {
+		return 0, errors.Wrap(err, "Version: WriteTo: Failed to marshal json")
 	}
-	return len(b), nil
+	n, err := w.Write(data)
+	return int64(n), errors.Wrap(err, "Version: WriteTo")
 }
 
 // The signature for the manifest
@@ -103,6 +133,18 @@ type ManifestData struct {
 
 type Manifest struct {
 	Data []ManifestData
+
+	index map[string]int // The position of the entries in Data, by name
+	raw   []byte         // The manifest as read from the Artifact
+	rd    serialized
+}
+
+// bytes returns the manifest as it is written to the Artifact
+func (m *Manifest) bytes() []byte {
+	if m.raw != nil {
+		return m.raw
+	}
+	return manifestBytes(m.Data)
 }
 
 func (m Manifest) String() string {
@@ -118,36 +160,48 @@ func (m *Manifest) Parse(r io.Reader) error {
 	if m == nil {
 		m = &Manifest{} /* Allow parsing into an empty value */
 	}
-	scanner := bufio.NewScanner(r)
-	var line string
-	for scanner.Scan() {
-		line = scanner.Text()
-		tmp := strings.Split(line, " ")
-		m.Data = append(m.Data,
-			ManifestData{
-				Signature: tmp[0],
-				Name:      tmp[2]})
-	}
-	return nil
+	data, err := parseManifestLines("manifest", r)
+	m.Data = append(m.Data, data...)
+	m.reindex()
+	return err
 }
 
+// Read reads the manifest, as it is written to the Artifact
 func (m *Manifest) Read(b []byte) (n int, err error) {
-	br := bytes.NewBuffer(nil)
-	for _, manifestData := range m.Data {
-		line := manifestData.Signature + " " + manifestData.Name + "\n"
-		_, err = br.Write([]byte(line))
+	return m.rd.read(b, func() ([]byte, error) {
+		return m.bytes(), nil
+	})
+}
+
+// WriteTo writes the whole manifest, as it is written to the Artifact, to w.
+// A parsed manifest is written as it was read, and a modified one line by
+// line.
+func (m *Manifest) WriteTo(w io.Writer) (int64, error) {
+	if m.raw != nil {
+		n, err := w.Write(m.raw)
+		return int64(n), errors.Wrap(err, "Manifest: WriteTo")
+	}
+	var written int64
+	for _, entry := range m.Data {
+		n, err := fmt.Fprintf(w, "%s  %s\n", entry.Signature, entry.Name)
+		written += int64(n)
 		if err != nil {
-			return 0, errors.Wrap(err, "Manifest: Read: Failed to write line")
+			return written, errors.Wrap(err, "Manifest: WriteTo")
 		}
 	}
-	b = br.Bytes()
-	return len(b), nil
+	return written, nil
 }
 
 // Format: base64 encoded ecdsa or rsa signature
 type ManifestSig struct {
 	// More data
 	sig []byte
+	rd  serialized
+
+	manifest []byte // The manifest the signature is of
+
+	// verified is set, atomically, once Verify has verified the signature
+	verified int32
 }
 
 func (m *ManifestSig) String() string {
@@ -160,13 +214,15 @@ func (m *ManifestSig) Parse(r io.Reader) error {
 	}
 	sig, err := ioutil.ReadAll(r)
 	m.sig = sig
+	atomic.StoreInt32(&m.verified, 0)
 	return err
 
 }
 
 func (m *ManifestSig) Read(b []byte) (n int, err error) {
-	b = m.sig
-	return len(b), nil
+	return m.rd.read(b, func() ([]byte, error) {
+		return m.sig, nil
+	})
 }
 
 // c57c4694532f96383b619  header-augment.tar.gz
@@ -174,6 +230,24 @@ func (m *ManifestSig) Read(b []byte) (n int, err error) {
 type ManifestAugment struct {
 	// Some Data 4 deltaz
 	augData []ManifestData
+
+	raw []byte // The manifest-augment as read from the Artifact
+	rd  serialized
+}
+
+func (m *ManifestAugment) String() string {
+	if m == nil {
+		return ""
+	}
+	return Manifest{Data: m.augData}.String()
+}
+
+// bytes returns the manifest-augment as it is written to the Artifact
+func (m *ManifestAugment) bytes() []byte {
+	if m.raw != nil {
+		return m.raw
+	}
+	return manifestBytes(m.augData)
 }
 
 func (m *ManifestAugment) Parse(r io.Reader) error {
@@ -181,31 +255,15 @@ func (m *ManifestAugment) Parse(r io.Reader) error {
 		m = &ManifestAugment{}
 	}
 	log.Debug("Parsing manifest-augment")
-	scanner := bufio.NewScanner(r)
-	var line string
-	for scanner.Scan() {
-		line = scanner.Text()
-		tmp := strings.Split(line, " ")
-		m.augData = append(m.augData,
-			ManifestData{
-				Signature: tmp[0],
-				Name:      tmp[1]})
-	}
-	return nil
+	data, err := parseManifestLines("manifest-augment", r)
+	m.augData = append(m.augData, data...)
+	return err
 }
 
 func (m *ManifestAugment) Read(b []byte) (n int, err error) {
-	br := bytes.NewBuffer(nil)
-	for _, maugData := range m.augData {
-		line := maugData.Signature + " " + maugData.Name + "\n"
-		_, err = br.Write([]byte(line))
-		if err != nil {
-			return 0, errors.Wrap(err,
-				"ManifestAugment: Read: Failed to write to byte buffer")
-		}
-	}
-	b = br.Bytes()
-	return len(b), nil
+	return m.rd.read(b, func() ([]byte, error) {
+		return m.bytes(), nil
+	})
 }
 
 type HeaderTar struct {
@@ -213,6 +271,26 @@ type HeaderTar struct {
 	Scripts    *Scripts
 	Headers    []SubHeader
 	ShaSum     []byte
+
+	compression CompressionAlgo
+	gzipLevel   *int   // The gzip level the header is written with, if set
+	raw         []byte // The header.tar.gz as read from the Artifact
+
+	// dirty is set when the header has been modified, and raw has to be
+	// regenerated
+	dirty bool
+	// restructured is set when sub-headers have been added, or removed,
+	// and the header has to be built anew, instead of from raw
+	restructured    bool
+	scriptUpdates   map[string][]byte
+	checksumUpdates map[int]string // rootfs_image_checksum, by sub-header
+	dependsUpdates  map[int]string
+
+	formatVersion int // The Artifact version, if not 3
+	rd            serialized
+
+	// strict fails the parsing on unknown entries, instead of skipping them
+	strict bool
 }
 
 func (h HeaderTar) String() string {
@@ -258,10 +336,13 @@ func (h *HeaderTar) Parse(r io.Reader) error {
 	log.Debug("Parsing header.tar")
 	sha := sha256.New()
 	teeReader := io.TeeReader(r, sha)
-	zr, err := gzip.NewReader(teeReader)
+	zr, err := h.compression.newReader(teeReader)
 	if err != nil {
 		return err
 	}
+	// The zstd decoder reads ahead in a goroutine, which has to be stopped
+	// before the Artifact tar moves on to the next section
+	defer zr.Close()
 	tarElement := tar.NewReader(zr)
 	hdr, err := tarElement.Next()
 	if err != nil {
@@ -271,36 +352,44 @@ func (h *HeaderTar) Parse(r io.Reader) error {
 		return fmt.Errorf("Unexpected header: %s", hdr.Name)
 	}
 	// Read the header info
+	h.HeaderInfo = &HeaderInfo{}
 	if err = h.HeaderInfo.Parse(tarElement); err != nil {
 		return fmt.Errorf("Failed to parse 'header-info'. Error: %v", err)
 	}
-	hdr, err = tarElement.Next()
-	if err != nil {
-		return err
-	}
 	// Read all the scripts
-	if strings.HasPrefix(hdr.Name, "strings") {
-		for {
-			err = h.Scripts.Parse(tarElement)
-			fmt.Println("Parsing scripts...")
-			switch err {
-			case io.EOF:
-				break // Move on to parse headers
-			default:
-				return fmt.Errorf("Failed to parse 'scripts'. Error: %v", err)
-			}
-		}
-		log.Trace("Parsed scripts")
+	if h.Scripts == nil {
+		h.Scripts = &Scripts{}
+	}
+	if hdr, err = h.Scripts.parseArchive(tarElement); err != nil {
+		return fmt.Errorf("Failed to parse 'scripts'. Error: %v", err)
 	}
+	if hdr == nil {
+		return errors.New("HeaderTar: No headers")
+	}
+	log.Trace("Parsed scripts")
 	// Read all the headers
 	log.Trace("Reading all the subheaders")
 	for {
 		// hdr.Name is already set, as we broke out of the script parsing loop
 		if filepath.Base(hdr.Name) != "type-info" {
-			return fmt.Errorf("Expected `type-info`. Got %s", hdr.Name) // TODO - this should probs be a parseError type
+			if h.strict {
+				return fmt.Errorf("%w: Expected `type-info`, got %s", ErrUnexpectedEntry, hdr.Name)
+			}
+			// Tolerate the entries of future versions of the format
+			log.Warnf("HeaderTar: Skipping the unknown entry %s", hdr.Name)
+			if hdr, err = tarElement.Next(); err == io.EOF {
+				break
+			} else if err != nil {
+				return errors.Wrap(err, "HeaderTar: failed to get next header")
+			}
+			continue
 		}
 		log.Trace("Reading type-info")
-		sh := SubHeader{}
+		sh := SubHeader{
+			name:     filepath.Base(filepath.Dir(hdr.Name)),
+			typeInfo: &TypeInfo{},
+			metaData: &MetaData{},
+		}
 		if err = sh.typeInfo.Parse(tarElement); err != nil {
 			return errors.Wrap(err, "HeaderTar")
 		}
@@ -309,10 +398,10 @@ func (h *HeaderTar) Parse(r io.Reader) error {
 		log.Trace(hdr, err)
 		// Finished reading `header.tar.gz`
 		if err == io.EOF {
-			log.Trace("subHeader read (EOF): %s\n", sh.String())
+			log.Tracef("subHeader read (EOF): %s\n", sh.String())
 			log.Trace(sh.typeInfo)
 			h.Headers = append(h.Headers, sh)
-			return nil
+			break
 		}
 		if err != nil {
 			return errors.Wrap(err, "HeaderTar: failed to next hdr")
@@ -330,23 +419,43 @@ func (h *HeaderTar) Parse(r io.Reader) error {
 			log.Trace()
 			if err == io.EOF {
 				log.Trace("EOF after parsing meta-data in header, breaking out")
+				h.Headers = append(h.Headers, sh)
 				break
 			} else if err != nil {
 				return errors.Wrap(err, "HeaderTar: failed to get next header")
 			}
 		}
-		log.Trace("subHeader read: %s\n", sh.String())
+		log.Tracef("subHeader read: %s\n", sh.String())
 		h.Headers = append(h.Headers, sh)
 	}
 
+	// Read the rest of the compressed header, past the end of the tar, so
+	// that the checksum covers all of it
+	if _, err = io.Copy(ioutil.Discard, teeReader); err != nil {
+		return errors.Wrap(err, "HeaderTar: failed to read the header")
+	}
 	// Extract the checksum from buf
 	h.ShaSum = sha.Sum(nil)
-	log.Trace("Header.tar.gz - shasum: %x\n", h.ShaSum)
+	log.Tracef("Header.tar.gz - shasum: %x\n", h.ShaSum)
 	return nil
 }
 
+// Read reads the compressed header tar. A modified header has to be rebuilt,
+// through RecomputeManifest, before it can be read. A header which was not
+// parsed, but populated by hand, is created from the HeaderInfo, the Scripts
+// and the sub-headers, and its checksum stored in ShaSum.
 func (h *HeaderTar) Read(b []byte) (n int, err error) {
-	return 0, errors.New("Unimplemented")
+	return h.rd.read(b, func() ([]byte, error) {
+		if h.dirty {
+			return nil, errors.New("HeaderTar: Read: The header has been modified, and has to be rebuilt")
+		}
+		if h.raw == nil {
+			if err := h.build(); err != nil {
+				return nil, errors.Wrap(err, "HeaderTar: Read")
+			}
+		}
+		return h.raw, nil
+	})
 }
 
 type Payload struct {
@@ -360,6 +469,36 @@ func (p Payload) String() string {
 type ArtifactProvides struct {
 	ArtifactName  string `json:"artifact_name"`
 	ArtifactGroup string `json:"artifact_group"`
+
+	// Extra holds any additional provides, ie, created_at
+	Extra map[string]interface{} `json:"-"`
+}
+
+func (a ArtifactProvides) MarshalJSON() ([]byte, error) {
+	m := map[string]interface{}{}
+	for k, v := range a.Extra {
+		m[k] = v
+	}
+	m["artifact_name"] = a.ArtifactName
+	m["artifact_group"] = a.ArtifactGroup
+	return json.Marshal(m)
+}
+
+func (a *ArtifactProvides) UnmarshalJSON(b []byte) error {
+	type provides ArtifactProvides
+	var p provides
+	if err := json.Unmarshal(b, &p); err != nil {
+		return err
+	}
+	var extra map[string]interface{}
+	if err := json.Unmarshal(b, &extra); err != nil {
+		return err
+	}
+	delete(extra, "artifact_name")
+	delete(extra, "artifact_group")
+	p.Extra = extra
+	*a = ArtifactProvides(p)
+	return nil
 }
 
 func (a ArtifactProvides) String() string {
@@ -367,8 +506,43 @@ func (a ArtifactProvides) String() string {
 }
 
 type ArtifactDepends struct {
-	ArtifactName []string `json:"artifact_name"`
-	DeviceType   []string `json:"device_type"`
+	ArtifactName  []string `json:"artifact_name"`
+	DeviceType    []string `json:"device_type"`
+	ArtifactGroup []string `json:"artifact_group,omitempty"`
+
+	// Extra holds any additional depends
+	Extra map[string]interface{} `json:"-"`
+}
+
+func (a ArtifactDepends) MarshalJSON() ([]byte, error) {
+	m := map[string]interface{}{}
+	for k, v := range a.Extra {
+		m[k] = v
+	}
+	m["artifact_name"] = a.ArtifactName
+	m["device_type"] = a.DeviceType
+	if len(a.ArtifactGroup) > 0 {
+		m["artifact_group"] = a.ArtifactGroup
+	}
+	return json.Marshal(m)
+}
+
+func (a *ArtifactDepends) UnmarshalJSON(b []byte) error {
+	type depends ArtifactDepends
+	var d depends
+	if err := json.Unmarshal(b, &d); err != nil {
+		return err
+	}
+	var extra map[string]interface{}
+	if err := json.Unmarshal(b, &extra); err != nil {
+		return err
+	}
+	delete(extra, "artifact_name")
+	delete(extra, "device_type")
+	delete(extra, "artifact_group")
+	d.Extra = extra
+	*a = ArtifactDepends(d)
+	return nil
 }
 
 func (a ArtifactDepends) String() string {
@@ -380,14 +554,19 @@ type HeaderInfo struct {
 	Payloads         []Payload        `json:"payloads"`
 	ArtifactProvides ArtifactProvides `json:"artifact_provides"`
 	ArtifactDepends  ArtifactDepends  `json:"artifact_depends"`
+
+	rd serialized
 }
 
 func (h *HeaderInfo) Parse(r io.Reader) error {
 	if h == nil {
 		h = &HeaderInfo{}
 	}
-	_, err := io.Copy(h, r)
-	return err
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, h)
 }
 
 func (h HeaderInfo) String() string {
@@ -396,24 +575,86 @@ func (h HeaderInfo) String() string {
 		fmt.Fprintf(buf, "Payload: %s\n", payload.String())
 	}
 	fmt.Fprintf(buf, "ArtifactProvides:\n\t%s", h.ArtifactProvides)
-	fmt.Fprintf(buf, "ArtifactDepends:\n\t%s", h.ArtifactProvides)
+	fmt.Fprintf(buf, "ArtifactDepends:\n\t%s", h.ArtifactDepends)
 	return buf.String()
 }
 
-func (h HeaderInfo) Write(b []byte) (n int, err error) {
-	err = json.Unmarshal(b, &h)
-	if err != nil {
+// PayloadList returns the payloads of the header-info
+func (h *HeaderInfo) PayloadList() []Payload {
+	return h.Payloads
+}
+
+// Provides returns the artifact_provides of the header-info
+func (h *HeaderInfo) Provides() ArtifactProvides {
+	return h.ArtifactProvides
+}
+
+// Depends returns the artifact_depends of the header-info
+func (h *HeaderInfo) Depends() ArtifactDepends {
+	return h.ArtifactDepends
+}
+
+// MarshalJSON marshals the header-info in the version 3 layout:
+//
+//	{"payloads": [...], "artifact_provides": {...}, "artifact_depends": {...}}
+func (h HeaderInfo) MarshalJSON() ([]byte, error) {
+	type headerInfo HeaderInfo
+	if h.Payloads == nil {
+		h.Payloads = []Payload{}
+	}
+	return json.Marshal(headerInfo(h))
+}
+
+// UnmarshalJSON unmarshals the header-info in either the version 3, or the
+// version 2, layout
+func (h *HeaderInfo) UnmarshalJSON(b []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	if _, ok := fields["updates"]; ok {
+		var info headerInfoV2
+		if err := json.Unmarshal(b, &info); err != nil {
+			return err
+		}
+		h.Payloads = info.Updates
+		h.ArtifactProvides = ArtifactProvides{
+			ArtifactName: info.ArtifactName,
+			Extra:        map[string]interface{}{},
+		}
+		h.ArtifactDepends = ArtifactDepends{
+			DeviceType: info.DeviceTypesCompatible,
+			Extra:      map[string]interface{}{},
+		}
+		return nil
+	}
+	type headerInfo HeaderInfo
+	return json.Unmarshal(b, (*headerInfo)(h))
+}
+
+func (h *HeaderInfo) Write(b []byte) (n int, err error) {
+	if err = json.Unmarshal(b, h); err != nil {
 		return 0, err
 	}
+	h.rd = serialized{}
 	return len(b), nil
 }
 
 func (h *HeaderInfo) Read(b []byte) (n int, err error) {
-	b, err = json.Marshal(h)
+	return h.rd.read(b, func() ([]byte, error) {
+		data, err := json.Marshal(h)
+		return data, errors.Wrap(err, "HeaderInfo: Read: Failed to marshal json")
+	})
+}
+
+// WriteTo writes the whole header-info to w
+func (h *HeaderInfo) WriteTo(w io.Writer) (int64, error) {
+	data, err := json.Marshal(h)
 	if err != nil {
-		return 0, errors.Wrap(err, "HeaderInfo: Read: Failed to marshal json")
+		return 0, errors.Wrap(err, "HeaderInfo: WriteTo: Failed to marshal json")
 	}
-	return len(b), nil
+	n, err := w.Write(data)
+	return int64(n), errors.Wrap(err, "HeaderInfo: WriteTo")
 }
 
 // All the Artifact scripts
@@ -423,9 +664,16 @@ type script struct {
 
 type Scripts struct {
 	scriptDir         string // configureable
+	tempDir           string // The parent of a temporary scriptDir
+	ownsDir           bool   // scriptDir is temporary, and removed on Close
 	currentScriptName string
-	file              *os.File
-	names             []string
+
+	mu    sync.Mutex // Guards file, and names
+	file  *os.File
+	names []string
+
+	annotations map[string]map[string]string
+	rd          serialized
 }
 
 // Parse The scripts Parse function reads a file from the tar reader
@@ -435,49 +683,205 @@ func (s *Scripts) Parse(tr *tar.Reader) error {
 		s = &Scripts{}
 	}
 	hdr, err := tr.Next()
-	log.Trace("Parsing scripts from: %v", hdr)
-	fmt.Println(hdr.Name)
 	if err != nil {
 		return err
 	}
-	if filepath.Dir(hdr.Name) == "headers/0000" {
-		return io.EOF // Move on to parsing headers
+	log.Tracef("Parsing scripts from: %s", hdr.Name)
+	if strings.HasPrefix(hdr.Name, "headers/") {
+		return io.EOF // Move on to parsing the sub-headers
 	}
 	if filepath.Dir(hdr.Name) != "scripts" {
-		return fmt.Errorf("Expected scripts. Got: %s", hdr.Name)
+		return fmt.Errorf("%w: Expected scripts, got %s", ErrUnexpectedEntry, hdr.Name)
+	}
+	if filepath.Base(hdr.Name) == scriptAnnotationFile {
+		if err = json.NewDecoder(tr).Decode(&s.annotations); err != nil {
+			return errors.Wrapf(err, "Failed to parse %s", hdr.Name)
+		}
+		return nil
 	}
 	if err = s.Next(filepath.Base(hdr.Name)); err != nil {
 		return err
 	}
-	_, err = io.Copy(s.file, tr)
+	_, err = io.Copy(s, tr)
+	if cerr := s.closeFile(); err == nil {
+		err = cerr
+	}
 	if err != nil {
 		return fmt.Errorf("Failed to parse 'scripts'. Error: %v", err)
 	}
 	return nil
 }
 
+// ParseArchive reads the scripts from the header tar r, starting at its next
+// entry, and writes them to the script directory. It stops at the first entry
+// which is not a script.
+func (s *Scripts) ParseArchive(r io.Reader) error {
+	_, err := s.parseArchive(r)
+	return err
+}
+
+// parseArchive returns the header of the entry following the scripts, or nil
+// if the tar ends with the scripts.
+func (s *Scripts) parseArchive(r io.Reader) (*tar.Header, error) {
+	tr, ok := r.(*tar.Reader)
+	if !ok {
+		tr = tar.NewReader(r)
+	}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if filepath.Dir(hdr.Name) != "scripts" {
+			return hdr, nil
+		}
+		if filepath.Base(hdr.Name) == scriptAnnotationFile {
+			if err = json.NewDecoder(tr).Decode(&s.annotations); err != nil {
+				return nil, errors.Wrapf(err, "Failed to parse %s", hdr.Name)
+			}
+			continue
+		}
+		log.Tracef("Parsing script: %s", hdr.Name)
+		if err = s.Next(filepath.Base(hdr.Name)); err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(s, tr)
+		if cerr := s.closeFile(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to write the script %s", hdr.Name)
+		}
+	}
+}
+
 func (s *Scripts) String() string {
 	buf := bytes.NewBuffer(nil)
-	for _, name := range s.names {
+	for _, name := range s.paths() {
 		fmt.Fprintf(buf, "\n\t%s", name)
 	}
 	fmt.Fprintln(buf)
 	return buf.String()
 }
 
+// List returns the names of the scripts, in the order they were parsed
+func (s *Scripts) List() []string {
+	if s == nil {
+		return nil
+	}
+	names := []string{}
+	for _, name := range s.paths() {
+		names = append(names, filepath.Base(name))
+	}
+	return names
+}
+
+// paths returns the paths the scripts are written to
+func (s *Scripts) paths() []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.names...)
+}
+
+// add adds the script at path, unless it is already there
+func (s *Scripts) add(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !containsString(s.names, path) {
+		s.names = append(s.names, path)
+	}
+}
+
+// Next creates the script filename in the script directory, and makes it the
+// target of Write. Unless a script directory is configured, every Scripts
+// gets its own temporary directory, so that Artifacts can be parsed in
+// parallel.
 func (s *Scripts) Next(filename string) error {
-	f, err := os.Create(filepath.Join(s.scriptDir, filename))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.makeScriptDir(); err != nil {
+		return err
+	}
+	// Finish off the previous script
+	if s.file != nil {
+		if err := s.file.Close(); err != nil {
+			return err
+		}
+		s.file = nil
+	}
+	path := filepath.Join(s.scriptDir, filename)
+	// The scripts are executed by the client, and so have to be executable
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
 	if err != nil {
 		return err
 	}
 	s.file = f
-	s.names = append(s.names, filepath.Join(s.scriptDir, filename))
+	if !containsString(s.names, path) {
+		s.names = append(s.names, path)
+	}
 	return nil
 }
 
+// makeScriptDir creates the script directory, if need be. MkdirAll does not
+// fail if another parser creates the same directory at the same time.
+func (s *Scripts) makeScriptDir() error {
+	if s.scriptDir != "" {
+		return os.MkdirAll(s.scriptDir, 0755)
+	}
+	if s.tempDir != "" {
+		if err := os.MkdirAll(s.tempDir, 0755); err != nil {
+			return err
+		}
+	}
+	dir, err := ioutil.TempDir(s.tempDir, "mender-scripts-")
+	if err != nil {
+		return err
+	}
+	s.scriptDir = dir
+	s.ownsDir = true
+	return nil
+}
+
+func (s *Scripts) closeFile() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// Close closes the script being written, if any, and removes the script
+// directory, if it is a temporary one
+func (s *Scripts) Close() error {
+	if s == nil {
+		return nil
+	}
+	err := s.closeFile()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ownsDir {
+		return err
+	}
+	if rerr := os.RemoveAll(s.scriptDir); err == nil {
+		err = rerr
+	}
+	s.scriptDir, s.ownsDir = "", false
+	return err
+}
+
 // The scripts Write reads a file from the byte stream
 // and writes it to /scripts/<ScriptName>
-func (s Scripts) Write(b []byte) (n int, err error) {
+func (s *Scripts) Write(b []byte) (n int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.file == nil {
 		return 0, fmt.Errorf("Next must be called, prior to writing a script")
 	}
@@ -485,8 +889,46 @@ func (s Scripts) Write(b []byte) (n int, err error) {
 	return len(b), err
 }
 
-func (s Scripts) Read(b []byte) (n int, err error) {
-	return 0, errors.New("Unimplemented")
+// Read reads the scripts as a tar, with the same layout as in the header
+func (s *Scripts) Read(b []byte) (n int, err error) {
+	return s.rd.read(b, func() ([]byte, error) {
+		buf := bytes.NewBuffer(nil)
+		tw := tar.NewWriter(buf)
+		if err := s.WriteTar(tw); err != nil {
+			return nil, errors.Wrap(err, "Scripts: Read")
+		}
+		if err := tw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	})
+}
+
+// WriteTar writes every script, and the annotations, if any, as a separate
+// scripts/<name> entry to tw
+func (s *Scripts) WriteTar(tw *tar.Writer) error {
+	if s == nil {
+		return nil
+	}
+	for _, path := range s.paths() {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err = writeTarEntry(tw, "scripts/"+filepath.Base(path), content); err != nil {
+			return err
+		}
+	}
+	if len(s.annotations) > 0 {
+		annotations, err := json.Marshal(s.annotations)
+		if err != nil {
+			return err
+		}
+		if err = writeTarEntry(tw, "scripts/"+scriptAnnotationFile, annotations); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 type TypeInfoProvides struct {
@@ -501,6 +943,164 @@ type TypeInfo struct {
 	Type             string           `json:"type"`
 	TypeInfoProvides TypeInfoProvides `json:"artifact_provides"`
 	TypeInfoDepends  TypeInfoDepends  `json:"artifact_depends"`
+
+	// raw is the type-info as parsed, so that the fields unknown to
+	// TypeInfo, ie, of custom payload types, are not lost
+	raw map[string]json.RawMessage
+
+	rd serialized
+	wr bytes.Buffer
+}
+
+// typeInfoFields is TypeInfo without its json methods
+type typeInfoFields TypeInfo
+
+// UnmarshalJSON unmarshals the known fields of the type-info, and keeps the
+// rest as raw json
+func (t *TypeInfo) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, (*typeInfoFields)(t)); err != nil {
+		return err
+	}
+	t.raw = nil
+	return json.Unmarshal(b, &t.raw)
+}
+
+// MarshalJSON marshals the known fields of the type-info, merged with any
+// fields unknown to TypeInfo
+func (t TypeInfo) MarshalJSON() ([]byte, error) {
+	known, err := json.Marshal(typeInfoFields(t))
+	if err != nil || t.raw == nil {
+		return known, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err = json.Unmarshal(known, &fields); err != nil {
+		return nil, err
+	}
+	for _, section := range []string{"artifact_provides", "artifact_depends"} {
+		merged, err := mergeTypeInfoSection(t.raw[section], fields[section])
+		if err != nil {
+			return nil, errors.Wrapf(err, "TypeInfo: Invalid %s", section)
+		}
+		if merged == nil {
+			delete(fields, section)
+		} else {
+			fields[section] = merged
+		}
+	}
+	// Keep the known fields first, as in the struct
+	buf := bytes.NewBufferString("{")
+	keys := []string{"type", "artifact_provides", "artifact_depends"}
+	var extra []string
+	for key := range t.raw {
+		if _, ok := fields[key]; !ok {
+			extra = append(extra, key)
+		}
+	}
+	sort.Strings(extra)
+	for _, key := range append(keys, extra...) {
+		value, ok := fields[key]
+		if !ok {
+			if value, ok = t.raw[key]; !ok {
+				continue
+			}
+		}
+		if buf.Len() > 1 {
+			buf.WriteString(",")
+		}
+		name, _ := json.Marshal(key)
+		buf.Write(name)
+		buf.WriteString(":")
+		buf.Write(value)
+	}
+	buf.WriteString("}")
+	return buf.Bytes(), nil
+}
+
+// mergeTypeInfoSection merges the known fields of the artifact_provides, or
+// artifact_depends, section into the raw section. Empty known fields are
+// only kept if the raw section has them. nil is returned if there is neither
+// a raw section, nor any known fields.
+func mergeTypeInfoSection(raw, known json.RawMessage) (json.RawMessage, error) {
+	var section, knownSection map[string]json.RawMessage
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &section); err != nil {
+			return nil, err
+		}
+	}
+	if err := json.Unmarshal(known, &knownSection); err != nil {
+		return nil, err
+	}
+	if section == nil && len(raw) > 0 {
+		section = map[string]json.RawMessage{}
+	}
+	for key, value := range knownSection {
+		if _, ok := section[key]; ok || string(value) != `""` {
+			if section == nil {
+				section = map[string]json.RawMessage{}
+			}
+			section[key] = value
+		}
+	}
+	if section == nil {
+		return nil, nil
+	}
+	return json.Marshal(section)
+}
+
+// RawProvides returns the artifact_provides of the type-info as raw json,
+// including any fields unknown to TypeInfo
+func (t *TypeInfo) RawProvides() map[string]json.RawMessage {
+	return t.rawSection("artifact_provides")
+}
+
+// RawDepends returns the artifact_depends of the type-info as raw json,
+// including any fields unknown to TypeInfo
+func (t *TypeInfo) RawDepends() map[string]json.RawMessage {
+	return t.rawSection("artifact_depends")
+}
+
+func (t *TypeInfo) rawSection(name string) map[string]json.RawMessage {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return nil
+	}
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(b, &fields); err != nil {
+		return nil
+	}
+	section := map[string]json.RawMessage{}
+	if len(fields[name]) == 0 {
+		return section
+	}
+	if err = json.Unmarshal(fields[name], &section); err != nil {
+		return nil
+	}
+	return section
+}
+
+// Provides returns the artifact_provides of the type-info
+func (t *TypeInfo) Provides() TypeInfoProvides {
+	return t.TypeInfoProvides
+}
+
+// Depends returns the artifact_depends of the type-info
+func (t *TypeInfo) Depends() TypeInfoDepends {
+	return t.TypeInfoDepends
+}
+
+// Write buffers the type-info json written to it, and unmarshals it once it
+// is complete.
+func (t *TypeInfo) Write(b []byte) (n int, err error) {
+	t.wr.Write(b)
+	if !json.Valid(t.wr.Bytes()) {
+		return len(b), nil
+	}
+	if err = json.Unmarshal(t.wr.Bytes(), t); err != nil {
+		return 0, errors.Wrap(err, "TypeInfo: Write: Failed to unmarshal json")
+	}
+	t.wr.Reset()
+	t.rd = serialized{}
+	return len(b), nil
 }
 
 func (t *TypeInfo) Parse(r *tar.Reader) error {
@@ -514,7 +1114,7 @@ func (t *TypeInfo) Parse(r *tar.Reader) error {
 	return json.Unmarshal(bytes, &t)
 }
 
-func (t TypeInfo) String() string {
+func (t *TypeInfo) String() string {
 	typeinfotmplstr := `{{ if .Type}} {{ printf "%s" .Type }} {{ end }}
 {{ if .TypeInfoProvides}} {{ printf "%s" .TypeInfoProvides }} {{ end }}
 {{ if .TypeInfoDepends}} {{ printf "%s" .TypeInfoDepends }} {{ end }}`
@@ -529,34 +1129,97 @@ func (t TypeInfo) String() string {
 	return buf.String()
 }
 
-func (t TypeInfo) Read(b []byte) (n int, err error) {
-	b, err = json.Marshal(&t)
+func (t *TypeInfo) Read(b []byte) (n int, err error) {
+	return t.rd.read(b, func() ([]byte, error) {
+		data, err := json.Marshal(t)
+		return data, errors.Wrap(err, "TypeInfo: Read: Failed to marshal json")
+	})
+}
+
+// WriteTo writes the whole type-info to w
+func (t *TypeInfo) WriteTo(w io.Writer) (int64, error) {
+	data, err := json.Marshal(t)
 	if err != nil {
-		return 0, errors.Wrap(err, "TypeInfo: Read: Failed to marshal json")
+		return 0, errors.Wrap(err, "TypeInfo: WriteTo: Failed to marshal json")
 	}
-	return len(b), nil
+	n, err := w.Write(data)
+	return int64(n), errors.Wrap(err, "TypeInfo: WriteTo")
 }
 
 type MetaData struct {
 	// meta-data
+	Data map[string]json.RawMessage
+	rd   serialized
+	wr   bytes.Buffer
 }
 
 func (m *MetaData) Parse(r *tar.Reader) error {
-	_, err := io.Copy(ioutil.Discard, r)
-	return err
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	// The meta-data is optional, and may be empty
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, &m.Data)
 }
 
-func (m MetaData) String() string {
-	return ""
+func (m *MetaData) String() string {
+	if m == nil || m.Data == nil {
+		return ""
+	}
+	data, _ := json.Marshal(m.Data)
+	return string(data)
 }
 
-func (t MetaData) Write(b []byte) (n int, err error) {
-	_, err = io.Copy(ioutil.Discard, bytes.NewReader(b))
-	return len(b), err
+// Write buffers the meta-data json written to it, and unmarshals it into
+// Data once it is complete.
+func (m *MetaData) Write(b []byte) (n int, err error) {
+	m.wr.Write(b)
+	if !json.Valid(m.wr.Bytes()) {
+		return len(b), nil
+	}
+	if err = json.Unmarshal(m.wr.Bytes(), &m.Data); err != nil {
+		return 0, errors.Wrap(err, "MetaData: Write: Failed to unmarshal json")
+	}
+	m.wr.Reset()
+	m.rd = serialized{}
+	return len(b), nil
 }
 
-func (t MetaData) Read(b []byte) (n int, err error) {
-	return 0, errors.New("Unimplemented")
+// Get returns the raw json value of key
+func (m *MetaData) Get(key string) (json.RawMessage, bool) {
+	if m == nil {
+		return nil, false
+	}
+	v, ok := m.Data[key]
+	return v, ok
+}
+
+// Set sets key to the json encoding of v
+func (m *MetaData) Set(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "MetaData: Set: Failed to marshal %s", key)
+	}
+	if m.Data == nil {
+		m.Data = map[string]json.RawMessage{}
+	}
+	m.Data[key] = data
+	m.rd = serialized{}
+	return nil
+}
+
+// Read reads the meta-data as json. Empty meta-data reads as nothing.
+func (t *MetaData) Read(b []byte) (n int, err error) {
+	return t.rd.read(b, func() ([]byte, error) {
+		if t.Data == nil {
+			return nil, nil
+		}
+		data, err := json.Marshal(t.Data)
+		return data, errors.Wrap(err, "MetaData: Read: Failed to marshal json")
+	})
 }
 
 // Wrapper for all the sub-headers
@@ -572,7 +1235,7 @@ func (t MetaData) Read(b []byte) (n int, err error) {
 //        |
 //        +- meta-data
 type SubHeader struct {
-	name     string
+	name     string // The directory of the sub-header, ie, 0001
 	typeInfo *TypeInfo
 	metaData *MetaData
 }
@@ -607,16 +1270,91 @@ func (s *SubHeader) Parse(r *tar.Reader) {
 	// h.subHeaders = append(h.subHeaders, sh)
 }
 
+// PayloadType returns the type of the payload, ie, rootfs-image, or "" if the
+// sub-header has no type-info
+func (s *SubHeader) PayloadType() string {
+	if s.typeInfo == nil {
+		return ""
+	}
+	return s.typeInfo.Type
+}
+
+// Index returns the position of the sub-header in headers/, ie, 1 for
+// headers/0001, or -1 if it is not a part of a header
+func (s *SubHeader) Index() int {
+	index, err := strconv.Atoi(s.name)
+	if err != nil {
+		return -1
+	}
+	return index
+}
+
+// MetaData returns the meta-data of the sub-header, or nil if it has none
+func (s *SubHeader) MetaData() *MetaData {
+	return s.metaData
+}
+
 func (s *SubHeader) String() string {
 	return fmt.Sprintf("Name: %s\nTypeInfo: %s\nMetaData: %s\n", s.name, s.typeInfo, s.metaData)
 }
 
 // Another tarball
 type HeaderSigned struct {
-	// data
-	data       []byte // TODO - What is in the header?
+	data       []byte // The header-signed.tar.gz as read from the Artifact
 	headerInfo HeaderInfo
-	scripts    Scripts
+	scripts    *Scripts
+
+	// The manifest, and its signature, covering the header
+	manifest *Manifest
+	sig      *ManifestSig
+}
+
+func (h *HeaderSigned) String() string {
+	if h == nil {
+		return ""
+	}
+	return h.headerInfo.String()
+}
+
+// Parse parses the header-signed.tar.gz, which holds the header-info, and the
+// scripts, like header.tar.gz. Any other entries are skipped.
+func (h *HeaderSigned) Parse(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return errors.Wrap(err, "HeaderSigned")
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "HeaderSigned")
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+	hdr, err := tr.Next()
+	if err != nil {
+		return errors.Wrap(err, "HeaderSigned")
+	}
+	if hdr.Name != "header-info" {
+		return fmt.Errorf("HeaderSigned: Unexpected header: %s", hdr.Name)
+	}
+	if err = h.headerInfo.Parse(tr); err != nil {
+		return errors.Wrap(err, "HeaderSigned: Failed to parse 'header-info'")
+	}
+	if h.scripts == nil {
+		h.scripts = &Scripts{}
+	}
+	if _, err = h.scripts.parseArchive(tr); err != nil {
+		return errors.Wrap(err, "HeaderSigned: Failed to parse 'scripts'")
+	}
+	h.data = data
+	return nil
+}
+
+// Scripts returns the names of the scripts in the signed header
+func (h *HeaderSigned) Scripts() []string {
+	if h == nil {
+		return nil
+	}
+	return h.scripts.List()
 }
 
 // Another tar-ball
@@ -624,6 +1362,20 @@ type HeaderSigned struct {
 type HeaderAugment struct {
 	headerInfo *HeaderInfo
 	subHeaders []SubHeader
+
+	raw []byte // The header-augment.tar.gz as read from the Artifact
+	rd  serialized
+}
+
+func (h *HeaderAugment) String() string {
+	if h == nil {
+		return ""
+	}
+	buf := bytes.NewBuffer(nil)
+	for _, header := range h.subHeaders {
+		buf.WriteString(header.String())
+	}
+	return buf.String()
 }
 
 func (h *HeaderAugment) Write(b []byte) (n int, err error) {
@@ -648,21 +1400,31 @@ func (h *HeaderAugment) Write(b []byte) (n int, err error) {
 	if err = h.headerInfo.Parse(tarElement); err != nil {
 		return 0, err
 	}
-	if _, err = io.Copy(h.headerInfo, tarElement); err != nil {
-		return 0, nil
+	hdr, err = tarElement.Next()
+	if err == io.EOF {
+		return len(b), nil
+	} else if err != nil {
+		return 0, err
 	}
 	// Read all the headers
 	for {
 		// hdr.Name is already set, as we broke out of the script parsing loop
 		if filepath.Base(hdr.Name) != "type-info" {
-			return 0, fmt.Errorf("Expected `type-info`. Got %s", hdr.Name) // TODO - this should probs be a parseError type
+			return 0, fmt.Errorf("%w: Expected `type-info`, got %s", ErrUnexpectedEntry, hdr.Name)
+		}
+		sh := SubHeader{
+			name:     filepath.Base(filepath.Dir(hdr.Name)),
+			typeInfo: &TypeInfo{},
+			metaData: &MetaData{},
 		}
-		sh := SubHeader{}
 		if err = sh.typeInfo.Parse(tarElement); err != nil {
 			return 0, err
 		}
 		hdr, err = tarElement.Next()
-		if err != nil {
+		if err == io.EOF {
+			h.subHeaders = append(h.subHeaders, sh)
+			return len(b), nil
+		} else if err != nil {
 			return 0, err
 		}
 		if filepath.Base(hdr.Name) == "meta-data" {
@@ -670,7 +1432,10 @@ func (h *HeaderAugment) Write(b []byte) (n int, err error) {
 				return 0, err
 			}
 			hdr, err = tarElement.Next()
-			if err != nil {
+			if err == io.EOF {
+				h.subHeaders = append(h.subHeaders, sh)
+				return len(b), nil
+			} else if err != nil {
 				return 0, err
 			}
 		}
@@ -678,8 +1443,33 @@ func (h *HeaderAugment) Write(b []byte) (n int, err error) {
 	}
 }
 
+// AugmentedDepends returns the artifact_depends of the type-info of every
+// augmented sub-header, ie, the rootfs_image_checksum a delta update has to be
+// applied to
+func (h *HeaderAugment) AugmentedDepends() []TypeInfoDepends {
+	if h == nil {
+		return nil
+	}
+	var depends []TypeInfoDepends
+	for _, sh := range h.subHeaders {
+		if sh.typeInfo != nil {
+			depends = append(depends, sh.typeInfo.Depends())
+		}
+	}
+	return depends
+}
+
+// Read reads the compressed header-augment tar. A header-augment which was
+// not parsed is created from the header-info and the sub-headers.
 func (h *HeaderAugment) Read(b []byte) (n int, err error) {
-	return 0, errors.New("Unimplemented")
+	return h.rd.read(b, func() ([]byte, error) {
+		if h.raw == nil {
+			if err := h.build(); err != nil {
+				return nil, errors.Wrap(err, "HeaderAugment: Read")
+			}
+		}
+		return h.raw, nil
+	})
 }
 
 type PayLoadData struct {
@@ -688,6 +1478,45 @@ type PayLoadData struct {
 	Data    bytes.Buffer
 	OutData io.Reader
 	Update  io.Reader
+
+	rd serialized
+}
+
+// NewPayloadData returns a payload named name, which reads the update from r
+func NewPayloadData(name string, r io.Reader) *PayLoadData {
+	return &PayLoadData{Name: name, Update: r}
+}
+
+// Close closes the update, and the out data, if they are files
+func (p *PayLoadData) Close() error {
+	var err error
+	for _, r := range []io.Reader{p.Update, p.OutData} {
+		if f, ok := r.(*os.File); ok {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
+
+// FileName returns the name of the (first) file in the payload tar, ie,
+// rootfs.ext4. The file itself is not read.
+func (p *PayLoadData) FileName() (string, error) {
+	compression, err := compressionFromName(p.Name)
+	if err != nil {
+		return "", errors.Wrap(err, "PayloadData")
+	}
+	zr, err := compression.newReader(bytes.NewReader(p.Data.Bytes()))
+	if err != nil {
+		return "", errors.Wrapf(err, "PayloadData: Failed to decompress %s", p.Name)
+	}
+	defer zr.Close()
+	hdr, err := tar.NewReader(zr).Next()
+	if err != nil {
+		return "", errors.Wrapf(err, "PayloadData: Failed to read %s", p.Name)
+	}
+	return hdr.Name, nil
 }
 
 func (p *PayLoadData) Write(b []byte) (n int, err error) {
@@ -696,15 +1525,19 @@ func (p *PayLoadData) Write(b []byte) (n int, err error) {
 	return len(b), nil
 }
 
+// Read reads the underlying update files, if set, and the payload, as it is
+// written to the Artifact, otherwise
 func (p *PayLoadData) Read(b []byte) (n int, err error) {
-	// Read from the underlying update files to the payload
-	buf := bytes.NewBuffer(b)
-	_, err = io.Copy(buf, p.Update)
-	if err != nil {
-		return 0, errors.Wrap(err, "PayloadData: Read")
+	if p.Update != nil {
+		n, err = p.Update.Read(b)
+		if err != nil && err != io.EOF {
+			return n, errors.Wrap(err, "PayloadData: Read")
+		}
+		return n, err
 	}
-	b = buf.Bytes()
-	return len(b), nil
+	return p.rd.read(b, func() ([]byte, error) {
+		return p.Data.Bytes(), nil
+	})
 }
 
 //     data
@@ -724,10 +1557,23 @@ func (p *PayLoadData) Read(b []byte) (n int, err error) {
 type Data struct {
 	// Updates 4 all ^^
 	payloads []PayLoadData
+
+	rd serialized
+}
+
+func (d *Data) String() string {
+	if d == nil {
+		return ""
+	}
+	buf := bytes.NewBuffer(nil)
+	for _, payload := range d.payloads {
+		fmt.Fprintf(buf, "%s\n", payload.Name)
+	}
+	return buf.String()
 }
 
 func (d *Data) Write(b []byte) (n int, err error) {
-	log.Trace("len(b): %d\n", len(b))
+	log.Tracef("len(b): %d\n", len(b))
 	gzipr, err := gzip.NewReader(bytes.NewReader(b))
 	if err != nil {
 		return 0, errors.Wrap(err, "Data: Write: Failed to unzip the Payload")
@@ -740,18 +1586,49 @@ func (d *Data) Write(b []byte) (n int, err error) {
 	return len(b), nil
 }
 
+// Read reads the payloads as a tar, with the same entries as the Artifact,
+// ie, data/0000.tar.gz...
 func (d *Data) Read(b []byte) (n int, err error) {
-	// Simply gzip and write the data to make it pretty for the tar-writer
-	buf := bytes.NewBuffer(nil)
-	gzw := gzip.NewWriter(buf)
-	for _, payload := range d.payloads {
-		_, err = io.Copy(gzw, &payload)
-		if err != nil {
-			return 0, errors.Wrap(err, "Data: Read")
+	return d.rd.read(b, func() ([]byte, error) {
+		buf := bytes.NewBuffer(nil)
+		tw := tar.NewWriter(buf)
+		for _, payload := range d.payloads {
+			if err := writeTarEntry(tw, payload.Name, payload.Data.Bytes()); err != nil {
+				return nil, errors.Wrap(err, "Data: Read")
+			}
+		}
+		if err := tw.Close(); err != nil {
+			return nil, errors.Wrap(err, "Data: Read")
 		}
+		return buf.Bytes(), nil
+	})
+}
+
+// Payloads returns the payloads, in order. Modifying them modifies the Data.
+func (d *Data) Payloads() []*PayLoadData {
+	if d == nil {
+		return nil
 	}
-	b = buf.Bytes()
-	return len(b), nil
+	payloads := make([]*PayLoadData, len(d.payloads))
+	for i := range d.payloads {
+		payloads[i] = &d.payloads[i]
+	}
+	return payloads
+}
+
+func (d *Data) PayloadCount() int {
+	if d == nil {
+		return 0
+	}
+	return len(d.payloads)
+}
+
+// PayloadAt returns the payload index, ie, data/0001.tar.gz for 1
+func (d *Data) PayloadAt(index int) (*PayLoadData, error) {
+	if index < 0 || index >= d.PayloadCount() {
+		return nil, fmt.Errorf("Data: No payload %d", index)
+	}
+	return &d.payloads[index], nil
 }
 
 type Artifact struct {
@@ -763,6 +1640,37 @@ type Artifact struct {
 	HeaderAugment   *HeaderAugment
 	HeaderSigned    *HeaderSigned
 	Data            *Data
+	// HeaderOnly is set if only the header of the Artifact was parsed, and
+	// Data is nil, ie, by Parser.ParseHeader
+	HeaderOnly bool
+
+	// Handlers for the sections following the payloads, by name
+	sectionHandlers map[string]SectionHandler
+
+	// The format version the Artifact has to be of, if set
+	version int
+	log     log.FieldLogger
+	// Called after every parsed section, if set
+	progress func(section string, bytesRead, total int64)
+	// The sizes of the sections in the Artifact tar, by name, and the names
+	// in the order they were read
+	sectionSizes map[string]int64
+	sectionNames []string
+	// The SHA256 of the sections read, by name
+	checksums map[string][]byte
+	// Validate warns about payloads without an SBOM in their meta-data
+	requireSBOM bool
+	// Fail on unknown entries in the header, instead of skipping them
+	strictParsing bool
+	// Added to every log entry as request_id, if set
+	requestID string
+	// Receives the metrics of the sections written by an ArtifactWriter
+	metrics MetricsCollector
+	// The payloads are decompressed, and verified, on this many goroutines,
+	// which read them from payloadSource, at their offsets in payloadOffsets
+	payloadWorkers int
+	payloadSource  io.ReaderAt
+	payloadOffsets map[string]int64
 
 	// The local parser
 	// p               *Parser
@@ -788,21 +1696,23 @@ func (a *Artifact) String() string {
 }
 
 // New returns an instantiated basic artifact, ready for parsing
-func New() *Artifact {
-	return &Artifact{
+func New(opts ...Option) *Artifact {
+	a := &Artifact{
 		// Version:         Version{},
 		// Manifest:        Manifest{},
 		// ManifestSig:     ManifestSig{},
 		// ManifestAugment: ManifestAugment{},
 		HeaderTar: &HeaderTar{
-			Scripts: &Scripts{
-				scriptDir: "/Users/olepor/go/src/github.com/olepor/ma-go/scripts", // TODO - make this configureable
-			},
+			Scripts: &Scripts{},
 		},
 		// HeaderAugment: HeaderAugment{},
 		// HeaderSigned:  HeaderSigned{},
 		// Data:          Data{},
 	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
 }
 
 type parser struct {
@@ -818,99 +1728,193 @@ type element struct {
 
 // Write parses an aritfact from the bytes it is fed.
 // TODO -- Change to parse method
-func (p *Artifact) Parse(r io.Reader) error {
-	log.Debug("Parsing Artifact...")
-	artifact := New()
-	tarElement := tar.NewReader(r)
-	// Expect `version`
-	hdr, err := tarElement.Next()
-	if err != nil {
-		return err
+func (a *Artifact) Parse(r io.Reader) error {
+	a.logger().Debug("Parsing Artifact...")
+	cr := &countingReader{r: r}
+	tarElement := tar.NewReader(cr)
+	order := sectionOrder{}
+	for {
+		hdr, err := tarElement.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if err = order.next(hdr.Name); err != nil {
+			return err
+		}
+		a.setSectionSize(hdr.Name, hdr.Size)
+		if a.payloadSource != nil && filepath.Dir(hdr.Name) == "data" {
+			// The tar reader has only read the header blocks
+			if a.payloadOffsets == nil {
+				a.payloadOffsets = map[string]int64{}
+			}
+			a.payloadOffsets[hdr.Name] = cr.n
+		}
+		if err = a.parseSection(hdr.Name, tarElement); err != nil {
+			return err
+		}
+		if a.progress != nil {
+			a.progress(hdr.Name, cr.n, hdr.Size)
+		}
 	}
-	if hdr.Name != "version" {
-		return fmt.Errorf("Expected version. Got %s", hdr.Name)
+	if err := order.done(); err != nil {
+		return err
 	}
-	if err = artifact.Version.Parse(tarElement); err != nil {
-		return fmt.Errorf("Failed to parse the Version header, error: %v", err)
+	trace(a.logger(), "Read all the payloads")
+	if err := a.verifyManifest(); err != nil {
+		return errors.Wrap(err, "Parse")
 	}
-	log.Trace("Parsed version")
-	log.Trace(artifact.Version)
-	// Expect `manifest`
-	hdr, err = tarElement.Next()
-	if err != nil {
+
+	return nil
+}
+
+// parseSection parses the section name of the Artifact tar, read from r, and
+// records its checksum. The sections are expected to come in the order
+// verified by sectionOrder.
+func (a *Artifact) parseSection(name string, r io.Reader) error {
+	return a.hashSection(name, r, a.parseSectionContent)
+}
+
+// parseExtraFile reads the file name, which follows the payloads, with its
+// SectionHandler, if one is registered, and records its checksum
+func (a *Artifact) parseExtraFile(name string, r io.Reader) error {
+	return a.hashSection(name, r, a.handleExtraFile)
+}
+
+// hashSection parses the section name, read from r, with parse, and records
+// its checksum
+func (a *Artifact) hashSection(name string, r io.Reader, parse func(string, io.Reader) error) error {
+	trace(a.logger().WithFields(log.Fields{"section": name, "size": a.sectionSizes[name]}), "Parsing section")
+	sha := sha256.New()
+	tr := io.TeeReader(r, sha)
+	if err := parse(name, tr); err != nil {
 		return err
 	}
-	if hdr.Name != "manifest" {
-		return fmt.Errorf("Expected `manifest`. Got %s", hdr.Name)
+	// Sections which are skipped are hashed all the same
+	if _, err := io.Copy(ioutil.Discard, tr); err != nil {
+		return errors.Wrapf(err, "Parse: Failed to read %s", name)
 	}
-	if err = artifact.Manifest.Parse(tarElement); err != nil {
-		return fmt.Errorf("Failed to parse the Manifest header. Error: %v", err)
+	if a.checksums == nil {
+		a.checksums = map[string][]byte{}
 	}
-	log.Trace("Parsed manifest")
-	log.Trace(artifact.Manifest)
-	// Optional expect `manifest.sig`
-	hdr, err = tarElement.Next()
-	if err != nil {
-		return err
+	a.checksums[name] = sha.Sum(nil)
+	return nil
+}
+
+// handleExtraFile reads the file name, which is not a part of the standard
+// Artifact, and follows the payloads. It is skipped, unless handled.
+func (a *Artifact) handleExtraFile(name string, r io.Reader) error {
+	handler, ok := a.sectionHandlers[name]
+	if !ok {
+		a.logger().WithField("section", name).Debug("Skipping the extra file")
+		return nil
 	}
-	log.Trace("hdr.Name: %s\n", hdr.Name)
-	if hdr.Name == "manifest.sig" {
-		log.Trace("Parsing manifest.sig")
-		if err = artifact.ManifestSig.Parse(tarElement); err != nil {
-			return fmt.Errorf("Failed to parse the Manifest signature. Error: %v", err)
+	return errors.Wrapf(handler(name, r), "Parse: Failed to handle the section %s", name)
+}
+
+func (a *Artifact) parseSectionContent(name string, r io.Reader) (err error) {
+	logger := a.logger().WithField("section", name)
+	raw := bytes.NewBuffer(nil)
+	switch {
+	case filepath.Dir(name) == "data":
+		if a.Data == nil {
+			trace(logger, "Ready to read `Data`")
+			a.Data = &Data{}
 		}
-		log.Trace("Parsed manifest.sig")
-		log.Trace(artifact.ManifestSig)
-		// Optional expect `manifest-augment`
-		hdr, err = tarElement.Next()
-		if err != nil {
+		trace(logger, "Reading the payload")
+		pl := PayLoadData{Name: name}
+		if _, err = io.Copy(&pl.Data, r); err != nil {
+			return errors.Wrapf(err, "Parse: Failed to read %s", name)
+		}
+		a.Data.payloads = append(a.Data.payloads, pl)
+	case a.Data != nil:
+		return a.handleExtraFile(name, r)
+	case name == "version":
+		a.Version = &Version{}
+		if err = a.Version.Parse(io.TeeReader(r, raw)); err != nil {
+			return errors.Wrap(err, "Failed to parse the Version header")
+		}
+		a.Version.raw = raw.Bytes()
+		switch {
+		case a.version != 0 && a.Version.Version != a.version:
+			return &UnsupportedVersionError{Section: name, Expected: strconv.Itoa(a.version), Actual: a.Version.Version}
+		case a.Version.Version != FormatVersion2 && a.Version.Version != FormatVersion3:
+			return &UnsupportedVersionError{Section: name, Expected: "2 or 3", Actual: a.Version.Version}
+		}
+		trace(logger, "Parsed version")
+		trace(logger, a.Version)
+	case name == "manifest":
+		a.Manifest = &Manifest{}
+		if err = a.Manifest.Parse(io.TeeReader(r, raw)); err != nil {
+			return errors.Wrap(err, "Failed to parse the Manifest header")
+		}
+		a.Manifest.raw = raw.Bytes()
+		trace(logger, "Parsed manifest")
+		trace(logger, a.Manifest)
+	case name == "manifest.sig":
+		a.ManifestSig = &ManifestSig{}
+		if err = a.ManifestSig.Parse(r); err != nil {
+			return errors.Wrap(err, "Failed to parse the Manifest signature")
+		}
+		if a.Manifest != nil {
+			a.ManifestSig.manifest = a.Manifest.bytes()
+		}
+		trace(logger, "Parsed manifest.sig")
+		trace(logger, a.ManifestSig)
+	case name == "manifest-augment":
+		a.ManifestAugment = &ManifestAugment{}
+		if err = a.ManifestAugment.Parse(io.TeeReader(r, raw)); err != nil {
+			return fmt.Errorf("Failed to parse 'manifest-augment'. Error: %v", err)
+		}
+		a.ManifestAugment.raw = raw.Bytes()
+		trace(logger, "Parsed manifest-augment")
+	case isHeader(name):
+		if a.HeaderTar == nil {
+			a.HeaderTar = &HeaderTar{}
+		}
+		if a.HeaderTar.compression, err = compressionFromName(name); err != nil {
 			return err
 		}
-		if hdr.Name == "manifest-augment" {
-			if err = artifact.ManifestAugment.Parse(tarElement); err != nil {
-				return fmt.Errorf("Failed to parse 'manifest-augment'. Error: %v", err)
-			}
+		a.HeaderTar.strict = a.strictParsing
+		// Keep the raw header around, so that it can be extracted as is
+		parse := a.HeaderTar.Parse
+		if a.Version != nil && a.Version.Version == FormatVersion2 {
+			a.HeaderTar.formatVersion = FormatVersion2
+			parse = a.HeaderTar.parseV2
 		}
-		log.Trace("Parsed manifest-augment")
-		hdr, err = tarElement.Next()
-		if err != nil {
+		if err = parse(io.TeeReader(r, raw)); err != nil {
+			trace(logger, "Error parsing header.tar.gz")
+			trace(logger, err)
 			return err
 		}
-	}
-	// Expect `header.tar.gz`
-	if hdr.Name != "header.tar.gz" {
-		return fmt.Errorf("Expected `header.tar.gz`. Got %s", hdr.Name)
-	}
-	if err = artifact.HeaderTar.Parse(tarElement); err != nil {
-		log.Trace("Error parsing header.tar.gz")
-		log.Trace(err)
-		return err
-	}
-	log.Trace("Parsed header.tar.gz")
-	log.Trace(artifact.HeaderTar)
-	// Optional `header-augment.tar.gz`
-	hdr, err = tarElement.Next()
-	if err != nil {
-		return err
-	}
-	if hdr.Name == "header-augment.tar.gz" {
-		if _, err = io.Copy(artifact.HeaderAugment, tarElement); err != nil {
+		if _, err = io.Copy(raw, r); err != nil {
+			return errors.Wrap(err, "Parse: Failed to read header.tar.gz")
+		}
+		a.HeaderTar.raw = raw.Bytes()
+		trace(logger, "Parsed header.tar.gz")
+		trace(logger, a.HeaderTar)
+	case name == "header-signed.tar.gz":
+		a.HeaderSigned = &HeaderSigned{manifest: a.Manifest, sig: a.ManifestSig, scripts: &Scripts{}}
+		if a.HeaderTar != nil && a.HeaderTar.Scripts != nil {
+			a.HeaderSigned.scripts.tempDir = a.HeaderTar.Scripts.tempDir
+		}
+		if err = a.HeaderSigned.Parse(r); err != nil {
 			return err
 		}
-		log.Trace("Parsed header-augment")
-		hdr, err = tarElement.Next()
-		if err != nil {
+		trace(logger, "Parsed header-signed")
+	case strings.HasPrefix(name, "header-augment.tar"):
+		a.HeaderAugment = &HeaderAugment{headerInfo: &HeaderInfo{}}
+		if _, err = io.Copy(raw, r); err != nil {
 			return err
 		}
+		if _, err = a.HeaderAugment.Write(raw.Bytes()); err != nil {
+			return err
+		}
+		a.HeaderAugment.raw = raw.Bytes()
+		trace(logger, "Parsed header-augment")
+	default:
+		return &UnexpectedSectionError{Section: name, Expected: "a standard Artifact section"}
 	}
-	// Need call next on `artifact`
-	// Expect `data`
-	log.Trace("Ready to read `Data`")
-	if filepath.Dir(hdr.Name) != "data" {
-		return fmt.Errorf("Expected `data`. Got %s", hdr.Name)
-	}
-	log.Trace("Data hdr: %s\n", hdr.Name)
-	log.Trace("Read all initial data, preparing to return Payloads\n")
-
 	return nil
 }
@@ -0,0 +1,217 @@
+package artifact_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+)
+
+// parse parses the Artifact b, which the caller has to close
+func parse(t *testing.T, b []byte, opts ...artifact.ParseOption) *artifact.Artifact {
+	t.Helper()
+	a, err := artifact.NewParser().Parse(bytes.NewReader(b), opts...)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	return a
+}
+
+// parseInfo returns the metadata of the Artifact b
+func parseInfo(t *testing.T, b []byte, opts ...artifact.ParseOption) artifact.ArtifactInfo {
+	t.Helper()
+	a := parse(t, b, opts...)
+	defer a.Close()
+	return a.Info()
+}
+
+// serialize writes a, and returns the Artifact written
+func serialize(t *testing.T, a *artifact.Artifact) []byte {
+	t.Helper()
+	buf := bytes.NewBuffer(nil)
+	if _, err := a.WriteTo(buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// scriptDir returns the directory the scripts of a were extracted to
+func scriptDir(t *testing.T, a *artifact.Artifact) string {
+	t.Helper()
+	names := a.HeaderTar.Scripts.Names()
+	if len(names) == 0 {
+		t.Fatal("The Artifact has no scripts")
+	}
+	return filepath.Dir(names[0])
+}
+
+// assertRemoved fails the test if dir still exists
+func assertRemoved(t *testing.T, dir string) {
+	t.Helper()
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("%s was not removed: %v", dir, err)
+	}
+}
+
+// readEntry returns the content of the entry name of the Artifact tar b
+func readEntry(t *testing.T, b []byte, name string) []byte {
+	t.Helper()
+	var content []byte
+	rewriteEntry(t, b, name, func(c []byte) []byte {
+		content = c
+		return c
+	})
+	return content
+}
+
+// rewriteEntry returns the Artifact b, with the content of the entry name
+// replaced by rewrite
+func rewriteEntry(t *testing.T, b []byte, name string, rewrite func([]byte) []byte) []byte {
+	t.Helper()
+	buf := bytes.NewBuffer(nil)
+	tr, tw := tar.NewReader(bytes.NewReader(b)), tar.NewWriter(buf)
+	found := false
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name == name {
+			content, found = rewrite(content), true
+			hdr.Size = int64(len(content))
+		}
+		if err = tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !found {
+		t.Fatalf("The Artifact has no %s", name)
+	}
+	return buf.Bytes()
+}
+
+// exportedDiff returns the path of the first exported field, reached from x
+// and y, which differs between them, or "" if they all are equal
+func exportedDiff(path string, x, y reflect.Value) string {
+	if x.IsValid() != y.IsValid() {
+		return path
+	}
+	if !x.IsValid() {
+		return ""
+	}
+	switch x.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if x.IsNil() || y.IsNil() {
+			if x.IsNil() != y.IsNil() {
+				return path
+			}
+			return ""
+		}
+		return exportedDiff(path, x.Elem(), y.Elem())
+	case reflect.Struct:
+		for i := 0; i < x.NumField(); i++ {
+			if x.Type().Field(i).PkgPath != "" {
+				continue
+			}
+			if diff := exportedDiff(path+"."+x.Type().Field(i).Name, x.Field(i), y.Field(i)); diff != "" {
+				return diff
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if x.Len() != y.Len() {
+			return path
+		}
+		for i := 0; i < x.Len(); i++ {
+			if diff := exportedDiff(fmt.Sprintf("%s[%d]", path, i), x.Index(i), y.Index(i)); diff != "" {
+				return diff
+			}
+		}
+	case reflect.Map:
+		if x.Len() != y.Len() {
+			return path
+		}
+		for _, key := range x.MapKeys() {
+			if diff := exportedDiff(fmt.Sprintf("%s[%v]", path, key), x.MapIndex(key), y.MapIndex(key)); diff != "" {
+				return diff
+			}
+		}
+	case reflect.Func:
+	default:
+		if x.Interface() != y.Interface() {
+			return path
+		}
+	}
+	return ""
+}
+
+// payloads returns the compressed payloads of a, by name
+func payloads(t *testing.T, a *artifact.Artifact) map[string][]byte {
+	t.Helper()
+	payloads := map[string][]byte{}
+	for i := 0; i < a.Data.PayloadCount(); i++ {
+		payload, err := a.Data.PayloadAt(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payloads[payload.Name] = payload.Data.Bytes()
+	}
+	return payloads
+}
+
+func TestRoundTrip(t *testing.T) {
+	b := testutil.MakeArtifact(t, testutil.ArtifactOptions{
+		ArtifactName: "release-1",
+		DeviceType:   "beaglebone",
+		Scripts:      map[string]string{"ArtifactInstall_Enter_00": "#!/bin/sh\necho enter\n"},
+		Payloads: []testutil.Payload{
+			{Filename: "rootfs.ext4", Content: []byte("rootfs")},
+			{Filename: "bootloader.img", Content: []byte("bootloader")},
+		},
+		Signed: true,
+	})
+	a := parse(t, b)
+	defer a.Close()
+	written := serialize(t, a)
+	if !bytes.Equal(written, b) {
+		t.Error("The serialized Artifact differs from the one parsed")
+	}
+
+	parsed := parse(t, written)
+	defer parsed.Close()
+	if diff := exportedDiff("Artifact", reflect.ValueOf(parsed), reflect.ValueOf(a)); diff != "" {
+		t.Errorf("%s differs after the round trip", diff)
+	}
+	if !reflect.DeepEqual(parsed.Info(), a.Info()) {
+		t.Errorf("Parsed %+v after the round trip, want %+v", parsed.Info(), a.Info())
+	}
+	if !reflect.DeepEqual(readScripts(t, parsed), readScripts(t, a)) {
+		t.Errorf("Parsed the scripts %v after the round trip, want %v", readScripts(t, parsed), readScripts(t, a))
+	}
+	if !reflect.DeepEqual(payloads(t, parsed), payloads(t, a)) {
+		t.Error("The payloads differ after the round trip")
+	}
+	// The sub-headers have no exported fields to compare
+	if !bytes.Equal(serialize(t, parsed), written) {
+		t.Error("The Artifact serializes differently after the round trip")
+	}
+}
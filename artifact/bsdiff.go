@@ -0,0 +1,317 @@
+package artifact
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/dsnet/compress/bzip2"
+	"github.com/pkg/errors"
+)
+
+// bsdiff creates a BSDIFF40 patch from obuf to nbuf, which can be applied
+// with bspatch. This is a port of bsdiff 4.3 by Colin Percival.
+func bsdiff(obuf, nbuf []byte) ([]byte, error) {
+	I := qsufsort(obuf)
+	db := make([]byte, len(nbuf))
+	eb := make([]byte, len(nbuf))
+	var dblen, eblen int
+	ctrl := bytes.NewBuffer(nil)
+
+	var scan, pos, length int
+	var lastscan, lastpos, lastoffset int
+	for scan < len(nbuf) {
+		oldscore := 0
+		scan += length
+		for scsc := scan; scan < len(nbuf); scan++ {
+			pos, length = search(I, obuf, nbuf[scan:], 0, len(obuf))
+			for ; scsc < scan+length; scsc++ {
+				if scsc+lastoffset < len(obuf) && obuf[scsc+lastoffset] == nbuf[scsc] {
+					oldscore++
+				}
+			}
+			if (length == oldscore && length != 0) || length > oldscore+8 {
+				break
+			}
+			if scan+lastoffset < len(obuf) && obuf[scan+lastoffset] == nbuf[scan] {
+				oldscore--
+			}
+		}
+		if length == oldscore && scan != len(nbuf) {
+			continue
+		}
+
+		var s, sf, lenf int
+		for i := 0; lastscan+i < scan && lastpos+i < len(obuf); {
+			if obuf[lastpos+i] == nbuf[lastscan+i] {
+				s++
+			}
+			i++
+			if s*2-i > sf*2-lenf {
+				sf, lenf = s, i
+			}
+		}
+
+		lenb := 0
+		if scan < len(nbuf) {
+			var s, sb int
+			for i := 1; scan >= lastscan+i && pos >= i; i++ {
+				if obuf[pos-i] == nbuf[scan-i] {
+					s++
+				}
+				if s*2-i > sb*2-lenb {
+					sb, lenb = s, i
+				}
+			}
+		}
+
+		if lastscan+lenf > scan-lenb {
+			overlap := (lastscan + lenf) - (scan - lenb)
+			var s, ss, lens int
+			for i := 0; i < overlap; i++ {
+				if nbuf[lastscan+lenf-overlap+i] == obuf[lastpos+lenf-overlap+i] {
+					s++
+				}
+				if nbuf[scan-lenb+i] == obuf[pos-lenb+i] {
+					s--
+				}
+				if s > ss {
+					ss, lens = s, i+1
+				}
+			}
+			lenf += lens - overlap
+			lenb -= lens
+		}
+
+		for i := 0; i < lenf; i++ {
+			db[dblen+i] = nbuf[lastscan+i] - obuf[lastpos+i]
+		}
+		extra := (scan - lenb) - (lastscan + lenf)
+		copy(eb[eblen:], nbuf[lastscan+lenf:lastscan+lenf+extra])
+		dblen += lenf
+		eblen += extra
+
+		var buf [24]byte
+		offtout(lenf, buf[0:8])
+		offtout(extra, buf[8:16])
+		offtout((pos-lenb)-(lastpos+lenf), buf[16:24])
+		ctrl.Write(buf[:])
+
+		lastscan = scan - lenb
+		lastpos = pos - lenb
+		lastoffset = pos - scan
+	}
+
+	ctrlBlock, err := bzip2Compress(ctrl.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	diffBlock, err := bzip2Compress(db[:dblen])
+	if err != nil {
+		return nil, err
+	}
+	extraBlock, err := bzip2Compress(eb[:eblen])
+	if err != nil {
+		return nil, err
+	}
+	patch := bytes.NewBuffer(nil)
+	var header [32]byte
+	copy(header[0:8], "BSDIFF40")
+	offtout(len(ctrlBlock), header[8:16])
+	offtout(len(diffBlock), header[16:24])
+	offtout(len(nbuf), header[24:32])
+	patch.Write(header[:])
+	patch.Write(ctrlBlock)
+	patch.Write(diffBlock)
+	patch.Write(extraBlock)
+	return patch.Bytes(), nil
+}
+
+func bzip2Compress(b []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	zw, err := bzip2.NewWriter(buf, &bzip2.WriterConfig{Level: bzip2.BestCompression})
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(zw, bytes.NewReader(b)); err != nil {
+		return nil, errors.Wrap(err, "Failed to bzip2 compress")
+	}
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// offtout writes x as a sign-magnitude little endian integer
+func offtout(x int, buf []byte) {
+	var y uint64
+	if x < 0 {
+		y = uint64(-x) | 1<<63
+	} else {
+		y = uint64(x)
+	}
+	binary.LittleEndian.PutUint64(buf, y)
+}
+
+func matchlen(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// search returns the position in obuf, and the length, of the longest prefix
+// of nbuf found in obuf
+func search(I []int, obuf, nbuf []byte, st, en int) (pos, n int) {
+	if en-st < 2 {
+		x := matchlen(obuf[I[st]:], nbuf)
+		y := matchlen(obuf[I[en]:], nbuf)
+		if x > y {
+			return I[st], x
+		}
+		return I[en], y
+	}
+	x := st + (en-st)/2
+	o := obuf[I[x]:]
+	if len(o) > len(nbuf) {
+		o = o[:len(nbuf)]
+	}
+	if bytes.Compare(o, nbuf[:len(o)]) < 0 {
+		return search(I, obuf, nbuf, x, en)
+	}
+	return search(I, obuf, nbuf, st, x)
+}
+
+// qsufsort returns the suffix array of obuf (Larsson and Sadakane)
+func qsufsort(obuf []byte) []int {
+	var buckets [256]int
+	I := make([]int, len(obuf)+1)
+	V := make([]int, len(obuf)+1)
+
+	for _, c := range obuf {
+		buckets[c]++
+	}
+	for i := 1; i < 256; i++ {
+		buckets[i] += buckets[i-1]
+	}
+	copy(buckets[1:], buckets[:255])
+	buckets[0] = 0
+
+	for i, c := range obuf {
+		buckets[c]++
+		I[buckets[c]] = i
+	}
+	I[0] = len(obuf)
+	for i, c := range obuf {
+		V[i] = buckets[c]
+	}
+	V[len(obuf)] = 0
+	for i := 1; i < 256; i++ {
+		if buckets[i] == buckets[i-1]+1 {
+			I[buckets[i]] = -1
+		}
+	}
+	I[0] = -1
+
+	for h := 1; I[0] != -(len(obuf) + 1); h += h {
+		n := 0
+		i := 0
+		for i < len(obuf)+1 {
+			if I[i] < 0 {
+				n -= I[i]
+				i -= I[i]
+			} else {
+				if n != 0 {
+					I[i-n] = -n
+				}
+				n = V[I[i]] + 1 - i
+				split(I, V, i, n, h)
+				i += n
+				n = 0
+			}
+		}
+		if n != 0 {
+			I[i-n] = -n
+		}
+	}
+
+	for i := 0; i < len(obuf)+1; i++ {
+		I[V[i]] = i
+	}
+	return I
+}
+
+func split(I, V []int, start, length, h int) {
+	if length < 16 {
+		for k := start; k < start+length; {
+			j := 1
+			x := V[I[k]+h]
+			for i := 1; k+i < start+length; i++ {
+				if V[I[k+i]+h] < x {
+					x = V[I[k+i]+h]
+					j = 0
+				}
+				if V[I[k+i]+h] == x {
+					I[k+i], I[k+j] = I[k+j], I[k+i]
+					j++
+				}
+			}
+			for i := 0; i < j; i++ {
+				V[I[k+i]] = k + j - 1
+			}
+			if j == 1 {
+				I[k] = -1
+			}
+			k += j
+		}
+		return
+	}
+
+	x := V[I[start+length/2]+h]
+	var jj, kk int
+	for i := start; i < start+length; i++ {
+		if V[I[i]+h] < x {
+			jj++
+		}
+		if V[I[i]+h] == x {
+			kk++
+		}
+	}
+	jj += start
+	kk += jj
+
+	i, j, k := start, 0, 0
+	for i < jj {
+		if V[I[i]+h] < x {
+			i++
+		} else if V[I[i]+h] == x {
+			I[i], I[jj+j] = I[jj+j], I[i]
+			j++
+		} else {
+			I[i], I[kk+k] = I[kk+k], I[i]
+			k++
+		}
+	}
+	for jj+j < kk {
+		if V[I[jj+j]+h] == x {
+			j++
+		} else {
+			I[jj+j], I[kk+k] = I[kk+k], I[jj+j]
+			k++
+		}
+	}
+
+	if jj > start {
+		split(I, V, start, jj-start, h)
+	}
+	for i := 0; i < kk-jj; i++ {
+		V[I[jj+i]] = kk - 1
+	}
+	if jj == kk-1 {
+		I[jj] = -1
+	}
+	if start+length > kk {
+		split(I, V, kk, start+length-kk, h)
+	}
+}
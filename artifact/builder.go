@@ -0,0 +1,838 @@
+package artifact
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ArtifactBuilder creates a mender-artifact from its parts.
+//
+//	err := NewArtifactBuilder().
+//		WithArtifactName("release-1").
+//		WithDeviceTypes("beaglebone").
+//		WithPayload("rootfs-image", "rootfs.ext4", image).
+//		Build(w)
+//
+// Errors are deferred, and returned from Build.
+type ArtifactBuilder struct {
+	version     int
+	name        string
+	group       string
+	deviceTypes []string
+	dependsOn   []string
+	compression CompressionAlgo
+	gzipLevel   int
+	scripts     []builderFile
+	payloads    []builderPayload
+	extra       []ManifestData
+	extraFiles  map[string]io.Reader
+	provides    map[string]interface{}
+	sections    []builderSection
+	signer      Signer
+
+	// The additional depends of a header-info.json, for NewFromDirectory
+	dependsGroups []string
+	depends       map[string]interface{}
+
+	// Receives the metrics of the sections as they are written, if set
+	metrics MetricsCollector
+
+	err error
+}
+
+type builderFile struct {
+	name string
+	r    io.Reader
+}
+
+type builderSection struct {
+	file     builderFile
+	manifest bool
+}
+
+type builderPayload struct {
+	payloadType string
+	file        builderFile
+	typeInfo    *TypeInfo // Overrides the generated type-info
+	metaData    map[string]interface{}
+	// checksum is set for payloads which are spooled to disk, and not
+	// read into memory
+	checksum string
+}
+
+// NewArtifactBuilder returns a builder for a version 3, gzip compressed Artifact
+func NewArtifactBuilder() *ArtifactBuilder {
+	return &ArtifactBuilder{
+		version:     3,
+		compression: CompressionGzip,
+		gzipLevel:   gzip.DefaultCompression,
+		extraFiles:  map[string]io.Reader{},
+		provides:    map[string]interface{}{},
+	}
+}
+
+// WithVersion sets the format version of the Artifact, either 2 or 3
+func (b *ArtifactBuilder) WithVersion(v int) *ArtifactBuilder {
+	if v != FormatVersion2 && v != FormatVersion3 {
+		b.setErr(fmt.Errorf("Unsupported Artifact version: %d", v))
+	}
+	b.version = v
+	return b
+}
+
+func (b *ArtifactBuilder) WithArtifactName(name string) *ArtifactBuilder {
+	b.name = name
+	return b
+}
+
+func (b *ArtifactBuilder) WithArtifactGroup(group string) *ArtifactBuilder {
+	b.group = group
+	return b
+}
+
+// WithDeviceTypes sets the device types the Artifact is compatible with
+func (b *ArtifactBuilder) WithDeviceTypes(deviceTypes ...string) *ArtifactBuilder {
+	b.deviceTypes = append(b.deviceTypes, deviceTypes...)
+	return b
+}
+
+// WithDependsArtifactNames sets the names of the Artifacts which have to be
+// installed on the device for the Artifact to be installable
+func (b *ArtifactBuilder) WithDependsArtifactNames(names ...string) *ArtifactBuilder {
+	b.dependsOn = append(b.dependsOn, names...)
+	return b
+}
+
+func (b *ArtifactBuilder) WithCompression(algo CompressionAlgo) *ArtifactBuilder {
+	if !algo.supported() {
+		b.setErr(fmt.Errorf("Unsupported compression: %s", algo))
+	}
+	b.compression = algo
+	return b
+}
+
+// WithGzipLevel sets the level the header, and the payloads, are compressed
+// with, when using gzip. The level is one of gzip.HuffmanOnly through
+// gzip.BestCompression, and the default is gzip.DefaultCompression.
+func (b *ArtifactBuilder) WithGzipLevel(level int) *ArtifactBuilder {
+	if err := checkGzipLevel(level); err != nil {
+		b.setErr(err)
+	}
+	b.gzipLevel = level
+	return b
+}
+
+// WithScript adds the state script name, read from r, to the header
+func (b *ArtifactBuilder) WithScript(name string, r io.Reader) *ArtifactBuilder {
+	b.scripts = append(b.scripts, builderFile{name: name, r: r})
+	return b
+}
+
+// WithPayload adds a payload of payloadType, holding the file filename read
+// from r. The payloads are numbered in the order they are added.
+func (b *ArtifactBuilder) WithPayload(payloadType, filename string, r io.Reader) *ArtifactBuilder {
+	b.payloads = append(b.payloads, builderPayload{
+		payloadType: payloadType,
+		file:        builderFile{name: filename, r: r},
+	})
+	return b
+}
+
+// WithPayloadStreaming adds a payload like WithPayload, but the file is
+// spooled through temporary files, and never held in memory. checksum is the
+// expected SHA256 of the file, and Build fails with a ChecksumMismatchError if
+// the file does not match it.
+func (b *ArtifactBuilder) WithPayloadStreaming(payloadType, filename string, r io.Reader, checksum string) *ArtifactBuilder {
+	b.payloads = append(b.payloads, builderPayload{
+		payloadType: payloadType,
+		file:        builderFile{name: filename, r: r},
+		checksum:    checksum,
+	})
+	return b
+}
+
+// WithPayloadMetaData sets the meta-data of the payload index, in the order
+// the payloads were added
+func (b *ArtifactBuilder) WithPayloadMetaData(index int, data map[string]interface{}) *ArtifactBuilder {
+	if index < 0 || index >= len(b.payloads) {
+		b.setErr(fmt.Errorf("No payload %d", index))
+		return b
+	}
+	b.payloads[index].metaData = data
+	return b
+}
+
+// WithPayloadTypeInfo sets the artifact_provides, and artifact_depends, of
+// the type-info of the payload index, ie, for custom payload types. For a
+// rootfs-image payload the rootfs_image_checksum is still filled in, unless
+// provides has it.
+func (b *ArtifactBuilder) WithPayloadTypeInfo(index int, provides, depends map[string]interface{}) *ArtifactBuilder {
+	if index < 0 || index >= len(b.payloads) {
+		b.setErr(fmt.Errorf("No payload %d", index))
+		return b
+	}
+	typeInfo := map[string]interface{}{"type": b.payloads[index].payloadType}
+	if provides != nil {
+		typeInfo["artifact_provides"] = provides
+	}
+	if depends != nil {
+		typeInfo["artifact_depends"] = depends
+	}
+	data, err := json.Marshal(typeInfo)
+	if err != nil {
+		b.setErr(errors.Wrapf(err, "Invalid type-info for the payload %d", index))
+		return b
+	}
+	b.payloads[index].typeInfo = &TypeInfo{}
+	if err = json.Unmarshal(data, b.payloads[index].typeInfo); err != nil {
+		b.setErr(errors.Wrapf(err, "Invalid type-info for the payload %d", index))
+	}
+	return b
+}
+
+// WithSigner signs the manifest with signer, and adds the signature to the
+// Artifact as manifest.sig. Both RSA, and ECDSA, keys are supported.
+func (b *ArtifactBuilder) WithSigner(signer Signer) *ArtifactBuilder {
+	b.signer = signer
+	return b
+}
+
+// WithMetricsCollector reports the sizes of every section, and the time it
+// took to create, and write, it to mc
+func (b *ArtifactBuilder) WithMetricsCollector(mc MetricsCollector) *ArtifactBuilder {
+	b.metrics = mc
+	return b
+}
+
+// WithExtraManifestEntry adds an entry for a file which is not one of the
+// standard Artifact sections to the manifest. The file itself has to be
+// registered through WithExtraFile, and is written to the Artifact tar after
+// the payloads. Build fails if the content does not match the checksum, and
+// so does Parse.
+func (b *ArtifactBuilder) WithExtraManifestEntry(filename, sha256 string) *ArtifactBuilder {
+	b.extra = append(b.extra, ManifestData{Signature: sha256, Name: filename})
+	return b
+}
+
+// WithExtraFile registers the content of an extra file added to the
+// manifest with WithExtraManifestEntry
+func (b *ArtifactBuilder) WithExtraFile(filename string, r io.Reader) *ArtifactBuilder {
+	b.extraFiles[filename] = r
+	return b
+}
+
+// artifactInfo is the artifact-info.json read by FromDirectory
+type artifactInfo struct {
+	ArtifactName  string   `json:"artifact_name"`
+	ArtifactGroup string   `json:"artifact_group"`
+	DeviceTypes   []string `json:"device_types"`
+}
+
+// FromDirectory configures the builder from a build output directory:
+//
+//	artifact-info.json  the artifact_name, artifact_group, and device_types
+//	*.ext4              rootfs-image payloads
+//	scripts/*.sh        state scripts, named by the file without .sh
+func (b *ArtifactBuilder) FromDirectory(dir string) (*ArtifactBuilder, error) {
+	content, err := ioutil.ReadFile(filepath.Join(dir, "artifact-info.json"))
+	if err != nil {
+		return nil, errors.Wrap(err, "FromDirectory")
+	}
+	info := artifactInfo{}
+	if err = json.Unmarshal(content, &info); err != nil {
+		return nil, errors.Wrap(err, "FromDirectory: Failed to parse artifact-info.json")
+	}
+	b.WithArtifactName(info.ArtifactName).
+		WithArtifactGroup(info.ArtifactGroup).
+		WithDeviceTypes(info.DeviceTypes...)
+
+	images, err := filepath.Glob(filepath.Join(dir, "*.ext4"))
+	if err != nil {
+		return nil, errors.Wrap(err, "FromDirectory")
+	}
+	for _, image := range images {
+		if content, err = ioutil.ReadFile(image); err != nil {
+			return nil, errors.Wrap(err, "FromDirectory")
+		}
+		b.WithPayload("rootfs-image", filepath.Base(image), bytes.NewReader(content))
+	}
+
+	scripts, err := filepath.Glob(filepath.Join(dir, "scripts", "*.sh"))
+	if err != nil {
+		return nil, errors.Wrap(err, "FromDirectory")
+	}
+	for _, script := range scripts {
+		if content, err = ioutil.ReadFile(script); err != nil {
+			return nil, errors.Wrap(err, "FromDirectory")
+		}
+		b.WithScript(strings.TrimSuffix(filepath.Base(script), ".sh"), bytes.NewReader(content))
+	}
+	return b, nil
+}
+
+// ErrMissingRequiredFile is returned by NewFromDirectory for a directory
+// lacking any of the files an Artifact needs
+var ErrMissingRequiredFile = errors.New("Missing required file")
+
+// NewFromDirectory creates an Artifact from a directory laid out like the
+// Artifact itself:
+//
+//	version.json      the format, and version, ie, {"format":"mender","version":3}
+//	header-info.json  the payloads, and the artifact_provides, and artifact_depends
+//	scripts/*         state scripts
+//	data/0000/*       the file of the first payload in header-info.json
+//	data/0001/*       ...
+//
+// ErrMissingRequiredFile is returned, with the names of the missing files,
+// if version.json, header-info.json, or the file of any payload, is missing.
+// The Artifact is built, and parsed with opts, and is ready to be written.
+func NewFromDirectory(dir string, opts ...Option) (*Artifact, error) {
+	var missing []string
+	read := func(name string, v interface{}) error {
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			missing = append(missing, name)
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "NewFromDirectory")
+		}
+		return errors.Wrapf(json.Unmarshal(content, v), "NewFromDirectory: Failed to parse %s", name)
+	}
+	version := Version{}
+	if err := read("version.json", &version); err != nil {
+		return nil, err
+	}
+	info := HeaderInfo{}
+	if err := read("header-info.json", &info); err != nil {
+		return nil, err
+	}
+	b := NewArtifactBuilder()
+	for i, payload := range info.Payloads {
+		name := fmt.Sprintf("data/%04d", i)
+		files, err := ioutil.ReadDir(filepath.Join(dir, name))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrap(err, "NewFromDirectory")
+		}
+		if len(files) == 0 {
+			missing = append(missing, name+"/*")
+			continue
+		}
+		if len(files) > 1 || !files[0].Mode().IsRegular() {
+			return nil, fmt.Errorf("NewFromDirectory: %s has to hold a single file", name)
+		}
+		content, err := ioutil.ReadFile(filepath.Join(dir, name, files[0].Name()))
+		if err != nil {
+			return nil, errors.Wrap(err, "NewFromDirectory")
+		}
+		b.WithPayload(payload.Type, files[0].Name(), bytes.NewReader(content))
+	}
+	if len(missing) > 0 {
+		return nil, errors.Wrapf(ErrMissingRequiredFile, "NewFromDirectory: %s", strings.Join(missing, ", "))
+	}
+	if version.Format != "mender" {
+		return nil, fmt.Errorf("NewFromDirectory: Unexpected format: %q, expected mender", version.Format)
+	}
+
+	scripts, err := ioutil.ReadDir(filepath.Join(dir, "scripts"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "NewFromDirectory")
+	}
+	for _, script := range scripts {
+		if !script.Mode().IsRegular() {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(dir, "scripts", script.Name()))
+		if err != nil {
+			return nil, errors.Wrap(err, "NewFromDirectory")
+		}
+		b.WithScript(script.Name(), bytes.NewReader(content))
+	}
+
+	provides, depends := info.ArtifactProvides, info.ArtifactDepends
+	b.WithVersion(version.Version).
+		WithArtifactName(provides.ArtifactName).
+		WithArtifactGroup(provides.ArtifactGroup).
+		WithDeviceTypes(depends.DeviceType...).
+		WithDependsArtifactNames(depends.ArtifactName...)
+	for k, v := range provides.Extra {
+		b.provides[k] = v
+	}
+	b.dependsGroups, b.depends = depends.ArtifactGroup, depends.Extra
+
+	buf := bytes.NewBuffer(nil)
+	if err = b.Build(buf); err != nil {
+		return nil, errors.Wrap(err, "NewFromDirectory")
+	}
+	a := New(opts...)
+	if err = a.Parse(buf); err != nil {
+		a.Close()
+		return nil, errors.Wrap(err, "NewFromDirectory: Failed to parse the Artifact")
+	}
+	return a, nil
+}
+
+// WithProvenance records who built the Artifact, and with what, in the
+// Artifact provides. The creation time is set to now.
+func (b *ArtifactBuilder) WithProvenance(createdBy, toolVersion, buildID string) *ArtifactBuilder {
+	b.provides[ProvenanceCreatedBy] = createdBy
+	b.provides[ProvenanceToolVersion] = toolVersion
+	b.provides[ProvenanceBuildID] = buildID
+	b.provides[ProvenanceCreatedAt] = time.Now().UTC().Format(time.RFC3339)
+	return b
+}
+
+// WithCustomSection adds the section name, read from r, to the Artifact tar
+// after the payloads. If addToManifest is set, the checksum of the section is
+// added to the manifest, and verified by Parse. Otherwise, the section is not
+// verified.
+func (b *ArtifactBuilder) WithCustomSection(name string, r io.Reader, addToManifest bool) *ArtifactBuilder {
+	b.sections = append(b.sections, builderSection{
+		file:     builderFile{name: name, r: r},
+		manifest: addToManifest,
+	})
+	return b
+}
+
+func (b *ArtifactBuilder) setErr(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// Build writes the Artifact to w
+func (b *ArtifactBuilder) Build(w io.Writer) error {
+	if b.err != nil {
+		return errors.Wrap(b.err, "ArtifactBuilder")
+	}
+	if b.name == "" {
+		return errors.New("ArtifactBuilder: The Artifact needs a name")
+	}
+	if len(b.deviceTypes) == 0 {
+		return errors.New("ArtifactBuilder: The Artifact needs at least one device type")
+	}
+	if len(b.payloads) == 0 {
+		return errors.New("ArtifactBuilder: The Artifact needs at least one payload")
+	}
+	if b.version == FormatVersion2 && (b.group != "" || len(b.dependsOn) > 0 || len(b.provides) > 0 ||
+		len(b.dependsGroups) > 0 || len(b.depends) > 0) {
+		return errors.New("ArtifactBuilder: Version 2 Artifacts have no groups, depends, nor additional provides")
+	}
+	manifest := &Manifest{}
+
+	version, err := json.Marshal(Version{Format: "mender", Version: b.version})
+	if err != nil {
+		return errors.Wrap(err, "ArtifactBuilder: Failed to marshal the version")
+	}
+	manifest.Data = append(manifest.Data, manifestEntry("version", version))
+
+	// Read the payloads first, as their checksums go into the header
+	payloads := make([][]byte, len(b.payloads))
+	spooled := make([]*os.File, len(b.payloads))
+	defer func() {
+		for _, f := range spooled {
+			if f != nil {
+				f.Close()
+				os.Remove(f.Name())
+			}
+		}
+	}()
+	typeInfos := make([]TypeInfo, len(b.payloads))
+	metrics := make([]sectionMetrics, len(b.payloads))
+	for i, payload := range b.payloads {
+		start := time.Now()
+		name := fmt.Sprintf("data/%04d/%s", i, payload.file.name)
+		var content []byte
+		var entry ManifestData
+		if payload.checksum != "" {
+			if spooled[i], metrics[i].uncompressed, err = b.spool(name, payload); err != nil {
+				return errors.Wrap(err, "ArtifactBuilder")
+			}
+			metrics[i].duration = time.Since(start)
+			entry = ManifestData{Signature: payload.checksum, Name: name}
+		} else {
+			if content, err = ioutil.ReadAll(payload.file.r); err != nil {
+				return errors.Wrapf(err, "ArtifactBuilder: Failed to read the payload %s", payload.file.name)
+			}
+			entry = manifestEntry(name, content)
+		}
+		manifest.Data = append(manifest.Data, entry)
+		typeInfos[i] = TypeInfo{Type: payload.payloadType}
+		if payload.typeInfo != nil {
+			typeInfos[i] = *payload.typeInfo
+		}
+		if payload.payloadType == "rootfs-image" && b.version != FormatVersion2 &&
+			typeInfos[i].TypeInfoProvides.RootfsImageChecksum == "" {
+			typeInfos[i].TypeInfoProvides.RootfsImageChecksum = entry.Signature
+		}
+		if spooled[i] != nil {
+			continue
+		}
+		if payloads[i], metrics[i].uncompressed, err = b.compress([]builderFile{
+			{name: payload.file.name, r: bytes.NewReader(content)}}); err != nil {
+			return errors.Wrapf(err, "ArtifactBuilder: Failed to create the payload %s", payload.file.name)
+		}
+		metrics[i].duration = time.Since(start)
+	}
+
+	start := time.Now()
+	header, headerSize, err := b.header(typeInfos)
+	if err != nil {
+		return errors.Wrap(err, "ArtifactBuilder: Failed to create the header")
+	}
+	headerMetrics := sectionMetrics{uncompressed: headerSize, duration: time.Since(start)}
+	headerName := "header.tar" + b.compression.Extension()
+	manifest.Data = append(manifest.Data, manifestEntry(headerName, header))
+
+	extraFiles := make([][]byte, len(b.extra))
+	for i, entry := range b.extra {
+		r, ok := b.extraFiles[entry.Name]
+		if !ok {
+			return fmt.Errorf("ArtifactBuilder: No file registered for the manifest entry %s", entry.Name)
+		}
+		if extraFiles[i], err = ioutil.ReadAll(r); err != nil {
+			return errors.Wrapf(err, "ArtifactBuilder: Failed to read %s", entry.Name)
+		}
+		if sum := manifestEntry(entry.Name, extraFiles[i]).Signature; sum != entry.Signature {
+			return fmt.Errorf("ArtifactBuilder: Checksum mismatch for %s. Expected %s, got %s",
+				entry.Name, entry.Signature, sum)
+		}
+		manifest.Data = append(manifest.Data, entry)
+	}
+	sections := make([][]byte, len(b.sections))
+	for i, section := range b.sections {
+		if sections[i], err = ioutil.ReadAll(section.file.r); err != nil {
+			return errors.Wrapf(err, "ArtifactBuilder: Failed to read %s", section.file.name)
+		}
+		if section.manifest {
+			manifest.Data = append(manifest.Data, manifestEntry(section.file.name, sections[i]))
+		}
+	}
+	sort.Slice(manifest.Data, func(i, j int) bool {
+		return manifest.Data[i].Name < manifest.Data[j].Name
+	})
+
+	tw := tar.NewWriter(w)
+	// writeSection writes, and reports the metrics of, an in memory section
+	writeSection := func(name string, content []byte, m sectionMetrics) error {
+		start := time.Now()
+		if err := writeTarEntry(tw, name, content); err != nil {
+			return err
+		}
+		b.recordSection(name, int64(len(content)), m, start)
+		return nil
+	}
+	if err = writeSection("version", version, sectionMetrics{}); err != nil {
+		return err
+	}
+	// The manifest is hashed for the signature as it is written
+	start = time.Now()
+	sum := sha256.New()
+	manifestSize := &countingWriter{w: sum}
+	if err = writeManifest(tw, manifest.Data, manifestSize); err != nil {
+		return err
+	}
+	b.recordSection("manifest", manifestSize.n, sectionMetrics{}, start)
+	if b.signer != nil {
+		start = time.Now()
+		raw, err := b.signer.Sign(rand.Reader, sum.Sum(nil), crypto.SHA256)
+		if err != nil {
+			return errors.Wrap(err, "ArtifactBuilder: Failed to sign the manifest")
+		}
+		sig := []byte(base64.StdEncoding.EncodeToString(raw))
+		if err = writeSection("manifest.sig", sig, sectionMetrics{duration: time.Since(start)}); err != nil {
+			return err
+		}
+	}
+	if err = writeSection(headerName, header, headerMetrics); err != nil {
+		return err
+	}
+	for i, payload := range payloads {
+		name := fmt.Sprintf("data/%04d.tar%s", i, b.compression.Extension())
+		if spooled[i] == nil {
+			if err = writeSection(name, payload, metrics[i]); err != nil {
+				return err
+			}
+			continue
+		}
+		start = time.Now()
+		info, err := spooled[i].Stat()
+		if err != nil {
+			return errors.Wrapf(err, "ArtifactBuilder: Failed to write %s", name)
+		}
+		if err = writeTarFile(tw, name, spooled[i]); err != nil {
+			return err
+		}
+		b.recordSection(name, info.Size(), metrics[i], start)
+	}
+	for i, entry := range b.extra {
+		if err = writeSection(entry.Name, extraFiles[i], sectionMetrics{}); err != nil {
+			return err
+		}
+	}
+	for i, section := range b.sections {
+		if err = writeSection(section.file.name, sections[i], sectionMetrics{}); err != nil {
+			return err
+		}
+	}
+	return errors.Wrap(tw.Close(), "ArtifactBuilder: Failed to close the Artifact")
+}
+
+// header creates the compressed header tar, and returns it along with its
+// uncompressed size
+func (b *ArtifactBuilder) header(typeInfos []TypeInfo) ([]byte, int64, error) {
+	info := HeaderInfo{
+		ArtifactProvides: ArtifactProvides{
+			ArtifactName:  b.name,
+			ArtifactGroup: b.group,
+			Extra:         b.provides,
+		},
+		ArtifactDepends: ArtifactDepends{
+			ArtifactName:  b.dependsOn,
+			DeviceType:    b.deviceTypes,
+			ArtifactGroup: b.dependsGroups,
+			Extra:         b.depends,
+		},
+	}
+	for _, payload := range b.payloads {
+		info.Payloads = append(info.Payloads, Payload{Type: payload.payloadType})
+	}
+	if b.version == FormatVersion2 {
+		return b.headerV2(info, typeInfos)
+	}
+	infoJSON, err := json.Marshal(info)
+	if err != nil {
+		return nil, 0, err
+	}
+	files := []builderFile{{name: "header-info", r: bytes.NewReader(infoJSON)}}
+	files = append(files, b.scriptFiles()...)
+	for i, typeInfo := range typeInfos {
+		typeInfoJSON, err := json.Marshal(typeInfo)
+		if err != nil {
+			return nil, 0, err
+		}
+		files = append(files, builderFile{
+			name: fmt.Sprintf("headers/%04d/type-info", i),
+			r:    bytes.NewReader(typeInfoJSON),
+		})
+		if metaData := b.payloads[i].metaData; metaData != nil {
+			metaDataJSON, err := json.Marshal(metaData)
+			if err != nil {
+				return nil, 0, err
+			}
+			files = append(files, builderFile{
+				name: fmt.Sprintf("headers/%04d/meta-data", i),
+				r:    bytes.NewReader(metaDataJSON),
+			})
+		}
+	}
+	return b.compress(files)
+}
+
+// scriptFiles returns the scripts to write to the header tar, sorted by name,
+// keeping b.scripts in the order they were added
+func (b *ArtifactBuilder) scriptFiles() []builderFile {
+	files := make([]builderFile, 0, len(b.scripts))
+	for _, script := range b.scripts {
+		files = append(files, builderFile{name: "scripts/" + script.name, r: script.r})
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].name < files[j].name })
+	return files
+}
+
+// headerV2 creates the compressed header tar of a version 2 Artifact
+func (b *ArtifactBuilder) headerV2(info HeaderInfo, typeInfos []TypeInfo) ([]byte, int64, error) {
+	infoJSON, err := info.marshalV2()
+	if err != nil {
+		return nil, 0, err
+	}
+	files := []builderFile{{name: "header-info", r: bytes.NewReader(infoJSON)}}
+	files = append(files, b.scriptFiles()...)
+	for i, typeInfo := range typeInfos {
+		filesJSON, err := json.Marshal(map[string][]string{"files": {b.payloads[i].file.name}})
+		if err != nil {
+			return nil, 0, err
+		}
+		typeInfoJSON, err := json.Marshal(map[string]string{"type": typeInfo.Type})
+		if err != nil {
+			return nil, 0, err
+		}
+		files = append(files,
+			builderFile{name: fmt.Sprintf("headers/%04d/files", i), r: bytes.NewReader(filesJSON)},
+			builderFile{name: fmt.Sprintf("headers/%04d/type-info", i), r: bytes.NewReader(typeInfoJSON)})
+	}
+	return b.compress(files)
+}
+
+// compress writes the files to a tar, compressed with the builders
+// compression, and returns it along with the size of the uncompressed tar
+func (b *ArtifactBuilder) compress(files []builderFile) ([]byte, int64, error) {
+	buf := bytes.NewBuffer(nil)
+	zw, err := b.compression.newWriterLevel(buf, b.gzipLevel)
+	if err != nil {
+		return nil, 0, err
+	}
+	cw := &countingWriter{w: zw}
+	tw := tar.NewWriter(cw)
+	for _, file := range files {
+		content, err := ioutil.ReadAll(file.r)
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "Failed to read %s", file.name)
+		}
+		if err = writeTarEntry(tw, file.name, content); err != nil {
+			return nil, 0, err
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return nil, 0, err
+	}
+	if err = zw.Close(); err != nil {
+		return nil, 0, err
+	}
+	return buf.Bytes(), cw.n, nil
+}
+
+// spool writes the payload, compressed, to a temporary file, without reading
+// it into memory, and verifies its checksum. The file is left open, at its
+// start, and returned along with the size of the uncompressed tar.
+func (b *ArtifactBuilder) spool(name string, payload builderPayload) (*os.File, int64, error) {
+	// The size of the file goes before the file in the payload tar, and so
+	// it is spooled as is first
+	raw, err := ioutil.TempFile("", "mender-payload-")
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		raw.Close()
+		os.Remove(raw.Name())
+	}()
+	sum := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(raw, sum), payload.file.r); err != nil {
+		return nil, 0, errors.Wrapf(err, "Failed to read the payload %s", payload.file.name)
+	}
+	if actual := hex.EncodeToString(sum.Sum(nil)); actual != payload.checksum {
+		return nil, 0, &ChecksumMismatchError{Filename: name, Expected: payload.checksum, Actual: actual}
+	}
+	if _, err = raw.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+
+	compressed, err := ioutil.TempFile("", "mender-payload-")
+	if err != nil {
+		return nil, 0, err
+	}
+	cw := &countingWriter{}
+	err = func() error {
+		zw, err := b.compression.newWriterLevel(compressed, b.gzipLevel)
+		if err != nil {
+			return err
+		}
+		cw.w = zw
+		tw := tar.NewWriter(cw)
+		if err = writeTarFile(tw, payload.file.name, raw); err != nil {
+			return err
+		}
+		if err = tw.Close(); err != nil {
+			return err
+		}
+		if err = zw.Close(); err != nil {
+			return err
+		}
+		_, err = compressed.Seek(0, io.SeekStart)
+		return err
+	}()
+	if err != nil {
+		compressed.Close()
+		os.Remove(compressed.Name())
+		return nil, 0, errors.Wrapf(err, "Failed to create the payload %s", payload.file.name)
+	}
+	return compressed, cw.n, nil
+}
+
+// writeTarFile writes the file f, from its current position, as name to tw
+func writeTarFile(tw *tar.Writer, name string, f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	offset, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     info.Size() - offset,
+		Typeflag: tar.TypeReg,
+	}
+	if err = tw.WriteHeader(hdr); err != nil {
+		return errors.Wrapf(err, "Failed to write the tar header for %s", name)
+	}
+	if _, err = io.Copy(tw, f); err != nil {
+		return errors.Wrapf(err, "Failed to write %s", name)
+	}
+	return nil
+}
+
+func writeTarEntry(tw *tar.Writer, name string, content []byte) error {
+	hdr := &tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(content)),
+		Typeflag: tar.TypeReg,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return errors.Wrapf(err, "Failed to write the tar header for %s", name)
+	}
+	if _, err := tw.Write(content); err != nil {
+		return errors.Wrapf(err, "Failed to write %s", name)
+	}
+	return nil
+}
+
+// writeManifest writes the manifest entries to tw, and to h, line by line,
+// without holding the whole manifest in memory
+func writeManifest(tw *tar.Writer, entries []ManifestData, h io.Writer) error {
+	var size int64
+	for _, entry := range entries {
+		// <checksum>  <name>\n
+		size += int64(len(entry.Signature) + 2 + len(entry.Name) + 1)
+	}
+	hdr := &tar.Header{
+		Name:     "manifest",
+		Mode:     0644,
+		Size:     size,
+		Typeflag: tar.TypeReg,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return errors.Wrap(err, "Failed to write the tar header for manifest")
+	}
+	w := io.MultiWriter(tw, h)
+	for _, entry := range entries {
+		if _, err := fmt.Fprintf(w, "%s  %s\n", entry.Signature, entry.Name); err != nil {
+			return errors.Wrap(err, "Failed to write manifest")
+		}
+	}
+	return nil
+}
+
+func manifestEntry(name string, content []byte) ManifestData {
+	sum := sha256.Sum256(content)
+	return ManifestData{Signature: hex.EncodeToString(sum[:]), Name: name}
+}
@@ -0,0 +1,137 @@
+package artifact_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+	"github.com/pkg/errors"
+)
+
+// buildWithExtraFile builds an Artifact with the extra file release-info,
+// listed in the manifest with the checksum sum
+func buildWithExtraFile(content, sum string) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	err := artifact.NewArtifactBuilder().
+		WithArtifactName("release-1").
+		WithDeviceTypes("beaglebone").
+		WithPayload("rootfs-image", "rootfs.ext4", strings.NewReader("payload")).
+		WithExtraManifestEntry("release-info", sum).
+		WithExtraFile("release-info", strings.NewReader(content)).
+		Build(buf)
+	return buf.Bytes(), err
+}
+
+func TestWithExtraManifestEntry(t *testing.T) {
+	content := `{"build": 42}`
+	sum := sha256.Sum256([]byte(content))
+	b, err := buildWithExtraFile(content, hex.EncodeToString(sum[:]))
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if names := entryNames(t, b); names[len(names)-1] != "release-info" {
+		t.Errorf("The entries are %v, want release-info after the payload", names)
+	}
+	if got := readEntry(t, b, "release-info"); string(got) != content {
+		t.Errorf("release-info holds %q, want %q", got, content)
+	}
+	a := parse(t, b)
+	if sig, ok := a.Manifest.Lookup("release-info"); !ok || sig != hex.EncodeToString(sum[:]) {
+		t.Errorf("The manifest lists release-info as %q, %t, want %x", sig, ok, sum)
+	}
+	a.Close()
+
+	if _, err = buildWithExtraFile(content, strings.Repeat("0", 64)); err == nil {
+		t.Error("Build with a wrong checksum succeeded")
+	}
+
+	tampered := rewriteEntry(t, b, "release-info", func([]byte) []byte {
+		return []byte(`{"build": 43}`)
+	})
+	_, err = artifact.NewParser().Parse(bytes.NewReader(tampered))
+	if _, ok := errors.Cause(err).(*artifact.ChecksumMismatchError); !ok {
+		t.Errorf("Parse of a changed release-info = %v, want a ChecksumMismatchError", err)
+	}
+}
+
+// artifactDirectory lays out the Artifact b in dir, as read by
+// NewFromDirectory
+func artifactDirectory(t *testing.T, dir string, b []byte, payload []byte) {
+	t.Helper()
+	a := parse(t, b)
+	defer a.Close()
+	files := map[string][]byte{
+		"version.json":          readEntry(t, b, "version"),
+		"header-info.json":      []byte(headerInfo(t, b)),
+		"data/0000/rootfs.ext4": payload,
+	}
+	for name, content := range readScripts(t, a) {
+		files["scripts/"+name] = []byte(content)
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestNewFromDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "from-directory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b := testutil.MakeArtifact(t, testutil.ArtifactOptions{
+		ArtifactName:   "release-1",
+		DeviceType:     "beaglebone",
+		Scripts:        map[string]string{"ArtifactInstall_Enter_00": "#!/bin/sh\necho enter\n"},
+		PayloadContent: []byte("rootfs"),
+	})
+	artifactDirectory(t, dir, b, []byte("rootfs"))
+
+	a, err := artifact.NewFromDirectory(dir)
+	if err != nil {
+		t.Fatalf("NewFromDirectory: %v", err)
+	}
+	defer a.Close()
+	parsed := parse(t, serialize(t, a))
+	defer parsed.Close()
+	if info, want := logicalInfo(parsed.Info()), logicalInfo(parseInfo(t, b)); !reflect.DeepEqual(info, want) {
+		t.Errorf("Parsed %+v from the directory, want %+v", info, want)
+	}
+	if scripts := readScripts(t, parsed); scripts["ArtifactInstall_Enter_00"] != "#!/bin/sh\necho enter\n" {
+		t.Errorf("Parsed the scripts %v from the directory", scripts)
+	}
+	if content := payloadContent(t, parsed); content != "rootfs" {
+		t.Errorf("Parsed the payload %q from the directory", content)
+	}
+
+	for _, name := range []string{"header-info.json", "data/0000/rootfs.ext4"} {
+		if err = os.Remove(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Without the header-info there are no payloads to look for
+	_, err = artifact.NewFromDirectory(dir)
+	if errors.Cause(err) != artifact.ErrMissingRequiredFile || !strings.Contains(err.Error(), "header-info.json") {
+		t.Errorf("NewFromDirectory without header-info.json returned %v", err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "header-info.json"), []byte(headerInfo(t, b)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = artifact.NewFromDirectory(dir)
+	if errors.Cause(err) != artifact.ErrMissingRequiredFile || !strings.Contains(err.Error(), "data/0000") {
+		t.Errorf("NewFromDirectory without the payload returned %v", err)
+	}
+}
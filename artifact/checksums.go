@@ -0,0 +1,76 @@
+package artifact
+
+import (
+	"sort"
+)
+
+// ChecksumRegistry holds the expected, and the actual, checksum of the files
+// of an Artifact, by their manifest name, ie, header.tar.gz, or
+// data/0000/update.ext4
+type ChecksumRegistry struct {
+	sums map[string][2]string
+}
+
+// NewChecksumRegistry returns an empty registry
+func NewChecksumRegistry() *ChecksumRegistry {
+	return &ChecksumRegistry{sums: map[string][2]string{}}
+}
+
+// Add sets the expected, and actual, checksum of the file section
+func (c *ChecksumRegistry) Add(section, expected, actual string) {
+	c.sums[section] = [2]string{expected, actual}
+}
+
+// Verify returns a ChecksumMismatchError for every file whose actual checksum
+// is not the expected one, sorted by name
+func (c *ChecksumRegistry) Verify() []ChecksumMismatchError {
+	var mismatches []ChecksumMismatchError
+	for _, name := range c.names() {
+		if sums := c.sums[name]; sums[0] != sums[1] {
+			mismatches = append(mismatches, ChecksumMismatchError{Filename: name, Expected: sums[0], Actual: sums[1]})
+		}
+	}
+	return mismatches
+}
+
+// All returns the expected, and the actual, checksum of every file
+func (c *ChecksumRegistry) All() map[string][2]string {
+	all := make(map[string][2]string, len(c.sums))
+	for name, sums := range c.sums {
+		all[name] = sums
+	}
+	return all
+}
+
+func (c *ChecksumRegistry) names() []string {
+	names := make([]string, 0, len(c.sums))
+	for name := range c.sums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checksumRegistry returns the registry of the files of the manifest, and the
+// manifest-augment, which have been read. Files which were not kept while
+// parsing, ie, extra files, are left out.
+func (a *Artifact) checksumRegistry() (*ChecksumRegistry, error) {
+	registry := NewChecksumRegistry()
+	if a.Manifest == nil {
+		return registry, nil
+	}
+	actual, err := a.parsedChecksums()
+	if err != nil {
+		return registry, err
+	}
+	entries := a.Manifest.Data
+	if a.ManifestAugment != nil {
+		entries = append(append([]ManifestData{}, entries...), a.ManifestAugment.augData...)
+	}
+	for _, entry := range entries {
+		if sum, ok := actual[entry.Name]; ok {
+			registry.Add(entry.Name, entry.Signature, sum)
+		}
+	}
+	return registry, nil
+}
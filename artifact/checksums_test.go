@@ -0,0 +1,42 @@
+package artifact_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+)
+
+func TestChecksumRegistryVerify(t *testing.T) {
+	b := testutil.MakeArtifact(t, testutil.ArtifactOptions{})
+	p := artifact.NewParser()
+	a, err := p.Parse(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	a.Close()
+	if mismatches := p.Checksums().Verify(); len(mismatches) != 0 {
+		t.Errorf("Verify returned %v for a clean Artifact", mismatches)
+	}
+	all := p.Checksums().All()
+	for _, name := range []string{"version", "header.tar.gz", "data/0000/rootfs.ext4"} {
+		if sums, ok := all[name]; !ok || sums[0] != sums[1] {
+			t.Errorf("The checksums of %s are %v", name, sums)
+		}
+	}
+
+	// Flip a bit of the version, keeping it valid json: mender -> lender
+	flipped := rewriteEntry(t, b, "version", func(version []byte) []byte {
+		i := bytes.Index(version, []byte("mender"))
+		version[i] ^= 1
+		return version
+	})
+	if _, err = p.Parse(bytes.NewReader(flipped)); err == nil {
+		t.Fatal("Parsed an Artifact with a bit flipped")
+	}
+	mismatches := p.Checksums().Verify()
+	if len(mismatches) != 1 || mismatches[0].Filename != "version" || mismatches[0].Expected != all["version"][0] {
+		t.Errorf("Verify returned %+v, want a mismatch of the version", mismatches)
+	}
+}
@@ -0,0 +1,230 @@
+package artifact
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The signatures SignWithTimestamp makes with a certificate carry a detached
+// CMS (RFC 5652) SignedData of the manifest. The digest of the manifest, and
+// the signing time, are signed attributes of it, so that the signature covers
+// the time as well.
+
+var (
+	oidData            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidSHA256          = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSAEncryption   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+// pemCMSType is the type of the PEM block holding the SignedData
+const pemCMSType = "CMS"
+
+type cmsContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type cmsSignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo cmsEncapsulatedContentInfo
+	Certificates     asn1.RawValue   `asn1:"optional,tag:0"`
+	SignerInfos      []cmsSignerInfo `asn1:"set"`
+}
+
+// cmsEncapsulatedContentInfo has no content, as the SignedData is detached
+type cmsEncapsulatedContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+}
+
+type cmsSignerInfo struct {
+	Version            int
+	IssuerAndSerial    cmsIssuerAndSerial
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+}
+
+type cmsIssuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type cmsAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue // SET OF AttributeValue
+}
+
+// cmsSign returns the DER encoded SignedData of the manifest, signed by
+// signer, the key of cert, at ts
+func cmsSign(signer Signer, cert *x509.Certificate, manifest []byte, ts time.Time) ([]byte, error) {
+	sum := sha256.Sum256(manifest)
+	var attrs [][]byte
+	for _, attr := range []struct {
+		oid   asn1.ObjectIdentifier
+		value interface{}
+	}{
+		{oidContentType, oidData},
+		{oidMessageDigest, sum[:]},
+		{oidSigningTime, ts.UTC()},
+	} {
+		value, err := asn1.Marshal(attr.value)
+		if err != nil {
+			return nil, err
+		}
+		der, err := asn1.Marshal(cmsAttribute{
+			Type:   attr.oid,
+			Values: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: value},
+		})
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, der)
+	}
+	// The elements of a DER SET OF are sorted
+	sort.Slice(attrs, func(i, j int) bool { return bytes.Compare(attrs[i], attrs[j]) < 0 })
+	signedAttrs := bytes.Join(attrs, nil)
+
+	var sigAlg pkix.AlgorithmIdentifier
+	switch signer.Public().(type) {
+	case *rsa.PublicKey:
+		sigAlg = pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+	case *ecdsa.PublicKey:
+		sigAlg = pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}
+	default:
+		return nil, errors.Errorf("Unsupported key type: %T", signer.Public())
+	}
+	digest, err := cmsAttributesDigest(signedAttrs)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := signer.Sign(rand.Reader, digest, crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	sha256Alg := pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+	signedData, err := asn1.Marshal(cmsSignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256Alg},
+		EncapContentInfo: cmsEncapsulatedContentInfo{ContentType: oidData},
+		Certificates: asn1.RawValue{
+			Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: cert.Raw,
+		},
+		SignerInfos: []cmsSignerInfo{{
+			Version:         1,
+			IssuerAndSerial: cmsIssuerAndSerial{Issuer: asn1.RawValue{FullBytes: cert.RawIssuer}, Serial: cert.SerialNumber},
+			DigestAlgorithm: sha256Alg,
+			SignedAttrs: asn1.RawValue{
+				Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedAttrs,
+			},
+			SignatureAlgorithm: sigAlg,
+			Signature:          sig,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(cmsContentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData},
+	})
+}
+
+// cmsAttributesDigest returns the digest the signature covers, that of the
+// signed attributes, encoded as a SET OF, rather than with their implicit tag
+func cmsAttributesDigest(signedAttrs []byte) ([]byte, error) {
+	der, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: signedAttrs})
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+	return sum[:], nil
+}
+
+// cmsSignature is the SignedData of a signature, as parsed by parseCMS
+type cmsSignature struct {
+	signedAttrs   []byte
+	messageDigest []byte
+	signingTime   *time.Time
+	signature     []byte
+}
+
+// parseCMS parses the DER encoded SignedData made by cmsSign
+func parseCMS(der []byte) (*cmsSignature, error) {
+	var info cmsContentInfo
+	if rest, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, errors.Wrap(err, "CMS: Invalid ContentInfo")
+	} else if len(rest) > 0 {
+		return nil, errors.New("CMS: Trailing data after the ContentInfo")
+	}
+	if !info.ContentType.Equal(oidSignedData) {
+		return nil, errors.Errorf("CMS: Unexpected content type %s", info.ContentType)
+	}
+	var signedData cmsSignedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &signedData); err != nil {
+		return nil, errors.Wrap(err, "CMS: Invalid SignedData")
+	}
+	if len(signedData.SignerInfos) != 1 {
+		return nil, errors.Errorf("CMS: Expected one signer, got %d", len(signedData.SignerInfos))
+	}
+	signer := signedData.SignerInfos[0]
+	if !signer.DigestAlgorithm.Algorithm.Equal(oidSHA256) {
+		return nil, errors.Errorf("CMS: Unsupported digest algorithm %s", signer.DigestAlgorithm.Algorithm)
+	}
+	c := &cmsSignature{signedAttrs: signer.SignedAttrs.Bytes, signature: signer.Signature}
+	for rest := c.signedAttrs; len(rest) > 0; {
+		var attr cmsAttribute
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &attr); err != nil {
+			return nil, errors.Wrap(err, "CMS: Invalid signed attribute")
+		}
+		switch {
+		case attr.Type.Equal(oidMessageDigest):
+			_, err = asn1.Unmarshal(attr.Values.Bytes, &c.messageDigest)
+		case attr.Type.Equal(oidSigningTime):
+			var t time.Time
+			if _, err = asn1.Unmarshal(attr.Values.Bytes, &t); err == nil {
+				c.signingTime = &t
+			}
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "CMS: Invalid signed attribute %s", attr.Type)
+		}
+	}
+	if c.messageDigest == nil {
+		return nil, errors.New("CMS: No message digest")
+	}
+	return c, nil
+}
+
+// verify checks that the SignedData is of the manifest, and signed with key
+func (c *cmsSignature) verify(manifest []byte, key crypto.PublicKey) error {
+	sum := sha256.Sum256(manifest)
+	if !bytes.Equal(c.messageDigest, sum[:]) {
+		return errors.Wrap(ErrSignatureInvalid, "CMS: The message digest is not that of the manifest")
+	}
+	digest, err := cmsAttributesDigest(c.signedAttrs)
+	if err != nil {
+		return errors.Wrap(err, "CMS")
+	}
+	return errors.Wrap(verifyDigest(key, digest, c.signature), "CMS")
+}
@@ -0,0 +1,261 @@
+package artifact
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ArtifactComparison is the semantic difference between two Artifacts
+type ArtifactComparison struct {
+	VersionChanged      bool
+	ArtifactNameChanged bool
+	DeviceTypesAdded    []string
+	DeviceTypesRemoved  []string
+	PayloadsChanged     []PayloadChange
+	ScriptsAdded        []string
+	ScriptsRemoved      []string
+	ScriptsModified     []string
+}
+
+// PayloadChange is a payload which differs between two Artifacts. The
+// checksum of a payload missing from one of the Artifacts is empty.
+type PayloadChange struct {
+	Index       int
+	OldChecksum string
+	NewChecksum string
+	SizeChange  int64
+}
+
+// Compare returns the difference between the Artifact, and other. Payloads
+// are compared by the checksum of their content, and not by their
+// compression.
+func (a *Artifact) Compare(other *Artifact) (ArtifactComparison, error) {
+	var c ArtifactComparison
+	for _, artifact := range []*Artifact{a, other} {
+		if artifact.Version == nil || artifact.HeaderTar == nil || artifact.HeaderTar.HeaderInfo == nil {
+			return c, errors.New("Compare: The Artifact has not been parsed")
+		}
+	}
+	c.VersionChanged = a.Version.Version != other.Version.Version
+	c.ArtifactNameChanged = a.HeaderTar.HeaderInfo.ArtifactProvides.ArtifactName !=
+		other.HeaderTar.HeaderInfo.ArtifactProvides.ArtifactName
+	c.DeviceTypesAdded, c.DeviceTypesRemoved = diffStrings(a.DeviceTypes(), other.DeviceTypes())
+
+	oldPayloads, err := a.payloadSummaries()
+	if err != nil {
+		return c, errors.Wrap(err, "Compare")
+	}
+	newPayloads, err := other.payloadSummaries()
+	if err != nil {
+		return c, errors.Wrap(err, "Compare")
+	}
+	for i := 0; i < len(oldPayloads) || i < len(newPayloads); i++ {
+		var change PayloadChange
+		change.Index = i
+		if i < len(oldPayloads) {
+			change.OldChecksum = oldPayloads[i].checksum
+			change.SizeChange -= oldPayloads[i].size
+		}
+		if i < len(newPayloads) {
+			change.NewChecksum = newPayloads[i].checksum
+			change.SizeChange += newPayloads[i].size
+		}
+		if change.OldChecksum != change.NewChecksum {
+			c.PayloadsChanged = append(c.PayloadsChanged, change)
+		}
+	}
+
+	oldScripts, err := a.HeaderTar.scriptContents()
+	if err != nil {
+		return c, errors.Wrap(err, "Compare")
+	}
+	newScripts, err := other.HeaderTar.scriptContents()
+	if err != nil {
+		return c, errors.Wrap(err, "Compare")
+	}
+	var oldNames, newNames []string
+	for name := range oldScripts {
+		oldNames = append(oldNames, name)
+	}
+	for name := range newScripts {
+		newNames = append(newNames, name)
+	}
+	c.ScriptsAdded, c.ScriptsRemoved = diffStrings(oldNames, newNames)
+	for name, content := range oldScripts {
+		if newContent, ok := newScripts[name]; ok && !bytes.Equal(content, newContent) {
+			c.ScriptsModified = append(c.ScriptsModified, name)
+		}
+	}
+	sort.Strings(c.ScriptsAdded)
+	sort.Strings(c.ScriptsRemoved)
+	sort.Strings(c.ScriptsModified)
+	return c, nil
+}
+
+// diffStrings returns the strings in new, but not in old, and the strings in
+// old, but not in new
+func diffStrings(old, new []string) (added, removed []string) {
+	for _, s := range new {
+		if !containsString(old, s) {
+			added = append(added, s)
+		}
+	}
+	for _, s := range old {
+		if !containsString(new, s) {
+			removed = append(removed, s)
+		}
+	}
+	return added, removed
+}
+
+type payloadSummary struct {
+	checksum string
+	size     int64
+}
+
+// payloadSummaries returns the checksum, and size, of the (first) file in
+// each of the payloads
+func (a *Artifact) payloadSummaries() ([]payloadSummary, error) {
+	if a.Data == nil {
+		return nil, nil
+	}
+	summaries := make([]payloadSummary, len(a.Data.payloads))
+	for i := range a.Data.payloads {
+		r, err := a.PayloadReader(i)
+		if err != nil {
+			return nil, err
+		}
+		n, err := io.Copy(ioutil.Discard, r)
+		r.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to read the payload %d", i)
+		}
+		summaries[i] = payloadSummary{checksum: r.Sum(), size: n}
+	}
+	return summaries, nil
+}
+
+// scriptContents returns the content of the scripts in the header, by name,
+// with any pending script updates applied
+func (h *HeaderTar) scriptContents() (map[string][]byte, error) {
+	contents := map[string][]byte{}
+	if h.raw != nil {
+		zr, err := h.compression.newReader(bytes.NewReader(h.raw))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		tr := tar.NewReader(zr)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, errors.Wrap(err, "Failed to read the header")
+			}
+			if filepath.Dir(hdr.Name) != "scripts" || filepath.Base(hdr.Name) == scriptAnnotationFile {
+				continue
+			}
+			if contents[filepath.Base(hdr.Name)], err = ioutil.ReadAll(tr); err != nil {
+				return nil, errors.Wrapf(err, "Failed to read the script %s", hdr.Name)
+			}
+		}
+	}
+	for name, content := range h.scriptUpdates {
+		if name != scriptAnnotationFile {
+			contents[name] = content
+		}
+	}
+	return contents, nil
+}
+
+// Difference is a single field which differs between two Artifacts. Old, or
+// New, is nil if the field is missing from the Artifact.
+type Difference struct {
+	Field string
+	Old   interface{}
+	New   interface{}
+}
+
+// DiffWith returns the metadata which differs between the Artifact, and
+// other, in the order: artifact_name, artifact_group, device types, payload
+// types, scripts, payload checksums, provides and depends. Only the payload
+// files are compared in the manifest, as the checksums of the header and the
+// version follow from the other differences.
+func (a *Artifact) DiffWith(other *Artifact) []Difference {
+	old, new := a.Info(), other.Info()
+	var diffs []Difference
+	diff := func(field string, o, n interface{}) {
+		if !sameJSON(o, n) {
+			diffs = append(diffs, Difference{Field: field, Old: o, New: n})
+		}
+	}
+	addedRemoved := func(field string, o, n []string) {
+		added, removed := diffStrings(o, n)
+		sort.Strings(added)
+		sort.Strings(removed)
+		for _, s := range removed {
+			diffs = append(diffs, Difference{Field: field, Old: s})
+		}
+		for _, s := range added {
+			diffs = append(diffs, Difference{Field: field, New: s})
+		}
+	}
+
+	diff("artifact_name", old.Name, new.Name)
+	diff("artifact_group", old.ArtifactProvides.ArtifactGroup, new.ArtifactProvides.ArtifactGroup)
+	addedRemoved("device_type", old.CompatibleDevices, new.CompatibleDevices)
+	diff("payload_types", old.PayloadTypes, new.PayloadTypes)
+	addedRemoved("scripts", old.Scripts, new.Scripts)
+
+	oldSums, newSums := map[string]interface{}{}, map[string]interface{}{}
+	for _, entry := range old.ManifestEntries {
+		oldSums[entry.Name] = entry.Signature
+	}
+	for _, entry := range new.ManifestEntries {
+		newSums[entry.Name] = entry.Signature
+	}
+	diffMaps("manifest/", oldSums, newSums, func(name string) bool {
+		return strings.HasPrefix(name, "data/")
+	}, &diffs)
+
+	diffMaps("artifact_provides/", old.ArtifactProvides.Extra, new.ArtifactProvides.Extra, nil, &diffs)
+	diff("artifact_depends/artifact_name", old.ArtifactDepends.ArtifactName, new.ArtifactDepends.ArtifactName)
+	diff("artifact_depends/artifact_group", old.ArtifactDepends.ArtifactGroup, new.ArtifactDepends.ArtifactGroup)
+	diffMaps("artifact_depends/", old.ArtifactDepends.Extra, new.ArtifactDepends.Extra, nil, &diffs)
+	return diffs
+}
+
+// diffMaps appends the keys, accepted by filter, if given, whose values
+// differ between old and new to diffs, in the order of the keys
+func diffMaps(prefix string, old, new map[string]interface{}, filter func(string) bool, diffs *[]Difference) {
+	keys := map[string]bool{}
+	for k := range old {
+		keys[k] = true
+	}
+	for k := range new {
+		keys[k] = true
+	}
+	var sorted []string
+	for k := range keys {
+		if filter == nil || filter(k) {
+			sorted = append(sorted, k)
+		}
+	}
+	sort.Strings(sorted)
+	for _, k := range sorted {
+		o, inOld := old[k]
+		n, inNew := new[k]
+		if inOld && inNew && sameJSON(o, n) {
+			continue
+		}
+		*diffs = append(*diffs, Difference{Field: prefix + k, Old: o, New: n})
+	}
+}
@@ -0,0 +1,83 @@
+package artifact_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+)
+
+func TestCompare(t *testing.T) {
+	old := parse(t, testutil.MakeArtifact(t, testutil.ArtifactOptions{
+		Version:      2,
+		ArtifactName: "release-1",
+		DeviceType:   "beaglebone",
+		Scripts: map[string]string{
+			"ArtifactInstall_Enter_00": "#!/bin/sh\necho enter\n",
+			"ArtifactInstall_Leave_00": "#!/bin/sh\necho leave\n",
+		},
+		Payloads: []testutil.Payload{
+			{Filename: "rootfs.ext4", Content: []byte("rootfs-1")},
+			{Filename: "bootloader.img", Content: []byte("bootloader")},
+		},
+	}))
+	defer old.Close()
+	if err := old.AddCompatibleDevice("raspberrypi3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := old.RecomputeManifest(); err != nil {
+		t.Fatal(err)
+	}
+	new := parse(t, testutil.MakeArtifact(t, testutil.ArtifactOptions{
+		Version:      3,
+		ArtifactName: "release-2",
+		DeviceType:   "beaglebone",
+		Scripts: map[string]string{
+			"ArtifactInstall_Enter_00": "#!/bin/sh\necho entered\n",
+			"ArtifactInstall_Error_00": "#!/bin/sh\necho error\n",
+		},
+		Payloads: []testutil.Payload{
+			{Filename: "rootfs.ext4", Content: []byte("rootfs-2.0")},
+			{Filename: "bootloader.img", Content: []byte("bootloader")},
+			{Filename: "dtb.img", Content: []byte("dtb")},
+		},
+	}))
+	defer new.Close()
+	if err := new.AddCompatibleDevice("qemux86-64"); err != nil {
+		t.Fatal(err)
+	}
+	if err := new.RecomputeManifest(); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := func(content string) string {
+		return fmt.Sprintf("%x", sha256.Sum256([]byte(content)))
+	}
+	c, err := old.Compare(new)
+	if err != nil {
+		t.Fatalf("Compare: %v", err)
+	}
+	want := artifact.ArtifactComparison{
+		VersionChanged:      true,
+		ArtifactNameChanged: true,
+		DeviceTypesAdded:    []string{"qemux86-64"},
+		DeviceTypesRemoved:  []string{"raspberrypi3"},
+		PayloadsChanged: []artifact.PayloadChange{
+			{Index: 0, OldChecksum: sum("rootfs-1"), NewChecksum: sum("rootfs-2.0"), SizeChange: 2},
+			{Index: 2, NewChecksum: sum("dtb"), SizeChange: 3},
+		},
+		ScriptsAdded:    []string{"ArtifactInstall_Error_00"},
+		ScriptsRemoved:  []string{"ArtifactInstall_Leave_00"},
+		ScriptsModified: []string{"ArtifactInstall_Enter_00"},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("Compare returned\n%+v, want\n%+v", c, want)
+	}
+
+	if c, err = old.Compare(old); err != nil || !reflect.DeepEqual(c, artifact.ArtifactComparison{}) {
+		t.Errorf("Compare of an Artifact with itself returned %+v, %v", c, err)
+	}
+}
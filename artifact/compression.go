@@ -0,0 +1,235 @@
+package artifact
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// CompressionAlgo is the compression applied to the header and the payloads
+// of an Artifact. The algorithm in use is given by the file extension of the
+// section in the Artifact tar. ie, header.tar.gz, or data/0000.tar.zst, and
+// no extension at all for CompressionNone. ie, header.tar
+type CompressionAlgo int
+
+const (
+	CompressionGzip CompressionAlgo = iota
+	CompressionZstd
+	CompressionNone
+)
+
+// supported returns true for the compression algorithms known
+func (c CompressionAlgo) supported() bool {
+	switch c {
+	case CompressionGzip, CompressionZstd, CompressionNone:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c CompressionAlgo) String() string {
+	switch c {
+	case CompressionGzip:
+		return "gzip"
+	case CompressionZstd:
+		return "zstd"
+	case CompressionNone:
+		return "none"
+	default:
+		return fmt.Sprintf("CompressionAlgo(%d)", int(c))
+	}
+}
+
+// Extension returns the file extension used for sections compressed with c,
+// which is empty for uncompressed sections
+func (c CompressionAlgo) Extension() string {
+	switch c {
+	case CompressionGzip:
+		return ".gz"
+	case CompressionZstd:
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+// compressionFromName returns the compression used for the section with the
+// given name in the Artifact tar.
+func compressionFromName(name string) (CompressionAlgo, error) {
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"):
+		return CompressionGzip, nil
+	case strings.HasSuffix(name, ".tar.zst"):
+		return CompressionZstd, nil
+	case strings.HasSuffix(name, ".tar"):
+		return CompressionNone, nil
+	default:
+		return 0, fmt.Errorf("Unsupported compression for: %s", name)
+	}
+}
+
+// trimCompression strips the compression extension from name.
+// ie, data/0000.tar.gz -> data/0000.tar
+func trimCompression(name string) string {
+	if c, err := compressionFromName(name); err == nil {
+		return strings.TrimSuffix(name, c.Extension())
+	}
+	return name
+}
+
+func (c CompressionAlgo) newReader(r io.Reader) (io.ReadCloser, error) {
+	switch c {
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	case CompressionNone:
+		return ioutil.NopCloser(r), nil
+	default:
+		return nil, fmt.Errorf("Unsupported compression: %s", c)
+	}
+}
+
+// ErrInvalidGzipLevel is returned for a gzip level outside of
+// gzip.HuffmanOnly through gzip.BestCompression
+var ErrInvalidGzipLevel = errors.New("Invalid gzip compression level")
+
+func checkGzipLevel(level int) error {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		return errors.Wrapf(ErrInvalidGzipLevel, "%d", level)
+	}
+	return nil
+}
+
+func (c CompressionAlgo) newWriter(w io.Writer) (io.WriteCloser, error) {
+	return c.newWriterLevel(w, gzip.DefaultCompression)
+}
+
+// newWriterLevel returns a writer compressing at the gzip level given. The
+// level only applies to gzip.
+func (c CompressionAlgo) newWriterLevel(w io.Writer, gzipLevel int) (io.WriteCloser, error) {
+	switch c {
+	case CompressionGzip:
+		if err := checkGzipLevel(gzipLevel); err != nil {
+			return nil, err
+		}
+		return gzip.NewWriterLevel(w, gzipLevel)
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported compression: %s", c)
+	}
+}
+
+// nopWriteCloser is the io.WriteCloser of uncompressed sections
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// recompress decompresses b with from, and compresses the result with to
+func recompress(b []byte, from, to CompressionAlgo) ([]byte, error) {
+	zr, err := from.newReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to decompress the %s data", from)
+	}
+	defer zr.Close()
+	buf := bytes.NewBuffer(nil)
+	zw, err := to.newWriter(buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(zw, zr); err != nil {
+		return nil, errors.Wrapf(err, "Failed to compress the data as %s", to)
+	}
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Compress returns a copy of the Artifact, with the header and all the
+// payloads compressed using algo. The logical content of the Artifact is left
+// unchanged, but as the compressed bytes differ, the manifest entries for the
+// affected sections are updated to match.
+//
+// A signed Artifact cannot be recompressed, as the new manifest would
+// invalidate the signature.
+func (a *Artifact) Compress(algo CompressionAlgo) (*Artifact, error) {
+	if a.ManifestSig != nil {
+		return nil, errors.New("Compress: Recompressing a signed Artifact invalidates the signature")
+	}
+	if a.Manifest == nil || a.HeaderTar == nil || a.HeaderTar.raw == nil {
+		return nil, errors.New("Compress: The Artifact has not been parsed")
+	}
+	if !algo.supported() {
+		return nil, fmt.Errorf("Compress: Unsupported compression: %s", algo)
+	}
+	// renamed maps the old section names to the new
+	renamed := map[string]ManifestData{}
+
+	header := *a.HeaderTar
+	raw, err := recompress(header.raw, header.compression, algo)
+	if err != nil {
+		return nil, errors.Wrap(err, "Compress: header")
+	}
+	sum := sha256.Sum256(raw)
+	renamed["header.tar"+header.compression.Extension()] = ManifestData{
+		Signature: hex.EncodeToString(sum[:]),
+		Name:      "header.tar" + algo.Extension(),
+	}
+	header.raw, header.ShaSum, header.compression = raw, sum[:], algo
+
+	var data *Data
+	if a.Data != nil {
+		data = &Data{}
+		for _, payload := range a.Data.payloads {
+			from, err := compressionFromName(payload.Name)
+			if err != nil {
+				return nil, errors.Wrap(err, "Compress")
+			}
+			raw, err := recompress(payload.Data.Bytes(), from, algo)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Compress: %s", payload.Name)
+			}
+			pl := PayLoadData{Name: trimCompression(payload.Name) + algo.Extension()}
+			pl.Data.Write(raw)
+			data.payloads = append(data.payloads, pl)
+			sum := sha256.Sum256(raw)
+			renamed[payload.Name] = ManifestData{
+				Signature: hex.EncodeToString(sum[:]),
+				Name:      pl.Name,
+			}
+		}
+	}
+
+	manifest := &Manifest{}
+	for _, entry := range a.Manifest.Data {
+		if r, ok := renamed[entry.Name]; ok {
+			entry = r
+		}
+		manifest.Data = append(manifest.Data, entry)
+	}
+
+	compressed := *a
+	compressed.Manifest = manifest
+	compressed.HeaderTar = &header
+	compressed.Data = data
+	return &compressed, nil
+}
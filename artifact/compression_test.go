@@ -0,0 +1,126 @@
+package artifact_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+	"github.com/pkg/errors"
+)
+
+// logicalInfo returns the metadata of the Artifact which does not depend on
+// how it is compressed, ie, without the checksums of the compressed sections
+func logicalInfo(info artifact.ArtifactInfo) artifact.ArtifactInfo {
+	entries := []artifact.ManifestData{}
+	for _, entry := range info.ManifestEntries {
+		if strings.HasPrefix(entry.Name, "data/") && strings.Count(entry.Name, "/") == 2 {
+			entries = append(entries, entry)
+		}
+	}
+	info.ManifestEntries, info.SectionSizes = entries, nil
+	return info
+}
+
+func TestCompress(t *testing.T) {
+	b := testutil.MakeArtifact(t, testutil.ArtifactOptions{
+		PayloadContent: bytes.Repeat([]byte("The same line, over and over again\n"), 1<<12),
+	})
+	a := parse(t, b)
+	defer a.Close()
+
+	compressed, err := a.Compress(artifact.CompressionZstd)
+	if err != nil {
+		t.Fatalf("Compress: %v", err)
+	}
+	zb := serialize(t, compressed)
+	if len(zb) >= len(b) {
+		t.Errorf("The zstd Artifact is %d bytes, and the gzip one %d", len(zb), len(b))
+	}
+	want := []string{"version", "manifest", "header.tar.zst", "data/0000.tar.zst"}
+	if names := entryNames(t, zb); !reflect.DeepEqual(names, want) {
+		t.Errorf("The zstd Artifact holds %v, want %v", names, want)
+	}
+	if info, want := logicalInfo(parseInfo(t, zb)), logicalInfo(a.Info()); !reflect.DeepEqual(info, want) {
+		t.Errorf("Parsed %+v, want %+v", info, want)
+	}
+}
+
+// writeArtifact returns an Artifact with the single payload file rootfs.ext4
+// of content, written by an ArtifactWriter with the options
+func writeArtifact(t *testing.T, content []byte, opts ...artifact.Option) []byte {
+	t.Helper()
+	buf := bytes.NewBuffer(nil)
+	aw := artifact.NewArtifactWriter(buf, opts...)
+	aw.SetArtifactName("release-1")
+	aw.SetCompatibleDevices([]string{"beaglebone"})
+	if err := aw.AddPayload("rootfs-image", "rootfs.ext4", bytes.NewReader(content)); err != nil {
+		t.Fatalf("AddPayload: %v", err)
+	}
+	if err := aw.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestZstdRoundTrip(t *testing.T) {
+	b := writeArtifact(t, []byte("rootfs"), artifact.WithCompression(artifact.CompressionZstd))
+	want := []string{"version", "manifest", "header.tar.zst", "data/0000.tar.zst"}
+	if names := entryNames(t, b); !reflect.DeepEqual(names, want) {
+		t.Errorf("The zstd Artifact holds %v, want %v", names, want)
+	}
+
+	p := artifact.NewParser()
+	a, err := p.Parse(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	defer a.Close()
+	if a.Version.Format != "mender" || a.Info().Name != "release-1" {
+		t.Errorf("Parsed the format %q, and the name %q", a.Version.Format, a.Info().Name)
+	}
+	r, err := p.Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if content, err := ioutil.ReadAll(r); err != nil || string(content) != "rootfs" {
+		t.Errorf("Read %q, %v from the payload, want rootfs", content, err)
+	}
+	if written := serialize(t, a); !bytes.Equal(written, b) {
+		t.Error("The zstd Artifact serializes differently")
+	}
+}
+
+func TestGzipLevel(t *testing.T) {
+	// Random words compress well, but only with some effort
+	rnd := rand.New(rand.NewSource(1))
+	words := []string{"mender ", "artifact ", "payload ", "header ", "manifest ", "rootfs "}
+	content := bytes.NewBuffer(nil)
+	for content.Len() < 1<<18 {
+		content.WriteString(words[rnd.Intn(len(words))])
+	}
+	fast := writeArtifact(t, content.Bytes(), artifact.WithGzipLevel(gzip.BestSpeed))
+	best := writeArtifact(t, content.Bytes(), artifact.WithGzipLevel(gzip.BestCompression))
+	if len(fast) <= len(best) {
+		t.Errorf("The Artifact is %d bytes at level 1, and %d at level 9", len(fast), len(best))
+	}
+	if info, want := logicalInfo(parseInfo(t, fast)), logicalInfo(parseInfo(t, best)); !reflect.DeepEqual(info, want) {
+		t.Errorf("Parsed %+v at level 1, want %+v", info, want)
+	}
+
+	aw := artifact.NewArtifactWriter(bytes.NewBuffer(nil), artifact.WithGzipLevel(gzip.BestCompression+1))
+	aw.SetArtifactName("release-1")
+	aw.SetCompatibleDevices([]string{"beaglebone"})
+	err := aw.AddPayload("rootfs-image", "rootfs.ext4", bytes.NewReader(content.Bytes()))
+	if err == nil {
+		err = aw.Flush()
+	}
+	if errors.Cause(err) != artifact.ErrInvalidGzipLevel {
+		t.Errorf("Writing at level 10 returned %v, want ErrInvalidGzipLevel", err)
+	}
+}
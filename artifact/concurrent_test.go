@@ -0,0 +1,137 @@
+package artifact_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"reflect"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+)
+
+// sourceReader reads the Artifact with Read, like Parse, and corrupts what is
+// read with ReadAt, like the payload workers, if corrupt is set
+type sourceReader struct {
+	*bytes.Reader
+	corrupt bool
+	readAts int32
+}
+
+func (s *sourceReader) ReadAt(b []byte, off int64) (int, error) {
+	atomic.AddInt32(&s.readAts, 1)
+	n, err := s.Reader.ReadAt(b, off)
+	if s.corrupt {
+		for i := 0; i < n; i++ {
+			b[i] ^= 0xff
+		}
+	}
+	return n, err
+}
+
+func TestConcurrentPayloads(t *testing.T) {
+	b := threePayloadArtifact(t)
+	info := parseInfo(t, b)
+
+	source := &sourceReader{Reader: bytes.NewReader(b)}
+	a, err := artifact.NewParser().Parse(source, artifact.WithConcurrentPayloads(3))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	defer a.Close()
+	if !reflect.DeepEqual(a.Info(), info) {
+		t.Errorf("Parsed %+v concurrently, want %+v", a.Info(), info)
+	}
+	if source.readAts == 0 {
+		t.Error("The payloads were not read from the source")
+	}
+
+	// The payloads are verified as read from the source
+	source = &sourceReader{Reader: bytes.NewReader(b), corrupt: true}
+	if _, err = artifact.NewParser().Parse(source, artifact.WithConcurrentPayloads(3)); err == nil {
+		t.Error("Parsed the corrupt payloads of the source")
+	}
+	source = &sourceReader{Reader: bytes.NewReader(b), corrupt: true}
+	if _, err = artifact.NewParser().Parse(source); err != nil {
+		t.Errorf("Parse read the payloads from the source without WithConcurrentPayloads: %v", err)
+	}
+
+	// A reader which cannot be read at an offset is verified sequentially
+	a, err = artifact.NewParser().Parse(struct{ io.Reader }{bytes.NewReader(b)}, artifact.WithConcurrentPayloads(3))
+	if err != nil {
+		t.Fatalf("Parse of a non-seekable reader: %v", err)
+	}
+	defer a.Close()
+	if !reflect.DeepEqual(a.Info(), info) {
+		t.Errorf("Parsed %+v from a non-seekable reader, want %+v", a.Info(), info)
+	}
+}
+
+func TestConcurrentPayloadsInvalidWorkers(t *testing.T) {
+	if _, err := artifact.NewParser().Parse(bytes.NewReader(nil), artifact.WithConcurrentPayloads(0)); err == nil {
+		t.Error("Parsed with 0 workers")
+	}
+}
+
+// parseDuration returns the fastest of three parses of the Artifact in f with
+// workers goroutines
+func parseDuration(t *testing.T, f *os.File, workers int) time.Duration {
+	t.Helper()
+	var fastest time.Duration
+	for i := 0; i < 3; i++ {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		a, err := artifact.NewParser().Parse(f, artifact.WithConcurrentPayloads(workers))
+		if err != nil {
+			t.Fatalf("Parse with %d workers: %v", workers, err)
+		}
+		d := time.Since(start)
+		a.Close()
+		if i == 0 || d < fastest {
+			fastest = d
+		}
+	}
+	return fastest
+}
+
+func TestConcurrentPayloadsDuration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Parses 24 MiB of payloads six times")
+	}
+	if runtime.NumCPU() < 3 {
+		t.Skipf("Three workers need three CPUs, there are %d", runtime.NumCPU())
+	}
+	rnd := rand.New(rand.NewSource(1))
+	var payloads []testutil.Payload
+	for _, name := range []string{"rootfs.ext4", "bootloader.img", "dtb.img"} {
+		// Compressible, so that decompressing it takes a while
+		content := make([]byte, 8<<20)
+		for i := range content {
+			content[i] = byte('a' + rnd.Intn(16))
+		}
+		payloads = append(payloads, testutil.Payload{Filename: name, Content: content})
+	}
+	f, err := ioutil.TempFile("", "concurrent-payloads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err = f.Write(testutil.MakeArtifact(t, testutil.ArtifactOptions{Payloads: payloads})); err != nil {
+		t.Fatal(err)
+	}
+
+	sequential := parseDuration(t, f, 1)
+	concurrent := parseDuration(t, f, 3)
+	if concurrent >= sequential {
+		t.Errorf("Parsed in %v with 3 workers, and in %v with 1", concurrent, sequential)
+	}
+}
@@ -0,0 +1,45 @@
+package artifact
+
+import (
+	"context"
+	"io"
+)
+
+// contextReader fails all reads with the error of ctx, once ctx is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func newContextReader(ctx context.Context, r io.Reader) io.Reader {
+	return &contextReader{ctx: ctx, r: r}
+}
+
+func (c *contextReader) Read(b []byte) (int, error) {
+	select {
+	case <-c.ctx.Done():
+		return 0, c.ctx.Err()
+	default:
+	}
+	return c.r.Read(b)
+}
+
+// ParseContext is Parse, but stops, and returns the error of ctx, once ctx
+// is cancelled.
+func (p *Parser) ParseContext(ctx context.Context, r io.Reader, opts ...ParseOption) (*Artifact, error) {
+	return p.Parse(newContextReader(ctx, r), opts...)
+}
+
+// NewArtifactReaderContext returns an ArtifactReader whose Parse, and Next,
+// stop, and return the error of ctx, once ctx is cancelled.
+func NewArtifactReaderContext(ctx context.Context, r io.Reader) *ArtifactReader {
+	return NewArtifactReader(newContextReader(ctx, r))
+}
+
+// ParseContext is Parse, but stops, and returns the error of ctx, once ctx
+// is cancelled.
+func (ar *ArtifactReader) ParseContext(ctx context.Context) error {
+	ar.Artifact = ar.newArtifact()
+	ar.checkpoints = nil
+	return ar.parse(&countingReader{r: newContextReader(ctx, ar.r)}, sectionOrder{})
+}
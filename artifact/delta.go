@@ -0,0 +1,103 @@
+package artifact
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// DeltaAlgorithm is the algorithm used to create the binary patch of a delta
+// Artifact
+type DeltaAlgorithm int
+
+const (
+	// DeltaAlgorithmBSDiff creates a BSDIFF40 patch, applied with bspatch
+	DeltaAlgorithmBSDiff DeltaAlgorithm = iota
+)
+
+func (d DeltaAlgorithm) String() string {
+	switch d {
+	case DeltaAlgorithmBSDiff:
+		return "bsdiff"
+	default:
+		return fmt.Sprintf("DeltaAlgorithm(%d)", int(d))
+	}
+}
+
+func (d DeltaAlgorithm) diff(from, to []byte) ([]byte, error) {
+	switch d {
+	case DeltaAlgorithmBSDiff:
+		return bsdiff(from, to)
+	default:
+		return nil, fmt.Errorf("Unsupported delta algorithm: %s", d)
+	}
+}
+
+// CreateDeltaFrom generates a delta Artifact, which updates a device running
+// base to a. The payload is a binary patch from the rootfs-image of base to
+// the rootfs-image of a. The delta depends on the checksum of the base image,
+// and provides the checksum of the image of a.
+func (a *Artifact) CreateDeltaFrom(base *Artifact, algorithm DeltaAlgorithm) (*Artifact, error) {
+	if a.HeaderTar == nil || a.HeaderTar.HeaderInfo == nil || a.Data == nil ||
+		base.HeaderTar == nil || base.HeaderTar.HeaderInfo == nil || base.Data == nil {
+		return nil, errors.New("CreateDeltaFrom: The Artifacts have not been parsed")
+	}
+	from, err := rootfsImage(base)
+	if err != nil {
+		return nil, errors.Wrap(err, "CreateDeltaFrom: base")
+	}
+	to, err := rootfsImage(a)
+	if err != nil {
+		return nil, errors.Wrap(err, "CreateDeltaFrom")
+	}
+	fromContent, err := ioutil.ReadAll(from.r)
+	if err != nil {
+		return nil, errors.Wrap(err, "CreateDeltaFrom")
+	}
+	toContent, err := ioutil.ReadAll(to.r)
+	if err != nil {
+		return nil, errors.Wrap(err, "CreateDeltaFrom")
+	}
+	patch, err := algorithm.diff(fromContent, toContent)
+	if err != nil {
+		return nil, errors.Wrap(err, "CreateDeltaFrom")
+	}
+
+	info := a.HeaderTar.HeaderInfo
+	typeInfo := &TypeInfo{Type: "rootfs-image-delta"}
+	typeInfo.TypeInfoProvides.RootfsImageChecksum = manifestEntry(to.name, toContent).Signature
+	typeInfo.TypeInfoDepends.RootfsImageChecksum = manifestEntry(from.name, fromContent).Signature
+	b := NewArtifactBuilder().
+		WithArtifactName(info.ArtifactProvides.ArtifactName).
+		WithArtifactGroup(info.ArtifactProvides.ArtifactGroup).
+		WithDependsArtifactNames(info.ArtifactDepends.ArtifactName...).
+		WithDeviceTypes(info.ArtifactDepends.DeviceType...).
+		WithCompression(a.HeaderTar.compression)
+	b.payloads = append(b.payloads, builderPayload{
+		payloadType: typeInfo.Type,
+		file:        builderFile{name: to.name + ".delta", r: bytes.NewReader(patch)},
+		typeInfo:    typeInfo,
+	})
+
+	buf := bytes.NewBuffer(nil)
+	if err = b.Build(buf); err != nil {
+		return nil, errors.Wrap(err, "CreateDeltaFrom")
+	}
+	delta := &Artifact{}
+	if err = delta.Parse(buf); err != nil {
+		return nil, errors.Wrap(err, "CreateDeltaFrom: Failed to parse the delta Artifact")
+	}
+	return delta, nil
+}
+
+// rootfsImage returns the image of an Artifact with a single rootfs-image
+// payload
+func rootfsImage(a *Artifact) (builderFile, error) {
+	payloads := a.HeaderTar.HeaderInfo.Payloads
+	if len(payloads) != 1 || payloads[0].Type != "rootfs-image" || len(a.Data.payloads) != 1 {
+		return builderFile{}, errors.New("Only Artifacts with a single rootfs-image payload are supported")
+	}
+	return payloadFile(a.Data.payloads[0])
+}
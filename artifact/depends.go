@@ -0,0 +1,294 @@
+package artifact
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// ErrIncompatibleArtifact is returned when an Artifact can not be installed
+// on any of the given devices
+var ErrIncompatibleArtifact = errors.New("The Artifact is not compatible with any of the devices")
+
+// ErrAlreadyBound is returned by BindToDevice if the Artifact is already
+// bound to the device
+var ErrAlreadyBound = errors.New("The Artifact is already bound to the device")
+
+// DeviceTypes returns the device types the Artifact is compatible with
+func (a *Artifact) DeviceTypes() []string {
+	if a.HeaderTar == nil || a.HeaderTar.HeaderInfo == nil {
+		return nil
+	}
+	return append([]string{}, a.HeaderTar.HeaderInfo.ArtifactDepends.DeviceType...)
+}
+
+// IsCompatibleWithDevice reports whether the Artifact can be installed on
+// the device type
+func (a *Artifact) IsCompatibleWithDevice(deviceType string) bool {
+	return containsString(a.DeviceTypes(), deviceType)
+}
+
+// BindToDevice returns a copy of the Artifact, which can only be installed on
+// the device deviceID. If the Artifact is already bound to it,
+// ErrAlreadyBound is returned.
+func (a *Artifact) BindToDevice(deviceID string) (_ *Artifact, err error) {
+	if a.HeaderTar == nil || a.HeaderTar.HeaderInfo == nil {
+		return nil, errors.New("BindToDevice: The Artifact has not been parsed")
+	}
+	if deviceID == "" {
+		return nil, errors.New("BindToDevice: Empty device ID")
+	}
+	deviceTypes := a.DeviceTypes()
+	if len(deviceTypes) == 1 && deviceTypes[0] == deviceID {
+		return nil, errors.Wrapf(ErrAlreadyBound, "BindToDevice: %s", deviceID)
+	}
+	bound := a.copyMetadata()
+	defer func() {
+		if err != nil {
+			bound.closeScripts()
+		}
+	}()
+	bound.HeaderTar.HeaderInfo.ArtifactDepends.DeviceType = []string{deviceID}
+	bound.HeaderTar.dirty = true
+	if err := bound.RecomputeManifest(); err != nil {
+		return nil, errors.Wrap(err, "BindToDevice")
+	}
+	return bound, nil
+}
+
+// ValidateDeviceCompatibility verifies that the Artifact is compatible with
+// at least one of the device types in the fleet. If not, the returned error
+// wraps ErrIncompatibleArtifact.
+func (a *Artifact) ValidateDeviceCompatibility(deviceTypes []string) error {
+	artifactDeviceTypes := a.DeviceTypes()
+	for _, deviceType := range artifactDeviceTypes {
+		if containsString(deviceTypes, deviceType) {
+			return nil
+		}
+	}
+	return errors.Wrapf(ErrIncompatibleArtifact,
+		"Artifact device types: %v, fleet device types: %v", artifactDeviceTypes, deviceTypes)
+}
+
+// CanUpgradeTo reports whether next can be installed on top of the Artifact.
+// That is, whether the provides of the Artifact satisfy the depends of next.
+func (a *Artifact) CanUpgradeTo(next *Artifact) bool {
+	if a.HeaderTar == nil || a.HeaderTar.HeaderInfo == nil ||
+		next.HeaderTar == nil || next.HeaderTar.HeaderInfo == nil {
+		return false
+	}
+	provides := a.HeaderTar.HeaderInfo.ArtifactProvides
+	current := a.HeaderTar.HeaderInfo.ArtifactDepends
+	depends := next.HeaderTar.HeaderInfo.ArtifactDepends
+	if len(depends.ArtifactName) > 0 &&
+		!containsString(depends.ArtifactName, provides.ArtifactName) {
+		return false
+	}
+	for key, value := range depends.Extra {
+		if !sameJSON(provides.Extra[key], value) {
+			return false
+		}
+	}
+	for _, deviceType := range current.DeviceType {
+		if containsString(depends.DeviceType, deviceType) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeviceState is what a device currently provides, as checked by Satisfies
+type DeviceState struct {
+	ArtifactName   string
+	ArtifactGroup  string
+	DeviceType     string
+	RootfsChecksum string // The checksum of the installed rootfs image
+}
+
+// Satisfies reports whether the Artifact can be installed on a device in the
+// state. The device type has to be one of the Artifact's, and the installed
+// Artifact name, and group, have to be among those the Artifact depends on,
+// if it depends on any. Payloads depending on a rootfs image, ie, deltas,
+// have to depend on the one installed.
+func (a *Artifact) Satisfies(state DeviceState) bool {
+	if a.HeaderTar == nil || a.HeaderTar.HeaderInfo == nil {
+		return false
+	}
+	depends := a.HeaderTar.HeaderInfo.ArtifactDepends
+	if !containsString(depends.DeviceType, state.DeviceType) {
+		return false
+	}
+	if len(depends.ArtifactName) > 0 && !containsString(depends.ArtifactName, state.ArtifactName) {
+		return false
+	}
+	if len(depends.ArtifactGroup) > 0 && !containsString(depends.ArtifactGroup, state.ArtifactGroup) {
+		return false
+	}
+	for _, sh := range a.HeaderTar.Headers {
+		if sh.typeInfo == nil {
+			continue
+		}
+		if sum := sh.typeInfo.TypeInfoDepends.RootfsImageChecksum; sum != "" && sum != state.RootfsChecksum {
+			return false
+		}
+	}
+	return true
+}
+
+// sameJSON reports whether a and b have the same JSON encoding, as values
+// may be of different types before, and after, a round trip through JSON.
+func sameJSON(a, b interface{}) bool {
+	ja, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	jb, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(ja, jb)
+}
+
+// AddProvidesDependency makes the Artifact provide key, with the value, and
+// depend on the same, so that it can only be installed on top of an Artifact
+// providing it.
+func (a *Artifact) AddProvidesDependency(key string, value interface{}) error {
+	if a.HeaderTar == nil || a.HeaderTar.HeaderInfo == nil {
+		return errors.New("AddProvidesDependency: The Artifact has not been parsed")
+	}
+	switch key {
+	case "", "artifact_name", "artifact_group", "device_type":
+		return fmt.Errorf("AddProvidesDependency: Invalid key: %q", key)
+	}
+	info := a.HeaderTar.HeaderInfo
+	if info.ArtifactProvides.Extra == nil {
+		info.ArtifactProvides.Extra = map[string]interface{}{}
+	}
+	if info.ArtifactDepends.Extra == nil {
+		info.ArtifactDepends.Extra = map[string]interface{}{}
+	}
+	info.ArtifactProvides.Extra[key] = value
+	info.ArtifactDepends.Extra[key] = value
+	a.HeaderTar.dirty = true
+	return nil
+}
+
+// RemoveProvidesDependency removes both the provides, and the depends, of key
+func (a *Artifact) RemoveProvidesDependency(key string) error {
+	if a.HeaderTar == nil || a.HeaderTar.HeaderInfo == nil {
+		return errors.New("RemoveProvidesDependency: The Artifact has not been parsed")
+	}
+	info := a.HeaderTar.HeaderInfo
+	delete(info.ArtifactProvides.Extra, key)
+	delete(info.ArtifactDepends.Extra, key)
+	a.HeaderTar.dirty = true
+	return nil
+}
+
+// ErrMergeConflict is returned when merging depends, or provides, which can
+// not both hold
+var ErrMergeConflict = errors.New("Conflicting artifact metadata")
+
+// Merge returns the depends satisfying both a and other. The device types
+// are the union of both. The artifact names, and groups, depended on are the
+// ones in both, or the ones of either, if the other has none, and
+// ErrMergeConflict is returned if they have none in common.
+func (a ArtifactDepends) Merge(other ArtifactDepends) (ArtifactDepends, error) {
+	merged := ArtifactDepends{
+		DeviceType: unionStrings(a.DeviceType, other.DeviceType),
+	}
+	var err error
+	if merged.ArtifactName, err = intersectDepends("artifact_name", a.ArtifactName, other.ArtifactName); err != nil {
+		return ArtifactDepends{}, errors.Wrap(err, "ArtifactDepends: Merge")
+	}
+	if merged.ArtifactGroup, err = intersectDepends("artifact_group", a.ArtifactGroup, other.ArtifactGroup); err != nil {
+		return ArtifactDepends{}, errors.Wrap(err, "ArtifactDepends: Merge")
+	}
+	if merged.Extra, err = mergeExtra(a.Extra, other.Extra); err != nil {
+		return ArtifactDepends{}, errors.Wrap(err, "ArtifactDepends: Merge")
+	}
+	return merged, nil
+}
+
+// MergeWith returns the provides of both a and other. ErrMergeConflict is
+// returned if they provide different artifact names, groups, or values for
+// the same additional provides.
+func (a ArtifactProvides) MergeWith(other ArtifactProvides) (ArtifactProvides, error) {
+	merged := ArtifactProvides{}
+	var err error
+	if merged.ArtifactName, err = mergeProvides("artifact_name", a.ArtifactName, other.ArtifactName); err != nil {
+		return ArtifactProvides{}, errors.Wrap(err, "ArtifactProvides: MergeWith")
+	}
+	if merged.ArtifactGroup, err = mergeProvides("artifact_group", a.ArtifactGroup, other.ArtifactGroup); err != nil {
+		return ArtifactProvides{}, errors.Wrap(err, "ArtifactProvides: MergeWith")
+	}
+	if merged.Extra, err = mergeExtra(a.Extra, other.Extra); err != nil {
+		return ArtifactProvides{}, errors.Wrap(err, "ArtifactProvides: MergeWith")
+	}
+	return merged, nil
+}
+
+// unionStrings returns the strings in either a, or b, in order, without
+// duplicates
+func unionStrings(a, b []string) []string {
+	var union []string
+	for _, s := range append(append([]string{}, a...), b...) {
+		if !containsString(union, s) {
+			union = append(union, s)
+		}
+	}
+	return union
+}
+
+// intersectDepends returns the values of the depends key in both a and b, or
+// the values of either, if the other is empty
+func intersectDepends(key string, a, b []string) ([]string, error) {
+	if len(a) == 0 {
+		return unionStrings(b, nil), nil
+	}
+	if len(b) == 0 {
+		return unionStrings(a, nil), nil
+	}
+	var both []string
+	for _, s := range a {
+		if containsString(b, s) && !containsString(both, s) {
+			both = append(both, s)
+		}
+	}
+	if len(both) == 0 {
+		return nil, errors.Wrapf(ErrMergeConflict, "%s: %v and %v have nothing in common", key, a, b)
+	}
+	return both, nil
+}
+
+// mergeProvides returns the value of the provides key, set in either a, or b
+func mergeProvides(key, a, b string) (string, error) {
+	if a != "" && b != "" && a != b {
+		return "", errors.Wrapf(ErrMergeConflict, "%s: %s and %s", key, a, b)
+	}
+	if a != "" {
+		return a, nil
+	}
+	return b, nil
+}
+
+// mergeExtra returns the union of the additional provides, or depends, a and
+// b. The keys in both have to have the same value.
+func mergeExtra(a, b map[string]interface{}) (map[string]interface{}, error) {
+	if a == nil && b == nil {
+		return nil, nil
+	}
+	merged := map[string]interface{}{}
+	for k, v := range a {
+		merged[k] = v
+	}
+	for k, v := range b {
+		if existing, ok := merged[k]; ok && !sameJSON(existing, v) {
+			return nil, errors.Wrapf(ErrMergeConflict, "%s: %v and %v", k, existing, v)
+		}
+		merged[k] = v
+	}
+	return merged, nil
+}
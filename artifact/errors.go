@@ -0,0 +1,166 @@
+package artifact
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The errors returned by Parse for an Artifact not following the format. They
+// can be told apart from I/O errors with errors.Cause, ie,
+//
+//	if err, ok := errors.Cause(err).(*UnexpectedSectionError); ok {
+//		...
+//	}
+//
+// or matched against the sentinels below with errors.Is, ie,
+//
+//	if errors.Is(err, artifact.ErrHeaderMissing) {
+//		...
+//	}
+//
+// Checksum errors are returned as a ChecksumMismatchError, and signature
+// errors as ErrSignatureInvalid.
+
+var (
+	// ErrArtifactNotFound is returned by an ArtifactStore for an Artifact it
+	// does not hold
+	ErrArtifactNotFound = errors.New("Artifact not found")
+
+	// ErrVersionMissing, ErrManifestMissing, ErrHeaderMissing, and
+	// ErrDataMissing match a MissingSectionError for the section, or an
+	// UnexpectedSectionError in the position of the version, or the manifest
+	ErrVersionMissing  = errors.New("Missing version")
+	ErrManifestMissing = errors.New("Missing manifest")
+	ErrHeaderMissing   = errors.New("Missing header")
+	ErrDataMissing     = errors.New("Missing data")
+
+	// ErrUnexpectedEntry matches an UnexpectedSectionError, a
+	// TokenSequenceError, and an unexpected entry in the header
+	ErrUnexpectedEntry = errors.New("Unexpected entry")
+)
+
+// UnexpectedSectionError is returned for a section which is not allowed in
+// its position in the Artifact
+type UnexpectedSectionError struct {
+	Section string
+	// Expected describes the sections allowed in the position
+	Expected string
+}
+
+func (u *UnexpectedSectionError) Error() string {
+	return fmt.Sprintf("Unexpected section: %s, expected %s", u.Section, u.Expected)
+}
+
+func (u *UnexpectedSectionError) Is(target error) bool {
+	switch target {
+	case ErrUnexpectedEntry:
+		return true
+	case ErrVersionMissing, ErrManifestMissing:
+		return target == missingSection(u.Expected)
+	}
+	return false
+}
+
+// MissingSectionError is returned when the Artifact ends before all the
+// required sections have been read, or when the manifest lists a section
+// which is not in the Artifact
+type MissingSectionError struct {
+	Section string
+}
+
+func (m *MissingSectionError) Error() string {
+	return fmt.Sprintf("Unexpected end of the Artifact: Missing %s", m.Section)
+}
+
+func (m *MissingSectionError) Is(target error) bool {
+	return target == missingSection(m.Section)
+}
+
+// missingSection returns the sentinel error for the section missing, ie,
+// ErrHeaderMissing for header.tar.gz, or nil
+func missingSection(section string) error {
+	switch {
+	case section == "version":
+		return ErrVersionMissing
+	case section == "manifest":
+		return ErrManifestMissing
+	case strings.HasPrefix(section, "header"):
+		return ErrHeaderMissing
+	case section == "data" || strings.HasPrefix(filepath.ToSlash(section), "data/"):
+		return ErrDataMissing
+	}
+	return nil
+}
+
+// UnsupportedVersionError is returned for an Artifact of a format version which
+// is not supported, or not the one required through WithVersion
+type UnsupportedVersionError struct {
+	Section  string
+	Expected string
+	Actual   int
+}
+
+func (u *UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("Unsupported Artifact version: %d, expected %s", u.Actual, u.Expected)
+}
+
+// TokenSequenceError is returned by the StateMachineParser for a section
+// which is not allowed in the state the parser is in
+type TokenSequenceError struct {
+	State   string
+	Token   TokenType
+	Section string
+}
+
+func (t *TokenSequenceError) Error() string {
+	return fmt.Sprintf("Unexpected section: %s (%s) in the state %s", t.Section, t.Token, t.State)
+}
+
+func (t *TokenSequenceError) Is(target error) bool {
+	return target == ErrUnexpectedEntry
+}
+
+// SelfTestError is returned by SelfTest for an Artifact whose sections do not
+// match its manifest
+type SelfTestError struct {
+	// Missing are the manifest entries which are not in the Artifact
+	Missing []string
+	// Unexpected are the sections preceding the payloads which are not a
+	// part of the format
+	Unexpected []string
+}
+
+func (s *SelfTestError) Error() string {
+	var problems []string
+	if len(s.Missing) > 0 {
+		problems = append(problems, "Missing: "+strings.Join(s.Missing, ", "))
+	}
+	if len(s.Unexpected) > 0 {
+		problems = append(problems, "Unexpected: "+strings.Join(s.Unexpected, ", "))
+	}
+	return "Self test failed: " + strings.Join(problems, ". ")
+}
+
+// InvalidScriptNameError is returned by Scripts.Validate for state scripts
+// not named <State>_<Enter|Leave|Error>[_nn], or named ambiguously
+type InvalidScriptNameError struct {
+	// Invalid are the names not following the naming convention
+	Invalid []string
+	// Duplicates are the names sharing the state, transition, and priority,
+	// of another script, ie, ArtifactInstall_Enter_1, and _01
+	Duplicates []string
+}
+
+func (s *InvalidScriptNameError) Error() string {
+	var problems []string
+	if len(s.Invalid) > 0 {
+		problems = append(problems, "Invalid: "+strings.Join(s.Invalid, ", "))
+	}
+	if len(s.Duplicates) > 0 {
+		problems = append(problems, "Duplicates: "+strings.Join(s.Duplicates, ", "))
+	}
+	return "Invalid script names: " + strings.Join(problems, ". ")
+}
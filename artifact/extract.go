@@ -0,0 +1,256 @@
+package artifact
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ExtractOptions configures how ExtractAll writes the Artifact to disk
+type ExtractOptions struct {
+	// DecompressPayloads also unpacks the contents of every
+	// data/000n.tar.gz entry into data/000n/
+	DecompressPayloads bool
+}
+
+// ExtractAll writes every section of a parsed Artifact as a separate file in
+// destDir. The layout is:
+//
+//	destDir
+//	  +---version.json
+//	  +---manifest.txt
+//	  +---manifest.sig.b64        (signed Artifacts only)
+//	  +---manifest-augment.txt    (augmented Artifacts only)
+//	  +---header.tar.gz           (or header.tar.zst)
+//	  +---header-augment.tar.gz   (augmented Artifacts only)
+//	  `---data
+//	       +---0000.tar.gz
+//	       +---0000               (ExtractOptions.DecompressPayloads only)
+//	       |    `---<payload files>
+//	       `---000n.tar.gz ...
+func (a *Artifact) ExtractAll(destDir string, opts ExtractOptions) error {
+	if err := os.MkdirAll(filepath.Join(destDir, "data"), 0755); err != nil {
+		return errors.Wrap(err, "ExtractAll: Failed to create the destination directory")
+	}
+	if a.Version == nil {
+		return errors.New("ExtractAll: The Artifact has no version")
+	}
+	version, err := json.Marshal(a.Version)
+	if err != nil {
+		return errors.Wrap(err, "ExtractAll: Failed to marshal the version")
+	}
+	if err = extractFile(destDir, "version.json", version); err != nil {
+		return err
+	}
+	if a.Manifest == nil {
+		return errors.New("ExtractAll: The Artifact has no manifest")
+	}
+	if err = extractFile(destDir, "manifest.txt", a.Manifest.bytes()); err != nil {
+		return err
+	}
+	if a.ManifestSig != nil {
+		// The signature is base64 encoded in the Artifact already
+		if err = extractFile(destDir, "manifest.sig.b64", a.ManifestSig.sig); err != nil {
+			return err
+		}
+	}
+	if a.ManifestAugment != nil {
+		if err = extractFile(destDir, "manifest-augment.txt", manifestBytes(a.ManifestAugment.augData)); err != nil {
+			return err
+		}
+	}
+	if a.HeaderTar == nil || a.HeaderTar.raw == nil {
+		return errors.New("ExtractAll: The Artifact has no header")
+	}
+	headerName := "header.tar" + a.HeaderTar.compression.Extension()
+	if err = extractFile(destDir, headerName, a.HeaderTar.raw); err != nil {
+		return err
+	}
+	if a.HeaderAugment != nil {
+		if err = extractFile(destDir, "header-augment.tar.gz", a.HeaderAugment.raw); err != nil {
+			return err
+		}
+	}
+	if a.Data == nil {
+		return nil
+	}
+	for _, payload := range a.Data.payloads {
+		if err = extractFile(destDir, payload.Name, payload.Data.Bytes()); err != nil {
+			return err
+		}
+		if !opts.DecompressPayloads {
+			continue
+		}
+		compression, err := compressionFromName(payload.Name)
+		if err != nil {
+			return errors.Wrap(err, "ExtractAll")
+		}
+		dir := filepath.Join(destDir, strings.TrimSuffix(trimCompression(payload.Name), ".tar"))
+		if err = extractPayload(dir, payload.Data.Bytes(), compression); err != nil {
+			return errors.Wrapf(err, "ExtractAll: %s", payload.Name)
+		}
+	}
+	return nil
+}
+
+func extractFile(dir, name string, b []byte) error {
+	if err := ioutil.WriteFile(filepath.Join(dir, name), b, 0644); err != nil {
+		return errors.Wrapf(err, "ExtractAll: Failed to write %s", name)
+	}
+	return nil
+}
+
+// extractPayload unpacks the compressed payload tar into dir
+func extractPayload(dir string, b []byte, compression CompressionAlgo) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	zr, err := compression.newReader(bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrap(err, "Failed to decompress the payload")
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return fmt.Errorf("Unexpected payload entry: %s", hdr.Name)
+		}
+		f, err := os.Create(filepath.Join(dir, filepath.Base(hdr.Name)))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// MultiError holds all the errors of an operation which does not stop at the
+// first one
+type MultiError []error
+
+func (m MultiError) Error() string {
+	msgs := make([]string, len(m))
+	for i, err := range m {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d errors: %s", len(m), strings.Join(msgs, "; "))
+}
+
+// ExtractTo unpacks the scripts, and the files of all the payloads, of a
+// parsed Artifact into dir, as:
+//
+//	dir
+//	  +---scripts
+//	  |    `---<scripts>
+//	  `---data
+//	       +---0000
+//	       |    `---<payload files>
+//	       `---000n ...
+//
+// The scripts are made executable. All the files are attempted, and any which
+// fail to extract are returned as a MultiError.
+func (a *Artifact) ExtractTo(dir string) error {
+	if a.HeaderTar == nil {
+		return errors.New("ExtractTo: The Artifact has not been parsed")
+	}
+	var errs MultiError
+	scripts, err := a.HeaderTar.scriptContents()
+	if err != nil {
+		errs = append(errs, errors.Wrap(err, "ExtractTo: Failed to read the scripts"))
+	}
+	if len(scripts) > 0 {
+		if err = os.MkdirAll(filepath.Join(dir, "scripts"), 0755); err != nil {
+			return errors.Wrap(err, "ExtractTo: Failed to create the scripts directory")
+		}
+	}
+	for name, content := range scripts {
+		if err = ioutil.WriteFile(filepath.Join(dir, "scripts", name), content, 0755); err != nil {
+			errs = append(errs, errors.Wrapf(err, "ExtractTo: Failed to write the script %s", name))
+		}
+	}
+	if a.Data != nil {
+		for _, payload := range a.Data.payloads {
+			errs = append(errs, extractPayloadFiles(dir, payload)...)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// extractPayloadFiles unpacks the files of the payload into
+// dir/data/000n/, and returns the errors of the files which failed
+func extractPayloadFiles(dir string, payload PayLoadData) []error {
+	name := strings.TrimSuffix(trimCompression(payload.Name), ".tar")
+	compression, err := compressionFromName(payload.Name)
+	if err != nil {
+		return []error{errors.Wrapf(err, "ExtractTo: %s", payload.Name)}
+	}
+	zr, err := compression.newReader(bytes.NewReader(payload.Data.Bytes()))
+	if err != nil {
+		return []error{errors.Wrapf(err, "ExtractTo: Failed to decompress %s", payload.Name)}
+	}
+	defer zr.Close()
+	if err = os.MkdirAll(filepath.Join(dir, name), 0755); err != nil {
+		return []error{errors.Wrapf(err, "ExtractTo: Failed to create %s", name)}
+	}
+	var errs []error
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return errs
+		} else if err != nil {
+			return append(errs, errors.Wrapf(err, "ExtractTo: Failed to read %s", payload.Name))
+		}
+		file := filepath.Join(name, filepath.Base(hdr.Name))
+		if hdr.Typeflag != tar.TypeReg {
+			errs = append(errs, fmt.Errorf("ExtractTo: Unexpected payload entry: %s", file))
+			continue
+		}
+		if err = writeFile(filepath.Join(dir, file), tr, 0644); err != nil {
+			errs = append(errs, errors.Wrapf(err, "ExtractTo: Failed to write %s", file))
+		}
+	}
+}
+
+func writeFile(path string, r io.Reader, mode os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// manifestBytes formats the manifest entries as in the Artifact.
+// 5ac394718e795d454941487c53d32  data/0000/update.ext4
+func manifestBytes(data []ManifestData) []byte {
+	buf := bytes.NewBuffer(nil)
+	for _, d := range data {
+		fmt.Fprintf(buf, "%s  %s\n", d.Signature, d.Name)
+	}
+	return buf.Bytes()
+}
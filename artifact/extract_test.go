@@ -0,0 +1,62 @@
+package artifact_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+)
+
+func TestExtractAll(t *testing.T) {
+	b := testutil.MakeArtifact(t, testutil.ArtifactOptions{PayloadContent: []byte("rootfs"), Signed: true})
+	a := parse(t, b)
+	defer a.Close()
+	dir, err := ioutil.TempDir("", "extract-all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = a.ExtractAll(dir, artifact.ExtractOptions{DecompressPayloads: true}); err != nil {
+		t.Fatalf("ExtractAll: %v", err)
+	}
+	extracted := func(name string) []byte {
+		t.Helper()
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("ExtractAll did not write %s: %v", name, err)
+		}
+		return content
+	}
+
+	var version artifact.Version
+	if err = json.Unmarshal(extracted("version.json"), &version); err != nil {
+		t.Fatalf("version.json: %v", err)
+	}
+	info := a.Info()
+	if version.Format != info.Format || version.Version != info.Version {
+		t.Errorf("version.json holds %s %d, want %s %d", version.Format, version.Version, info.Format, info.Version)
+	}
+	if content := extracted("data/0000/rootfs.ext4"); string(content) != "rootfs" {
+		t.Errorf("The payload file holds %q, want rootfs", content)
+	}
+	if sig := extracted("manifest.sig.b64"); string(sig) != string(readEntry(t, b, "manifest.sig")) {
+		t.Errorf("manifest.sig.b64 holds %q, want the manifest.sig %q", sig, readEntry(t, b, "manifest.sig"))
+	}
+
+	// The extracted sections are those of the Artifact
+	var entries []string
+	for _, section := range [][2]string{
+		{"version", "version.json"}, {"manifest", "manifest.txt"}, {"manifest.sig", "manifest.sig.b64"},
+		{"header.tar.gz", "header.tar.gz"}, {"data/0000.tar.gz", "data/0000.tar.gz"},
+	} {
+		entries = append(entries, section[0], string(extracted(section[1])))
+	}
+	if rebuilt := parseInfo(t, makeTar(t, entries...)); !reflect.DeepEqual(rebuilt, info) {
+		t.Errorf("The extracted sections parse to %+v, want %+v", rebuilt, info)
+	}
+}
@@ -0,0 +1,127 @@
+package artifact
+
+import (
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrFrozenArtifact is the panic value of the write methods of a
+// FrozenArtifact
+var ErrFrozenArtifact = errors.New("The Artifact is frozen")
+
+// FrozenArtifact is an immutable Artifact, which can safely be shared between
+// goroutines. The methods which modify the Artifact in place panic with
+// ErrFrozenArtifact, while all the others are those of the Artifact.
+type FrozenArtifact struct {
+	*Artifact
+}
+
+// Freeze returns a frozen copy of the Artifact, which has to be closed, like
+// the Artifact
+func (a *Artifact) Freeze() FrozenArtifact {
+	return FrozenArtifact{a.deepCopy()}
+}
+
+// Thaw returns a mutable copy of the frozen Artifact, which has to be closed
+// as well
+func (f FrozenArtifact) Thaw() *Artifact {
+	return f.Artifact.deepCopy()
+}
+
+// deepCopy returns a copy of the Artifact which shares no state with it
+func (a *Artifact) deepCopy() *Artifact {
+	c := a.copyMetadata()
+	if a.Data != nil {
+		payloads := make([]PayLoadData, len(a.Data.payloads))
+		for i, payload := range a.Data.payloads {
+			payloads[i] = PayLoadData{Name: payload.Name}
+			payloads[i].Data.Write(payload.Data.Bytes())
+		}
+		c.Data = &Data{payloads: payloads}
+	}
+	if a.sectionHandlers != nil {
+		c.sectionHandlers = map[string]SectionHandler{}
+		for name, handler := range a.sectionHandlers {
+			c.sectionHandlers[name] = handler
+		}
+	}
+	return c
+}
+
+func (f FrozenArtifact) Parse(r io.Reader) error {
+	panic(ErrFrozenArtifact)
+}
+
+func (f FrozenArtifact) AnnotateScripts(annotations map[string]string) error {
+	panic(ErrFrozenArtifact)
+}
+
+func (f FrozenArtifact) RecomputeManifest() error {
+	panic(ErrFrozenArtifact)
+}
+
+func (f FrozenArtifact) RecalculateChecksumFor(sectionName string) error {
+	panic(ErrFrozenArtifact)
+}
+
+func (f FrozenArtifact) SetTypeInfoProvides(payloadIndex int, checksum string) error {
+	panic(ErrFrozenArtifact)
+}
+
+func (f FrozenArtifact) SetCreationTimestamp(t time.Time) error {
+	panic(ErrFrozenArtifact)
+}
+
+func (f FrozenArtifact) RegisterSectionHandler(name string, handler SectionHandler) {
+	panic(ErrFrozenArtifact)
+}
+
+func (f FrozenArtifact) SignWithTimestamp(key SigningKey, ts time.Time) error {
+	panic(ErrFrozenArtifact)
+}
+
+func (f FrozenArtifact) AddProvidesDependency(key string, value interface{}) error {
+	panic(ErrFrozenArtifact)
+}
+
+func (f FrozenArtifact) RemoveProvidesDependency(key string) error {
+	panic(ErrFrozenArtifact)
+}
+
+func (f FrozenArtifact) GenerateUpdateID() (string, error) {
+	panic(ErrFrozenArtifact)
+}
+
+func (f FrozenArtifact) SetArtifactName(name string) error {
+	panic(ErrFrozenArtifact)
+}
+
+func (f FrozenArtifact) SetArtifactGroup(group string) error {
+	panic(ErrFrozenArtifact)
+}
+
+func (f FrozenArtifact) AddCompatibleDevice(deviceType string) error {
+	panic(ErrFrozenArtifact)
+}
+
+func (f FrozenArtifact) RemoveCompatibleDevice(deviceType string) error {
+	panic(ErrFrozenArtifact)
+}
+
+func (f FrozenArtifact) RenameDevice(oldType, newType string) error {
+	panic(ErrFrozenArtifact)
+}
+
+func (f FrozenArtifact) UpgradeTo(targetVersion int) error {
+	panic(ErrFrozenArtifact)
+}
+
+func (f FrozenArtifact) AddPayloadHeader(sh SubHeader) {
+	panic(ErrFrozenArtifact)
+}
+
+func (f FrozenArtifact) RemovePayloadHeader(index int) error {
+	panic(ErrFrozenArtifact)
+}
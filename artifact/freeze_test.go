@@ -0,0 +1,156 @@
+package artifact_test
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+)
+
+// frozenWrites are calls of the write methods of a FrozenArtifact
+var frozenWrites = map[string]func(f artifact.FrozenArtifact){
+	"Parse":                    func(f artifact.FrozenArtifact) { f.Parse(bytes.NewReader(nil)) },
+	"AnnotateScripts":          func(f artifact.FrozenArtifact) { f.AnnotateScripts(map[string]string{"a": "b"}) },
+	"RecomputeManifest":        func(f artifact.FrozenArtifact) { f.RecomputeManifest() },
+	"RecalculateChecksumFor":   func(f artifact.FrozenArtifact) { f.RecalculateChecksumFor("version") },
+	"SetTypeInfoProvides":      func(f artifact.FrozenArtifact) { f.SetTypeInfoProvides(0, "abc") },
+	"SetCreationTimestamp":     func(f artifact.FrozenArtifact) { f.SetCreationTimestamp(time.Now()) },
+	"RegisterSectionHandler":   func(f artifact.FrozenArtifact) { f.RegisterSectionHandler("release-notes", nil) },
+	"SignWithTimestamp":        func(f artifact.FrozenArtifact) { f.SignWithTimestamp(artifact.SigningKey{}, time.Now()) },
+	"AddProvidesDependency":    func(f artifact.FrozenArtifact) { f.AddProvidesDependency("rootfs-image.version", "2") },
+	"RemoveProvidesDependency": func(f artifact.FrozenArtifact) { f.RemoveProvidesDependency("rootfs-image.version") },
+	"GenerateUpdateID":         func(f artifact.FrozenArtifact) { f.GenerateUpdateID() },
+	"SetArtifactName":          func(f artifact.FrozenArtifact) { f.SetArtifactName("changed") },
+	"SetArtifactGroup":         func(f artifact.FrozenArtifact) { f.SetArtifactGroup("changed") },
+	"AddCompatibleDevice":      func(f artifact.FrozenArtifact) { f.AddCompatibleDevice("raspberrypi4") },
+	"RemoveCompatibleDevice":   func(f artifact.FrozenArtifact) { f.RemoveCompatibleDevice("beaglebone") },
+	"RenameDevice":             func(f artifact.FrozenArtifact) { f.RenameDevice("beaglebone", "raspberrypi4") },
+	"UpgradeTo":                func(f artifact.FrozenArtifact) { f.UpgradeTo(artifact.FormatVersion3) },
+	"AddPayloadHeader":         func(f artifact.FrozenArtifact) { f.AddPayloadHeader(artifact.SubHeader{}) },
+	"RemovePayloadHeader":      func(f artifact.FrozenArtifact) { f.RemovePayloadHeader(0) },
+}
+
+// assertPanics fails the test unless write panics with ErrFrozenArtifact
+func assertPanics(t *testing.T, name string, write func()) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r != artifact.ErrFrozenArtifact {
+			t.Errorf("%s panicked with %v, want ErrFrozenArtifact", name, r)
+		}
+	}()
+	write()
+}
+
+func TestFrozenArtifactWrites(t *testing.T) {
+	a := parse(t, scriptedArtifact(t))
+	defer a.Close()
+	f := a.Freeze()
+	defer f.Close()
+	info := f.Info()
+	for name, write := range frozenWrites {
+		assertPanics(t, name, func() { write(f) })
+	}
+	if !reflect.DeepEqual(f.Info(), info) {
+		t.Errorf("The frozen Artifact changed from %+v to %+v", info, f.Info())
+	}
+}
+
+// frozenReads are the methods of the Artifact which do not modify it, and are
+// therefore promoted to the FrozenArtifact as they are. Any other method has
+// to be overridden in freeze.go.
+var frozenReads = map[string]bool{
+	"AllPayloadTypes": true, "Amend": true, "AugmentedPayloadTypes": true,
+	"BindToDevice": true, "CanUpgradeTo": true, "Clone": true, "Close": true,
+	"Compare": true, "Compress": true, "ContentAddressedName": true,
+	"CreateDeltaFrom": true, "CreationTimestamp": true, "DeviceTypes": true,
+	"DiffWith": true, "ExtractAll": true, "ExtractTo": true,
+	"Fingerprint": true, "Freeze": true, "Info": true, "InspectPayload": true,
+	"IsCompatibleWithDevice": true, "Lint": true, "MarshalJSON": true,
+	"Metadata": true, "Normalize": true, "Obfuscate": true,
+	"PayloadCompressedSize": true, "PayloadReader": true,
+	"PayloadTarEntry": true, "PayloadTypes": true, "Provenance": true,
+	"Rollback": true, "Satisfies": true, "ScriptAnnotation": true,
+	"Scripts": true, "SectionChecksum": true, "SectionSize": true,
+	"SelfTest": true, "Snapshot": true, "String": true,
+	"SupportedStates": true, "TimestampedManifest": true, "ToArchive": true,
+	"TransformPayload": true, "Validate": true, "ValidateAugment": true,
+	"ValidateDeviceCompatibility": true, "VerifyWithPolicy": true,
+	"WrapInHTTPResponse": true, "Write": true, "WriteMetadata": true,
+	"WriteParts": true, "WriteTo": true,
+}
+
+// frozenOverrides returns the names of the methods declared on FrozenArtifact
+// in freeze.go
+func frozenOverrides(t *testing.T) map[string]bool {
+	file, err := parser.ParseFile(token.NewFileSet(), "freeze.go", nil, 0)
+	if err != nil {
+		t.Fatalf("Failed to parse freeze.go: %v", err)
+	}
+	overrides := map[string]bool{}
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv == nil {
+			continue
+		}
+		if recv, ok := fn.Recv.List[0].Type.(*ast.Ident); ok && recv.Name == "FrozenArtifact" {
+			overrides[fn.Name.Name] = true
+		}
+	}
+	return overrides
+}
+
+func TestFrozenArtifactOverrides(t *testing.T) {
+	overrides := frozenOverrides(t)
+	methods := reflect.TypeOf(&artifact.Artifact{})
+	for i := 0; i < methods.NumMethod(); i++ {
+		name := methods.Method(i).Name
+		switch {
+		case frozenReads[name] && overrides[name]:
+			t.Errorf("%s is both read-only and overridden", name)
+		case frozenReads[name]:
+		case !overrides[name]:
+			t.Errorf("%s is neither read-only nor overridden by FrozenArtifact", name)
+		case frozenWrites[name] == nil:
+			t.Errorf("%s is overridden, but not in frozenWrites", name)
+		}
+	}
+	for name := range frozenReads {
+		if _, ok := methods.MethodByName(name); !ok {
+			t.Errorf("The read-only %s is not a method of the Artifact", name)
+		}
+	}
+}
+
+func TestThaw(t *testing.T) {
+	a := parse(t, scriptedArtifact(t))
+	defer a.Close()
+	f := a.Freeze()
+	defer f.Close()
+	info := f.Info()
+
+	thawed := f.Thaw()
+	defer thawed.Close()
+	if !reflect.DeepEqual(thawed.Info(), info) {
+		t.Errorf("Thaw returned %+v, want %+v", thawed.Info(), info)
+	}
+	if err := thawed.SetArtifactName("thawed"); err != nil {
+		t.Fatalf("SetArtifactName: %v", err)
+	}
+	if err := thawed.AddCompatibleDevice("raspberrypi4"); err != nil {
+		t.Fatalf("AddCompatibleDevice: %v", err)
+	}
+	if name := thawed.Info().Name; name != "thawed" {
+		t.Errorf("The thawed Artifact is named %q, want thawed", name)
+	}
+	if !reflect.DeepEqual(f.Info(), info) {
+		t.Errorf("Changing the thawed Artifact changed the frozen one from %+v to %+v", info, f.Info())
+	}
+	if !reflect.DeepEqual(f.DeviceTypes(), []string{"beaglebone"}) {
+		t.Errorf("The frozen Artifact is compatible with %v", f.DeviceTypes())
+	}
+}
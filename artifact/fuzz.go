@@ -0,0 +1,23 @@
+//go:build gofuzz
+// +build gofuzz
+
+package artifact
+
+import "bytes"
+
+// Fuzz is the entry point for go-fuzz. Parse has to fail, and not panic, on
+// any malformed Artifact. Build, and run, it with
+//
+//	go-fuzz-build github.com/olepor/mender-artifact-refac/artifact
+//	go-fuzz -bin artifact-fuzz.zip -workdir fuzz
+//
+// with a few valid Artifacts, ie, of version 2 and 3, in fuzz/corpus to
+// start from. FuzzParse is the same target for the native Go fuzzer.
+func Fuzz(data []byte) int {
+	a, err := NewParser().Parse(bytes.NewReader(data))
+	if err != nil {
+		return 0
+	}
+	a.Close()
+	return 1
+}
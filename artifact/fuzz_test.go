@@ -0,0 +1,35 @@
+//go:build go1.18
+// +build go1.18
+
+package artifact_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+)
+
+// FuzzParse checks that Parse fails, and does not panic, on any malformed
+// Artifact. It starts from a minimal Artifact of version 2, and one of version
+// 3. Run it with
+//
+//	go test -run '^$' -fuzz FuzzParse ./artifact
+//
+// Go 1.18, or later, is required. See fuzz.go for go-fuzz.
+func FuzzParse(f *testing.F) {
+	for _, version := range []int{artifact.FormatVersion2, artifact.FormatVersion3} {
+		f.Add(testutil.MakeArtifact(f, testutil.ArtifactOptions{Version: version}))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		a, err := artifact.NewParser().Parse(bytes.NewReader(data))
+		if err != nil {
+			if a != nil {
+				t.Errorf("Parse returned an Artifact along with the error %v", err)
+			}
+			return
+		}
+		a.Close()
+	})
+}
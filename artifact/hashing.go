@@ -0,0 +1,39 @@
+package artifact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+)
+
+// HashingReader computes the SHA256 of everything read through it
+type HashingReader struct {
+	r      io.Reader
+	h      hash.Hash
+	closer io.Closer
+}
+
+// NewHashingReader returns a HashingReader reading from r
+func NewHashingReader(r io.Reader) *HashingReader {
+	return &HashingReader{r: r, h: sha256.New()}
+}
+
+func (h *HashingReader) Read(b []byte) (n int, err error) {
+	n, err = h.r.Read(b)
+	h.h.Write(b[:n])
+	return n, err
+}
+
+// Sum returns the hex encoded SHA256 of the bytes read so far
+func (h *HashingReader) Sum() string {
+	return hex.EncodeToString(h.h.Sum(nil))
+}
+
+// Close releases the resources held by the underlying reader, if any
+func (h *HashingReader) Close() error {
+	if h.closer == nil {
+		return nil
+	}
+	return h.closer.Close()
+}
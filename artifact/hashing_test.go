@@ -0,0 +1,65 @@
+package artifact_test
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+)
+
+// manifestEntry returns the manifest entry of the file name in a
+func manifestEntry(t *testing.T, a *artifact.Artifact, name string) artifact.ManifestData {
+	t.Helper()
+	for _, entry := range a.Manifest.Data {
+		if entry.Name == name {
+			return entry
+		}
+	}
+	t.Fatalf("The manifest has no entry for %s", name)
+	return artifact.ManifestData{}
+}
+
+func TestPayloadReader(t *testing.T) {
+	a := parse(t, testutil.MakeArtifact(t, testutil.ArtifactOptions{
+		Payloads: []testutil.Payload{
+			{Filename: "rootfs.ext4", Content: []byte("rootfs")},
+			{Filename: "bootloader.img", Content: []byte("bootloader")},
+		},
+	}))
+	defer a.Close()
+
+	for i, file := range []string{"data/0000/rootfs.ext4", "data/0001/bootloader.img"} {
+		r, err := a.PayloadReader(i)
+		if err != nil {
+			t.Fatalf("PayloadReader(%d): %v", i, err)
+		}
+		content, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("Failed to read payload %d: %v", i, err)
+		}
+		if err = r.Close(); err != nil {
+			t.Errorf("Close: %v", err)
+		}
+		if err = manifestEntry(t, a, file).Verify(r.Sum()); err != nil {
+			t.Errorf("The checksum of %q read from payload %d: %v", content, i, err)
+		}
+	}
+
+	// A payload read only in part does not verify
+	r, err := a.PayloadReader(0)
+	if err != nil {
+		t.Fatalf("PayloadReader(0): %v", err)
+	}
+	defer r.Close()
+	if _, err = r.Read(make([]byte, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if err = manifestEntry(t, a, "data/0000/rootfs.ext4").Verify(r.Sum()); err == nil {
+		t.Error("The checksum of a partly read payload verified")
+	}
+
+	if _, err = a.PayloadReader(2); err == nil {
+		t.Error("PayloadReader of a missing payload succeeded")
+	}
+}
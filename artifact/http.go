@@ -0,0 +1,130 @@
+package artifact
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ContentType is the media type of a mender-artifact
+const ContentType = "application/vnd.mender-artifact"
+
+// WrapInHTTPResponse writes the Artifact as the body of an HTTP response,
+// along with the headers needed to deliver it. The ETag is the SHA256 of
+// the Artifact, and Last-Modified is its creation time, if it is known.
+func (a *Artifact) WrapInHTTPResponse(w http.ResponseWriter) error {
+	// The length and checksum have to be known before the body is written,
+	// so the Artifact is written twice, instead of being buffered
+	sha := sha256.New()
+	size, err := a.WriteTo(sha)
+	if err != nil {
+		return errors.Wrap(err, "WrapInHTTPResponse")
+	}
+	sum := sha.Sum(nil)
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("ETag", fmt.Sprintf("%q", fmt.Sprintf("%x", sum)))
+	if created, err := a.CreationTimestamp(); err == nil && created != nil {
+		w.Header().Set("Last-Modified", created.UTC().Format(http.TimeFormat))
+	}
+	if _, err := a.WriteTo(w); err != nil {
+		return errors.Wrap(err, "WrapInHTTPResponse: Failed to write the Artifact")
+	}
+	return nil
+}
+
+// ArtifactStore holds the Artifacts served by ArtifactHandler. The handler
+// closes the Artifacts it gets from the store once they are served.
+type ArtifactStore interface {
+	// Get returns the Artifact name, or ErrArtifactNotFound
+	Get(name string) (*Artifact, error)
+	List() ([]*Artifact, error)
+}
+
+// ArtifactHandler serves the Artifacts in store:
+//
+//	GET /artifacts         The ArtifactInfo of every Artifact, as JSON
+//	GET /artifacts/{name}  The Artifact name
+func ArtifactHandler(store ArtifactStore) http.Handler {
+	return &artifactHandler{store: store}
+}
+
+type artifactHandler struct {
+	store ArtifactStore
+}
+
+func (h *artifactHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch {
+	case r.URL.Path == "/artifacts":
+		h.list(w)
+	case strings.HasPrefix(r.URL.Path, "/artifacts/") && len(r.URL.Path) > len("/artifacts/"):
+		h.get(w, strings.TrimPrefix(r.URL.Path, "/artifacts/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *artifactHandler) list(w http.ResponseWriter) {
+	artifacts, err := h.store.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	infos := make([]ArtifactInfo, 0, len(artifacts))
+	for _, a := range artifacts {
+		infos = append(infos, a.Info())
+		a.Close()
+	}
+	body, err := json.Marshal(infos)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Write(body)
+}
+
+func (h *artifactHandler) get(w http.ResponseWriter, name string) {
+	a, err := h.store.Get(name)
+	if errors.Is(err, ErrArtifactNotFound) || (err == nil && a == nil) {
+		http.Error(w, fmt.Sprintf("Artifact not found: %s", name), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer a.Close()
+	rw := &responseWriter{ResponseWriter: w}
+	if err = a.WrapInHTTPResponse(rw); err == nil {
+		return
+	}
+	// Once the body has been started, the failure can only be logged
+	if !rw.written {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Errorf("ArtifactHandler: Failed to serve %s: %v", name, err)
+}
+
+// responseWriter records whether the body has been started
+type responseWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,122 @@
+package artifact_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+)
+
+// memoryStore parses its Artifacts anew on every Get, and List
+type memoryStore map[string][]byte
+
+func (s memoryStore) Get(name string) (*artifact.Artifact, error) {
+	b, ok := s[name]
+	if !ok {
+		return nil, artifact.ErrArtifactNotFound
+	}
+	return artifact.NewParser().Parse(bytes.NewReader(b))
+}
+
+func (s memoryStore) List() ([]*artifact.Artifact, error) {
+	var artifacts []*artifact.Artifact
+	for name := range s {
+		a, err := s.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, a)
+	}
+	return artifacts, nil
+}
+
+func TestArtifactHandlerClosesArtifacts(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "http-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	// The scripts are extracted to temporary directories in TMPDIR
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	os.Setenv("TMPDIR", tmp)
+
+	store := memoryStore{}
+	for _, name := range []string{"release-1", "release-2"} {
+		store[name] = testutil.MakeArtifact(t, testutil.ArtifactOptions{
+			ArtifactName: name,
+			Scripts:      map[string]string{"ArtifactInstall_Enter_00": "#!/bin/sh\n"},
+		})
+	}
+	server := httptest.NewServer(artifact.ArtifactHandler(store))
+	defer server.Close()
+
+	for path, want := range map[string]int{
+		"/artifacts":           http.StatusOK,
+		"/artifacts/release-1": http.StatusOK,
+		"/artifacts/release-3": http.StatusNotFound,
+	} {
+		res, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != want {
+			t.Errorf("GET %s: %s, want %d", path, res.Status, want)
+		}
+	}
+	if dirs, _ := filepath.Glob(filepath.Join(tmp, "mender-scripts-*")); len(dirs) > 0 {
+		t.Errorf("The script directories %v were not removed", dirs)
+	}
+}
+
+func TestWrapInHTTPResponse(t *testing.T) {
+	a := parse(t, testutil.MakeArtifact(t, testutil.ArtifactOptions{}))
+	defer a.Close()
+
+	w := httptest.NewRecorder()
+	if err := a.WrapInHTTPResponse(w); err != nil {
+		t.Fatalf("WrapInHTTPResponse: %v", err)
+	}
+	if lm := w.Header().Get("Last-Modified"); lm != "" {
+		t.Errorf("Last-Modified is %s for an Artifact without a creation time", lm)
+	}
+
+	created := time.Date(2026, 10, 17, 12, 34, 56, 0, time.UTC)
+	if err := a.SetCreationTimestamp(created); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.RecomputeManifest(); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	if err := a.WrapInHTTPResponse(w); err != nil {
+		t.Fatalf("WrapInHTTPResponse: %v", err)
+	}
+	body := w.Body.Bytes()
+	for header, want := range map[string]string{
+		"Content-Type":   "application/vnd.mender-artifact",
+		"Content-Length": strconv.Itoa(len(body)),
+		"ETag":           fmt.Sprintf("\"%x\"", sha256.Sum256(body)),
+		"Last-Modified":  "Sat, 17 Oct 2026 12:34:56 GMT",
+	} {
+		if got := w.Header().Get(header); got != want {
+			t.Errorf("%s is %q, want %q", header, got, want)
+		}
+	}
+	parsed := parse(t, body)
+	defer parsed.Close()
+	if got, err := parsed.CreationTimestamp(); err != nil || got == nil || !got.Equal(created) {
+		t.Errorf("The Artifact in the response body was created at %v, want %v: %v", got, created, err)
+	}
+}
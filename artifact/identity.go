@@ -0,0 +1,83 @@
+package artifact
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ContentAddressedName returns an identifier of the Artifact content, on the
+// form <artifact_name>@sha256:<sha256>. The checksum is that of the payload
+// for single payload Artifacts, and otherwise the SHA256 of the sorted
+// checksums of all the payloads.
+func (a *Artifact) ContentAddressedName() (string, error) {
+	if a.Manifest == nil || a.HeaderTar == nil || a.HeaderTar.HeaderInfo == nil {
+		return "", errors.New("ContentAddressedName: The Artifact has not been parsed")
+	}
+	var sums []string
+	for _, entry := range a.Manifest.Data {
+		if strings.HasPrefix(filepath.Dir(entry.Name), "data/") {
+			sums = append(sums, entry.Signature)
+		}
+	}
+	if len(sums) == 0 {
+		return "", errors.New("ContentAddressedName: The Artifact has no payloads")
+	}
+	sum := sums[0]
+	if len(sums) > 1 {
+		sort.Strings(sums)
+		sum = fmt.Sprintf("%x", sha256.Sum256([]byte(strings.Join(sums, ""))))
+	}
+	return fmt.Sprintf("%s@sha256:%s", a.HeaderTar.HeaderInfo.ArtifactProvides.ArtifactName, sum), nil
+}
+
+// Fingerprint returns the SHA256 of the Artifact, as written by WriteTo, to
+// tell Artifacts apart by their content rather than their name. Artifacts
+// written to the same bytes have the same fingerprint, while any change, ie,
+// to the Artifact name, changes it.
+func (a *Artifact) Fingerprint() (string, error) {
+	sha := sha256.New()
+	if _, err := a.WriteTo(sha); err != nil {
+		return "", errors.Wrap(err, "Fingerprint")
+	}
+	return fmt.Sprintf("%x", sha.Sum(nil)), nil
+}
+
+// updateIDNamespace is the namespace of the UUIDs generated by
+// GenerateUpdateID
+var updateIDNamespace = [16]byte{
+	0x6d, 0x65, 0x6e, 0x64, 0x65, 0x72, 0x4a, 0x2e,
+	0x9b, 0x1f, 0x61, 0x72, 0x74, 0x69, 0x66, 0x63,
+}
+
+// UpdateIDKey is the Artifact provides key GenerateUpdateID stores the ID in
+const UpdateIDKey = "update_id"
+
+// GenerateUpdateID returns a UUID (version 5) of the content of the
+// Artifact, as given by ContentAddressedName, and stores it in the Artifact
+// provides. The same content always gives the same ID.
+func (a *Artifact) GenerateUpdateID() (string, error) {
+	name, err := a.ContentAddressedName()
+	if err != nil {
+		return "", errors.Wrap(err, "GenerateUpdateID")
+	}
+	provides := &a.HeaderTar.HeaderInfo.ArtifactProvides
+	if provides.Extra == nil {
+		return "", errors.New("GenerateUpdateID: The Artifact provides have not been parsed")
+	}
+	h := sha1.New()
+	h.Write(updateIDNamespace[:])
+	h.Write([]byte(name))
+	u := h.Sum(nil)[:16]
+	u[6] = u[6]&0x0f | 0x50 // Version 5
+	u[8] = u[8]&0x3f | 0x80 // RFC 4122 variant
+	id := fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+	provides.Extra[UpdateIDKey] = id
+	a.HeaderTar.dirty = true
+	return id, nil
+}
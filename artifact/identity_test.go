@@ -0,0 +1,38 @@
+package artifact_test
+
+import (
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+)
+
+func TestFingerprint(t *testing.T) {
+	var fingerprints []string
+	for i := 0; i < 2; i++ {
+		a := parse(t, scriptedArtifact(t))
+		fingerprint, err := a.Fingerprint()
+		a.Close()
+		if err != nil {
+			t.Fatalf("Fingerprint: %v", err)
+		}
+		fingerprints = append(fingerprints, fingerprint)
+	}
+	if fingerprints[0] != fingerprints[1] {
+		t.Errorf("Identical Artifacts have the fingerprints %s and %s", fingerprints[0], fingerprints[1])
+	}
+
+	a := parse(t, scriptedArtifact(t))
+	defer a.Close()
+	if err := a.SetArtifactName("release-2"); err != nil {
+		t.Fatalf("SetArtifactName: %v", err)
+	}
+	if renamed, err := a.Fingerprint(); err != nil || renamed == fingerprints[0] {
+		t.Errorf("The renamed Artifact has the fingerprint %s, %v, like the original", renamed, err)
+	}
+
+	other := parse(t, testutil.MakeArtifact(t, testutil.ArtifactOptions{ArtifactName: "release-1", DeviceType: "beaglebone"}))
+	defer other.Close()
+	if fingerprint, err := other.Fingerprint(); err != nil || fingerprint == fingerprints[0] {
+		t.Errorf("An Artifact without the script has the fingerprint %s, %v, like the original", fingerprint, err)
+	}
+}
@@ -0,0 +1,177 @@
+package artifact
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ArtifactInfo holds all the metadata of a parsed Artifact. It is a copy, and
+// modifying it does not affect the Artifact.
+type ArtifactInfo struct {
+	Name              string           `json:"name"`
+	Version           int              `json:"version"`
+	Format            string           `json:"format"`
+	CompatibleDevices []string         `json:"compatible_devices"`
+	PayloadTypes      []string         `json:"payload_types"`
+	ArtifactDepends   ArtifactDepends  `json:"artifact_depends"`
+	ArtifactProvides  ArtifactProvides `json:"artifact_provides"`
+	Scripts           []string         `json:"scripts"`
+	ManifestEntries   []ManifestData   `json:"manifest"`
+	// AugmentedDepends are the depends of the payloads in the
+	// header-augment, if any, ie, of a delta update
+	AugmentedDepends []TypeInfoDepends `json:"augmented_depends,omitempty"`
+	// SectionSizes are the sizes of the sections read from the Artifact
+	// tar, by name, ie, data/0000.tar.gz
+	SectionSizes map[string]int64 `json:"section_sizes"`
+}
+
+// Info returns the metadata of the Artifact. Sections which have not been
+// parsed are left empty.
+func (a *Artifact) Info() ArtifactInfo {
+	info := ArtifactInfo{
+		CompatibleDevices: []string{},
+		PayloadTypes:      []string{},
+		Scripts:           []string{},
+		ManifestEntries:   []ManifestData{},
+		SectionSizes:      map[string]int64{},
+	}
+	for name, size := range a.sectionSizes {
+		info.SectionSizes[name] = size
+	}
+	if a.Version != nil {
+		info.Version = a.Version.Version
+		info.Format = a.Version.Format
+	}
+	if a.Manifest != nil {
+		info.ManifestEntries = append(info.ManifestEntries, a.Manifest.Data...)
+	}
+	if a.ManifestAugment != nil {
+		info.ManifestEntries = append(info.ManifestEntries, a.ManifestAugment.augData...)
+	}
+	info.AugmentedDepends = a.HeaderAugment.AugmentedDepends()
+	if a.HeaderTar == nil {
+		return info
+	}
+	if a.HeaderTar.Scripts != nil {
+		info.Scripts = append(info.Scripts, a.HeaderTar.Scripts.List()...)
+	}
+	if h := a.HeaderTar.HeaderInfo; h != nil {
+		info.Name = h.ArtifactProvides.ArtifactName
+		info.CompatibleDevices = append(info.CompatibleDevices, h.ArtifactDepends.DeviceType...)
+		info.PayloadTypes = append(info.PayloadTypes, payloadTypes(h.Payloads)...)
+		info.ArtifactProvides = h.ArtifactProvides
+		info.ArtifactProvides.Extra = copyExtra(h.ArtifactProvides.Extra)
+		info.ArtifactDepends = ArtifactDepends{
+			ArtifactName:  append([]string(nil), h.ArtifactDepends.ArtifactName...),
+			DeviceType:    append([]string(nil), h.ArtifactDepends.DeviceType...),
+			ArtifactGroup: append([]string(nil), h.ArtifactDepends.ArtifactGroup...),
+			Extra:         copyExtra(h.ArtifactDepends.Extra),
+		}
+	}
+	return info
+}
+
+// SectionSize returns the size of the section name, ie, version, or
+// data/0000.tar.gz, as given by its header in the Artifact tar, or -1 if the
+// section has not been read. The sizes are the ones read, and are not updated
+// when the Artifact is modified.
+func (a *Artifact) SectionSize(name string) int64 {
+	size, ok := a.sectionSizes[name]
+	if !ok {
+		return -1
+	}
+	return size
+}
+
+// PayloadCompressedSize returns the size of the compressed payload index, ie,
+// of data/0000.tar.gz, or -1 if it has not been read
+func (a *Artifact) PayloadCompressedSize(index int) int64 {
+	prefix := fmt.Sprintf("data/%04d.tar", index)
+	for name, size := range a.sectionSizes {
+		if strings.HasPrefix(name, prefix) {
+			return size
+		}
+	}
+	return -1
+}
+
+func (a *Artifact) setSectionSize(name string, size int64) {
+	if a.sectionSizes == nil {
+		a.sectionSizes = map[string]int64{}
+	}
+	if _, ok := a.sectionSizes[name]; !ok {
+		a.sectionNames = append(a.sectionNames, name)
+	}
+	a.sectionSizes[name] = size
+}
+
+func copyExtra(extra map[string]interface{}) map[string]interface{} {
+	if extra == nil {
+		return nil
+	}
+	c := make(map[string]interface{}, len(extra))
+	for k, v := range extra {
+		c[k] = v
+	}
+	return c
+}
+
+func (i ArtifactInfo) String() string {
+	s := &strings.Builder{}
+	fmt.Fprintf(s, "Name: %s\n", i.Name)
+	fmt.Fprintf(s, "Format: %s\n", i.Format)
+	fmt.Fprintf(s, "Version: %d\n", i.Version)
+	fmt.Fprintf(s, "Compatible devices: %s\n", strings.Join(i.CompatibleDevices, ", "))
+	fmt.Fprintf(s, "Payload types: %s\n", strings.Join(i.PayloadTypes, ", "))
+	if provides, err := json.Marshal(i.ArtifactProvides); err == nil {
+		fmt.Fprintf(s, "Provides: %s\n", provides)
+	}
+	if depends, err := json.Marshal(i.ArtifactDepends); err == nil {
+		fmt.Fprintf(s, "Depends: %s\n", depends)
+	}
+	fmt.Fprintf(s, "Scripts: %s\n", strings.Join(i.Scripts, ", "))
+	fmt.Fprintf(s, "Manifest:\n")
+	for _, entry := range i.ManifestEntries {
+		fmt.Fprintf(s, "\t%s  %s\n", entry.Signature, entry.Name)
+	}
+	for _, depends := range i.AugmentedDepends {
+		fmt.Fprintf(s, "Augmented depends: rootfs_image_checksum: %s\n", depends.RootfsImageChecksum)
+	}
+	return s.String()
+}
+
+func (i ArtifactInfo) MarshalJSON() ([]byte, error) {
+	type info ArtifactInfo
+	return json.Marshal(info(i))
+}
+
+// artifactSummary is the JSON form of an Artifact
+type artifactSummary struct {
+	Version           int            `json:"version"`
+	Format            string         `json:"format"`
+	ArtifactName      string         `json:"artifact_name"`
+	ArtifactGroup     string         `json:"artifact_group"`
+	CompatibleDevices []string       `json:"compatible_devices"`
+	PayloadTypes      []string       `json:"payload_types"`
+	Manifest          []ManifestData `json:"manifest"`
+	Scripts           []string       `json:"scripts"`
+	Augmented         bool           `json:"augmented"`
+}
+
+// MarshalJSON marshals a summary of the metadata of the Artifact. The
+// Artifact can not be unmarshaled from it.
+func (a *Artifact) MarshalJSON() ([]byte, error) {
+	info := a.Info()
+	return json.Marshal(artifactSummary{
+		Version:           info.Version,
+		Format:            info.Format,
+		ArtifactName:      info.Name,
+		ArtifactGroup:     info.ArtifactProvides.ArtifactGroup,
+		CompatibleDevices: info.CompatibleDevices,
+		PayloadTypes:      info.PayloadTypes,
+		Manifest:          info.ManifestEntries,
+		Scripts:           info.Scripts,
+		Augmented:         a.ManifestAugment != nil || a.HeaderAugment != nil,
+	})
+}
@@ -0,0 +1,224 @@
+package artifact
+
+import (
+	"archive/tar"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+//go:generate stringer -type=TokenType -linecomment
+
+// TokenType identifies a section of the outer Artifact tar
+type TokenType int
+
+const (
+	TokenError           TokenType = iota // error
+	TokenEOF                              // EOF
+	TokenVersion                          // version
+	TokenManifest                         // manifest
+	TokenManifestSig                      // manifest.sig
+	TokenManifestAugment                  // manifest-augment
+	TokenHeaderSigned                     // header-signed.tar.gz
+	TokenHeaderTar                        // header.tar
+	TokenHeaderAugment                    // header-augment.tar
+	TokenData                             // data
+	// TokenDataEntry is a file of a payload, named data/<index>/<file>, like
+	// in the manifest. It has to follow the data section of its payload.
+	TokenDataEntry // data entry
+	// TokenExtraFile is any file following the data
+	TokenExtraFile // extra file
+)
+
+// Token is a section of the outer Artifact tar, as identified by the Lexer
+type Token struct {
+	Type TokenType
+	// Value is the name of the section
+	Value string
+	// Offset is the offset of the content of the section in the Artifact
+	// tar, or -1 if it is not known, as for the tokens of NewLexer
+	Offset int64
+	Header tar.Header
+	// Err is set for TokenError
+	Err error
+}
+
+// Lexer identifies the sections of the outer Artifact tar from their tar
+// headers, and verifies that they come in the order given by the format. It
+// knows nothing of the content of the sections.
+//
+// A token is emitted for every header received. Once the header channel is
+// closed, TokenEOF is emitted, or TokenError if the Artifact is incomplete.
+// The token channel is closed after TokenEOF, or the first TokenError.
+type Lexer struct {
+	inputs <-chan lexerInput
+	tokens chan Token
+	order  sectionOrder
+
+	unordered bool // Leave the order checks to the consumer
+}
+
+// lexerInput is a header, and the offset of the content following it
+type lexerInput struct {
+	hdr    tar.Header
+	offset int64
+}
+
+// NewLexer returns a lexer of the headers, and starts lexing them in a
+// separate goroutine
+func NewLexer(headers <-chan tar.Header) *Lexer {
+	inputs := make(chan lexerInput)
+	go func() {
+		defer close(inputs)
+		for hdr := range headers {
+			inputs <- lexerInput{hdr: hdr, offset: -1}
+		}
+	}()
+	return newLexer(inputs, false)
+}
+
+// newLexer returns a lexer of the inputs. An unordered lexer only identifies
+// the sections, and does not check their order, ie, for the
+// StateMachineParser.
+func newLexer(inputs <-chan lexerInput, unordered bool) *Lexer {
+	l := &Lexer{
+		inputs:    inputs,
+		tokens:    make(chan Token),
+		unordered: unordered,
+	}
+	go l.run()
+	return l
+}
+
+// Tokens returns the channel the tokens are emitted on
+func (l *Lexer) Tokens() <-chan Token {
+	return l.tokens
+}
+
+// emit sends a token of the type t for the input in
+func (l *Lexer) emit(t TokenType, in lexerInput, err error) {
+	l.tokens <- Token{Type: t, Value: in.hdr.Name, Offset: in.offset, Header: in.hdr, Err: err}
+}
+
+func (l *Lexer) run() {
+	defer close(l.tokens)
+	end := lexerInput{offset: -1}
+	for in := range l.inputs {
+		t := tokenType(in.hdr.Name)
+		if l.unordered {
+			l.emit(t, in, nil)
+			continue
+		}
+		var err error
+		if t == TokenDataEntry {
+			err = l.order.dataEntry(in.hdr.Name)
+		} else {
+			err = l.order.next(in.hdr.Name)
+		}
+		if err != nil {
+			l.emit(TokenError, in, err)
+			return
+		}
+		l.emit(t, in, nil)
+	}
+	if l.unordered {
+		l.emit(TokenEOF, end, nil)
+		return
+	}
+	if err := l.order.done(); err != nil {
+		l.emit(TokenError, end, err)
+		return
+	}
+	l.emit(TokenEOF, end, nil)
+}
+
+// dataEntry verifies that the payload file name follows the data section of
+// its payload, or another file of it
+func (s *sectionOrder) dataEntry(name string) error {
+	parts := strings.SplitN(name, "/", 3)
+	payload := parts[0] + "/" + parts[1]
+	if !strings.HasPrefix(s.last, payload+".tar") {
+		return &UnexpectedSectionError{Section: name, Expected: payload + ".tar"}
+	}
+	return nil
+}
+
+// isDataEntry reports whether name is a file of a payload, data/<index>/<file>
+func isDataEntry(name string) bool {
+	return strings.HasPrefix(name, "data/") && strings.Count(name, "/") >= 2
+}
+
+// tokenType identifies the section name, which has already passed the order
+// checks
+func tokenType(name string) TokenType {
+	switch {
+	case name == "version":
+		return TokenVersion
+	case name == "manifest":
+		return TokenManifest
+	case name == "manifest.sig":
+		return TokenManifestSig
+	case name == "manifest-augment":
+		return TokenManifestAugment
+	case name == "header-signed.tar.gz":
+		return TokenHeaderSigned
+	case isHeader(name):
+		return TokenHeaderTar
+	case strings.HasPrefix(name, "header-augment.tar"):
+		return TokenHeaderAugment
+	case filepath.Dir(name) == "data":
+		return TokenData
+	case isDataEntry(name):
+		return TokenDataEntry
+	default:
+		return TokenExtraFile
+	}
+}
+
+// parseTokens parses the Artifact read from r, like Parse, but leaves the
+// identification of the sections to a Lexer
+func (a *Artifact) parseTokens(r io.Reader) error {
+	inputs := make(chan lexerInput)
+	tokens := newLexer(inputs, false).Tokens()
+	// Stop the lexer, and wait for it to finish
+	stop := func() {
+		close(inputs)
+		for range tokens {
+		}
+	}
+	cr := &countingReader{r: r}
+	tr := tar.NewReader(cr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			stop()
+			return errors.Wrap(err, "Parse")
+		}
+		inputs <- lexerInput{hdr: *hdr, offset: cr.n}
+		token := <-tokens
+		if token.Type == TokenError {
+			stop()
+			return token.Err
+		}
+		a.setSectionSize(hdr.Name, hdr.Size)
+		if err = a.parseSection(hdr.Name, tr); err != nil {
+			stop()
+			return err
+		}
+		if a.progress != nil {
+			a.progress(hdr.Name, cr.n, hdr.Size)
+		}
+	}
+	close(inputs)
+	token := <-tokens
+	for range tokens {
+	}
+	if token.Type == TokenError {
+		return token.Err
+	}
+	return errors.Wrap(a.verifyManifest(), "Parse")
+}
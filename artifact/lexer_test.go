@@ -0,0 +1,112 @@
+package artifact_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+)
+
+func TestTokenTypeString(t *testing.T) {
+	tests := map[artifact.TokenType]string{
+		artifact.TokenError:           "error",
+		artifact.TokenEOF:             "EOF",
+		artifact.TokenVersion:         "version",
+		artifact.TokenManifest:        "manifest",
+		artifact.TokenManifestSig:     "manifest.sig",
+		artifact.TokenManifestAugment: "manifest-augment",
+		artifact.TokenHeaderSigned:    "header-signed.tar.gz",
+		artifact.TokenHeaderTar:       "header.tar",
+		artifact.TokenHeaderAugment:   "header-augment.tar",
+		artifact.TokenData:            "data",
+		artifact.TokenDataEntry:       "data entry",
+		artifact.TokenExtraFile:       "extra file",
+		artifact.TokenType(-1):        "TokenType(-1)",
+		artifact.TokenType(42):        "TokenType(42)",
+	}
+	for token, want := range tests {
+		if got := token.String(); got != want {
+			t.Errorf("TokenType(%d).String() = %q, want %q", int(token), got, want)
+		}
+	}
+}
+
+// lex returns the tokens the Lexer emits for the sections names
+func lex(names ...string) []artifact.Token {
+	headers := make(chan tar.Header)
+	l := artifact.NewLexer(headers)
+	go func() {
+		for _, name := range names {
+			headers <- tar.Header{Name: name}
+		}
+		close(headers)
+	}()
+	var tokens []artifact.Token
+	for token := range l.Tokens() {
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+func TestLexer(t *testing.T) {
+	names := []string{"version", "manifest", "header.tar.gz", "data/0000.tar.gz",
+		"data/0000/rootfs.ext4", "data/0000/rootfs.ext4.sig"}
+	want := []artifact.TokenType{artifact.TokenVersion, artifact.TokenManifest, artifact.TokenHeaderTar,
+		artifact.TokenData, artifact.TokenDataEntry, artifact.TokenDataEntry, artifact.TokenEOF}
+	tokens := lex(names...)
+	if len(tokens) != len(want) {
+		t.Fatalf("Got %d tokens, want %d: %v", len(tokens), len(want), tokens)
+	}
+	for i, token := range tokens {
+		if token.Type != want[i] {
+			t.Errorf("Token %d is %s, want %s", i, token.Type, want[i])
+		}
+		if i < len(names) && token.Value != names[i] {
+			t.Errorf("Token %d has the value %q, want %q", i, token.Value, names[i])
+		}
+		if token.Offset != -1 {
+			t.Errorf("Token %d has the offset %d, want -1", i, token.Offset)
+		}
+	}
+}
+
+func TestLexerDataEntryOrder(t *testing.T) {
+	for _, names := range [][]string{
+		{"version", "manifest", "header.tar.gz", "data/0001/rootfs.ext4"},
+		{"version", "manifest", "header.tar.gz", "data/0000.tar.gz", "data/0001/rootfs.ext4"},
+	} {
+		tokens := lex(names...)
+		last := tokens[len(tokens)-1]
+		if last.Type != artifact.TokenError || last.Value != "data/0001/rootfs.ext4" {
+			t.Errorf("%v: The last token is %s %s, want an error", names, last.Type, last.Value)
+		}
+	}
+}
+
+func TestTokenOffset(t *testing.T) {
+	b := testutil.MakeArtifact(t, testutil.ArtifactOptions{})
+	offsets := map[string]int64{}
+	p := artifact.NewStateMachineParser()
+	for _, tokenType := range []artifact.TokenType{artifact.TokenVersion, artifact.TokenManifest} {
+		p.Handle(tokenType, func(a *artifact.Artifact, token artifact.Token, r io.Reader) error {
+			content, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(content, b[token.Offset:token.Offset+int64(len(content))]) {
+				t.Errorf("%s is not at the offset %d", token.Value, token.Offset)
+			}
+			offsets[token.Value] = token.Offset
+			return nil
+		})
+	}
+	// Without the version, and the manifest, parsed, Parse fails
+	p.Parse(bytes.NewReader(b))
+	if len(offsets) != 2 {
+		t.Errorf("Got the offsets of %v, want those of version and manifest", offsets)
+	}
+}
@@ -0,0 +1,130 @@
+package artifact
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The codes of the LintWarnings
+const (
+	LintArtifactNameReserved     = "artifact-name-reserved-characters"
+	LintTooManyDeviceTypes       = "too-many-device-types"
+	LintPayloadTooLarge          = "payload-too-large"
+	LintTooManyScripts           = "too-many-scripts"
+	LintArtifactGroupMismatch    = "artifact-group-mismatch"
+	LintRootfsChecksumUnverified = "rootfs-checksum-not-verified"
+)
+
+// LintSeverity is the severity of a LintWarning
+type LintSeverity string
+
+const (
+	LintSeverityError   LintSeverity = "error"
+	LintSeverityWarning LintSeverity = "warning"
+	LintSeverityInfo    LintSeverity = "info"
+)
+
+// LintWarning is a best practice the Artifact does not follow
+type LintWarning struct {
+	Code     string
+	Message  string
+	Severity LintSeverity
+}
+
+func (l LintWarning) String() string {
+	return fmt.Sprintf("%s: %s: %s", l.Severity, l.Code, l.Message)
+}
+
+const (
+	// lintReservedCharacters are not allowed in file names on common
+	// filesystems
+	lintReservedCharacters = `/\:*?"<>|`
+	lintMaxDeviceTypes     = 100
+	// lintMaxPayloadSize is the largest file UEFI (FAT32) can hold
+	lintMaxPayloadSize  = 4 << 30
+	lintMaxStateScripts = 10
+)
+
+// Lint returns the quality problems of the Artifact, which do not make it
+// invalid, but are likely to cause problems when it is deployed.
+func (a *Artifact) Lint() []LintWarning {
+	warnings := []LintWarning{}
+	warn := func(severity LintSeverity, code, format string, args ...interface{}) {
+		warnings = append(warnings, LintWarning{
+			Code:     code,
+			Message:  fmt.Sprintf(format, args...),
+			Severity: severity,
+		})
+	}
+	if a.HeaderTar == nil || a.HeaderTar.HeaderInfo == nil {
+		return warnings
+	}
+	info := a.HeaderTar.HeaderInfo
+
+	name := info.ArtifactProvides.ArtifactName
+	if i := strings.IndexAny(name, lintReservedCharacters); i >= 0 {
+		warn(LintSeverityWarning, LintArtifactNameReserved,
+			"The Artifact name %q contains the reserved character %q", name, name[i])
+	}
+	if n := len(info.ArtifactDepends.DeviceType); n > lintMaxDeviceTypes {
+		warn(LintSeverityWarning, LintTooManyDeviceTypes,
+			"The Artifact depends on %d device types, more than %d", n, lintMaxDeviceTypes)
+	}
+	if info.ArtifactProvides.ArtifactGroup != "" && len(info.ArtifactDepends.ArtifactGroup) == 0 {
+		warn(LintSeverityInfo, LintArtifactGroupMismatch,
+			"The Artifact provides the group %q, but does not depend on any group",
+			info.ArtifactProvides.ArtifactGroup)
+	}
+
+	states := map[string]int{}
+	for _, script := range a.HeaderTar.Scripts.List() {
+		// ie, ArtifactInstall_Enter_00
+		parts := strings.SplitN(script, "_", 3)
+		if len(parts) < 2 {
+			continue
+		}
+		states[parts[0]+"_"+parts[1]]++
+	}
+	var stateNames []string
+	for state := range states {
+		stateNames = append(stateNames, state)
+	}
+	sort.Strings(stateNames)
+	for _, state := range stateNames {
+		if states[state] > lintMaxStateScripts {
+			warn(LintSeverityWarning, LintTooManyScripts,
+				"The state %s has %d scripts, more than %d", state, states[state], lintMaxStateScripts)
+		}
+	}
+
+	if a.Data == nil {
+		return warnings
+	}
+	for i, payload := range a.Data.payloads {
+		if fi, err := a.InspectPayload(i); err == nil && fi.InnerFileSize > lintMaxPayloadSize {
+			warn(LintSeverityError, LintPayloadTooLarge,
+				"The payload %s is %d bytes, larger than the 4 GiB UEFI limit", fi.InnerFileName, fi.InnerFileSize)
+		}
+		// Version 2 Artifacts have no rootfs_image_checksum
+		if i >= len(a.HeaderTar.Headers) || a.HeaderTar.Headers[i].typeInfo == nil ||
+			a.HeaderTar.Headers[i].typeInfo.Type != "rootfs-image" ||
+			a.HeaderTar.formatVersion == FormatVersion2 {
+			continue
+		}
+		provided := a.HeaderTar.Headers[i].typeInfo.TypeInfoProvides.RootfsImageChecksum
+		sums, err := payloadChecksums(payload)
+		if err != nil || len(sums) != 1 {
+			warn(LintSeverityWarning, LintRootfsChecksumUnverified,
+				"The rootfs_image_checksum of the payload %d could not be verified", i)
+			continue
+		}
+		for file, sum := range sums {
+			if sum != provided {
+				warn(LintSeverityWarning, LintRootfsChecksumUnverified,
+					"The rootfs_image_checksum %q does not match the checksum of %s", provided, file)
+			}
+		}
+	}
+	return warnings
+}
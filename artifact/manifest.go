@@ -0,0 +1,248 @@
+package artifact
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ManifestFormat is the format a manifest is exported in
+type ManifestFormat int
+
+const (
+	// ManifestFormatSha256sum is the native format of the manifest, which
+	// can be checked with sha256sum --check
+	ManifestFormatSha256sum ManifestFormat = iota
+	// ManifestFormatJSON is a JSON object mapping each filename to its
+	// checksum
+	ManifestFormatJSON
+)
+
+func (f ManifestFormat) String() string {
+	switch f {
+	case ManifestFormatSha256sum:
+		return "sha256sum"
+	case ManifestFormatJSON:
+		return "json"
+	default:
+		return fmt.Sprintf("ManifestFormat(%d)", int(f))
+	}
+}
+
+// Export writes the manifest to w in the given format
+func (m *Manifest) Export(w io.Writer, format ManifestFormat) error {
+	switch format {
+	case ManifestFormatSha256sum:
+		_, err := w.Write(m.bytes())
+		return errors.Wrap(err, "Manifest: Export")
+	case ManifestFormatJSON:
+		sums := map[string]string{}
+		for _, entry := range m.Data {
+			sums[entry.Name] = entry.Signature
+		}
+		return errors.Wrap(json.NewEncoder(w).Encode(sums), "Manifest: Export")
+	default:
+		return fmt.Errorf("Manifest: Export: Unsupported format: %s", format)
+	}
+}
+
+// Verify checks that sum, the hex encoded SHA256 of the file, matches the
+// manifest entry
+func (m ManifestData) Verify(sum string) error {
+	if m.Signature != sum {
+		return fmt.Errorf("Manifest: Checksum mismatch for %s: expected %s, got %s", m.Name, m.Signature, sum)
+	}
+	return nil
+}
+
+// Lookup returns the checksum of the file filename, if it is in the manifest
+func (m *Manifest) Lookup(filename string) (digest string, ok bool) {
+	i, ok := m.position(filename)
+	if !ok {
+		return "", false
+	}
+	return m.Data[i].Signature, true
+}
+
+// Add adds the file filename, with the checksum digest, to the end of the
+// manifest, or updates its checksum if it is already in the manifest
+func (m *Manifest) Add(filename, digest string) {
+	if i, ok := m.position(filename); ok {
+		m.Data[i].Signature = digest
+	} else {
+		m.Data = append(m.Data, ManifestData{Signature: digest, Name: filename})
+		m.index[filename] = len(m.Data) - 1
+	}
+	m.raw, m.rd = nil, serialized{}
+}
+
+// Remove removes the file filename from the manifest, and reports whether it
+// was in it
+func (m *Manifest) Remove(filename string) bool {
+	i, ok := m.position(filename)
+	if !ok {
+		return false
+	}
+	m.Data = append(m.Data[:i:i], m.Data[i+1:]...)
+	m.reindex()
+	m.raw, m.rd = nil, serialized{}
+	return true
+}
+
+// Regenerate updates the manifest, which has to be the one of the Artifact
+// a, from the sections of a, ie, after the version, or the header, has been
+// changed. Entries are added for the sections missing from the manifest, and
+// dropped for the version, header, and payload files no longer in the
+// Artifact. Other entries, ie, extra files, are left as they are. The header
+// is only rebuilt if it has been modified. Any signature is dropped, if the
+// manifest changes.
+func (m *Manifest) Regenerate(a *Artifact) error {
+	if a == nil || a.Manifest != m {
+		return errors.New("Manifest: Regenerate: Not the manifest of the Artifact")
+	}
+	if a.Version == nil || a.HeaderTar == nil {
+		return errors.New("Manifest: Regenerate: The Artifact has not been parsed")
+	}
+	sums := map[string]string{}
+	version, err := a.Version.bytes()
+	if err != nil {
+		return errors.Wrap(err, "Manifest: Regenerate: Failed to marshal the version")
+	}
+	sums["version"] = manifestEntry("version", version).Signature
+	if a.HeaderTar.dirty || len(a.HeaderTar.ShaSum) == 0 {
+		if err = a.HeaderTar.rebuild(); err != nil {
+			return errors.Wrap(err, "Manifest: Regenerate")
+		}
+	}
+	sums["header.tar"+a.HeaderTar.compression.Extension()] = hex.EncodeToString(a.HeaderTar.ShaSum)
+	if a.HeaderSigned != nil {
+		sums["header-signed.tar.gz"] = manifestEntry("header-signed.tar.gz", a.HeaderSigned.data).Signature
+	}
+	if a.HeaderAugment != nil {
+		sums["header-augment.tar.gz"] = manifestEntry("header-augment.tar.gz", a.HeaderAugment.raw).Signature
+	}
+	for _, payload := range a.Data.Payloads() {
+		payloadSums, err := payloadChecksums(*payload)
+		if err != nil {
+			return errors.Wrap(err, "Manifest: Regenerate")
+		}
+		for name, sum := range payloadSums {
+			sums[name] = sum
+		}
+	}
+	a.updateManifest(sums)
+
+	// The payload files can only be dropped if the payloads have been read
+	changed := false
+	data := make([]ManifestData, 0, len(m.Data))
+	listed := map[string]bool{}
+	for _, entry := range m.Data {
+		if _, ok := sums[entry.Name]; !ok && isParsedSection(entry.Name) &&
+			(filepath.Dir(filepath.Dir(entry.Name)) != "data" || a.Data != nil) {
+			changed = true
+			continue
+		}
+		listed[entry.Name] = true
+		data = append(data, entry)
+	}
+	for name, sum := range sums {
+		if _, ok := a.ManifestAugment.Lookup(name); ok || listed[name] {
+			continue
+		}
+		data = append(data, ManifestData{Signature: sum, Name: name})
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].Name < data[j].Name })
+	m.Data, m.raw = data, nil
+	if a.ManifestSig != nil {
+		log.Warn("The manifest has changed, dropping the now invalid signature")
+		a.ManifestSig = nil
+	}
+	return nil
+}
+
+// position returns the position of filename in Data. As Data may be modified
+// directly, the index is rebuilt whenever it is found to be out of date. The
+// index of a zero Manifest is created here.
+func (m *Manifest) position(filename string) (int, bool) {
+	if m.index == nil || len(m.index) != len(m.Data) {
+		m.reindex()
+	}
+	i, ok := m.index[filename]
+	if ok && (i >= len(m.Data) || m.Data[i].Name != filename) {
+		m.reindex()
+		i, ok = m.index[filename]
+	}
+	return i, ok
+}
+
+func (m *Manifest) reindex() {
+	m.index = make(map[string]int, len(m.Data))
+	for i, entry := range m.Data {
+		m.index[entry.Name] = i
+	}
+}
+
+// Entries returns a copy of the entries of the manifest-augment, in the order
+// they were parsed
+func (m *ManifestAugment) Entries() []ManifestData {
+	if m == nil {
+		return nil
+	}
+	return append([]ManifestData(nil), m.augData...)
+}
+
+// Lookup returns the checksum of the file filename, if it is in the
+// manifest-augment
+func (m *ManifestAugment) Lookup(filename string) (digest string, ok bool) {
+	if m == nil {
+		return "", false
+	}
+	for _, entry := range m.augData {
+		if entry.Name == filename {
+			return entry.Signature, true
+		}
+	}
+	return "", false
+}
+
+// ParseError is returned when a line of the manifest, or the
+// manifest-augment, is malformed
+type ParseError struct {
+	Section string
+	Line    int
+	Text    string
+}
+
+func (p *ParseError) Error() string {
+	return fmt.Sprintf("%s: line %d: Expected a checksum and a filename, got: %q", p.Section, p.Line, p.Text)
+}
+
+// parseManifestLines parses the '<checksum>  <filename>' lines of a manifest.
+// Blank lines are skipped.
+func parseManifestLines(section string, r io.Reader) ([]ManifestData, error) {
+	var data []ManifestData
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return data, &ParseError{Section: section, Line: lineno, Text: scanner.Text()}
+		}
+		data = append(data, ManifestData{Signature: fields[0], Name: fields[1]})
+	}
+	return data, errors.Wrapf(scanner.Err(), "Failed to read the %s", section)
+}
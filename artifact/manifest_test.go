@@ -0,0 +1,105 @@
+package artifact_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+)
+
+func TestManifestAdd(t *testing.T) {
+	m := &artifact.Manifest{}
+	m.Add("version", "aaaa")
+	m.Add("header.tar.gz", "bbbb")
+	m.Add("version", "cccc")
+
+	if len(m.Data) != 2 {
+		t.Fatalf("Data = %v, want two entries", m.Data)
+	}
+	for name, want := range map[string]string{"version": "cccc", "header.tar.gz": "bbbb"} {
+		if digest, ok := m.Lookup(name); !ok || digest != want {
+			t.Errorf("Lookup(%s) = %q, %v, want %q", name, digest, ok, want)
+		}
+	}
+	if !m.Remove("version") {
+		t.Error("Remove(version) = false")
+	}
+	if _, ok := m.Lookup("version"); ok {
+		t.Error("version is still in the manifest")
+	}
+}
+
+func TestManifestExport(t *testing.T) {
+	b := testutil.MakeArtifact(t, testutil.ArtifactOptions{PayloadContent: []byte("rootfs")})
+	a := parse(t, b)
+	defer a.Close()
+
+	var sha256sum bytes.Buffer
+	if err := a.Manifest.Export(&sha256sum, artifact.ManifestFormatSha256sum); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	imported := &artifact.Manifest{}
+	if err := imported.Parse(bytes.NewReader(sha256sum.Bytes())); err != nil {
+		t.Fatalf("Failed to import the sha256sum manifest: %v", err)
+	}
+	if !reflect.DeepEqual(imported.Data, a.Manifest.Data) {
+		t.Errorf("Imported %v from the sha256sum manifest, want %v", imported.Data, a.Manifest.Data)
+	}
+
+	var js bytes.Buffer
+	if err := a.Manifest.Export(&js, artifact.ManifestFormatJSON); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	sums := map[string]string{}
+	if err := json.Unmarshal(js.Bytes(), &sums); err != nil {
+		t.Fatalf("Failed to import the JSON manifest: %v", err)
+	}
+	if len(sums) != len(a.Manifest.Data) {
+		t.Errorf("The JSON manifest has %d entries, want %d", len(sums), len(a.Manifest.Data))
+	}
+	for _, entry := range a.Manifest.Data {
+		if sums[entry.Name] != entry.Signature {
+			t.Errorf("The JSON manifest has %q for %s, want %s", sums[entry.Name], entry.Name, entry.Signature)
+		}
+	}
+
+	if err := a.Manifest.Export(ioutil.Discard, artifact.ManifestFormat(7)); err == nil {
+		t.Error("Export in an unknown format succeeded")
+	}
+
+	// The files of the manifest check out with sha256sum
+	path, err := exec.LookPath("sha256sum")
+	if err != nil {
+		t.Skip("sha256sum is not installed")
+	}
+	dir, err := ioutil.TempDir("", "manifest-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string][]byte{
+		"version":               readEntry(t, b, "version"),
+		"header.tar.gz":         readEntry(t, b, "header.tar.gz"),
+		"data/0000/rootfs.ext4": []byte("rootfs"),
+	}
+	for name, content := range files {
+		if err = os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command(path, "--check", "--strict")
+	cmd.Dir, cmd.Stdin = dir, &sha256sum
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("sha256sum --check: %v\n%s", err, out)
+	}
+}
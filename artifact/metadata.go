@@ -0,0 +1,174 @@
+package artifact
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Metadata returns the meta-data of all the payloads, merged into one map.
+// If the Artifact has more than one payload, the keys are prefixed with the
+// index of the payload, ie, "0.device_revision", "1.device_revision".
+func (a *Artifact) Metadata() map[string]interface{} {
+	metadata := map[string]interface{}{}
+	if a.HeaderTar == nil {
+		return metadata
+	}
+	prefix := len(a.HeaderTar.Headers) > 1
+	for i, header := range a.HeaderTar.Headers {
+		if header.metaData == nil {
+			continue
+		}
+		for k, raw := range header.metaData.Data {
+			var v interface{}
+			if err := json.Unmarshal(raw, &v); err != nil {
+				continue
+			}
+			if prefix {
+				k = fmt.Sprintf("%d.%s", i, k)
+			}
+			metadata[k] = v
+		}
+	}
+	return metadata
+}
+
+// PayloadTypes returns the types of the payloads in the header
+func (a *Artifact) PayloadTypes() []string {
+	if a.HeaderTar == nil || a.HeaderTar.HeaderInfo == nil {
+		return nil
+	}
+	return payloadTypes(a.HeaderTar.HeaderInfo.Payloads)
+}
+
+// AugmentedPayloadTypes returns the types of the payloads in the augmented
+// header, ie, delta updates
+func (a *Artifact) AugmentedPayloadTypes() []string {
+	if a.HeaderAugment == nil || a.HeaderAugment.headerInfo == nil {
+		return nil
+	}
+	return payloadTypes(a.HeaderAugment.headerInfo.Payloads)
+}
+
+// AllPayloadTypes returns the payload types of the header, followed by those
+// of the augmented header
+func (a *Artifact) AllPayloadTypes() []string {
+	return append(a.PayloadTypes(), a.AugmentedPayloadTypes()...)
+}
+
+func payloadTypes(payloads []Payload) []string {
+	types := []string{}
+	for _, payload := range payloads {
+		types = append(types, payload.Type)
+	}
+	return types
+}
+
+// SupportedStates returns the state machine states the scripts of the
+// Artifact run in, sorted. ie, ArtifactInstall_Enter_00 runs in
+// ArtifactInstall.
+func (a *Artifact) SupportedStates() []string {
+	states := []string{}
+	if a.HeaderTar == nil {
+		return states
+	}
+	for _, name := range a.HeaderTar.Scripts.List() {
+		state := strings.SplitN(name, "_", 2)[0]
+		if !containsString(states, state) {
+			states = append(states, state)
+		}
+	}
+	sort.Strings(states)
+	return states
+}
+
+// PayloadFileInfo describes the file held by a payload
+type PayloadFileInfo struct {
+	InnerFileName string
+	InnerFileSize int64
+	InnerFileMode os.FileMode
+	PayloadType   string
+}
+
+// InspectPayload returns the info of the (first) file in the payload index,
+// from its tar header. The file itself is not read.
+func (a *Artifact) InspectPayload(index int) (PayloadFileInfo, error) {
+	if a.Data == nil || index < 0 || index >= len(a.Data.payloads) {
+		return PayloadFileInfo{}, fmt.Errorf("InspectPayload: No payload %d", index)
+	}
+	payload := a.Data.payloads[index]
+	compression, err := compressionFromName(payload.Name)
+	if err != nil {
+		return PayloadFileInfo{}, errors.Wrap(err, "InspectPayload")
+	}
+	zr, err := compression.newReader(bytes.NewReader(payload.Data.Bytes()))
+	if err != nil {
+		return PayloadFileInfo{}, errors.Wrapf(err, "InspectPayload: Failed to decompress %s", payload.Name)
+	}
+	defer zr.Close()
+	hdr, err := tar.NewReader(zr).Next()
+	if err != nil {
+		return PayloadFileInfo{}, errors.Wrapf(err, "InspectPayload: Failed to read %s", payload.Name)
+	}
+	info := PayloadFileInfo{
+		InnerFileName: hdr.Name,
+		InnerFileSize: hdr.Size,
+		InnerFileMode: hdr.FileInfo().Mode(),
+	}
+	if types := a.PayloadTypes(); index < len(types) {
+		info.PayloadType = types[index]
+	}
+	return info, nil
+}
+
+// PayloadTarEntry returns the tar header, and the raw content, of the
+// data/000N.tar.* entry of payload index in the Artifact tar. The content is
+// neither decompressed, nor untarred, and thus matches the checksum in the
+// manifest, if the Artifact lists it. The header is the one the entry is
+// written with by the Artifact.
+func (a *Artifact) PayloadTarEntry(index int) (tar.Header, io.Reader, error) {
+	if a.Data == nil || index < 0 || index >= len(a.Data.payloads) {
+		return tar.Header{}, nil, fmt.Errorf("PayloadTarEntry: No payload %d", index)
+	}
+	payload := a.Data.payloads[index]
+	hdr := tar.Header{
+		Name:     payload.Name,
+		Mode:     0644,
+		Size:     int64(payload.Data.Len()),
+		Typeflag: tar.TypeReg,
+	}
+	return hdr, bytes.NewReader(payload.Data.Bytes()), nil
+}
+
+// PayloadReader returns a reader over the content of the (first) file in the
+// payload index, which computes its checksum as it is read. The reader has to
+// be closed when done.
+func (a *Artifact) PayloadReader(index int) (*HashingReader, error) {
+	if a.Data == nil || index < 0 || index >= len(a.Data.payloads) {
+		return nil, fmt.Errorf("PayloadReader: No payload %d", index)
+	}
+	payload := a.Data.payloads[index]
+	compression, err := compressionFromName(payload.Name)
+	if err != nil {
+		return nil, errors.Wrap(err, "PayloadReader")
+	}
+	zr, err := compression.newReader(bytes.NewReader(payload.Data.Bytes()))
+	if err != nil {
+		return nil, errors.Wrapf(err, "PayloadReader: Failed to decompress %s", payload.Name)
+	}
+	tr := tar.NewReader(zr)
+	if _, err = tr.Next(); err != nil {
+		zr.Close()
+		return nil, errors.Wrapf(err, "PayloadReader: Failed to read %s", payload.Name)
+	}
+	h := NewHashingReader(tr)
+	h.closer = zr
+	return h, nil
+}
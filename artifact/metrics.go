@@ -0,0 +1,58 @@
+package artifact
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MetricsCollector receives the sizes of the sections written by an
+// ArtifactWriter, and the time it took to create, and write, each of them.
+// The uncompressed size of sections which are not compressed, ie, the
+// manifest, is the size written.
+type MetricsCollector interface {
+	RecordSection(name string, compressedBytes, uncompressedBytes int64, duration time.Duration)
+}
+
+// LogMetricsCollector logs the metrics of every section to Logger, or to the
+// standard logger if Logger is nil
+type LogMetricsCollector struct {
+	Logger log.FieldLogger
+}
+
+func (l LogMetricsCollector) RecordSection(name string, compressedBytes, uncompressedBytes int64, duration time.Duration) {
+	logger := l.Logger
+	if logger == nil {
+		logger = log.StandardLogger()
+	}
+	ratio := 1.0
+	if uncompressedBytes > 0 {
+		ratio = float64(compressedBytes) / float64(uncompressedBytes)
+	}
+	logger.WithFields(log.Fields{
+		"section":      name,
+		"compressed":   compressedBytes,
+		"uncompressed": uncompressedBytes,
+		"ratio":        ratio,
+		"duration":     duration,
+	}).Info("Wrote section")
+}
+
+// sectionMetrics are the metrics of a section gathered before it is written
+type sectionMetrics struct {
+	uncompressed int64
+	duration     time.Duration
+}
+
+// recordSection reports the section name, of size bytes, written since start,
+// to the MetricsCollector of the builder, if any
+func (b *ArtifactBuilder) recordSection(name string, size int64, m sectionMetrics, start time.Time) {
+	if b.metrics == nil {
+		return
+	}
+	uncompressed := m.uncompressed
+	if uncompressed == 0 {
+		uncompressed = size
+	}
+	b.metrics.RecordSection(name, size, uncompressed, m.duration+time.Since(start))
+}
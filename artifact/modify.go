@@ -0,0 +1,624 @@
+package artifact
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrUnknownSection is returned for a section which is not a part of the
+// Artifact
+var ErrUnknownSection = errors.New("Unknown section")
+
+// RecomputeManifest regenerates the sections of the Artifact which have been
+// modified since it was parsed, and updates their checksums in the manifest.
+//
+// As the manifest changes, any existing signature is no longer valid, and is
+// dropped. The Artifact has to be signed anew.
+func (a *Artifact) RecomputeManifest() error {
+	if a.Manifest == nil || a.HeaderTar == nil {
+		return errors.New("RecomputeManifest: The Artifact has not been parsed")
+	}
+	if !a.HeaderTar.dirty {
+		return nil
+	}
+	name := "header.tar" + a.HeaderTar.compression.Extension()
+	return errors.Wrap(a.RecalculateChecksumFor(name), "RecomputeManifest")
+}
+
+// RecalculateChecksumFor regenerates the section sectionName, ie,
+// header.tar.gz, and updates its checksum in the manifest. All other manifest
+// entries are left as they are. ErrUnknownSection is returned if the Artifact
+// has no such section.
+func (a *Artifact) RecalculateChecksumFor(sectionName string) error {
+	if a.Version == nil || a.Manifest == nil || a.HeaderTar == nil {
+		return errors.New("RecalculateChecksumFor: The Artifact has not been parsed")
+	}
+	sums := map[string]string{}
+	switch {
+	case sectionName == "version":
+		version, err := a.Version.bytes()
+		if err != nil {
+			return errors.Wrap(err, "RecalculateChecksumFor: Failed to marshal the version")
+		}
+		sums[sectionName] = manifestEntry(sectionName, version).Signature
+	case sectionName == "header.tar"+a.HeaderTar.compression.Extension():
+		if err := a.HeaderTar.rebuild(); err != nil {
+			return errors.Wrap(err, "RecalculateChecksumFor")
+		}
+		sums[sectionName] = hex.EncodeToString(a.HeaderTar.ShaSum)
+	case sectionName == "header-augment.tar.gz" && a.HeaderAugment != nil:
+		sums[sectionName] = manifestEntry(sectionName, a.HeaderAugment.raw).Signature
+	case filepath.Dir(sectionName) == "data" && a.Data != nil:
+		for _, payload := range a.Data.payloads {
+			if payload.Name != sectionName {
+				continue
+			}
+			var err error
+			if sums, err = payloadChecksums(payload); err != nil {
+				return errors.Wrap(err, "RecalculateChecksumFor")
+			}
+		}
+		if len(sums) == 0 {
+			return errors.Wrapf(ErrUnknownSection, "RecalculateChecksumFor: %s", sectionName)
+		}
+	default:
+		return errors.Wrapf(ErrUnknownSection, "RecalculateChecksumFor: %s", sectionName)
+	}
+	a.updateManifest(sums)
+	return nil
+}
+
+// updateManifest sets the checksums of the manifest entries in sums. If the
+// manifest changes, any signature is dropped. It reports whether the manifest
+// changed.
+func (a *Artifact) updateManifest(sums map[string]string) bool {
+	update := func(entries []ManifestData) bool {
+		changed := false
+		for i, entry := range entries {
+			if sum, ok := sums[entry.Name]; ok && sum != entry.Signature {
+				entries[i].Signature = sum
+				changed = true
+			}
+		}
+		return changed
+	}
+	changed := update(a.Manifest.Data)
+	if changed {
+		a.Manifest.raw = nil
+	}
+	if a.ManifestAugment != nil && update(a.ManifestAugment.augData) {
+		a.ManifestAugment.raw = nil
+		changed = true
+	}
+	if changed && a.ManifestSig != nil {
+		log.Warn("The manifest has changed, dropping the now invalid signature")
+		a.ManifestSig = nil
+	}
+	return changed
+}
+
+// payloadChecksums returns the manifest checksums of the files in the payload
+func payloadChecksums(payload PayLoadData) (map[string]string, error) {
+	return readPayloadChecksums(payload.Name, bytes.NewReader(payload.Data.Bytes()))
+}
+
+// readPayloadChecksums returns the manifest checksums of the files in the
+// payload name, whose compressed data is read from r
+func readPayloadChecksums(name string, r io.Reader) (map[string]string, error) {
+	var index int
+	if _, err := fmt.Sscanf(filepath.Base(name), "%04d", &index); err != nil {
+		return nil, errors.Wrapf(err, "Invalid payload name %s", name)
+	}
+	compression, err := compressionFromName(name)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := compression.newReader(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to decompress %s", name)
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+	sums := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return sums, nil
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "Failed to read %s", name)
+		}
+		sum := sha256.New()
+		if _, err = io.Copy(sum, tr); err != nil {
+			return nil, errors.Wrapf(err, "Failed to read %s", name)
+		}
+		sums[fmt.Sprintf("data/%04d/%s", index, hdr.Name)] = hex.EncodeToString(sum.Sum(nil))
+	}
+}
+
+// SetTypeInfoProvides sets the rootfs_image_checksum provided by the payload
+// payloadIndex. Any sub-header depending on the old checksum is updated to
+// depend on the new one. The manifest has to be recomputed afterwards.
+func (a *Artifact) SetTypeInfoProvides(payloadIndex int, checksum string) error {
+	if a.HeaderTar == nil {
+		return errors.New("SetTypeInfoProvides: The Artifact has not been parsed")
+	}
+	h := a.HeaderTar
+	var old string
+	if payloadIndex >= 0 && payloadIndex < len(h.Headers) && h.Headers[payloadIndex].typeInfo != nil {
+		old = h.Headers[payloadIndex].typeInfo.TypeInfoProvides.RootfsImageChecksum
+	}
+	if err := h.setRootfsImageChecksum(payloadIndex, checksum); err != nil {
+		return errors.Wrap(err, "SetTypeInfoProvides")
+	}
+	if old == "" || old == checksum {
+		return nil
+	}
+	for i, sh := range h.Headers {
+		if i == payloadIndex || sh.typeInfo == nil ||
+			sh.typeInfo.TypeInfoDepends.RootfsImageChecksum != old {
+			continue
+		}
+		if err := h.setRootfsImageDepends(i, checksum); err != nil {
+			return errors.Wrap(err, "SetTypeInfoProvides")
+		}
+	}
+	return nil
+}
+
+// setScript replaces the content of the script name, or adds it if it does
+// not already exist.
+func (h *HeaderTar) setScript(name string, content []byte) {
+	if h.scriptUpdates == nil {
+		h.scriptUpdates = map[string][]byte{}
+	}
+	h.scriptUpdates[name] = content
+	h.dirty = true
+}
+
+// setRootfsImageChecksum sets the rootfs_image_checksum provided by the
+// sub-header index
+func (h *HeaderTar) setRootfsImageChecksum(index int, sum string) error {
+	if index < 0 || index >= len(h.Headers) || h.Headers[index].typeInfo == nil {
+		return fmt.Errorf("HeaderTar: No type-info for the payload %d", index)
+	}
+	if h.checksumUpdates == nil {
+		h.checksumUpdates = map[int]string{}
+	}
+	h.checksumUpdates[index] = sum
+	h.Headers[index].typeInfo.TypeInfoProvides.RootfsImageChecksum = sum
+	h.dirty = true
+	return nil
+}
+
+// setRootfsImageDepends sets the rootfs_image_checksum the sub-header index
+// depends on
+func (h *HeaderTar) setRootfsImageDepends(index int, sum string) error {
+	if index < 0 || index >= len(h.Headers) || h.Headers[index].typeInfo == nil {
+		return fmt.Errorf("HeaderTar: No type-info for the payload %d", index)
+	}
+	if h.dependsUpdates == nil {
+		h.dependsUpdates = map[int]string{}
+	}
+	h.dependsUpdates[index] = sum
+	h.Headers[index].typeInfo.TypeInfoDepends.RootfsImageChecksum = sum
+	h.dirty = true
+	return nil
+}
+
+// rebuild regenerates the raw header from the parsed header-info, and any
+// script and checksum updates. All other entries are copied over as is.
+func (h *HeaderTar) rebuild() error {
+	if h.restructured {
+		if err := h.build(); err != nil {
+			return errors.Wrap(err, "HeaderTar")
+		}
+		h.restructured, h.dirty = false, false
+		return nil
+	}
+	if h.raw == nil {
+		return errors.New("HeaderTar: No header to rebuild")
+	}
+	marshal := func() ([]byte, error) { return json.Marshal(h.HeaderInfo) }
+	if h.formatVersion == FormatVersion2 {
+		marshal = h.HeaderInfo.marshalV2
+	}
+	info, err := marshal()
+	if err != nil {
+		return errors.Wrap(err, "HeaderTar: Failed to marshal the header-info")
+	}
+	zr, err := h.compression.newReader(bytes.NewReader(h.raw))
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+
+	buf := bytes.NewBuffer(nil)
+	zw, err := h.newWriter(buf)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(zw)
+
+	// Scripts not already in the header are added after the existing ones
+	var added []string
+	for name := range h.scriptUpdates {
+		added = append(added, name)
+	}
+	sort.Strings(added)
+	writeAdded := func() error {
+		for _, name := range added {
+			if content, ok := h.scriptUpdates[name]; ok {
+				if err := writeTarEntry(tw, "scripts/"+name, content); err != nil {
+					return err
+				}
+			}
+		}
+		added = nil
+		return nil
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "HeaderTar: Failed to read the header")
+		}
+		switch {
+		case hdr.Name == "header-info":
+			err = writeTarEntry(tw, hdr.Name, info)
+		case strings.HasPrefix(hdr.Name, "scripts/"):
+			name := filepath.Base(hdr.Name)
+			if content, ok := h.scriptUpdates[name]; ok {
+				err = writeTarEntry(tw, hdr.Name, content)
+				added = removeString(added, name)
+				break
+			}
+			err = copyTarEntry(tw, hdr, tr)
+		case filepath.Base(hdr.Name) == "type-info":
+			if err = writeAdded(); err != nil {
+				return err
+			}
+			err = h.rebuildTypeInfo(tw, hdr, tr)
+		default:
+			if err = writeAdded(); err != nil {
+				return err
+			}
+			err = copyTarEntry(tw, hdr, tr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err = writeAdded(); err != nil {
+		return err
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	h.raw, h.ShaSum = buf.Bytes(), sum[:]
+	h.scriptUpdates = nil
+	h.checksumUpdates = nil
+	h.dependsUpdates = nil
+	h.dirty = false
+	return nil
+}
+
+// build creates the raw header from the parsed header-info, the scripts, and
+// the sub-headers
+func (h *HeaderTar) build() error {
+	if h.HeaderInfo == nil {
+		return errors.New("No header-info")
+	}
+	if h.formatVersion == FormatVersion2 {
+		return errors.New("Creating a version 2 header is not supported")
+	}
+	info, err := json.Marshal(h.HeaderInfo)
+	if err != nil {
+		return errors.Wrap(err, "Failed to marshal the header-info")
+	}
+	buf := bytes.NewBuffer(nil)
+	zw, err := h.newWriter(buf)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(zw)
+	if err = writeTarEntry(tw, "header-info", info); err != nil {
+		return err
+	}
+	if err = h.writeScripts(tw); err != nil {
+		return err
+	}
+	if err = writeSubHeaders(tw, h.Headers); err != nil {
+		return err
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	h.raw, h.ShaSum = buf.Bytes(), sum[:]
+	h.scriptUpdates = nil
+	h.checksumUpdates = nil
+	h.dependsUpdates = nil
+	return nil
+}
+
+// writeScripts writes the scripts, with any script updates applied, to tw
+func (h *HeaderTar) writeScripts(tw *tar.Writer) error {
+	if len(h.scriptUpdates) == 0 {
+		return h.Scripts.WriteTar(tw)
+	}
+	scripts, err := h.scriptContents()
+	if err != nil {
+		return err
+	}
+	// The parsed scripts keep their order, and any added ones follow them
+	var names, added []string
+	if h.Scripts != nil {
+		for _, path := range h.Scripts.paths() {
+			name := filepath.Base(path)
+			names = append(names, name)
+			if _, ok := scripts[name]; ok {
+				continue
+			}
+			if scripts[name], err = ioutil.ReadFile(path); err != nil {
+				return errors.Wrapf(err, "Failed to read the script %s", name)
+			}
+		}
+		if _, ok := h.scriptUpdates[scriptAnnotationFile]; !ok && len(h.Scripts.annotations) > 0 {
+			if scripts[scriptAnnotationFile], err = json.Marshal(h.Scripts.annotations); err != nil {
+				return errors.Wrap(err, "Failed to marshal the script annotations")
+			}
+		}
+	}
+	if content, ok := h.scriptUpdates[scriptAnnotationFile]; ok {
+		scripts[scriptAnnotationFile] = content
+	}
+	for name := range scripts {
+		if !containsString(names, name) {
+			added = append(added, name)
+		}
+	}
+	sort.Strings(added)
+	for _, name := range append(names, added...) {
+		if err = writeTarEntry(tw, "scripts/"+name, scripts[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// build creates the raw header-augment from the header-info and the
+// sub-headers
+func (h *HeaderAugment) build() error {
+	if h.headerInfo == nil {
+		return errors.New("No header-info")
+	}
+	info, err := json.Marshal(h.headerInfo)
+	if err != nil {
+		return errors.Wrap(err, "Failed to marshal the header-info")
+	}
+	buf := bytes.NewBuffer(nil)
+	zw, err := CompressionGzip.newWriter(buf)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(zw)
+	if err = writeTarEntry(tw, "header-info", info); err != nil {
+		return err
+	}
+	if err = writeSubHeaders(tw, h.subHeaders); err != nil {
+		return err
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		return err
+	}
+	h.raw = buf.Bytes()
+	return nil
+}
+
+// writeSubHeaders writes the type-info, and the meta-data, if any, of the
+// sub-headers to tw
+func writeSubHeaders(tw *tar.Writer, headers []SubHeader) error {
+	for i, sh := range headers {
+		if sh.typeInfo != nil {
+			typeInfo, err := json.Marshal(sh.typeInfo)
+			if err != nil {
+				return errors.Wrap(err, "Failed to marshal the type-info")
+			}
+			if err = writeTarEntry(tw, fmt.Sprintf("headers/%04d/type-info", i), typeInfo); err != nil {
+				return err
+			}
+		}
+		if sh.metaData != nil && sh.metaData.Data != nil {
+			metaData, err := json.Marshal(sh.metaData.Data)
+			if err != nil {
+				return errors.Wrap(err, "Failed to marshal the meta-data")
+			}
+			if err = writeTarEntry(tw, fmt.Sprintf("headers/%04d/meta-data", i), metaData); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rebuildTypeInfo writes the type-info read from r, with any checksum updates
+// applied. Only the checksums are touched, as the type-info may hold fields
+// unknown to TypeInfo.
+func (h *HeaderTar) rebuildTypeInfo(tw *tar.Writer, hdr *tar.Header, r io.Reader) error {
+	var index int
+	if _, err := fmt.Sscanf(filepath.Base(filepath.Dir(hdr.Name)), "%04d", &index); err != nil {
+		return errors.Wrapf(err, "HeaderTar: Invalid sub-header %s", hdr.Name)
+	}
+	provides, okProvides := h.checksumUpdates[index]
+	depends, okDepends := h.dependsUpdates[index]
+	if !okProvides && !okDepends {
+		return copyTarEntry(tw, hdr, r)
+	}
+	typeInfo := map[string]interface{}{}
+	if err := json.NewDecoder(r).Decode(&typeInfo); err != nil {
+		return errors.Wrapf(err, "HeaderTar: Failed to parse %s", hdr.Name)
+	}
+	if okProvides {
+		setChecksum(typeInfo, "artifact_provides", provides)
+	}
+	if okDepends {
+		setChecksum(typeInfo, "artifact_depends", depends)
+	}
+	b, err := json.Marshal(typeInfo)
+	if err != nil {
+		return errors.Wrapf(err, "HeaderTar: Failed to marshal %s", hdr.Name)
+	}
+	return writeTarEntry(tw, hdr.Name, b)
+}
+
+// setChecksum sets the rootfs_image_checksum in the section of the type-info
+func setChecksum(typeInfo map[string]interface{}, section, sum string) {
+	m, _ := typeInfo[section].(map[string]interface{})
+	if m == nil {
+		m = map[string]interface{}{}
+	}
+	m["rootfs_image_checksum"] = sum
+	typeInfo[section] = m
+}
+
+// AddPayloadHeader adds the sub-header sh after the existing ones, along with
+// its payload type in the header-info. The payload itself is not added. The
+// manifest has to be recomputed afterwards.
+func (a *Artifact) AddPayloadHeader(sh SubHeader) {
+	h := a.header()
+	if h.HeaderInfo == nil {
+		h.HeaderInfo = &HeaderInfo{}
+	}
+	sh.name = fmt.Sprintf("%04d", len(h.Headers))
+	h.Headers = append(h.Headers, sh)
+	h.HeaderInfo.Payloads = append(h.HeaderInfo.Payloads, Payload{Type: sh.PayloadType()})
+	h.restructured, h.dirty = true, true
+}
+
+// RemovePayloadHeader removes the sub-header index, along with its payload,
+// and its entries in the header-info and the manifest. The following
+// sub-headers, and payloads, are renumbered to keep the numbering contiguous.
+// The manifest has to be recomputed afterwards.
+func (a *Artifact) RemovePayloadHeader(index int) error {
+	if a.HeaderTar == nil || a.HeaderTar.HeaderInfo == nil {
+		return errors.New("RemovePayloadHeader: The Artifact has not been parsed")
+	}
+	h := a.HeaderTar
+	if index < 0 || index >= len(h.Headers) {
+		return fmt.Errorf("RemovePayloadHeader: No sub-header %d", index)
+	}
+	h.Headers = append(h.Headers[:index:index], h.Headers[index+1:]...)
+	for i := range h.Headers {
+		h.Headers[i].name = fmt.Sprintf("%04d", i)
+	}
+	if payloads := h.HeaderInfo.Payloads; index < len(payloads) {
+		h.HeaderInfo.Payloads = append(payloads[:index:index], payloads[index+1:]...)
+		h.HeaderInfo.rd = serialized{}
+	}
+	h.restructured, h.dirty = true, true
+
+	// renumber returns name, ie, data/0002.tar.gz, or data/0002/update.ext4,
+	// with the payload number moved down by one, if it follows index
+	renumber := func(name string) (string, bool) {
+		var n int
+		if _, err := fmt.Sscanf(strings.TrimPrefix(name, "data/"), "%04d", &n); err != nil {
+			return name, false
+		} else if n <= index {
+			return name, n == index
+		}
+		return fmt.Sprintf("data/%04d", n-1) + name[len("data/0000"):], false
+	}
+	if a.Data != nil {
+		var payloads []PayLoadData
+		for _, payload := range a.Data.payloads {
+			name, removed := renumber(payload.Name)
+			if removed {
+				continue
+			}
+			payload.Name = name
+			payloads = append(payloads, payload)
+		}
+		a.Data.payloads = payloads
+		a.Data.rd = serialized{}
+	}
+	if a.Manifest != nil {
+		var entries []ManifestData
+		for _, entry := range a.Manifest.Data {
+			if !strings.HasPrefix(entry.Name, "data/") {
+				entries = append(entries, entry)
+				continue
+			}
+			name, removed := renumber(entry.Name)
+			if removed {
+				continue
+			}
+			entries = append(entries, ManifestData{Signature: entry.Signature, Name: name})
+		}
+		a.Manifest.Data = entries
+		a.Manifest.reindex()
+		a.Manifest.raw, a.Manifest.rd = nil, serialized{}
+	}
+	return nil
+}
+
+// SetGzipLevel sets the level the header is compressed with when it is
+// regenerated, ie, by Read, if the header is gzip compressed
+func (h *HeaderTar) SetGzipLevel(level int) error {
+	if err := checkGzipLevel(level); err != nil {
+		return errors.Wrap(err, "HeaderTar")
+	}
+	h.gzipLevel = &level
+	return nil
+}
+
+func (h *HeaderTar) newWriter(w io.Writer) (io.WriteCloser, error) {
+	if h.gzipLevel == nil {
+		return h.compression.newWriter(w)
+	}
+	return h.compression.newWriterLevel(w, *h.gzipLevel)
+}
+
+func copyTarEntry(tw *tar.Writer, hdr *tar.Header, r io.Reader) error {
+	if err := tw.WriteHeader(hdr); err != nil {
+		return errors.Wrapf(err, "Failed to write the tar header for %s", hdr.Name)
+	}
+	if _, err := io.Copy(tw, r); err != nil {
+		return errors.Wrapf(err, "Failed to write %s", hdr.Name)
+	}
+	return nil
+}
+
+func removeString(list []string, s string) []string {
+	res := list[:0]
+	for _, l := range list {
+		if l != s {
+			res = append(res, l)
+		}
+	}
+	return res
+}
@@ -0,0 +1,52 @@
+package artifact_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+)
+
+// threePayloadArtifact returns an Artifact with the payloads rootfs.ext4,
+// bootloader.img and dtb.img, in order
+func threePayloadArtifact(t *testing.T) []byte {
+	return testutil.MakeArtifact(t, testutil.ArtifactOptions{Payloads: []testutil.Payload{
+		{Filename: "rootfs.ext4", Content: []byte("rootfs")},
+		{Filename: "bootloader.img", Content: []byte("bootloader")},
+		{Filename: "dtb.img", Content: []byte("dtb")},
+	}})
+}
+
+func TestRemovePayloadHeader(t *testing.T) {
+	a := parse(t, threePayloadArtifact(t))
+	defer a.Close()
+	if err := a.RemovePayloadHeader(1); err != nil {
+		t.Fatalf("RemovePayloadHeader: %v", err)
+	}
+	for i := range a.HeaderTar.Headers {
+		if index := a.HeaderTar.Headers[i].Index(); index != i {
+			t.Errorf("Sub-header %d has the index %d", i, index)
+		}
+	}
+	if n := len(a.HeaderTar.HeaderInfo.Payloads); n != 2 {
+		t.Errorf("The header-info has %d payloads, want 2", n)
+	}
+	if err := a.RecomputeManifest(); err != nil {
+		t.Fatalf("RecomputeManifest: %v", err)
+	}
+
+	removed := parse(t, serialize(t, a))
+	defer removed.Close()
+	var names []string
+	for _, entry := range removed.Manifest.Data {
+		if entry.Name != "version" && entry.Name != "header.tar.gz" {
+			names = append(names, entry.Name)
+		}
+	}
+	if want := []string{"data/0000/rootfs.ext4", "data/0001/dtb.img"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("The manifest lists %v, want %v", names, want)
+	}
+	if n := removed.Data.PayloadCount(); n != 2 {
+		t.Errorf("The Artifact has %d payloads, want 2", n)
+	}
+}
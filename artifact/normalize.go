@@ -0,0 +1,176 @@
+package artifact
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Normalize returns a copy of the Artifact in a canonical form, so that two
+// Artifacts with the same content are byte identical, regardless of the tool
+// which created them. That is:
+//
+//	the manifest entries are sorted
+//	all JSON is compact, with sorted keys
+//	all tar entries have the same mode, and no timestamps or owners
+//	the compressed sections are compressed anew, at the default level
+//
+// As the manifest changes, any signature is dropped.
+func (a *Artifact) Normalize() (_ *Artifact, err error) {
+	if a.Version == nil || a.Manifest == nil || a.HeaderTar == nil || a.Data == nil {
+		return nil, errors.New("Normalize: The Artifact has not been parsed")
+	}
+	n := a.copyMetadata()
+	defer func() {
+		if err != nil {
+			n.closeScripts()
+		}
+	}()
+	if err := n.RecomputeManifest(); err != nil {
+		return nil, errors.Wrap(err, "Normalize")
+	}
+	sums := map[string]ManifestData{}
+
+	version, err := n.Version.bytes()
+	if err != nil {
+		return nil, errors.Wrap(err, "Normalize: Failed to marshal the version")
+	}
+	if version, err = canonicalJSON(version); err != nil {
+		return nil, errors.Wrap(err, "Normalize: Invalid version")
+	}
+	n.Version = &Version{Format: n.Version.Format, Version: n.Version.Version, raw: version}
+	sums["version"] = manifestEntry("version", version)
+
+	if n.HeaderTar.raw, err = normalizeTar(n.HeaderTar.raw, n.HeaderTar.compression, true); err != nil {
+		return nil, errors.Wrap(err, "Normalize: header")
+	}
+	name := "header.tar" + n.HeaderTar.compression.Extension()
+	sums[name] = manifestEntry(name, n.HeaderTar.raw)
+
+	if n.HeaderAugment != nil {
+		augment := *n.HeaderAugment
+		if augment.raw, err = normalizeTar(augment.raw, CompressionGzip, true); err != nil {
+			return nil, errors.Wrap(err, "Normalize: header-augment")
+		}
+		n.HeaderAugment = &augment
+		sums["header-augment.tar.gz"] = manifestEntry("header-augment.tar.gz", augment.raw)
+	}
+
+	payloads := make([]PayLoadData, len(n.Data.payloads))
+	for i, payload := range n.Data.payloads {
+		compression, err := compressionFromName(payload.Name)
+		if err != nil {
+			return nil, errors.Wrap(err, "Normalize")
+		}
+		content, err := normalizeTar(payload.Data.Bytes(), compression, false)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Normalize: %s", payload.Name)
+		}
+		payloads[i] = PayLoadData{Name: payload.Name}
+		payloads[i].Data.Write(content)
+	}
+	n.Data = &Data{payloads: payloads}
+
+	n.Manifest = &Manifest{Data: normalizeManifest(n.Manifest.Data, sums)}
+	if n.ManifestAugment != nil {
+		n.ManifestAugment = &ManifestAugment{augData: normalizeManifest(n.ManifestAugment.augData, sums)}
+	}
+	if n.ManifestSig != nil {
+		log.Warn("The manifest has changed, dropping the now invalid signature")
+		n.ManifestSig = nil
+	}
+
+	// Parse the normalized Artifact, so that all the parsed state matches
+	buf := bytes.NewBuffer(nil)
+	if err = n.writeTar(buf); err != nil {
+		return nil, errors.Wrap(err, "Normalize")
+	}
+	normalized := &Artifact{}
+	if err = normalized.Parse(buf); err != nil {
+		return nil, errors.Wrap(err, "Normalize: Failed to parse the normalized Artifact")
+	}
+	return normalized, nil
+}
+
+// normalizeManifest returns the entries with the checksums in sums applied,
+// sorted by name
+func normalizeManifest(entries []ManifestData, sums map[string]ManifestData) []ManifestData {
+	res := make([]ManifestData, len(entries))
+	for i, entry := range entries {
+		if sum, ok := sums[entry.Name]; ok {
+			entry = sum
+		}
+		res[i] = entry
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// normalizeTar rewrites the compressed tar b with canonical tar headers. If
+// header is set, the JSON files of the Artifact header are made canonical.
+func normalizeTar(b []byte, compression CompressionAlgo, header bool) ([]byte, error) {
+	zr, err := compression.newReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+
+	buf := bytes.NewBuffer(nil)
+	zw, err := compression.newWriter(buf)
+	if err != nil {
+		return nil, err
+	}
+	tw := tar.NewWriter(zw)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to read %s", hdr.Name)
+		}
+		switch filepath.Base(hdr.Name) {
+		case "header-info", "type-info", "meta-data":
+			if !header || len(bytes.TrimSpace(content)) == 0 {
+				break
+			}
+			if content, err = canonicalJSON(content); err != nil {
+				return nil, errors.Wrapf(err, "Invalid %s", hdr.Name)
+			}
+		}
+		if err = writeTarEntry(tw, hdr.Name, content); err != nil {
+			return nil, err
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return nil, err
+	}
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// canonicalJSON re-encodes b compactly, with the object keys sorted
+func canonicalJSON(b []byte) ([]byte, error) {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
@@ -0,0 +1,116 @@
+package artifact_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+)
+
+// regzip returns the gzip compressed b, compressed anew at level
+func regzip(t *testing.T, b []byte, level int) []byte {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.NewBuffer(nil)
+	zw, err := gzip.NewWriterLevel(buf, level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = zw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// reencoded returns the Artifact b as another tool could have written it:
+// with indented JSON, other compression levels and the manifest reversed
+func reencoded(t *testing.T, b []byte) []byte {
+	t.Helper()
+	sums := map[string]string{}
+	rewrite := func(b []byte, name string, rewrite func([]byte) []byte) []byte {
+		return rewriteEntry(t, b, name, func(content []byte) []byte {
+			content = rewrite(content)
+			sum := sha256.Sum256(content)
+			sums[name] = hex.EncodeToString(sum[:])
+			return content
+		})
+	}
+	b = rewrite(b, "version", func(content []byte) []byte {
+		return []byte(strings.NewReplacer("{", "{\n  ", ",", ",\n  ", ":", ": ", "}", "\n}\n").Replace(string(content)))
+	})
+	b = rewrite(b, "header.tar.gz", func(content []byte) []byte {
+		return regzip(t, content, gzip.BestCompression)
+	})
+	b = rewriteEntry(t, b, "data/0000.tar.gz", func(content []byte) []byte {
+		return regzip(t, content, gzip.BestSpeed)
+	})
+	return rewriteEntry(t, b, "manifest", func(content []byte) []byte {
+		lines := strings.SplitAfter(string(content), "\n")
+		manifest := ""
+		for i := len(lines) - 1; i >= 0; i-- {
+			if fields := strings.Fields(lines[i]); len(fields) == 2 {
+				if sum, ok := sums[fields[1]]; ok {
+					lines[i] = sum + "  " + fields[1] + "\n"
+				}
+				manifest += lines[i]
+			}
+		}
+		return []byte(manifest)
+	})
+}
+
+func TestNormalize(t *testing.T) {
+	b := testutil.MakeArtifact(t, testutil.ArtifactOptions{
+		Payloads: []testutil.Payload{
+			{Filename: "rootfs.ext4", Content: []byte("rootfs")},
+			{Filename: "bootloader.img", Content: []byte("bootloader")},
+		},
+	})
+	other := reencoded(t, testutil.MakeArtifact(t, testutil.ArtifactOptions{
+		Payloads: []testutil.Payload{
+			{Filename: "rootfs.ext4", Content: []byte("rootfs")},
+			{Filename: "bootloader.img", Content: []byte("bootloader")},
+		},
+	}))
+	for _, name := range []string{"version", "manifest", "header.tar.gz", "data/0000.tar.gz"} {
+		if bytes.Equal(readEntry(t, b, name), readEntry(t, other, name)) {
+			t.Fatalf("The re-encoded Artifact has the same %s", name)
+		}
+	}
+
+	var normalized [][]byte
+	for _, b := range [][]byte{b, other} {
+		a := parse(t, b)
+		n, err := a.Normalize()
+		a.Close()
+		if err != nil {
+			t.Fatalf("Normalize: %v", err)
+		}
+		normalized = append(normalized, serialize(t, n))
+		n.Close()
+	}
+	if !bytes.Equal(normalized[0], normalized[1]) {
+		t.Error("The normalized Artifacts differ")
+	}
+
+	a := parse(t, normalized[0])
+	defer a.Close()
+	if content := payloadContent(t, a); content != "rootfs" {
+		t.Errorf("The normalized payload is %q, want rootfs", content)
+	}
+}
@@ -0,0 +1,72 @@
+package artifact
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// redacted replaces the values of obfuscated fields
+const redacted = "<redacted>"
+
+// Obfuscate returns a copy of the Artifact with the values of the header-info
+// fields redacted, so that it can be shared without exposing them. A field is
+// redacted in both the provides and the depends of the Artifact, and may be
+// any of artifact_name, artifact_group, device_type, or the key of an
+// additional provide or depend. The manifest is recomputed, and any signature
+// dropped.
+func (a *Artifact) Obfuscate(fields []string) (_ *Artifact, err error) {
+	if a.HeaderTar == nil || a.HeaderTar.HeaderInfo == nil {
+		return nil, errors.New("Obfuscate: The Artifact has not been parsed")
+	}
+	o := a.copyMetadata()
+	defer func() {
+		if err != nil {
+			o.closeScripts()
+		}
+	}()
+	provides := &o.HeaderTar.HeaderInfo.ArtifactProvides
+	depends := &o.HeaderTar.HeaderInfo.ArtifactDepends
+	redactSlice := func(s []string) []string {
+		if len(s) == 0 {
+			return s
+		}
+		return []string{redacted}
+	}
+	for _, field := range fields {
+		switch field {
+		case "artifact_name":
+			provides.ArtifactName = redacted
+			depends.ArtifactName = redactSlice(depends.ArtifactName)
+		case "artifact_group":
+			if provides.ArtifactGroup != "" {
+				provides.ArtifactGroup = redacted
+			}
+			depends.ArtifactGroup = redactSlice(depends.ArtifactGroup)
+		case "device_type":
+			depends.DeviceType = redactSlice(depends.DeviceType)
+		default:
+			found := false
+			for _, extra := range []map[string]interface{}{provides.Extra, depends.Extra} {
+				v, ok := extra[field]
+				if !ok {
+					continue
+				}
+				found = true
+				if _, ok := v.([]interface{}); ok {
+					extra[field] = []string{redacted}
+				} else {
+					extra[field] = redacted
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("Obfuscate: The Artifact has no field %q", field)
+			}
+		}
+	}
+	o.HeaderTar.dirty = true
+	if err := o.RecomputeManifest(); err != nil {
+		return nil, errors.Wrap(err, "Obfuscate")
+	}
+	return o, nil
+}
@@ -0,0 +1,50 @@
+package artifact_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+)
+
+func TestObfuscate(t *testing.T) {
+	a := parse(t, testutil.MakeArtifact(t, testutil.ArtifactOptions{
+		ArtifactName: "customer-release-1",
+		DeviceType:   "beaglebone",
+		Signed:       true,
+	}))
+	defer a.Close()
+
+	o, err := a.Obfuscate([]string{"artifact_name"})
+	if err != nil {
+		t.Fatalf("Obfuscate: %v", err)
+	}
+	defer o.Close()
+	if o.ManifestSig != nil {
+		t.Error("The obfuscated Artifact kept the now invalid signature")
+	}
+	info := parseInfo(t, serialize(t, o))
+	if info.Name != "<redacted>" {
+		t.Errorf("The obfuscated Artifact is named %q", info.Name)
+	}
+	if !reflect.DeepEqual(info.CompatibleDevices, []string{"beaglebone"}) {
+		t.Errorf("The obfuscated Artifact is compatible with %v, want beaglebone", info.CompatibleDevices)
+	}
+	if name := a.Info().Name; name != "customer-release-1" {
+		t.Errorf("Obfuscate renamed the original Artifact to %q", name)
+	}
+
+	o, err = a.Obfuscate([]string{"device_type"})
+	if err != nil {
+		t.Fatalf("Obfuscate: %v", err)
+	}
+	defer o.Close()
+	info = parseInfo(t, serialize(t, o))
+	if info.Name != "customer-release-1" || !reflect.DeepEqual(info.CompatibleDevices, []string{"<redacted>"}) {
+		t.Errorf("Obfuscating the device type gave %s, compatible with %v", info.Name, info.CompatibleDevices)
+	}
+
+	if _, err = a.Obfuscate([]string{"serial_number"}); err == nil {
+		t.Error("Obfuscate of a missing field succeeded")
+	}
+}
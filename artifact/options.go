@@ -0,0 +1,150 @@
+package artifact
+
+import (
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Option configures the Artifact returned by New
+type Option func(*Artifact)
+
+// WithVersion makes parsing fail, unless the Artifact is of the format
+// version v
+func WithVersion(v int) Option {
+	return func(a *Artifact) {
+		a.version = v
+	}
+}
+
+// WithScriptDir sets the directory the state scripts are written to when
+// parsing. The directory is created if need be, and is left as is on Close.
+// Artifacts parsed in parallel need a directory each.
+func WithScriptDir(dir string) Option {
+	return func(a *Artifact) {
+		a.scripts().scriptDir = dir
+	}
+}
+
+// WithTempDir sets the directory the temporary script directory is created
+// in. The default is os.TempDir().
+func WithTempDir(dir string) Option {
+	return func(a *Artifact) {
+		a.scripts().tempDir = dir
+	}
+}
+
+// WithLogger sets the logger of the Artifact
+func WithLogger(l log.FieldLogger) Option {
+	return func(a *Artifact) {
+		a.log = l
+	}
+}
+
+// WithRequestID adds the field request_id, set to id, to every entry logged
+// for the Artifact, ie, to tell apart Artifacts parsed in parallel by a server
+func WithRequestID(id string) Option {
+	return func(a *Artifact) {
+		a.requestID = id
+	}
+}
+
+// WithGzipLevel sets the level the header is compressed with when it is
+// regenerated, and, for an ArtifactWriter, the level of the payloads as well.
+// The level is one of gzip.HuffmanOnly through gzip.BestCompression, and
+// ErrInvalidGzipLevel is returned when writing otherwise.
+func WithGzipLevel(level int) Option {
+	return func(a *Artifact) {
+		a.header().gzipLevel = &level
+	}
+}
+
+// WithCompression sets the compression of the header, and the payloads, of an
+// ArtifactWriter. Parsed Artifacts keep the compression they were read with.
+func WithCompression(algo CompressionAlgo) Option {
+	return func(a *Artifact) {
+		a.header().compression = algo
+	}
+}
+
+// WithMetricsCollector reports the size, and the write time, of every section
+// written by an ArtifactWriter to mc
+func WithMetricsCollector(mc MetricsCollector) Option {
+	return func(a *Artifact) {
+		a.metrics = mc
+	}
+}
+
+// RequireSBOM makes Validate warn about payloads without an SBOM in their
+// meta-data, as set by MetaData.SetSBOM
+func RequireSBOM() Option {
+	return func(a *Artifact) {
+		a.requireSBOM = true
+	}
+}
+
+// header returns the HeaderTar of the Artifact, creating it if need be
+func (a *Artifact) header() *HeaderTar {
+	if a.HeaderTar == nil {
+		a.HeaderTar = &HeaderTar{}
+	}
+	return a.HeaderTar
+}
+
+// scripts returns the Scripts of the Artifact, creating them if need be
+func (a *Artifact) scripts() *Scripts {
+	a.header()
+	if a.HeaderTar.Scripts == nil {
+		a.HeaderTar.Scripts = &Scripts{}
+	}
+	return a.HeaderTar.Scripts
+}
+
+func (a *Artifact) logger() log.FieldLogger {
+	var l log.FieldLogger = log.StandardLogger()
+	if a.log != nil {
+		l = a.log
+	}
+	if a.requestID != "" {
+		return l.WithField("request_id", a.requestID)
+	}
+	return l
+}
+
+// trace logs args at the trace level, or at the debug level for loggers
+// without one
+func trace(l log.FieldLogger, args ...interface{}) {
+	if t, ok := l.(interface{ Trace(...interface{}) }); ok {
+		t.Trace(args...)
+		return
+	}
+	l.Debug(args...)
+}
+
+// Close removes the temporary files of the Artifact, ie, the scripts, unless
+// they were written to a directory given by WithScriptDir, and closes any
+// payload files. Copies of the Artifact, made by Amend et al, have script
+// directories of their own, are not affected by Close, and have to be closed
+// as well.
+func (a *Artifact) Close() error {
+	var err error
+	if a.Data != nil {
+		for i := range a.Data.payloads {
+			if perr := a.Data.payloads[i].Close(); err == nil {
+				err = perr
+			}
+		}
+	}
+	if a.HeaderSigned != nil {
+		if serr := a.HeaderSigned.scripts.Close(); err == nil && !os.IsNotExist(serr) {
+			err = serr
+		}
+	}
+	if a.HeaderTar == nil {
+		return err
+	}
+	if serr := a.HeaderTar.Scripts.Close(); err == nil && !os.IsNotExist(serr) {
+		err = serr
+	}
+	return err
+}
@@ -0,0 +1,609 @@
+package artifact
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Parser reads the sections of an Artifact from the outer Artifact tar
+type Parser struct {
+	// The payloads of the last parsed Artifact, iterated by Next
+	data         *Data
+	artifact     *Artifact // The manifests the payloads are verified against
+	next         int
+	payload      *tar.Reader
+	decompressor io.Closer
+	headerOnly   bool // The last Artifact was parsed by ParseHeader
+
+	reopened io.Closer // The payload file reopened by PayloadReader.Seek
+
+	lexer     bool // Identify the sections with a Lexer
+	checksums *ChecksumRegistry
+
+	// StrictParsing fails the parsing on entries of the header unknown to
+	// the parser. By default, they are skipped, so that Artifacts of future
+	// versions of the format can be read.
+	StrictParsing bool
+}
+
+func NewParser() *Parser {
+	return &Parser{}
+}
+
+// NewParserWithLexer returns a Parser which leaves the identification of the
+// sections of the Artifact to a Lexer
+func NewParserWithLexer() *Parser {
+	return &Parser{lexer: true}
+}
+
+type parseOptions struct {
+	verificationKey crypto.PublicKey
+	progress        func(section string, bytesRead, total int64)
+	strict          bool
+	err             error
+
+	payloadWorkers int
+}
+
+// ParseOption configures Parser.Parse
+type ParseOption func(*parseOptions)
+
+// WithVerification verifies the signature of the Artifact against pubKey
+func WithVerification(pubKey crypto.PublicKey) ParseOption {
+	return func(o *parseOptions) {
+		o.verificationKey = pubKey
+	}
+}
+
+// WithVerificationFromPEMFile verifies the signature of the Artifact against
+// the PEM encoded public key in the file path
+func WithVerificationFromPEMFile(path string) ParseOption {
+	return func(o *parseOptions) {
+		o.verificationKey, o.err = LoadPublicKey(path)
+	}
+}
+
+// WithStrictParsing fails the parsing on entries of the header unknown to the
+// parser, like setting Parser.StrictParsing
+func WithStrictParsing() ParseOption {
+	return func(o *parseOptions) {
+		o.strict = true
+	}
+}
+
+// WithConcurrentPayloads decompresses, and verifies, the payloads of a
+// multi-payload Artifact on workers goroutines, once the whole Artifact has
+// been read. Each goroutine reads its payloads at their offsets in the reader
+// given to Parse, which therefore has to be an io.ReaderAt, and an io.Seeker,
+// like an os.File. The payloads of any other reader are verified
+// sequentially. The checksums are collected in the order of the payloads, and
+// the error of the first failing payload is returned.
+func WithConcurrentPayloads(workers int) ParseOption {
+	return func(o *parseOptions) {
+		if workers < 1 {
+			o.err = errors.Errorf("WithConcurrentPayloads: Invalid number of workers: %d", workers)
+			return
+		}
+		o.payloadWorkers = workers
+	}
+}
+
+// WithProgressFunc calls fn after each section of the Artifact has been
+// parsed, with the name of the section, the number of bytes of the Artifact
+// read so far, and the size of the section, or -1 if unknown. fn is called
+// from the goroutine calling Parse.
+func WithProgressFunc(fn func(section string, bytesRead, total int64)) ParseOption {
+	return func(o *parseOptions) {
+		o.progress = fn
+	}
+}
+
+// Parse parses the whole Artifact read from r. If a verification key is
+// given, the Artifact has to be signed with it, and ErrSignatureInvalid is
+// returned otherwise.
+func (p *Parser) Parse(r io.Reader, opts ...ParseOption) (*Artifact, error) {
+	o, err := applyParseOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	a := &Artifact{progress: o.progress, strictParsing: p.StrictParsing || o.strict}
+	if o.payloadWorkers > 1 {
+		if a.payloadSource = payloadSource(r); a.payloadSource != nil {
+			a.payloadWorkers = o.payloadWorkers
+		} else {
+			log.Debug("Parse: The Artifact cannot be read at an offset, verifying the payloads sequentially")
+		}
+	}
+	parse := a.Parse
+	if p.lexer {
+		parse = a.parseTokens
+	}
+	err = parse(r)
+	// The payloads are only read from r while parsing
+	a.payloadWorkers, a.payloadSource, a.payloadOffsets = 0, nil, nil
+	// Keep the checksums of a failed parse as well, to tell what failed
+	p.checksums, _ = a.checksumRegistry()
+	if err == nil {
+		err = o.verify(a)
+	}
+	if err != nil {
+		a.Close()
+		return nil, err
+	}
+	p.reset()
+	p.data, p.artifact = a.Data, a
+	return a, nil
+}
+
+// Checksums returns the expected, and the actual, checksums of the files of
+// the Artifact parsed last by Parse, or ParseHeader, including those of a
+// parse which failed. Files which were not read are not included.
+func (p *Parser) Checksums() *ChecksumRegistry {
+	if p.checksums == nil {
+		return NewChecksumRegistry()
+	}
+	return p.checksums
+}
+
+// ErrHeaderOnly is returned by Next after ParseHeader, as the payloads have
+// not been read
+var ErrHeaderOnly = errors.New("Only the header of the Artifact has been parsed")
+
+// ParseHeader parses the sections of the Artifact read from r up to, and
+// including, header-augment.tar.gz, and returns as soon as the first payload
+// is reached, without reading it. The checksums of the parsed sections are
+// verified against the manifest.
+//
+// The returned Artifact has HeaderOnly set, and no Data.
+func (p *Parser) ParseHeader(r io.Reader, opts ...ParseOption) (*Artifact, error) {
+	a, _, err := p.parseHeader(r, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "ParseHeader")
+	}
+	return a, nil
+}
+
+// parseHeader parses the Artifact up to the first payload, and returns it
+// along with a PayloadStreamer continuing from there
+func (p *Parser) parseHeader(r io.Reader, opts []ParseOption) (_ *Artifact, _ *PayloadStreamer, err error) {
+	o, err := applyParseOptions(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	a := &Artifact{progress: o.progress, HeaderOnly: true, strictParsing: p.StrictParsing || o.strict}
+	defer func() {
+		if err != nil {
+			a.Close()
+		}
+	}()
+	cr := &countingReader{r: r}
+	tr := tar.NewReader(cr)
+	order := sectionOrder{}
+	var payload *tar.Header
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			// Let sectionOrder tell what is missing
+			if err = order.done(); err != nil {
+				return nil, nil, err
+			}
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+		if err = order.next(hdr.Name); err != nil {
+			return nil, nil, err
+		}
+		a.setSectionSize(hdr.Name, hdr.Size)
+		if filepath.Dir(hdr.Name) == "data" {
+			payload = hdr
+			break
+		}
+		if err = a.parseSection(hdr.Name, tr); err != nil {
+			return nil, nil, err
+		}
+		if a.progress != nil {
+			a.progress(hdr.Name, cr.n, hdr.Size)
+		}
+	}
+	p.checksums, _ = a.checksumRegistry()
+	if err := a.verifyManifest(); err != nil {
+		return nil, nil, err
+	}
+	if err := o.verify(a); err != nil {
+		return nil, nil, err
+	}
+	p.reset()
+	p.headerOnly = true
+	return a, &PayloadStreamer{artifact: a, tr: tr, order: order, hdr: payload}, nil
+}
+
+// payloadSource returns the Artifact read from r, as of the current offset of
+// r, as an io.ReaderAt, or nil if r cannot be read at an offset
+func payloadSource(r io.Reader) io.ReaderAt {
+	ra, ok := r.(io.ReaderAt)
+	s, seekable := r.(io.Seeker)
+	if !ok || !seekable {
+		return nil
+	}
+	start, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil
+	}
+	return io.NewSectionReader(ra, start, math.MaxInt64-start)
+}
+
+func applyParseOptions(opts []ParseOption) (parseOptions, error) {
+	o := parseOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.err != nil {
+		return o, errors.Wrap(o.err, "Parse")
+	}
+	return o, nil
+}
+
+// verify checks the signature of the Artifact, if a verification key is given
+func (o parseOptions) verify(a *Artifact) error {
+	if o.verificationKey == nil {
+		return nil
+	}
+	if a.ManifestSig == nil {
+		return errors.Wrap(ErrSignatureInvalid, "Parse: The Artifact is not signed")
+	}
+	if err := a.ManifestSig.Verify(o.verificationKey); err != nil {
+		return invalidSignature(err, "Parse")
+	}
+	return nil
+}
+
+func (p *Parser) closeReopened() {
+	if p.reopened != nil {
+		p.reopened.Close()
+		p.reopened = nil
+	}
+}
+
+func (p *Parser) reset() {
+	if p.decompressor != nil {
+		p.decompressor.Close()
+	}
+	p.closeReopened()
+	p.data, p.artifact, p.next, p.payload, p.decompressor = nil, nil, 0, nil, nil
+	p.headerOnly = false
+}
+
+// Next returns a reader for the next payload file of the Artifact parsed last,
+// in the order of the payloads, ie, data/0000/update.ext4,
+// data/0001/update.ext4, or io.EOF when there are no more payload files. The
+// returned reader is only valid until the next call. ErrHeaderOnly is returned
+// if the Artifact was parsed by ParseHeader.
+//
+// The file is verified against the manifest as it is read, and the reader
+// returns a ChecksumMismatchError at the end of a file not matching it.
+// ErrManifestEntryMissing is returned for a file which is not in the manifest.
+func (p *Parser) Next() (*PayloadReader, error) {
+	if p.headerOnly {
+		return nil, ErrHeaderOnly
+	}
+	p.closeReopened()
+	for {
+		if p.payload != nil {
+			hdr, err := p.payload.Next()
+			if err == nil {
+				name := fmt.Sprintf("data/%04d/%s", p.next-1, hdr.Name)
+				expected, ok := p.artifact.manifestChecksum(name)
+				if !ok {
+					return nil, errors.Wrapf(ErrManifestEntryMissing, "Parser: %s", name)
+				}
+				payload := &p.data.payloads[p.next-1]
+				compression, _ := compressionFromName(payload.Name)
+				fileName := hdr.Name
+				return &PayloadReader{
+					name:           hdr.Name,
+					index:          p.next - 1,
+					size:           hdr.Size,
+					h:              NewHashingReader(p.payload),
+					expected:       expected,
+					compressedSize: int64(payload.Data.Len()),
+					// The payloads are in memory, so they can always be reopened
+					reopen: func() (io.Reader, error) {
+						p.closeReopened()
+						r, closer, err := openPayloadFile(bytes.NewReader(payload.Data.Bytes()), compression, fileName)
+						p.reopened = closer
+						return r, err
+					},
+				}, nil
+			}
+			p.decompressor.Close()
+			p.payload, p.decompressor = nil, nil
+			if err != io.EOF {
+				return nil, errors.Wrapf(err, "Parser: Failed to read the payload %d", p.next-1)
+			}
+		}
+		if p.next >= p.data.PayloadCount() {
+			return nil, io.EOF
+		}
+		payload, err := p.data.PayloadAt(p.next)
+		if err != nil {
+			return nil, errors.Wrap(err, "Parser")
+		}
+		p.next++
+		compression, err := compressionFromName(payload.Name)
+		if err != nil {
+			return nil, errors.Wrap(err, "Parser")
+		}
+		zr, err := compression.newReader(bytes.NewReader(payload.Data.Bytes()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Parser: Failed to decompress %s", payload.Name)
+		}
+		p.payload, p.decompressor = tar.NewReader(zr), zr
+	}
+}
+
+// ArtifactSection is a single entry in the outer Artifact tar.
+// ie, version, manifest, header.tar.gz, data/0000.tar.gz...
+type ArtifactSection struct {
+	Name string
+	Data io.Reader
+}
+
+// ParseReader parses the Artifact read from r in a separate goroutine, and
+// sends every section on the returned section channel, in the order they
+// appear in the Artifact. The section channel is closed once the whole
+// Artifact has been read, or on the first error. Errors are sent on the error
+// channel, which is closed after the section channel.
+//
+// The data of each section is buffered, so the consumer does not have to
+// read it before receiving the next section.
+func (p *Parser) ParseReader(r io.Reader) (chan ArtifactSection, chan error) {
+	sections := make(chan ArtifactSection)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(sections)
+		tr := tar.NewReader(r)
+		order := sectionOrder{}
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				if err = order.done(); err != nil {
+					errs <- err
+				}
+				return
+			}
+			if err != nil {
+				errs <- errors.Wrap(err, "ParseReader")
+				return
+			}
+			if err = order.next(hdr.Name); err != nil {
+				errs <- err
+				return
+			}
+			log.Tracef("ParseReader: section: %s", hdr.Name)
+			buf := bytes.NewBuffer(nil)
+			if _, err = io.Copy(buf, tr); err != nil {
+				errs <- errors.Wrapf(err, "ParseReader: Failed to read %s", hdr.Name)
+				return
+			}
+			sections <- ArtifactSection{Name: hdr.Name, Data: buf}
+		}
+	}()
+	return sections, errs
+}
+
+// ParseAt reads the single section whose tar header starts at offset in rs,
+// ie, the offset of a SectionInfo.
+func (p *Parser) ParseAt(rs io.ReadSeeker, offset int64) (ArtifactSection, error) {
+	if _, err := rs.Seek(offset, io.SeekStart); err != nil {
+		return ArtifactSection{}, errors.Wrap(err, "ParseAt")
+	}
+	tr := tar.NewReader(rs)
+	hdr, err := tr.Next()
+	if err != nil {
+		return ArtifactSection{}, errors.Wrapf(err, "ParseAt: No section at offset %d", offset)
+	}
+	buf := bytes.NewBuffer(nil)
+	if _, err = io.Copy(buf, tr); err != nil {
+		return ArtifactSection{}, errors.Wrapf(err, "ParseAt: Failed to read %s", hdr.Name)
+	}
+	return ArtifactSection{Name: hdr.Name, Data: buf}, nil
+}
+
+// SectionInfo locates a section in the Artifact tar
+type SectionInfo struct {
+	Name string
+	// Offset is the offset of the tar header of the section
+	Offset int64
+	// Size is the size of the section data
+	Size int64
+}
+
+// SectionReader lists the sections of an Artifact, without reading their
+// data into memory.
+type SectionReader struct {
+	cr *countingReader
+	tr *tar.Reader
+}
+
+func NewSectionReader(r io.Reader) *SectionReader {
+	cr := &countingReader{r: r}
+	return &SectionReader{cr: cr, tr: tar.NewReader(cr)}
+}
+
+// Next returns the next section of the Artifact, or io.EOF when there are no
+// more sections.
+func (s *SectionReader) Next() (SectionInfo, error) {
+	// Skip the data of the previous section, so that the offset points to
+	// the block following it
+	if _, err := io.Copy(ioutil.Discard, s.tr); err != nil {
+		return SectionInfo{}, errors.Wrap(err, "SectionReader")
+	}
+	offset := (s.cr.n + tarBlockSize - 1) / tarBlockSize * tarBlockSize
+	hdr, err := s.tr.Next()
+	if err == io.EOF {
+		return SectionInfo{}, io.EOF
+	} else if err != nil {
+		return SectionInfo{}, errors.Wrap(err, "SectionReader")
+	}
+	return SectionInfo{Name: hdr.Name, Offset: offset, Size: hdr.Size}, nil
+}
+
+// RandomAccessParser locates the sections of an Artifact, and reads any one
+// of them, without reading the ones preceding it. Only the tar headers of the
+// sections are read to locate them.
+type RandomAccessParser struct {
+	r    io.ReaderAt
+	size int64
+
+	sections []randomAccessSection
+	err      error
+	indexed  bool
+}
+
+type randomAccessSection struct {
+	SectionInfo
+	dataOffset int64
+}
+
+func NewRandomAccessParser(r io.ReaderAt, size int64) *RandomAccessParser {
+	return &RandomAccessParser{r: r, size: size}
+}
+
+// index locates all the sections of the Artifact, on the first call
+func (p *RandomAccessParser) index() error {
+	if p.indexed {
+		return p.err
+	}
+	p.indexed = true
+	for offset := int64(0); offset < p.size; {
+		cr := &countingReader{r: io.NewSectionReader(p.r, offset, p.size-offset)}
+		hdr, err := tar.NewReader(cr).Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			p.err = errors.Wrapf(err, "RandomAccessParser: Failed to read the section at offset %d", offset)
+			return p.err
+		}
+		// The tar reader has only read the header blocks
+		dataOffset := offset + cr.n
+		p.sections = append(p.sections, randomAccessSection{
+			SectionInfo: SectionInfo{Name: hdr.Name, Offset: offset, Size: hdr.Size},
+			dataOffset:  dataOffset,
+		})
+		offset = dataOffset + (hdr.Size+tarBlockSize-1)/tarBlockSize*tarBlockSize
+	}
+	return nil
+}
+
+// Sections returns all the sections of the Artifact, in order
+func (p *RandomAccessParser) Sections() ([]SectionInfo, error) {
+	if err := p.index(); err != nil {
+		return nil, err
+	}
+	sections := make([]SectionInfo, len(p.sections))
+	for i, section := range p.sections {
+		sections[i] = section.SectionInfo
+	}
+	return sections, nil
+}
+
+// Section returns a reader of the data of the section name, ie, header.tar.gz
+func (p *RandomAccessParser) Section(name string) (io.Reader, error) {
+	if err := p.index(); err != nil {
+		return nil, err
+	}
+	for _, section := range p.sections {
+		if section.Name == name {
+			return io.NewSectionReader(p.r, section.dataOffset, section.Size), nil
+		}
+	}
+	return nil, errors.Wrapf(ErrUnknownSection, "RandomAccessParser: %s", name)
+}
+
+// sectionOrder verifies that the sections of an Artifact appear in the order
+// given by the format:
+//
+//	version
+//	manifest
+//	manifest.sig           (optional)
+//	manifest-augment       (optional, signed Artifacts only)
+//	header-signed.tar.gz   (optional, signed Artifacts only)
+//	header.tar.gz
+//	header-augment.tar.gz  (optional)
+//	data/0000.tar.gz
+//	data/000n.tar.gz ...
+//	<extra files>          (optional)
+type sectionOrder struct {
+	last string
+}
+
+func (s *sectionOrder) next(name string) error {
+	var ok bool
+	var expected string
+	switch s.last {
+	case "":
+		ok, expected = name == "version", "version"
+	case "version":
+		ok, expected = name == "manifest", "manifest"
+	case "manifest":
+		ok, expected = name == "manifest.sig" || isHeader(name), "manifest.sig or header.tar"
+	case "manifest.sig":
+		ok = name == "manifest-augment" || name == "header-signed.tar.gz" || isHeader(name)
+		expected = "manifest-augment, header-signed.tar.gz or header.tar"
+	case "manifest-augment":
+		ok, expected = name == "header-signed.tar.gz" || isHeader(name), "header-signed.tar.gz or header.tar"
+	case "header-signed.tar.gz":
+		ok, expected = isHeader(name), "header.tar"
+	case "header.tar":
+		ok = strings.HasPrefix(name, "header-augment.tar") || filepath.Dir(name) == "data"
+		expected = "header-augment.tar or data"
+	case "header-augment.tar":
+		ok, expected = filepath.Dir(name) == "data", "data"
+	default:
+		// Data, and any extra files following the data
+		ok = true
+	}
+	if !ok {
+		return &UnexpectedSectionError{Section: name, Expected: expected}
+	}
+	switch {
+	case isHeader(name):
+		s.last = "header.tar"
+	case strings.HasPrefix(name, "header-augment.tar"):
+		s.last = "header-augment.tar"
+	default:
+		s.last = name
+	}
+	return nil
+}
+
+// done verifies that all the required sections have been seen
+func (s *sectionOrder) done() error {
+	switch s.last {
+	case "":
+		return &MissingSectionError{Section: "version"}
+	case "version":
+		return &MissingSectionError{Section: "manifest"}
+	case "manifest", "manifest.sig", "manifest-augment", "header-signed.tar.gz":
+		return &MissingSectionError{Section: "header.tar.gz"}
+	case "header.tar", "header-augment.tar":
+		return &MissingSectionError{Section: "data"}
+	}
+	return nil
+}
+
+func isHeader(name string) bool {
+	return strings.HasPrefix(name, "header.tar")
+}
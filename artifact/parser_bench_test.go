@@ -0,0 +1,98 @@
+package artifact_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	mathrand "math/rand"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+)
+
+const (
+	smallPayloadSize = 1 << 10
+	largePayloadSize = 100 << 20
+	flushPayloadSize = 1 << 20
+)
+
+// payload returns size bytes of incompressible content, so that the size of
+// the Artifact is close to that of its payload
+func payload(size int) []byte {
+	b := make([]byte, size)
+	mathrand.New(mathrand.NewSource(1)).Read(b)
+	return b
+}
+
+// benchmarkParse parses the Artifact b b.N times
+func benchmarkParse(b *testing.B, artifactBytes []byte) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(artifactBytes)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		a, err := artifact.NewParser().Parse(bytes.NewReader(artifactBytes))
+		if err != nil {
+			b.Fatalf("Parse: %v", err)
+		}
+		a.Close()
+	}
+}
+
+func BenchmarkParseSmallArtifact(b *testing.B) {
+	benchmarkParse(b, testutil.MakeArtifact(b, testutil.ArtifactOptions{
+		PayloadContent: payload(smallPayloadSize),
+	}))
+}
+
+func BenchmarkParseLargePayload(b *testing.B) {
+	benchmarkParse(b, testutil.MakeArtifact(b, testutil.ArtifactOptions{
+		PayloadContent: payload(largePayloadSize),
+	}))
+}
+
+func BenchmarkArtifactWriterFlush(b *testing.B) {
+	content := payload(flushPayloadSize)
+	buf := bytes.NewBuffer(nil)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(testutil.MakeArtifact(b, testutil.ArtifactOptions{PayloadContent: content}))))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		aw := artifact.NewArtifactWriter(buf)
+		aw.SetArtifactName("test-artifact")
+		aw.SetCompatibleDevices([]string{"test-device"})
+		if err := aw.AddPayload("rootfs-image", "rootfs.ext4", bytes.NewReader(content)); err != nil {
+			b.Fatalf("AddPayload: %v", err)
+		}
+		if err := aw.Flush(); err != nil {
+			b.Fatalf("Flush: %v", err)
+		}
+	}
+}
+
+func BenchmarkManifestVerify(b *testing.B) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		b.Fatal(err)
+	}
+	artifactBytes := testutil.MakeArtifact(b, testutil.ArtifactOptions{
+		PayloadContent: payload(smallPayloadSize),
+		Signed:         true,
+		Key:            key,
+	})
+	a, err := artifact.NewParser().Parse(bytes.NewReader(artifactBytes))
+	if err != nil {
+		b.Fatalf("Parse: %v", err)
+	}
+	defer a.Close()
+	b.ReportAllocs()
+	b.SetBytes(int64(len(artifactBytes)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := a.ManifestSig.Verify(key.Public()); err != nil {
+			b.Fatalf("Verify: %v", err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package artifact_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+	"github.com/pkg/errors"
+)
+
+// makeTar returns a tar of the entries, in order, as name, content pairs
+func makeTar(t *testing.T, entries ...string) []byte {
+	t.Helper()
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	for i := 0; i < len(entries); i += 2 {
+		hdr := &tar.Header{Name: entries[i], Mode: 0644, Size: int64(len(entries[i+1]))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entries[i+1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// The outer tar of an Artifact is not compressed, only its entries are. The
+// header is required, so the minimal Artifact has four entries.
+func TestParseMinimalArtifact(t *testing.T) {
+	b := testutil.MakeArtifact(t, testutil.ArtifactOptions{})
+	var entries []string
+	for _, name := range []string{"version", "manifest", "header.tar.gz", "data/0000.tar.gz"} {
+		entries = append(entries, name, string(readEntry(t, b, name)))
+	}
+	a, err := artifact.NewParser().Parse(bytes.NewReader(makeTar(t, entries...)))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	defer a.Close()
+	if a.Data.PayloadCount() != 1 {
+		t.Errorf("Got %d payloads, want 1", a.Data.PayloadCount())
+	}
+}
+
+func TestParseErrorTypes(t *testing.T) {
+	b := testutil.MakeArtifact(t, testutil.ArtifactOptions{})
+	version, manifest := string(readEntry(t, b, "version")), string(readEntry(t, b, "manifest"))
+	header, data := string(readEntry(t, b, "header.tar.gz")), string(readEntry(t, b, "data/0000.tar.gz"))
+	tests := map[string]struct {
+		entries []string
+		want    error
+		// typeOnly compares the type of the error, but not its fields
+		typeOnly bool
+	}{
+		"wrong section order": {
+			entries: []string{"manifest", manifest, "version", version},
+			want:    &artifact.UnexpectedSectionError{Section: "manifest", Expected: "version"},
+		},
+		"bad version JSON": {
+			entries:  []string{"version", `{"format": "mender", "version": `},
+			want:     &json.SyntaxError{},
+			typeOnly: true,
+		},
+		"unsupported version": {
+			entries: []string{"version", `{"format": "mender", "version": 7}`},
+			want:    &artifact.UnsupportedVersionError{Section: "version", Expected: "2 or 3", Actual: 7},
+		},
+		"missing header.tar.gz": {
+			entries: []string{"version", version, "manifest", manifest},
+			want:    &artifact.MissingSectionError{Section: "header.tar.gz"},
+		},
+		"data instead of header.tar.gz": {
+			entries: []string{"version", version, "manifest", manifest, "data/0000.tar.gz", data},
+			want:    &artifact.UnexpectedSectionError{Section: "data/0000.tar.gz", Expected: "manifest.sig or header.tar"},
+		},
+		"header.tar.gz twice": {
+			entries: []string{"version", version, "manifest", manifest, "header.tar.gz", header, "header.tar.gz", header},
+			want:    &artifact.UnexpectedSectionError{Section: "header.tar.gz", Expected: "header-augment.tar or data"},
+		},
+	}
+	for name, test := range tests {
+		_, err := artifact.NewParser().Parse(bytes.NewReader(makeTar(t, test.entries...)))
+		target := reflect.New(reflect.TypeOf(test.want))
+		if !errors.As(err, target.Interface()) {
+			t.Errorf("%s: Parse returned %v, want a %T", name, err, test.want)
+			continue
+		}
+		if !test.typeOnly && !reflect.DeepEqual(target.Elem().Interface(), test.want) {
+			t.Errorf("%s: Parse returned %+v, want %+v", name, target.Elem().Interface(), test.want)
+		}
+	}
+}
+
+func TestParseReader(t *testing.T) {
+	b := testutil.MakeArtifact(t, testutil.ArtifactOptions{
+		Payloads: []testutil.Payload{
+			{Filename: "rootfs.ext4", Content: []byte("rootfs")},
+			{Filename: "bootloader.img", Content: []byte("bootloader")},
+		},
+		Signed: true,
+	})
+	sections, errs := artifact.NewParser().ParseReader(bytes.NewReader(b))
+	var names []string
+	for section := range sections {
+		names = append(names, section.Name)
+		content, err := ioutil.ReadAll(section.Data)
+		if err != nil {
+			t.Fatalf("Read %s: %v", section.Name, err)
+		}
+		if !bytes.Equal(content, readEntry(t, b, section.Name)) {
+			t.Errorf("Received %s with the wrong content", section.Name)
+		}
+	}
+	if err := <-errs; err != nil {
+		t.Errorf("ParseReader: %v", err)
+	}
+	want := []string{"version", "manifest", "manifest.sig", "header.tar.gz", "data/0000.tar.gz", "data/0001.tar.gz"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Received the sections %v, want %v", names, want)
+	}
+
+	// The sections are sent up to the one out of order
+	sections, errs = artifact.NewParser().ParseReader(bytes.NewReader(makeTar(t,
+		"version", string(readEntry(t, b, "version")), "header.tar.gz", string(readEntry(t, b, "header.tar.gz")))))
+	names = nil
+	for section := range sections {
+		names = append(names, section.Name)
+	}
+	var unexpected *artifact.UnexpectedSectionError
+	if err := <-errs; !errors.As(err, &unexpected) || unexpected.Section != "header.tar.gz" {
+		t.Errorf("ParseReader of the sections out of order returned %v", err)
+	}
+	if !reflect.DeepEqual(names, []string{"version"}) {
+		t.Errorf("Received the sections %v out of order, want version", names)
+	}
+}
@@ -0,0 +1,160 @@
+package artifact
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// PayloadStreamer streams the payloads of an Artifact parsed by
+// ParsePartial straight from the reader of the Artifact, without buffering
+// them. The payloads are either read file by file, through Next, or as the
+// compressed payload tars, one after the other, through Read, but the two
+// can not be mixed.
+type PayloadStreamer struct {
+	artifact *Artifact
+	tr       *tar.Reader
+	order    sectionOrder
+	hdr      *tar.Header // The payload read by parseHeader, if not yet used
+
+	// The state of Next
+	payload      *tar.Reader
+	payloadIndex int
+	payloadSize  int64
+	decompressor io.Closer
+	// The state of Read
+	reading            bool // A payload tar is being read
+	usedRead, usedNext bool
+}
+
+// ParsePartial parses the sections of the Artifact read from r preceding the
+// payloads, like ParseHeader, and returns a PayloadStreamer for the payloads
+// following them, which reads the rest of r. The returned Artifact has no
+// Data.
+func (p *Parser) ParsePartial(r io.Reader, opts ...ParseOption) (*Artifact, *PayloadStreamer, error) {
+	a, s, err := p.parseHeader(r, opts)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "ParsePartial")
+	}
+	return a, s, nil
+}
+
+// nextPayload returns the tar header of the next payload tar, parsing any
+// other section on the way, or io.EOF at the end of the Artifact
+func (s *PayloadStreamer) nextPayload() (*tar.Header, error) {
+	if hdr := s.hdr; hdr != nil {
+		s.hdr = nil
+		return hdr, nil
+	}
+	for {
+		hdr, err := s.tr.Next()
+		if err == io.EOF {
+			if err = s.order.done(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		} else if err != nil {
+			return nil, errors.Wrap(err, "PayloadStreamer")
+		}
+		if err = s.order.next(hdr.Name); err != nil {
+			return nil, err
+		}
+		s.artifact.setSectionSize(hdr.Name, hdr.Size)
+		if filepath.Dir(hdr.Name) == "data" {
+			return hdr, nil
+		}
+		if err = s.artifact.parseSection(hdr.Name, s.tr); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Read reads the compressed payload tars, ie, data/0000.tar.gz, followed by
+// data/0001.tar.gz, as they are in the Artifact
+func (s *PayloadStreamer) Read(b []byte) (int, error) {
+	if s.usedNext {
+		return 0, errors.New("PayloadStreamer: Read can not be used after Next")
+	}
+	s.usedRead = true
+	for {
+		if !s.reading {
+			if _, err := s.nextPayload(); err != nil {
+				return 0, err
+			}
+			s.reading = true
+		}
+		n, err := s.tr.Read(b)
+		if err == io.EOF {
+			s.reading = false
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// Next returns a reader for the next payload file, like Parser.Next, or
+// io.EOF when there are no more payload files. The file is verified against
+// the manifest as it is read. The returned reader is only valid until the
+// next call.
+func (s *PayloadStreamer) Next() (*PayloadReader, error) {
+	if s.usedRead {
+		return nil, errors.New("PayloadStreamer: Next can not be used after Read")
+	}
+	s.usedNext = true
+	for {
+		if s.payload != nil {
+			hdr, err := s.payload.Next()
+			if err == nil {
+				name := fmt.Sprintf("data/%04d/%s", s.payloadIndex, hdr.Name)
+				expected, ok := s.artifact.manifestChecksum(name)
+				if !ok {
+					return nil, errors.Wrapf(ErrManifestEntryMissing, "PayloadStreamer: %s", name)
+				}
+				return &PayloadReader{
+					name:           hdr.Name,
+					index:          s.payloadIndex,
+					size:           hdr.Size,
+					h:              NewHashingReader(s.payload),
+					expected:       expected,
+					compressedSize: s.payloadSize,
+				}, nil
+			}
+			s.decompressor.Close()
+			s.payload, s.decompressor = nil, nil
+			if err != io.EOF {
+				return nil, errors.Wrapf(err, "PayloadStreamer: Failed to read the payload %d", s.payloadIndex)
+			}
+		}
+		hdr, err := s.nextPayload()
+		if err != nil {
+			return nil, err
+		}
+		if _, err = fmt.Sscanf(filepath.Base(hdr.Name), "%04d", &s.payloadIndex); err != nil {
+			return nil, errors.Wrapf(err, "PayloadStreamer: Invalid payload name %s", hdr.Name)
+		}
+		compression, err := compressionFromName(hdr.Name)
+		if err != nil {
+			return nil, errors.Wrap(err, "PayloadStreamer")
+		}
+		zr, err := compression.newReader(s.tr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "PayloadStreamer: Failed to decompress %s", hdr.Name)
+		}
+		s.payload, s.decompressor, s.payloadSize = tar.NewReader(zr), zr, hdr.Size
+	}
+}
+
+// Close stops any payload being read by Next
+func (s *PayloadStreamer) Close() error {
+	if s.decompressor != nil {
+		s.decompressor.Close()
+		s.payload, s.decompressor = nil, nil
+	}
+	return nil
+}
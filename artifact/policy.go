@@ -0,0 +1,167 @@
+package artifact
+
+import (
+	"crypto"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The rules of a VerificationPolicy, as reported in the PolicyResult
+const (
+	PolicyRuleSignature    = "signature"
+	PolicyRuleDeviceTypes  = "device-types"
+	PolicyRuleCreatedAfter = "created-after"
+	PolicyRuleArtifactName = "artifact-name"
+)
+
+// VerificationPolicy is a set of rules an Artifact has to satisfy.
+// Rules left at their zero value are not checked.
+type VerificationPolicy struct {
+	// RequireSignature requires the Artifact to be signed
+	RequireSignature bool
+	// TrustedKeys are the keys the signature is verified against. If the
+	// Artifact is signed, the signature has to be valid for one of them.
+	TrustedKeys []crypto.PublicKey
+	// DeviceTypes requires the Artifact to be compatible with at least one
+	// of the device types
+	DeviceTypes []string
+	// CreatedAfter requires the Artifact to have been created after the time
+	CreatedAfter *time.Time
+	// ArtifactNamePattern is a regular expression the Artifact name has
+	// to match
+	ArtifactNamePattern string
+}
+
+// PolicyViolation is a rule the Artifact does not satisfy
+type PolicyViolation struct {
+	Rule   string
+	Reason string
+}
+
+func (p PolicyViolation) String() string {
+	return p.Rule + ": " + p.Reason
+}
+
+// PolicyResult lists the rules which passed, and which failed
+type PolicyResult struct {
+	Passed []string
+	Failed []PolicyViolation
+}
+
+// OK reports whether the Artifact satisfies the whole policy
+func (p PolicyResult) OK() bool {
+	return len(p.Failed) == 0
+}
+
+func (p PolicyResult) String() string {
+	failed := make([]string, 0, len(p.Failed))
+	for _, f := range p.Failed {
+		failed = append(failed, f.String())
+	}
+	return fmt.Sprintf("Passed: [%s] Failed: [%s]",
+		strings.Join(p.Passed, ", "), strings.Join(failed, ", "))
+}
+
+func (p *PolicyResult) check(rule string, reason string) {
+	if reason == "" {
+		p.Passed = append(p.Passed, rule)
+	} else {
+		p.Failed = append(p.Failed, PolicyViolation{Rule: rule, Reason: reason})
+	}
+}
+
+// VerifyWithPolicy checks the Artifact against every rule of the policy.
+// Rule violations are reported in the PolicyResult; an error is only
+// returned if the policy itself is invalid, or the Artifact is not parsed.
+func (a *Artifact) VerifyWithPolicy(policy VerificationPolicy) (PolicyResult, error) {
+	res := PolicyResult{}
+	if a.Manifest == nil || a.HeaderTar == nil || a.HeaderTar.HeaderInfo == nil {
+		return res, errors.New("VerifyWithPolicy: The Artifact has not been parsed")
+	}
+	var namePattern *regexp.Regexp
+	if policy.ArtifactNamePattern != "" {
+		var err error
+		if namePattern, err = regexp.Compile(policy.ArtifactNamePattern); err != nil {
+			return res, errors.Wrap(err, "VerifyWithPolicy: Invalid ArtifactNamePattern")
+		}
+	}
+	info := a.HeaderTar.HeaderInfo
+
+	if policy.RequireSignature || len(policy.TrustedKeys) > 0 {
+		res.check(PolicyRuleSignature, a.checkSignature(policy))
+	}
+	if len(policy.DeviceTypes) > 0 {
+		reason := fmt.Sprintf("Compatible with %v, not any of %v",
+			info.ArtifactDepends.DeviceType, policy.DeviceTypes)
+		for _, deviceType := range policy.DeviceTypes {
+			if containsString(info.ArtifactDepends.DeviceType, deviceType) {
+				reason = ""
+				break
+			}
+		}
+		res.check(PolicyRuleDeviceTypes, reason)
+	}
+	if policy.CreatedAfter != nil {
+		reason := ""
+		created, err := a.createdAt()
+		switch {
+		case err != nil:
+			reason = err.Error()
+		case created == nil:
+			reason = "The creation time of the Artifact is unknown"
+		case !created.After(*policy.CreatedAfter):
+			reason = fmt.Sprintf("Created at %s, which is not after %s",
+				created.Format(time.RFC3339), policy.CreatedAfter.Format(time.RFC3339))
+		}
+		res.check(PolicyRuleCreatedAfter, reason)
+	}
+	if namePattern != nil {
+		reason := ""
+		if name := info.ArtifactProvides.ArtifactName; !namePattern.MatchString(name) {
+			reason = fmt.Sprintf("%q does not match %q", name, policy.ArtifactNamePattern)
+		}
+		res.check(PolicyRuleArtifactName, reason)
+	}
+	return res, nil
+}
+
+// checkSignature returns the reason the signature rule failed, if any
+func (a *Artifact) checkSignature(policy VerificationPolicy) string {
+	if a.ManifestSig == nil {
+		if policy.RequireSignature {
+			return "The Artifact is not signed"
+		}
+		return ""
+	}
+	if len(policy.TrustedKeys) == 0 {
+		return ""
+	}
+	for _, key := range policy.TrustedKeys {
+		if err := a.ManifestSig.verify(a.Manifest.bytes(), key); err == nil {
+			return ""
+		}
+	}
+	return "The signature does not match any of the trusted keys"
+}
+
+// createdAt returns the creation time recorded in the Artifact provides, or
+// nil if there is none.
+func (a *Artifact) createdAt() (*time.Time, error) {
+	v, ok := a.HeaderTar.HeaderInfo.ArtifactProvides.Extra[ProvenanceCreatedAt]
+	if !ok {
+		return nil, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("Invalid created_at: %v", v)
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid created_at")
+	}
+	return &t, nil
+}
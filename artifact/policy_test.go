@@ -0,0 +1,68 @@
+package artifact_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"reflect"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+)
+
+func TestVerifyWithPolicy(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := artifact.VerificationPolicy{
+		RequireSignature: true,
+		TrustedKeys:      []crypto.PublicKey{key.Public()},
+		DeviceTypes:      []string{"beaglebone"},
+	}
+
+	tests := map[string]struct {
+		opts   testutil.ArtifactOptions
+		failed []string
+	}{
+		"matching": {
+			opts: testutil.ArtifactOptions{DeviceType: "beaglebone", Signed: true, Key: key},
+		},
+		"unsigned": {
+			opts:   testutil.ArtifactOptions{DeviceType: "beaglebone"},
+			failed: []string{artifact.PolicyRuleSignature},
+		},
+		"untrusted key": {
+			opts:   testutil.ArtifactOptions{DeviceType: "beaglebone", Signed: true, Key: other},
+			failed: []string{artifact.PolicyRuleSignature},
+		},
+		"other device": {
+			opts:   testutil.ArtifactOptions{DeviceType: "raspberrypi4", Signed: true, Key: key},
+			failed: []string{artifact.PolicyRuleDeviceTypes},
+		},
+	}
+	for name, test := range tests {
+		a := parse(t, testutil.MakeArtifact(t, test.opts))
+		res, err := a.VerifyWithPolicy(policy)
+		a.Close()
+		if err != nil {
+			t.Fatalf("%s: VerifyWithPolicy: %v", name, err)
+		}
+		var failed []string
+		for _, violation := range res.Failed {
+			failed = append(failed, violation.Rule)
+		}
+		if !reflect.DeepEqual(failed, test.failed) {
+			t.Errorf("%s: Failed = %v, want %v", name, res.Failed, test.failed)
+		}
+		if res.OK() != (len(test.failed) == 0) {
+			t.Errorf("%s: OK() = %v", name, res.OK())
+		}
+	}
+}
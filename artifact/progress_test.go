@@ -0,0 +1,63 @@
+package artifact_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+)
+
+func TestParseWithProgress(t *testing.T) {
+	b := testutil.MakeArtifact(t, testutil.ArtifactOptions{
+		Payloads: []testutil.Payload{
+			{Filename: "rootfs.ext4", Content: bytes.Repeat([]byte("rootfs"), 1<<10)},
+			{Filename: "bootloader.img", Content: []byte("bootloader")},
+		},
+		Signed: true,
+	})
+	var progress bytes.Buffer
+	ar := artifact.NewArtifactReader(nil)
+	a, err := ar.ParseWithProgress(bytes.NewReader(b), &progress)
+	if err != nil {
+		t.Fatalf("ParseWithProgress: %v", err)
+	}
+	defer ar.Close()
+	if a.Info().Name != "test-artifact" {
+		t.Errorf("Parsed the Artifact %s", a.Info().Name)
+	}
+
+	var events []artifact.ProgressEvent
+	s := bufio.NewScanner(&progress)
+	for s.Scan() {
+		event := artifact.ProgressEvent{}
+		if err = json.Unmarshal(s.Bytes(), &event); err != nil {
+			t.Fatalf("The progress line %q is not a ProgressEvent: %v", s.Text(), err)
+		}
+		events = append(events, event)
+	}
+	names := entryNames(t, b)
+	if len(events) != 2*len(names) {
+		t.Fatalf("Wrote %d progress events for %d sections: %+v", len(events), len(names), events)
+	}
+	var sections []string
+	for i := 0; i < len(events); i += 2 {
+		started, done := events[i], events[i+1]
+		sections = append(sections, started.Section)
+		if started.Status != artifact.ProgressStarted || started.Bytes != 0 {
+			t.Errorf("The section %s starts with %+v", started.Section, started)
+		}
+		if done.Section != started.Section || done.Status != artifact.ProgressDone {
+			t.Errorf("The section %s ends with %+v", started.Section, done)
+		}
+		if size := int64(len(readEntry(t, b, done.Section))); done.Bytes != size || done.Bytes < started.Bytes {
+			t.Errorf("The section %s is done after %d bytes, want %d", done.Section, done.Bytes, size)
+		}
+	}
+	if !reflect.DeepEqual(sections, names) {
+		t.Errorf("Reported the progress of %v, want %v", sections, names)
+	}
+}
@@ -0,0 +1,65 @@
+package artifact
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The keys in the Artifact provides holding the provenance of the Artifact
+const (
+	ProvenanceCreatedBy   = "created_by"
+	ProvenanceCreatedAt   = "created_at"
+	ProvenanceToolVersion = "tool_version"
+	ProvenanceBuildID     = "build_id"
+)
+
+// ProvenanceInfo describes who created the Artifact, and how
+type ProvenanceInfo struct {
+	CreatedBy   string
+	CreatedAt   *time.Time
+	ToolVersion string
+	BuildID     string
+}
+
+// Provenance returns the provenance recorded in the Artifact provides.
+// Fields which are absent, or malformed, are left at their zero value.
+func (a *Artifact) Provenance() ProvenanceInfo {
+	p := ProvenanceInfo{}
+	if a.HeaderTar == nil || a.HeaderTar.HeaderInfo == nil {
+		return p
+	}
+	extra := a.HeaderTar.HeaderInfo.ArtifactProvides.Extra
+	p.CreatedBy, _ = extra[ProvenanceCreatedBy].(string)
+	p.ToolVersion, _ = extra[ProvenanceToolVersion].(string)
+	p.BuildID, _ = extra[ProvenanceBuildID].(string)
+	if createdAt, err := a.createdAt(); err == nil {
+		p.CreatedAt = createdAt
+	}
+	return p
+}
+
+// SetCreationTimestamp records t as the creation time of the Artifact.
+// The header is modified, so the manifest has to be recomputed afterwards.
+func (a *Artifact) SetCreationTimestamp(t time.Time) error {
+	if a.HeaderTar == nil || a.HeaderTar.HeaderInfo == nil {
+		return errors.New("SetCreationTimestamp: The Artifact has not been parsed")
+	}
+	provides := &a.HeaderTar.HeaderInfo.ArtifactProvides
+	if provides.Extra == nil {
+		return errors.New("SetCreationTimestamp: The Artifact provides have not been parsed")
+	}
+	provides.Extra[ProvenanceCreatedAt] = t.UTC().Format(time.RFC3339)
+	a.HeaderTar.dirty = true
+	return nil
+}
+
+// CreationTimestamp returns the creation time of the Artifact, or nil if it
+// has none.
+func (a *Artifact) CreationTimestamp() (*time.Time, error) {
+	if a.HeaderTar == nil || a.HeaderTar.HeaderInfo == nil {
+		return nil, errors.New("CreationTimestamp: The Artifact has not been parsed")
+	}
+	t, err := a.createdAt()
+	return t, errors.Wrap(err, "CreationTimestamp")
+}
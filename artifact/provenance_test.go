@@ -0,0 +1,34 @@
+package artifact_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+)
+
+func TestCreationTimestamp(t *testing.T) {
+	a := parse(t, testutil.MakeArtifact(t, testutil.ArtifactOptions{}))
+	defer a.Close()
+	if created, err := a.CreationTimestamp(); err != nil || created != nil {
+		t.Errorf("CreationTimestamp of an Artifact without one returned %v, %v", created, err)
+	}
+
+	created := time.Date(2026, 10, 17, 12, 34, 56, 789000000, time.FixedZone("CEST", 2*60*60))
+	if err := a.SetCreationTimestamp(created); err != nil {
+		t.Fatalf("SetCreationTimestamp: %v", err)
+	}
+	if err := a.RecomputeManifest(); err != nil {
+		t.Fatalf("RecomputeManifest: %v", err)
+	}
+	parsed := parse(t, serialize(t, a))
+	defer parsed.Close()
+	got, err := parsed.CreationTimestamp()
+	if err != nil {
+		t.Fatalf("CreationTimestamp: %v", err)
+	}
+	// The timestamp is stored with a precision of a second
+	if want := created.Truncate(time.Second); got == nil || !got.Equal(want) {
+		t.Errorf("CreationTimestamp returned %v, want %v", got, want)
+	}
+}
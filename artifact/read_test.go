@@ -0,0 +1,86 @@
+package artifact_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+)
+
+func TestDataRead(t *testing.T) {
+	a := parse(t, testutil.MakeArtifact(t, testutil.ArtifactOptions{}))
+	defer a.Close()
+	b := make([]byte, 1024)
+	n, err := a.Data.Read(b)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if n == 0 {
+		t.Fatal("Read read nothing")
+	}
+	// Data reads as a tar of the gzipped payloads, data/0000.tar.gz...
+	tr := tar.NewReader(bytes.NewReader(b[:n]))
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if hdr.Name != "data/0000.tar.gz" {
+		t.Errorf("Read the entry %s, want data/0000.tar.gz", hdr.Name)
+	}
+	if _, err = gzip.NewReader(tr); err != nil {
+		t.Errorf("%s is not gzipped: %v", hdr.Name, err)
+	}
+}
+
+// readChunks reads r, size bytes at a time
+func readChunks(t *testing.T, r io.Reader, size int) []byte {
+	t.Helper()
+	var read []byte
+	b := make([]byte, size)
+	for {
+		n, err := r.Read(b)
+		read = append(read, b[:n]...)
+		if err == io.EOF {
+			return read
+		} else if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+	}
+}
+
+func TestReadChunks(t *testing.T) {
+	b := testutil.MakeArtifact(t, testutil.ArtifactOptions{Signed: true})
+	augment := "c57c4694532f96383b619c57c4694532f96383b619c57c4694532f96383b6190  header-augment.tar.gz\n"
+
+	tests := map[string]struct {
+		reader func(a *artifact.Artifact) io.Reader
+		want   []byte
+	}{
+		"version":      {func(a *artifact.Artifact) io.Reader { return a.Version }, readEntry(t, b, "version")},
+		"manifest":     {func(a *artifact.Artifact) io.Reader { return a.Manifest }, readEntry(t, b, "manifest")},
+		"manifest.sig": {func(a *artifact.Artifact) io.Reader { return a.ManifestSig }, readEntry(t, b, "manifest.sig")},
+		"manifest-augment": {func(a *artifact.Artifact) io.Reader {
+			m := &artifact.ManifestAugment{}
+			if err := m.Parse(strings.NewReader(augment)); err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			return m
+		}, []byte(augment)},
+		"data": {func(a *artifact.Artifact) io.Reader { return a.Data },
+			makeTar(t, "data/0000.tar.gz", string(readEntry(t, b, "data/0000.tar.gz")))},
+	}
+	for name, test := range tests {
+		for _, size := range []int{1, 7, 512, 4096} {
+			a := parse(t, b)
+			if got := readChunks(t, test.reader(a), size); !bytes.Equal(got, test.want) {
+				t.Errorf("%s: Reading %d bytes at a time gave %q, want %q", name, size, got, test.want)
+			}
+			a.Close()
+		}
+	}
+}
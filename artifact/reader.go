@@ -0,0 +1,546 @@
+package artifact
+
+import (
+	"archive/tar"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ArtifactReader parses an Artifact from a reader, and keeps track of how far
+// it has come, so that an interrupted parse can be resumed.
+//
+// An ArtifactReader is not safe for concurrent use, ie, Next must not be
+// called from several goroutines at once. Separate ArtifactReaders can parse
+// Artifacts in parallel, as long as they do not share a script directory
+// set with WithScriptDir.
+type ArtifactReader struct {
+	Artifact *Artifact
+
+	r           io.Reader
+	checkpoints []ParseCheckpoint
+	progress    *json.Encoder
+	opts        []Option // Applied to every Artifact parsed
+
+	// The state of Next
+	tr           *tar.Reader
+	order        sectionOrder
+	payload      *tar.Reader
+	payloadIndex int
+	payloadSize  int64 // The compressed size of the payload being read
+	decompressor io.Closer
+	nextDone     bool // Next has returned io.EOF, or an error
+
+	// The payloads are reopened from seeker by PayloadReader.Seek. cr counts
+	// the bytes read by tr from base, the offset of seeker when Next started.
+	seeker             io.ReadSeeker
+	cr                 *countingReader
+	base               int64
+	payloadOffsets     []int64 // The offsets of the payload data in the Artifact tar
+	payloadCompression CompressionAlgo
+	reopened           io.Closer
+	restore            bool // seeker has to be moved back to base+cr.n
+}
+
+// ParseCheckpoint marks the end of a successfully parsed section of the
+// Artifact. The parsing can be resumed from here with ResumeFrom.
+type ParseCheckpoint struct {
+	// Section is the name of the last parsed section
+	Section string
+	// Offset is the offset of the next section in the Artifact tar
+	Offset int64
+
+	order sectionOrder
+	state *Artifact
+}
+
+// NewArtifactReader returns an ArtifactReader for the Artifact read from r.
+// The options, ie, WithRequestID, apply to every Artifact parsed by it.
+func NewArtifactReader(r io.Reader, opts ...Option) *ArtifactReader {
+	ar := &ArtifactReader{r: r, opts: opts}
+	ar.Artifact = ar.newArtifact()
+	return ar
+}
+
+// newArtifact returns an empty Artifact, with the options of the reader
+func (ar *ArtifactReader) newArtifact() *Artifact {
+	a := &Artifact{}
+	for _, opt := range ar.opts {
+		opt(a)
+	}
+	return a
+}
+
+// NewBufferedArtifactReader returns an ArtifactReader reading r through a
+// buffer of bufSize bytes, ie, for an Artifact streamed through an io.Pipe,
+// where every small read of the tar reader would otherwise be a round trip to
+// the writing goroutine. A bufSize of at least 65536 bytes is recommended.
+func NewBufferedArtifactReader(r io.Reader, bufSize int) *ArtifactReader {
+	return NewArtifactReader(bufio.NewReaderSize(r, bufSize))
+}
+
+// Reset closes the current Artifact, and prepares the reader for parsing
+// the Artifact read from r. Reset fails if Next has been called, but has not
+// returned io.EOF, or an error, yet.
+func (ar *ArtifactReader) Reset(r io.Reader) error {
+	if ar.tr != nil && !ar.nextDone {
+		return errors.New("ArtifactReader: Reset: The payloads are still being read")
+	}
+	if err := ar.Close(); err != nil {
+		return errors.Wrap(err, "ArtifactReader: Reset")
+	}
+	ar.Artifact = ar.newArtifact()
+	ar.r = r
+	ar.checkpoints = ar.checkpoints[:0]
+	ar.tr, ar.order, ar.payload, ar.payloadIndex, ar.nextDone = nil, sectionOrder{}, nil, 0, false
+	ar.seeker, ar.cr, ar.payloadOffsets, ar.restore = nil, nil, nil, false
+	return nil
+}
+
+// Parse parses the whole Artifact into ar.Artifact
+func (ar *ArtifactReader) Parse() error {
+	ar.Artifact = ar.newArtifact()
+	ar.checkpoints = nil
+	return ar.parse(&countingReader{r: ar.r}, sectionOrder{})
+}
+
+// Checkpoints returns a checkpoint for every section parsed so far
+func (ar *ArtifactReader) Checkpoints() []ParseCheckpoint {
+	return append([]ParseCheckpoint{}, ar.checkpoints...)
+}
+
+// ResumeFrom restores the Artifact to the state it had at the checkpoint,
+// and continues parsing from the following section, read from r. r has to
+// hold the same Artifact as the one the checkpoint was made from.
+func (ar *ArtifactReader) ResumeFrom(checkpoint ParseCheckpoint, r io.ReadSeeker) error {
+	if checkpoint.state == nil {
+		return errors.New("ResumeFrom: Invalid checkpoint")
+	}
+	if _, err := r.Seek(checkpoint.Offset, io.SeekStart); err != nil {
+		return errors.Wrap(err, "ResumeFrom: Failed to seek to the checkpoint")
+	}
+	ar.r = r
+	ar.Artifact = checkpoint.state.checkpoint()
+	ar.Artifact.logger().WithFields(log.Fields{"section": checkpoint.Section, "offset": checkpoint.Offset}).
+		Debug("Resuming the parsing")
+	for i, c := range ar.checkpoints {
+		if c.Offset == checkpoint.Offset {
+			ar.checkpoints = ar.checkpoints[:i]
+			break
+		}
+	}
+	ar.checkpoints = append(ar.checkpoints, checkpoint)
+	return ar.parse(&countingReader{r: r, n: checkpoint.Offset}, checkpoint.order)
+}
+
+func (ar *ArtifactReader) parse(cr *countingReader, order sectionOrder) error {
+	tr := tar.NewReader(cr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			if err = order.done(); err != nil {
+				return err
+			}
+			return errors.Wrap(ar.Artifact.verifyManifest(), "ArtifactReader")
+		} else if err != nil {
+			return errors.Wrap(err, "ArtifactReader")
+		}
+		if err = order.next(hdr.Name); err != nil {
+			return err
+		}
+		ar.Artifact.setSectionSize(hdr.Name, hdr.Size)
+		if err = ar.reportProgress(hdr.Name, ProgressStarted, 0); err != nil {
+			return err
+		}
+		section := &countingReader{r: tr}
+		if err = ar.Artifact.parseSection(hdr.Name, section); err != nil {
+			return err
+		}
+		// Drain the section, so that the offset points to its end
+		if _, err = io.Copy(ioutil.Discard, section); err != nil {
+			return errors.Wrapf(err, "ArtifactReader: Failed to read %s", hdr.Name)
+		}
+		if err = ar.reportProgress(hdr.Name, ProgressDone, section.n); err != nil {
+			return err
+		}
+		ar.checkpoints = append(ar.checkpoints, ParseCheckpoint{
+			Section: hdr.Name,
+			// The next tar header starts at the following block boundary
+			Offset: (cr.n + tarBlockSize - 1) / tarBlockSize * tarBlockSize,
+			order:  order,
+			state:  ar.Artifact.checkpoint(),
+		})
+	}
+}
+
+// Checksums returns the expected, and the actual, checksums of the files of
+// the Artifact read so far. The payload files streamed by Next are not kept,
+// and are not included.
+func (ar *ArtifactReader) Checksums() *ChecksumRegistry {
+	if ar.Artifact == nil {
+		return NewChecksumRegistry()
+	}
+	registry, _ := ar.Artifact.checksumRegistry()
+	return registry
+}
+
+// The statuses of a ProgressEvent
+const (
+	ProgressStarted = "started"
+	ProgressDone    = "done"
+)
+
+// ProgressEvent reports the progress of parsing a section of the Artifact.
+// Bytes is the number of bytes of the section read so far.
+type ProgressEvent struct {
+	Section string `json:"section"`
+	Status  string `json:"status"`
+	Bytes   int64  `json:"bytes"`
+}
+
+// ParseWithProgress parses the Artifact from r, and writes a ProgressEvent
+// to progressWriter, as a line of JSON, when each section is started, and
+// when it is done.
+func (ar *ArtifactReader) ParseWithProgress(r io.Reader, progressWriter io.Writer) (*Artifact, error) {
+	ar.r = r
+	ar.progress = json.NewEncoder(progressWriter)
+	defer func() { ar.progress = nil }()
+	if err := ar.Parse(); err != nil {
+		return nil, err
+	}
+	return ar.Artifact, nil
+}
+
+func (ar *ArtifactReader) reportProgress(section, status string, n int64) error {
+	if ar.progress == nil {
+		return nil
+	}
+	err := ar.progress.Encode(ProgressEvent{Section: section, Status: status, Bytes: n})
+	return errors.Wrap(err, "ArtifactReader: Failed to write the progress")
+}
+
+// PayloadReader reads a file of a payload, and computes its checksum as it
+// is read
+type PayloadReader struct {
+	name  string
+	index int
+	size  int64
+	h     *HashingReader
+
+	// offset is the number of bytes of the file read so far. reopen returns
+	// a reader from the start of the file, if the file can be seeked.
+	offset int64
+	reopen func() (io.Reader, error)
+
+	// The size of the compressed payload, ie, data/0000.tar.gz, the file is in
+	compressedSize int64
+
+	// The checksum of the file in the manifest, verified on EOF, if set
+	expected string
+}
+
+// Name returns the name of the file, ie, update.ext4
+func (p *PayloadReader) Name() string {
+	return p.name
+}
+
+// UncompressedSize returns the size of the file, like Size, ie, the size of
+// the update image
+func (p *PayloadReader) UncompressedSize() int64 {
+	return p.size
+}
+
+// CompressedSize returns the size of the compressed payload holding the file,
+// ie, data/0000.tar.gz, as it is in the Artifact tar
+func (p *PayloadReader) CompressedSize() int64 {
+	return p.compressedSize
+}
+
+// Index returns the index of the payload the file belongs to
+func (p *PayloadReader) Index() int {
+	return p.index
+}
+
+// Size returns the size of the file
+func (p *PayloadReader) Size() int64 {
+	return p.size
+}
+
+// Read reads the file. If the reader verifies the file, a
+// ChecksumMismatchError is returned in place of io.EOF for a file not
+// matching the manifest.
+func (p *PayloadReader) Read(b []byte) (int, error) {
+	n, err := p.h.Read(b)
+	p.offset += int64(n)
+	if err == io.EOF && p.expected != "" {
+		if actual := p.h.Sum(); actual != p.expected {
+			name := fmt.Sprintf("data/%04d/%s", p.index, p.name)
+			return n, &ChecksumMismatchError{Filename: name, Expected: p.expected, Actual: actual}
+		}
+	}
+	return n, err
+}
+
+// SupportsSeek returns true if the file can be seeked, ie, the payload was
+// read by a Parser, or by an ArtifactReader reading an io.ReadSeeker, like an
+// os.File
+func (p *PayloadReader) SupportsSeek() bool {
+	return p.reopen != nil
+}
+
+// Seek sets the offset of the next Read in the file, ie, to restart reading
+// the file after a network interruption. As the payload is compressed,
+// seeking backwards decompresses the payload again from its start, and
+// seeking forwards skips the bytes in between, so that the checksum stays
+// complete.
+func (p *PayloadReader) Seek(offset int64, whence int) (int64, error) {
+	if p.reopen == nil {
+		return 0, errors.New("PayloadReader: Seek: The Artifact is not read from an io.ReadSeeker")
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += p.offset
+	case io.SeekEnd:
+		offset += p.size
+	default:
+		return 0, errors.Errorf("PayloadReader: Seek: Invalid whence %d", whence)
+	}
+	if offset < 0 || offset > p.size {
+		return 0, errors.Errorf("PayloadReader: Seek: Offset %d is outside of %s", offset, p.name)
+	}
+	if offset < p.offset {
+		r, err := p.reopen()
+		if err != nil {
+			return 0, errors.Wrap(err, "PayloadReader: Seek")
+		}
+		p.h, p.offset = NewHashingReader(r), 0
+	}
+	n, err := io.CopyN(ioutil.Discard, p.h, offset-p.offset)
+	p.offset += n
+	if err != nil {
+		return p.offset, errors.Wrapf(err, "PayloadReader: Seek: Failed to read %s", p.name)
+	}
+	return p.offset, nil
+}
+
+// openPayloadFile returns a reader for the file name in the payload read
+// from r
+func openPayloadFile(r io.Reader, compression CompressionAlgo, name string) (io.Reader, io.Closer, error) {
+	zr, err := compression.newReader(r)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Failed to decompress the payload")
+	}
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			zr.Close()
+			if err == io.EOF {
+				return nil, nil, errors.Errorf("No file %s in the payload", name)
+			}
+			return nil, nil, errors.Wrap(err, "Failed to read the payload")
+		}
+		if hdr.Name == name {
+			return tr, zr, nil
+		}
+	}
+}
+
+// Checksum returns the SHA256 of the file. It is only complete once the
+// file has been read to EOF.
+func (p *PayloadReader) Checksum() []byte {
+	return p.h.h.Sum(nil)
+}
+
+// Next streams the Artifact, and returns a reader for the next payload file,
+// ie, data/0000/update.ext4, or io.EOF when there are no more payload files.
+// The sections preceding the payloads are parsed into ar.Artifact, but the
+// payloads are not kept, and their checksums are left to the caller to verify
+// against the manifest. The returned reader is only valid until the next
+// call.
+func (ar *ArtifactReader) Next() (*PayloadReader, error) {
+	p, err := ar.next()
+	if err != nil {
+		ar.nextDone = true
+	}
+	return p, err
+}
+
+func (ar *ArtifactReader) next() (*PayloadReader, error) {
+	if ar.tr == nil {
+		ar.seeker, ar.base = nil, 0
+		// Pipes are os.Files as well, but fail to seek
+		if rs, ok := ar.r.(io.ReadSeeker); ok {
+			if base, err := rs.Seek(0, io.SeekCurrent); err == nil {
+				ar.seeker, ar.base = rs, base
+			}
+		}
+		ar.cr = &countingReader{r: ar.r}
+		ar.tr = tar.NewReader(ar.cr)
+	}
+	if err := ar.restorePosition(); err != nil {
+		return nil, err
+	}
+	for {
+		if ar.payload != nil {
+			hdr, err := ar.payload.Next()
+			if err == nil {
+				p := &PayloadReader{
+					name:           hdr.Name,
+					index:          ar.payloadIndex,
+					size:           hdr.Size,
+					h:              NewHashingReader(ar.payload),
+					compressedSize: ar.payloadSize,
+				}
+				if ar.seeker != nil {
+					p.reopen = ar.reopenPayload(ar.payloadOffsets[len(ar.payloadOffsets)-1], ar.payloadSize, ar.payloadCompression, hdr.Name)
+				}
+				return p, nil
+			}
+			ar.decompressor.Close()
+			ar.payload, ar.decompressor = nil, nil
+			if err != io.EOF {
+				return nil, errors.Wrapf(err, "ArtifactReader: Failed to read the payload %d", ar.payloadIndex)
+			}
+		}
+		hdr, err := ar.tr.Next()
+		if err == io.EOF {
+			if err = ar.order.done(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		} else if err != nil {
+			return nil, errors.Wrap(err, "ArtifactReader")
+		}
+		if err = ar.order.next(hdr.Name); err != nil {
+			return nil, err
+		}
+		ar.Artifact.setSectionSize(hdr.Name, hdr.Size)
+		if filepath.Dir(hdr.Name) != "data" && len(ar.payloadOffsets) > 0 {
+			// Files which are not a part of the standard Artifact may
+			// follow the payloads
+			if err = ar.Artifact.parseExtraFile(hdr.Name, ar.tr); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if filepath.Dir(hdr.Name) != "data" {
+			if err = ar.Artifact.parseSection(hdr.Name, ar.tr); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if _, err = fmt.Sscanf(filepath.Base(hdr.Name), "%04d", &ar.payloadIndex); err != nil {
+			return nil, errors.Wrapf(err, "ArtifactReader: Invalid payload name %s", hdr.Name)
+		}
+		compression, err := compressionFromName(hdr.Name)
+		if err != nil {
+			return nil, errors.Wrap(err, "ArtifactReader")
+		}
+		// The tar reader stops at the start of the data of the entry, which
+		// the decompressor then reads ahead of
+		ar.payloadOffsets = append(ar.payloadOffsets, ar.base+ar.cr.n)
+		zr, err := compression.newReader(ar.tr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "ArtifactReader: Failed to decompress %s", hdr.Name)
+		}
+		ar.payload, ar.decompressor = tar.NewReader(zr), zr
+		ar.payloadSize, ar.payloadCompression = hdr.Size, compression
+	}
+}
+
+// reopenPayload returns a function reopening the file name of the payload
+// at offset in the Artifact, for PayloadReader.Seek. The position of the
+// underlying reader is restored by the following call to Next.
+func (ar *ArtifactReader) reopenPayload(offset, size int64, compression CompressionAlgo, name string) func() (io.Reader, error) {
+	return func() (io.Reader, error) {
+		if ar.reopened != nil {
+			ar.reopened.Close()
+			ar.reopened = nil
+		}
+		ar.restore = true
+		if _, err := ar.seeker.Seek(offset, io.SeekStart); err != nil {
+			return nil, errors.Wrap(err, "Failed to seek to the payload")
+		}
+		r, closer, err := openPayloadFile(io.LimitReader(ar.seeker, size), compression, name)
+		if err != nil {
+			return nil, err
+		}
+		ar.reopened = closer
+		return r, nil
+	}
+}
+
+// restorePosition moves the underlying reader back to where the Artifact tar
+// is being read, after a payload has been reopened
+func (ar *ArtifactReader) restorePosition() error {
+	if !ar.restore {
+		return nil
+	}
+	if ar.reopened != nil {
+		ar.reopened.Close()
+		ar.reopened = nil
+	}
+	if _, err := ar.seeker.Seek(ar.base+ar.cr.n, io.SeekStart); err != nil {
+		return errors.Wrap(err, "ArtifactReader: Failed to seek back to the Artifact")
+	}
+	ar.restore = false
+	return nil
+}
+
+// Close stops any payload being read by Next, and closes the Artifact
+func (ar *ArtifactReader) Close() error {
+	if ar.reopened != nil {
+		ar.reopened.Close()
+		ar.reopened = nil
+	}
+	if ar.decompressor != nil {
+		ar.decompressor.Close()
+		ar.payload, ar.decompressor = nil, nil
+	}
+	if ar.Artifact == nil {
+		return nil
+	}
+	return ar.Artifact.Close()
+}
+
+// checkpoint returns a copy of the Artifact, which is not affected by
+// parsing any further sections into the Artifact.
+func (a *Artifact) checkpoint() *Artifact {
+	c := *a
+	if a.Data != nil {
+		c.Data = &Data{payloads: append([]PayLoadData{}, a.Data.payloads...)}
+	}
+	// The sections parsed later add to these
+	c.sectionSizes = make(map[string]int64, len(a.sectionSizes))
+	for name, size := range a.sectionSizes {
+		c.sectionSizes[name] = size
+	}
+	c.sectionNames = append([]string(nil), a.sectionNames...)
+	c.checksums = make(map[string][]byte, len(a.checksums))
+	for name, sum := range a.checksums {
+		c.checksums[name] = sum
+	}
+	return &c
+}
+
+const tarBlockSize = 512
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,396 @@
+package artifact
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// droppingReader fails, like a dropped connection, after n bytes
+type droppingReader struct {
+	r io.Reader
+	n int
+}
+
+func (d *droppingReader) Read(b []byte) (int, error) {
+	if d.n <= 0 {
+		return 0, errors.New("connection reset by peer")
+	}
+	if len(b) > d.n {
+		b = b[:d.n]
+	}
+	n, err := d.r.Read(b)
+	d.n -= n
+	return n, err
+}
+
+// testArtifact returns an Artifact with a single payload of content
+func testArtifact(t *testing.T, content []byte) []byte {
+	t.Helper()
+	buf := bytes.NewBuffer(nil)
+	aw := NewArtifactWriter(buf, WithVersion(3))
+	aw.SetArtifactName("release-1")
+	aw.SetCompatibleDevices([]string{"beaglebone"})
+	if err := aw.AddPayload("rootfs-image", "rootfs.ext4", bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := aw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// tarEntry returns the content of the entry name of the tar b
+func tarEntry(t *testing.T, b []byte, name string) []byte {
+	t.Helper()
+	tr := tar.NewReader(bytes.NewReader(b))
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("No %s: %v", name, err)
+		}
+		if hdr.Name == name {
+			content, err := ioutil.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return content
+		}
+	}
+}
+
+func TestResumeFrom(t *testing.T) {
+	// Random data does not compress, so that the payload is large
+	content := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(content)
+	b := testArtifact(t, content)
+
+	ar := NewArtifactReader(bytes.NewReader(b))
+	if err := ar.Parse(); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	var header ParseCheckpoint
+	for _, c := range ar.Checkpoints() {
+		if c.Section == "header.tar.gz" {
+			header = c
+		}
+	}
+	ar.Close()
+	if header.state == nil {
+		t.Fatal("No checkpoint after the header")
+	}
+
+	// The connection drops in the middle of the payload
+	ar = NewArtifactReader(&droppingReader{r: bytes.NewReader(b), n: int(header.Offset) + 4096})
+	defer ar.Close()
+	if err := ar.Parse(); err == nil {
+		t.Fatal("Parse of a dropped connection succeeded")
+	}
+	checkpoints := ar.Checkpoints()
+	last := checkpoints[len(checkpoints)-1]
+	if last.Section != "header.tar.gz" || last.Offset != header.Offset {
+		t.Fatalf("The last checkpoint is %s at %d, want header.tar.gz at %d", last.Section, last.Offset, header.Offset)
+	}
+
+	if err := ar.ResumeFrom(last, bytes.NewReader(b)); err != nil {
+		t.Fatalf("ResumeFrom: %v", err)
+	}
+	payload, err := ar.Artifact.Data.PayloadAt(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(payload.Data.Bytes(), tarEntry(t, b, "data/0000.tar.gz")) {
+		t.Error("The resumed parse read the wrong payload")
+	}
+
+	// The checkpoint is not affected by the sections parsed after it
+	if _, ok := last.state.sectionSizes["data/0000.tar.gz"]; ok {
+		t.Error("The checkpoint has the size of the payload")
+	}
+	if _, ok := last.state.checksums["data/0000.tar.gz"]; ok {
+		t.Error("The checkpoint has the checksum of the payload")
+	}
+	for _, name := range last.state.sectionNames {
+		if name == "data/0000.tar.gz" {
+			t.Error("The checkpoint has the payload section")
+		}
+	}
+}
+
+// payloadsArtifact returns an Artifact with a payload for each of the files,
+// holding the name of the file, and the custom section release-notes after
+// the payloads, if withNotes is set
+func payloadsArtifact(t *testing.T, withNotes bool, files ...string) []byte {
+	t.Helper()
+	b := NewArtifactBuilder().WithArtifactName("release-1").WithDeviceTypes("beaglebone")
+	for _, file := range files {
+		b.WithPayload("rootfs-image", file, strings.NewReader(file))
+	}
+	if withNotes {
+		b.WithCustomSection("release-notes", strings.NewReader("notes"), true)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := b.Build(buf); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// readPayloads reads the payload files with Next, and returns their names,
+// failing the test if their content is not their name, or their checksum
+// does not match the manifest
+func readPayloads(t *testing.T, ar *ArtifactReader) []string {
+	t.Helper()
+	var names []string
+	for {
+		p, err := ar.Next()
+		if err == io.EOF {
+			return names
+		} else if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		content, err := ioutil.ReadAll(p)
+		if err != nil {
+			t.Fatalf("Read %s: %v", p.Name(), err)
+		}
+		if string(content) != p.Name() || p.Size() != int64(len(content)) {
+			t.Errorf("%s: Read %q, of the size %d", p.Name(), content, p.Size())
+		}
+		name := fmt.Sprintf("data/%04d/%s", p.Index(), p.Name())
+		if sum, _ := ar.Artifact.Manifest.Lookup(name); sum != hex.EncodeToString(p.Checksum()) {
+			t.Errorf("%s: The checksum is %x, want %s", name, p.Checksum(), sum)
+		}
+		names = append(names, name)
+	}
+}
+
+func TestNextPayloads(t *testing.T) {
+	ar := NewArtifactReader(bytes.NewReader(payloadsArtifact(t, false, "rootfs.ext4", "bootloader.img")))
+	defer ar.Close()
+	want := []string{"data/0000/rootfs.ext4", "data/0001/bootloader.img"}
+	if names := readPayloads(t, ar); !reflect.DeepEqual(names, want) {
+		t.Errorf("Next returned %v, want %v", names, want)
+	}
+}
+
+func TestNextExtraFiles(t *testing.T) {
+	b := payloadsArtifact(t, true, "rootfs.ext4")
+	for _, handled := range []bool{false, true} {
+		ar := NewArtifactReader(bytes.NewReader(b))
+		var notes []byte
+		if handled {
+			ar.Artifact.RegisterSectionHandler("release-notes", func(name string, r io.Reader) error {
+				var err error
+				notes, err = ioutil.ReadAll(r)
+				return err
+			})
+		}
+		if names := readPayloads(t, ar); len(names) != 1 {
+			t.Errorf("Next returned %v, want data/0000/rootfs.ext4", names)
+		}
+		if handled && string(notes) != "notes" {
+			t.Errorf("The handler read %q, want notes", notes)
+		}
+		if _, ok := ar.Artifact.checksums["release-notes"]; !ok {
+			t.Error("The checksum of release-notes was not recorded")
+		}
+		ar.Close()
+	}
+}
+
+// parseScriptedFile parses the Artifact in the file path with an
+// ArtifactReader, and returns the directory its script was extracted to
+func parseScriptedFile(path, script string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	ar := NewArtifactReader(f)
+	defer ar.Close()
+	if err = ar.Parse(); err != nil {
+		return "", err
+	}
+	names := ar.Artifact.HeaderTar.Scripts.Names()
+	if len(names) != 1 {
+		return "", fmt.Errorf("Parsed the scripts %v", names)
+	}
+	content, err := ioutil.ReadFile(names[0])
+	if err != nil {
+		return "", err
+	}
+	if string(content) != script {
+		return "", fmt.Errorf("Parsed the script %q, want %q", content, script)
+	}
+	return filepath.Dir(names[0]), nil
+}
+
+func TestConcurrentArtifactReaders(t *testing.T) {
+	const script = "#!/bin/sh\necho install\n"
+	buf := bytes.NewBuffer(nil)
+	err := NewArtifactBuilder().WithArtifactName("release-1").WithDeviceTypes("beaglebone").
+		WithScript("ArtifactInstall_Enter_00", strings.NewReader(script)).
+		WithPayload("rootfs-image", "rootfs.ext4", strings.NewReader("rootfs")).
+		Build(buf)
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	f, err := ioutil.TempFile("", "concurrent-readers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(buf.Bytes())
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const readers = 10
+	dirs := make([]string, readers)
+	errs := make([]error, readers)
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			dirs[i], errs[i] = parseScriptedFile(f.Name(), script)
+		}(i)
+	}
+	wg.Wait()
+	seen := map[string]bool{}
+	for i := range dirs {
+		if errs[i] != nil {
+			t.Errorf("Reader %d: %v", i, errs[i])
+			continue
+		}
+		if seen[dirs[i]] {
+			t.Errorf("Reader %d shared the script directory %s", i, dirs[i])
+		}
+		seen[dirs[i]] = true
+		if _, err := os.Stat(dirs[i]); !os.IsNotExist(err) {
+			t.Errorf("Reader %d left the script directory %s behind", i, dirs[i])
+		}
+	}
+}
+
+func TestParserNextThreePayloads(t *testing.T) {
+	files := []string{"rootfs.ext4", "bootloader.img", "dtb.img"}
+	p := NewParser()
+	a, err := p.Parse(bytes.NewReader(payloadsArtifact(t, false, files...)))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	defer a.Close()
+	for i, file := range files {
+		r, err := p.Next()
+		if err != nil {
+			t.Fatalf("Next %d: %v", i, err)
+		}
+		content, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("Read %s: %v", r.Name(), err)
+		}
+		if r.Name() != file || r.Index() != i || string(content) != file {
+			t.Errorf("Next %d returned %s of the payload %d, holding %q, want %s", i, r.Name(), r.Index(), content, file)
+		}
+	}
+	if _, err = p.Next(); err != io.EOF {
+		t.Errorf("Next after the last payload = %v, want io.EOF", err)
+	}
+}
+
+func TestBufferedArtifactReaderPipe(t *testing.T) {
+	b := payloadsArtifact(t, false, "rootfs.ext4", "bootloader.img")
+	for _, bufSize := range []int{128 << 10, 64} {
+		pr, pw := io.Pipe()
+		go func() {
+			// Small writes, so that the reader has to wait for most blocks
+			for rest := b; len(rest) > 0; {
+				n := 100
+				if n > len(rest) {
+					n = len(rest)
+				}
+				if _, err := pw.Write(rest[:n]); err != nil {
+					return
+				}
+				rest = rest[n:]
+			}
+			pw.Close()
+		}()
+		ar := NewBufferedArtifactReader(pr, bufSize)
+		want := []string{"data/0000/rootfs.ext4", "data/0001/bootloader.img"}
+		if names := readPayloads(t, ar); !reflect.DeepEqual(names, want) {
+			t.Errorf("Buffer of %d bytes: Next returned %v, want %v", bufSize, names, want)
+		}
+		if name := ar.Artifact.Info().Name; name != "release-1" {
+			t.Errorf("Buffer of %d bytes: Parsed %q, want release-1", bufSize, name)
+		}
+		ar.Close()
+		pr.Close()
+	}
+}
+
+func TestPayloadReaderSeekFile(t *testing.T) {
+	content := make([]byte, 64<<10)
+	rand.New(rand.NewSource(1)).Read(content)
+	f, err := ioutil.TempFile("", "payload-seek")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err = f.Write(testArtifact(t, content)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	ar := NewArtifactReader(f)
+	defer ar.Close()
+	p, err := ar.Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if !p.SupportsSeek() {
+		t.Fatal("The payload of an os.File does not support Seek")
+	}
+	half := make([]byte, len(content)/2)
+	if _, err = io.ReadFull(p, half); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if offset, err := p.Seek(0, io.SeekStart); err != nil || offset != 0 {
+		t.Fatalf("Seek(0) = %d, %v", offset, err)
+	}
+	full, err := ioutil.ReadAll(p)
+	if err != nil {
+		t.Fatalf("Read after Seek: %v", err)
+	}
+	if !bytes.Equal(full, content) || !bytes.Equal(full[:len(half)], half) {
+		t.Error("The payload read after Seek differs from the one read before")
+	}
+	if sum, _ := ar.Artifact.Manifest.Lookup("data/0000/rootfs.ext4"); sum != hex.EncodeToString(p.Checksum()) {
+		t.Errorf("The checksum after Seek is %x, want %s", p.Checksum(), sum)
+	}
+
+	unseekable := NewArtifactReader(struct{ io.Reader }{bytes.NewReader(testArtifact(t, content))})
+	defer unseekable.Close()
+	if p, err = unseekable.Next(); err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if p.SupportsSeek() {
+		t.Error("The payload of a plain io.Reader supports Seek")
+	}
+}
@@ -0,0 +1,82 @@
+package artifact_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"runtime"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+)
+
+func TestArtifactReaderReset(t *testing.T) {
+	const count = 100
+	rnd := rand.New(rand.NewSource(1))
+	artifacts := make([][]byte, count)
+	for i := range artifacts {
+		// Random content does not compress, so that every parsed Artifact
+		// holds 64 KiB of payload
+		content := make([]byte, 64<<10)
+		rnd.Read(content)
+		artifacts[i] = testutil.MakeArtifact(t, testutil.ArtifactOptions{
+			ArtifactName:   fmt.Sprintf("release-%d", i),
+			PayloadContent: content,
+		})
+	}
+
+	heapAlloc := func() uint64 {
+		var m runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&m)
+		return m.HeapAlloc
+	}
+	var baseline uint64
+	ar := artifact.NewArtifactReader(nil)
+	defer ar.Close()
+	for i, b := range artifacts {
+		if err := ar.Reset(bytes.NewReader(b)); err != nil {
+			t.Fatalf("Reset before Artifact %d: %v", i, err)
+		}
+		if i%2 == 0 {
+			if err := ar.Parse(); err != nil {
+				t.Fatalf("Parse of Artifact %d: %v", i, err)
+			}
+		} else {
+			p, err := ar.Next()
+			if err != nil {
+				t.Fatalf("Next of Artifact %d: %v", i, err)
+			}
+			if _, err = io.Copy(ioutil.Discard, p); err != nil {
+				t.Fatalf("Failed to read the payload of Artifact %d: %v", i, err)
+			}
+			if _, err = ar.Next(); err != io.EOF {
+				t.Fatalf("Next after the payload of Artifact %d returned %v", i, err)
+			}
+		}
+		if name, want := ar.Artifact.Info().Name, fmt.Sprintf("release-%d", i); name != want {
+			t.Fatalf("Parsed the Artifact %s, want %s", name, want)
+		}
+		// The first Artifacts warm up the reader
+		if i == 9 {
+			baseline = heapAlloc()
+		}
+	}
+	if growth := int64(heapAlloc()) - int64(baseline); growth > 1<<20 {
+		t.Errorf("The heap grew by %d bytes over %d Artifacts", growth, count-10)
+	}
+
+	// An Artifact, whose payloads are still being read, can not be reset
+	if err := ar.Reset(bytes.NewReader(artifacts[0])); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if _, err := ar.Next(); err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if err := ar.Reset(bytes.NewReader(artifacts[1])); err == nil {
+		t.Error("Reset while the payloads are being read succeeded")
+	}
+}
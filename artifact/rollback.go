@@ -0,0 +1,105 @@
+package artifact
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+type rollbackOptions struct {
+	payload *builderFile
+}
+
+// RollbackOption configures the Artifact created by Artifact.Rollback
+type RollbackOption func(*rollbackOptions)
+
+// WithRollbackPayload sets the image to roll back to. If not given, the
+// payloads of the Artifact rolled back to are used.
+func WithRollbackPayload(filename string, r io.Reader) RollbackOption {
+	return func(o *rollbackOptions) {
+		o.payload = &builderFile{name: filename, r: r}
+	}
+}
+
+// Rollback generates an Artifact which undoes the update to a, restoring the
+// device to the state given by the Artifact to. The provides and depends are
+// inverted, so that the rollback Artifact depends on a being installed, and
+// provides what to provided.
+func (a *Artifact) Rollback(to *Artifact, opts ...RollbackOption) (*Artifact, error) {
+	if a.HeaderTar == nil || a.HeaderTar.HeaderInfo == nil ||
+		to.HeaderTar == nil || to.HeaderTar.HeaderInfo == nil {
+		return nil, errors.New("Rollback: The Artifacts have not been parsed")
+	}
+	o := rollbackOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	current := a.HeaderTar.HeaderInfo
+	previous := to.HeaderTar.HeaderInfo
+
+	b := NewArtifactBuilder().
+		WithArtifactName(previous.ArtifactProvides.ArtifactName).
+		WithArtifactGroup(previous.ArtifactProvides.ArtifactGroup).
+		WithDependsArtifactNames(current.ArtifactProvides.ArtifactName).
+		WithDeviceTypes(current.ArtifactDepends.DeviceType...).
+		WithCompression(to.HeaderTar.compression)
+
+	if o.payload != nil {
+		payloadType := "rootfs-image"
+		if len(previous.Payloads) > 0 {
+			payloadType = previous.Payloads[0].Type
+		}
+		b.WithPayload(payloadType, o.payload.name, o.payload.r)
+	} else {
+		if to.Data == nil || len(to.Data.payloads) != len(previous.Payloads) {
+			return nil, errors.New("Rollback: No payloads to roll back to")
+		}
+		for i, payload := range to.Data.payloads {
+			file, err := payloadFile(payload)
+			if err != nil {
+				return nil, errors.Wrap(err, "Rollback")
+			}
+			b.WithPayload(previous.Payloads[i].Type, file.name, file.r)
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := b.Build(buf); err != nil {
+		return nil, errors.Wrap(err, "Rollback")
+	}
+	rollback := New()
+	if err := rollback.Parse(buf); err != nil {
+		return nil, errors.Wrap(err, "Rollback: Failed to parse the rollback Artifact")
+	}
+	return rollback, nil
+}
+
+// payloadFile returns the single file held by the payload
+func payloadFile(payload PayLoadData) (builderFile, error) {
+	compression, err := compressionFromName(payload.Name)
+	if err != nil {
+		return builderFile{}, err
+	}
+	zr, err := compression.newReader(bytes.NewReader(payload.Data.Bytes()))
+	if err != nil {
+		return builderFile{}, errors.Wrapf(err, "Failed to decompress %s", payload.Name)
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+	hdr, err := tr.Next()
+	if err != nil {
+		return builderFile{}, errors.Wrapf(err, "Failed to read %s", payload.Name)
+	}
+	content, err := ioutil.ReadAll(tr)
+	if err != nil {
+		return builderFile{}, errors.Wrapf(err, "Failed to read %s", payload.Name)
+	}
+	if _, err = tr.Next(); err != io.EOF {
+		return builderFile{}, fmt.Errorf("Only single file payloads are supported: %s", payload.Name)
+	}
+	return builderFile{name: hdr.Name, r: bytes.NewReader(content)}, nil
+}
@@ -0,0 +1,73 @@
+package artifact_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+)
+
+// payloadContent returns the content of the first payload file of a
+func payloadContent(t *testing.T, a *artifact.Artifact) string {
+	t.Helper()
+	p := artifact.NewParser()
+	parsed, err := p.Parse(bytes.NewReader(serialize(t, a)))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	defer parsed.Close()
+	r, err := p.Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	return string(content)
+}
+
+func TestRollback(t *testing.T) {
+	previous := parse(t, testutil.MakeArtifact(t, testutil.ArtifactOptions{
+		ArtifactName: "release-1", DeviceType: "beaglebone", PayloadContent: []byte("release-1 image"),
+	}))
+	defer previous.Close()
+	current := parse(t, testutil.MakeArtifact(t, testutil.ArtifactOptions{
+		ArtifactName: "release-2", DeviceType: "beaglebone", PayloadContent: []byte("release-2 image"),
+	}))
+	defer current.Close()
+
+	rollback, err := current.Rollback(previous)
+	if err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	defer rollback.Close()
+	if !current.CanUpgradeTo(rollback) {
+		t.Error("The rollback Artifact cannot be installed on top of the current one")
+	}
+	if previous.CanUpgradeTo(rollback) {
+		t.Error("The rollback Artifact can be installed on top of the previous one")
+	}
+	// After the rollback, the device is in the previous state again
+	if !rollback.CanUpgradeTo(current) {
+		t.Error("The current Artifact cannot be installed on top of the rollback Artifact")
+	}
+	if info := rollback.Info(); info.Name != "release-1" || info.CompatibleDevices[0] != "beaglebone" {
+		t.Errorf("The rollback Artifact provides %s, for %v, want release-1, for beaglebone", info.Name, info.CompatibleDevices)
+	}
+	if content := payloadContent(t, rollback); content != "release-1 image" {
+		t.Errorf("The rollback Artifact holds %q, want the previous image", content)
+	}
+
+	supplied, err := current.Rollback(previous, artifact.WithRollbackPayload("rootfs.ext4", strings.NewReader("supplied image")))
+	if err != nil {
+		t.Fatalf("Rollback with a payload: %v", err)
+	}
+	defer supplied.Close()
+	if content := payloadContent(t, supplied); content != "supplied image" {
+		t.Errorf("The rollback Artifact holds %q, want the supplied image", content)
+	}
+}
@@ -0,0 +1,223 @@
+package artifact
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrUnsupportedSBOMFormat is returned by WriteMetadata for unknown formats
+var ErrUnsupportedSBOMFormat = errors.New("Unsupported SBOM format")
+
+// The SBOM formats supported by WriteMetadata
+const (
+	SBOMFormatSPDX      = "spdx"
+	SBOMFormatCycloneDX = "cyclonedx"
+)
+
+// WriteMetadata writes a Software Bill of Materials of the Artifact to w,
+// listing the Artifact, and the checksums of its payloads. format is either
+// "spdx", for an SPDX 2.3 JSON document, or "cyclonedx", for CycloneDX 1.4
+// JSON.
+func (a *Artifact) WriteMetadata(w io.Writer, format string) error {
+	if a.Version == nil || a.Manifest == nil || a.HeaderTar == nil || a.HeaderTar.HeaderInfo == nil {
+		return errors.New("WriteMetadata: The Artifact has not been parsed")
+	}
+	var doc interface{}
+	switch format {
+	case SBOMFormatSPDX:
+		doc = a.spdx()
+	case SBOMFormatCycloneDX:
+		doc = a.cycloneDX()
+	default:
+		return errors.Wrapf(ErrUnsupportedSBOMFormat, "WriteMetadata: %q", format)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return errors.Wrap(err, "WriteMetadata: Failed to write the SBOM")
+	}
+	return nil
+}
+
+// sbomMetaDataKey is the meta-data key SetSBOM stores the SBOM under
+const sbomMetaDataKey = "_sbom"
+
+type sbomMetaData struct {
+	Format string          `json:"format"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// SetSBOM stores the SBOM data, a JSON document of format "spdx", or
+// "cyclonedx", in the meta-data, under the key _sbom
+func (m *MetaData) SetSBOM(format string, data json.RawMessage) error {
+	if format != SBOMFormatSPDX && format != SBOMFormatCycloneDX {
+		return errors.Wrapf(ErrUnsupportedSBOMFormat, "MetaData: SetSBOM: %q", format)
+	}
+	if !json.Valid(data) {
+		return errors.New("MetaData: SetSBOM: The SBOM is not valid json")
+	}
+	return m.Set(sbomMetaDataKey, sbomMetaData{Format: format, Data: data})
+}
+
+// SBOM returns the SBOM stored by SetSBOM, and its format
+func (m *MetaData) SBOM() (format string, data json.RawMessage, ok bool) {
+	raw, ok := m.Get(sbomMetaDataKey)
+	if !ok {
+		return "", nil, false
+	}
+	sbom := sbomMetaData{}
+	if err := json.Unmarshal(raw, &sbom); err != nil || sbom.Format == "" {
+		return "", nil, false
+	}
+	return sbom.Format, sbom.Data, true
+}
+
+// sbomPayloads returns the manifest entries of the payload files
+func (a *Artifact) sbomPayloads() []ManifestData {
+	var payloads []ManifestData
+	for _, entry := range a.Manifest.Data {
+		if strings.HasPrefix(filepath.Dir(entry.Name), "data/") {
+			payloads = append(payloads, entry)
+		}
+	}
+	return payloads
+}
+
+// sbomTimestamp returns the creation time of the Artifact, if it is known,
+// and the current time otherwise
+func (a *Artifact) sbomTimestamp() string {
+	t := time.Now()
+	if created, err := a.createdAt(); err == nil && created != nil {
+		t = *created
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxPackage struct {
+	Name             string         `json:"name"`
+	SPDXID           string         `json:"SPDXID"`
+	VersionInfo      string         `json:"versionInfo,omitempty"`
+	DownloadLocation string         `json:"downloadLocation"`
+	FilesAnalyzed    bool           `json:"filesAnalyzed"`
+	Checksums        []spdxChecksum `json:"checksums,omitempty"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+type spdxDocument struct {
+	SPDXVersion       string `json:"spdxVersion"`
+	DataLicense       string `json:"dataLicense"`
+	SPDXID            string `json:"SPDXID"`
+	Name              string `json:"name"`
+	DocumentNamespace string `json:"documentNamespace"`
+	CreationInfo      struct {
+		Created  string   `json:"created"`
+		Creators []string `json:"creators"`
+	} `json:"creationInfo"`
+	Packages      []spdxPackage      `json:"packages"`
+	Relationships []spdxRelationship `json:"relationships"`
+}
+
+func (a *Artifact) spdx() spdxDocument {
+	name := a.HeaderTar.HeaderInfo.ArtifactProvides.ArtifactName
+	doc := spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              name,
+		DocumentNamespace: "https://mender.io/spdxdocs/" + name,
+	}
+	if id, err := a.ContentAddressedName(); err == nil {
+		doc.DocumentNamespace = "https://mender.io/spdxdocs/" + strings.Replace(id, "@sha256:", "-", 1)
+	}
+	doc.CreationInfo.Created = a.sbomTimestamp()
+	doc.CreationInfo.Creators = []string{"Tool: mender-artifact"}
+	doc.Packages = append(doc.Packages, spdxPackage{
+		Name:             name,
+		SPDXID:           "SPDXRef-Artifact",
+		VersionInfo:      strconv.Itoa(a.Version.Version),
+		DownloadLocation: "NOASSERTION",
+	})
+	doc.Relationships = append(doc.Relationships, spdxRelationship{
+		SPDXElementID:      "SPDXRef-DOCUMENT",
+		RelationshipType:   "DESCRIBES",
+		RelatedSPDXElement: "SPDXRef-Artifact",
+	})
+	for i, payload := range a.sbomPayloads() {
+		id := fmt.Sprintf("SPDXRef-Payload-%d", i)
+		doc.Packages = append(doc.Packages, spdxPackage{
+			Name:             payload.Name,
+			SPDXID:           id,
+			DownloadLocation: "NOASSERTION",
+			Checksums:        []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: payload.Signature}},
+		})
+		doc.Relationships = append(doc.Relationships, spdxRelationship{
+			SPDXElementID:      "SPDXRef-Artifact",
+			RelationshipType:   "CONTAINS",
+			RelatedSPDXElement: id,
+		})
+	}
+	return doc
+}
+
+type cycloneDXHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cycloneDXComponent struct {
+	Type    string          `json:"type"`
+	Name    string          `json:"name"`
+	Version string          `json:"version,omitempty"`
+	Hashes  []cycloneDXHash `json:"hashes,omitempty"`
+}
+
+type cycloneDXDocument struct {
+	BOMFormat   string `json:"bomFormat"`
+	SpecVersion string `json:"specVersion"`
+	Version     int    `json:"version"`
+	Metadata    struct {
+		Timestamp string             `json:"timestamp"`
+		Component cycloneDXComponent `json:"component"`
+	} `json:"metadata"`
+	Components []cycloneDXComponent `json:"components"`
+}
+
+func (a *Artifact) cycloneDX() cycloneDXDocument {
+	doc := cycloneDXDocument{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: "1.4",
+		Version:     1,
+		Components:  []cycloneDXComponent{},
+	}
+	doc.Metadata.Timestamp = a.sbomTimestamp()
+	doc.Metadata.Component = cycloneDXComponent{
+		Type:    "firmware",
+		Name:    a.HeaderTar.HeaderInfo.ArtifactProvides.ArtifactName,
+		Version: strconv.Itoa(a.Version.Version),
+	}
+	for _, payload := range a.sbomPayloads() {
+		doc.Components = append(doc.Components, cycloneDXComponent{
+			Type:   "file",
+			Name:   payload.Name,
+			Hashes: []cycloneDXHash{{Alg: "SHA-256", Content: payload.Signature}},
+		})
+	}
+	return doc
+}
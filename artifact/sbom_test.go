@@ -0,0 +1,110 @@
+package artifact_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+	"github.com/pkg/errors"
+)
+
+// The fields of an SPDX 2.3 JSON document read by the test
+type spdxDocument struct {
+	SPDXVersion       string `json:"spdxVersion"`
+	DataLicense       string `json:"dataLicense"`
+	SPDXID            string `json:"SPDXID"`
+	Name              string `json:"name"`
+	DocumentNamespace string `json:"documentNamespace"`
+	CreationInfo      struct {
+		Created  string   `json:"created"`
+		Creators []string `json:"creators"`
+	} `json:"creationInfo"`
+	Packages []struct {
+		Name             string `json:"name"`
+		SPDXID           string `json:"SPDXID"`
+		VersionInfo      string `json:"versionInfo"`
+		DownloadLocation string `json:"downloadLocation"`
+		FilesAnalyzed    bool   `json:"filesAnalyzed"`
+		Checksums        []struct {
+			Algorithm     string `json:"algorithm"`
+			ChecksumValue string `json:"checksumValue"`
+		} `json:"checksums"`
+	} `json:"packages"`
+	Relationships []struct {
+		SPDXElementID      string `json:"spdxElementId"`
+		RelationshipType   string `json:"relationshipType"`
+		RelatedSPDXElement string `json:"relatedSpdxElement"`
+	} `json:"relationships"`
+}
+
+func TestWriteMetadata(t *testing.T) {
+	a := parse(t, testutil.MakeArtifact(t, testutil.ArtifactOptions{
+		ArtifactName: "release-1",
+		Payloads: []testutil.Payload{
+			{Filename: "rootfs.ext4", Content: []byte("rootfs")},
+			{Filename: "bootloader.img", Content: []byte("bootloader")},
+		},
+	}))
+	defer a.Close()
+
+	var buf bytes.Buffer
+	if err := a.WriteMetadata(&buf, artifact.SBOMFormatSPDX); err != nil {
+		t.Fatalf("WriteMetadata: %v", err)
+	}
+	d := json.NewDecoder(&buf)
+	d.DisallowUnknownFields()
+	doc := spdxDocument{}
+	if err := d.Decode(&doc); err != nil {
+		t.Fatalf("The SPDX document does not parse: %v", err)
+	}
+	if doc.SPDXVersion != "SPDX-2.3" || doc.DataLicense != "CC0-1.0" || doc.SPDXID != "SPDXRef-DOCUMENT" ||
+		doc.Name != "release-1" || doc.DocumentNamespace == "" || doc.CreationInfo.Created == "" ||
+		len(doc.CreationInfo.Creators) == 0 {
+		t.Errorf("The SPDX document is missing required fields: %+v", doc)
+	}
+	if len(doc.Packages) != 3 || doc.Packages[0].Name != "release-1" || doc.Packages[0].VersionInfo != "3" {
+		t.Fatalf("The SPDX packages are %+v, want the Artifact and its two payloads", doc.Packages)
+	}
+	sums := map[string]string{}
+	for _, p := range doc.Packages[1:] {
+		if p.DownloadLocation == "" || len(p.Checksums) != 1 || p.Checksums[0].Algorithm != "SHA256" {
+			t.Fatalf("The SPDX package is %+v", p)
+		}
+		sums[p.Name] = p.Checksums[0].ChecksumValue
+	}
+	want := map[string]string{}
+	for _, name := range []string{"data/0000/rootfs.ext4", "data/0001/bootloader.img"} {
+		want[name], _ = a.Manifest.Lookup(name)
+	}
+	if !reflect.DeepEqual(sums, want) {
+		t.Errorf("The SPDX payload checksums are %v, want %v", sums, want)
+	}
+	if len(doc.Relationships) != 3 || doc.Relationships[0].RelationshipType != "DESCRIBES" {
+		t.Errorf("The SPDX relationships are %+v", doc.Relationships)
+	}
+
+	buf.Reset()
+	if err := a.WriteMetadata(&buf, artifact.SBOMFormatCycloneDX); err != nil {
+		t.Fatalf("WriteMetadata: %v", err)
+	}
+	cdx := struct {
+		BOMFormat   string `json:"bomFormat"`
+		SpecVersion string `json:"specVersion"`
+		Components  []struct {
+			Name string `json:"name"`
+		} `json:"components"`
+	}{}
+	if err := json.Unmarshal(buf.Bytes(), &cdx); err != nil {
+		t.Fatalf("The CycloneDX document does not parse: %v", err)
+	}
+	if cdx.BOMFormat != "CycloneDX" || cdx.SpecVersion != "1.4" || len(cdx.Components) != 2 {
+		t.Errorf("The CycloneDX document is %+v", cdx)
+	}
+
+	if err := a.WriteMetadata(&buf, "swid"); errors.Cause(err) != artifact.ErrUnsupportedSBOMFormat {
+		t.Errorf("WriteMetadata of an unknown format returned %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package artifact
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrScriptTimeout is returned by Scripts.Execute for a script which did not
+// finish in time
+var ErrScriptTimeout = errors.New("The script timed out")
+
+// Scripts returns the paths the state scripts of the Artifact have been
+// written to, in the order they were parsed
+func (a *Artifact) Scripts() []string {
+	if a.HeaderTar == nil {
+		return nil
+	}
+	return a.HeaderTar.Scripts.Names()
+}
+
+// Names returns the paths the scripts have been written to, ie,
+// /tmp/mender-scripts123/ArtifactInstall_Enter_00, in the order they were
+// parsed. Use List for the bare names.
+func (s *Scripts) Names() []string {
+	return s.paths()
+}
+
+// Execute runs the script name, ie, ArtifactInstall_Enter_00, from the script
+// directory, with the environment env, and returns what it wrote to stdout,
+// and stderr. A nil env runs the script with the environment of the current
+// process. The script is killed, and ErrScriptTimeout returned, if it has not
+// finished after timeout. A timeout of 0 means no timeout.
+func (s *Scripts) Execute(name string, env []string, timeout time.Duration) (stdout, stderr []byte, err error) {
+	if s == nil || filepath.Base(name) != name {
+		return nil, nil, errors.Errorf("Scripts: Execute: No script %s", name)
+	}
+	path := filepath.Join(s.scriptDir, name)
+	if !containsString(s.paths(), path) {
+		return nil, nil, errors.Errorf("Scripts: Execute: No script %s", name)
+	}
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	// The output is captured in files, and not pipes, so that a killed script
+	// does not hang Run, through any child processes holding on to the pipes
+	outFile, err := ioutil.TempFile("", "mender-script-stdout")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Scripts: Execute")
+	}
+	defer os.Remove(outFile.Name())
+	defer outFile.Close()
+	errFile, err := ioutil.TempFile("", "mender-script-stderr")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Scripts: Execute")
+	}
+	defer os.Remove(errFile.Name())
+	defer errFile.Close()
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Env = env
+	cmd.Dir = s.scriptDir
+	cmd.Stdout, cmd.Stderr = outFile, errFile
+	runErr := cmd.Run()
+	if stdout, err = ioutil.ReadFile(outFile.Name()); err != nil {
+		return nil, nil, errors.Wrap(err, "Scripts: Execute: Failed to read the output")
+	}
+	if stderr, err = ioutil.ReadFile(errFile.Name()); err != nil {
+		return nil, nil, errors.Wrap(err, "Scripts: Execute: Failed to read the output")
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return stdout, stderr,
+			errors.Wrapf(ErrScriptTimeout, "Scripts: Execute: %s did not finish in %s", name, timeout)
+	}
+	return stdout, stderr, errors.Wrapf(runErr, "Scripts: Execute: %s failed", name)
+}
+
+// scriptNamePattern matches the state script names, ie,
+// ArtifactInstall_Enter_01, capturing the state, the transition, and the
+// priority
+var scriptNamePattern = regexp.MustCompile(`^([A-Za-z]+)_(Enter|Leave|Error)(?:_([0-9]+))?$`)
+
+// Validate checks that the scripts are named <State>_<Enter|Leave|Error>,
+// with an optional _nn priority suffix, and that no two scripts share the
+// same state, transition, and priority. An *InvalidScriptNameError is
+// returned, listing all the offending scripts.
+func (s *Scripts) Validate() error {
+	nameErr := &InvalidScriptNameError{Invalid: s.InvalidNames()}
+	seen := map[string]string{}
+	for _, name := range s.ValidNames() {
+		m := scriptNamePattern.FindStringSubmatch(name)
+		key := m[1] + "_" + m[2]
+		if m[3] != "" {
+			priority, _ := strconv.Atoi(m[3])
+			key += "_" + strconv.Itoa(priority)
+		}
+		if other, ok := seen[key]; ok {
+			if !containsString(nameErr.Duplicates, other) {
+				nameErr.Duplicates = append(nameErr.Duplicates, other)
+			}
+			nameErr.Duplicates = append(nameErr.Duplicates, name)
+			continue
+		}
+		seen[key] = name
+	}
+	if len(nameErr.Invalid) > 0 || len(nameErr.Duplicates) > 0 {
+		return nameErr
+	}
+	return nil
+}
+
+// ValidNames returns the names of the scripts following the naming
+// convention
+func (s *Scripts) ValidNames() []string {
+	var names []string
+	for _, name := range s.List() {
+		if scriptNamePattern.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// InvalidNames returns the names of the scripts not following the naming
+// convention
+func (s *Scripts) InvalidNames() []string {
+	var names []string
+	for _, name := range s.List() {
+		if name != scriptAnnotationFile && !scriptNamePattern.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
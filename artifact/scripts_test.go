@@ -0,0 +1,119 @@
+package artifact_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+)
+
+// headerTar returns an uncompressed header tar holding the entries, in order
+func headerTar(t *testing.T, entries ...string) *tar.Reader {
+	t.Helper()
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	for _, name := range entries {
+		content := "content of " + name
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return tar.NewReader(buf)
+}
+
+// parseScripts parses the scripts of tr with Scripts.Parse, until it moves on
+// to the sub-headers. The caller has to close the Scripts.
+func parseScripts(t *testing.T, tr *tar.Reader) *artifact.Scripts {
+	t.Helper()
+	s := &artifact.Scripts{}
+	for {
+		err := s.Parse(tr)
+		if err == io.EOF {
+			return s
+		} else if err != nil {
+			s.Close()
+			t.Fatalf("Parse: %v", err)
+		}
+	}
+}
+
+func TestScriptsParse(t *testing.T) {
+	tr := headerTar(t,
+		"scripts/ArtifactInstall_Enter_00",
+		"scripts/ArtifactInstall_Leave_00",
+		"headers/0000/type-info",
+		"headers/0001/type-info",
+	)
+	s := parseScripts(t, tr)
+	defer s.Close()
+
+	names := s.List()
+	sort.Strings(names)
+	want := []string{"ArtifactInstall_Enter_00", "ArtifactInstall_Leave_00"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("List() = %v, want %v", names, want)
+	}
+	// Parse stops at the first sub-header, and leaves the rest
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != "headers/0001/type-info" {
+		t.Errorf("Next() = %v, %v, want headers/0001/type-info", hdr, err)
+	}
+}
+
+func TestScriptsParseEnd(t *testing.T) {
+	s := &artifact.Scripts{}
+	defer s.Close()
+	if err := s.Parse(headerTar(t)); err != io.EOF {
+		t.Errorf("Parse() = %v, want io.EOF", err)
+	}
+}
+
+func TestScriptsParseUnexpected(t *testing.T) {
+	s := &artifact.Scripts{}
+	defer s.Close()
+	err := s.Parse(headerTar(t, "data/0000.tar.gz"))
+	if err == nil || !strings.Contains(err.Error(), "Expected scripts") {
+		t.Errorf("Parse() = %v, want an unexpected entry error", err)
+	}
+}
+
+func TestParseScriptsAndSubHeaders(t *testing.T) {
+	b := testutil.MakeArtifact(t, testutil.ArtifactOptions{
+		Scripts: map[string]string{
+			"ArtifactInstall_Enter_00": "#!/bin/sh\n",
+			"ArtifactInstall_Leave_00": "#!/bin/sh\n",
+		},
+	})
+
+	info := parseInfo(t, b)
+	scripts := append([]string(nil), info.Scripts...)
+	sort.Strings(scripts)
+	if want := []string{"ArtifactInstall_Enter_00", "ArtifactInstall_Leave_00"}; !reflect.DeepEqual(scripts, want) {
+		t.Errorf("Scripts = %v, want %v", scripts, want)
+	}
+	if len(info.PayloadTypes) != 1 {
+		t.Errorf("PayloadTypes = %v, want one payload", info.PayloadTypes)
+	}
+}
+
+func TestScriptsParseNoScripts(t *testing.T) {
+	for _, first := range []string{"headers/0000/type-info", "headers/0000/meta-data", "headers/0001/type-info"} {
+		s := parseScripts(t, headerTar(t, first))
+		if names := s.List(); len(names) != 0 {
+			t.Errorf("%s: List() = %v, want no scripts", first, names)
+		}
+		s.Close()
+	}
+}
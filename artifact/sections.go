@@ -0,0 +1,90 @@
+package artifact
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// SectionHandler handles a custom section, read from r, following the
+// payloads of an Artifact
+type SectionHandler func(name string, r io.Reader) error
+
+// RegisterSectionHandler registers handler for the custom section name.
+// Custom sections without a handler are skipped when parsing.
+func (a *Artifact) RegisterSectionHandler(name string, handler SectionHandler) {
+	if a.sectionHandlers == nil {
+		a.sectionHandlers = map[string]SectionHandler{}
+	}
+	a.sectionHandlers[name] = handler
+}
+
+// sections returns all the sections of the Artifact, in the order they are
+// written to the Artifact tar.
+func (a *Artifact) sections() ([]ArtifactSection, error) {
+	if a.Version == nil || a.Manifest == nil || a.HeaderTar == nil || a.HeaderTar.raw == nil {
+		return nil, errors.New("The Artifact is not complete")
+	}
+	if a.HeaderTar.dirty {
+		return nil, errors.New("The header has been modified. The manifest has to be recomputed")
+	}
+	version, err := a.Version.bytes()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to marshal the version")
+	}
+	sections := []ArtifactSection{
+		{Name: "version", Data: bytes.NewReader(version)},
+		{Name: "manifest", Data: bytes.NewReader(a.Manifest.bytes())},
+	}
+	if a.ManifestSig != nil {
+		sections = append(sections, ArtifactSection{
+			Name: "manifest.sig", Data: bytes.NewReader(a.ManifestSig.sig)})
+		if a.ManifestAugment != nil {
+			sections = append(sections, ArtifactSection{
+				Name: "manifest-augment", Data: bytes.NewReader(a.ManifestAugment.bytes())})
+		}
+		if a.HeaderSigned != nil {
+			sections = append(sections, ArtifactSection{
+				Name: "header-signed.tar.gz", Data: bytes.NewReader(a.HeaderSigned.data)})
+		}
+	}
+	sections = append(sections, ArtifactSection{
+		Name: "header.tar" + a.HeaderTar.compression.Extension(),
+		Data: bytes.NewReader(a.HeaderTar.raw),
+	})
+	if a.HeaderAugment != nil {
+		sections = append(sections, ArtifactSection{
+			Name: "header-augment.tar.gz", Data: bytes.NewReader(a.HeaderAugment.raw)})
+	}
+	if a.Data != nil {
+		for _, payload := range a.Data.payloads {
+			sections = append(sections, ArtifactSection{
+				Name: payload.Name, Data: bytes.NewReader(payload.Data.Bytes())})
+		}
+	}
+	return sections, nil
+}
+
+// writeTar writes the Artifact as a mender-artifact tar to w
+func (a *Artifact) writeTar(w io.Writer) error {
+	sections, err := a.sections()
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	for _, section := range sections {
+		r := section.Data.(*bytes.Reader)
+		hdr := &tar.Header{
+			Name:     section.Name,
+			Mode:     0644,
+			Size:     r.Size(),
+			Typeflag: tar.TypeReg,
+		}
+		if err = copyTarEntry(tw, hdr, r); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
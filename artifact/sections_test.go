@@ -0,0 +1,45 @@
+package artifact_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/pkg/errors"
+)
+
+// parseReleaseNotes parses the Artifact b, and returns the release-notes
+// read by the registered SectionHandler
+func parseReleaseNotes(b []byte) ([]byte, error) {
+	var notes []byte
+	a := artifact.New()
+	defer a.Close()
+	a.RegisterSectionHandler("release-notes", func(name string, r io.Reader) error {
+		var err error
+		notes, err = ioutil.ReadAll(r)
+		return err
+	})
+	err := a.Parse(bytes.NewReader(b))
+	return notes, err
+}
+
+func TestCustomSection(t *testing.T) {
+	b := releaseNotesArtifact(t)
+	notes, err := parseReleaseNotes(b)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if string(notes) != releaseNotes {
+		t.Errorf("The handler read %q, want %q", notes, releaseNotes)
+	}
+
+	tampered := rewriteEntry(t, b, "release-notes", func([]byte) []byte {
+		return []byte("Adds a back door\n")
+	})
+	_, err = parseReleaseNotes(tampered)
+	if _, ok := errors.Cause(err).(*artifact.ChecksumMismatchError); !ok {
+		t.Errorf("Parse of changed release-notes = %v, want a ChecksumMismatchError", err)
+	}
+}
@@ -0,0 +1,38 @@
+package artifact
+
+// SelectCompatibleArtifacts returns the artifacts which can be installed on
+// the device type. The artifacts are only read, and may be shared between
+// goroutines.
+func SelectCompatibleArtifacts(artifacts []*Artifact, deviceType string) []*Artifact {
+	return selectArtifacts(artifacts, func(a *Artifact) bool {
+		return a.IsCompatibleWithDevice(deviceType)
+	})
+}
+
+// SelectByArtifactName returns the artifacts with the artifact_name name
+func SelectByArtifactName(artifacts []*Artifact, name string) []*Artifact {
+	return selectArtifacts(artifacts, func(a *Artifact) bool {
+		return a.HeaderTar != nil && a.HeaderTar.HeaderInfo != nil &&
+			a.HeaderTar.HeaderInfo.ArtifactProvides.ArtifactName == name
+	})
+}
+
+// SelectByPayloadType returns the artifacts with at least one payload of the
+// type payloadType, ie, rootfs-image
+func SelectByPayloadType(artifacts []*Artifact, payloadType string) []*Artifact {
+	return selectArtifacts(artifacts, func(a *Artifact) bool {
+		return containsString(a.PayloadTypes(), payloadType)
+	})
+}
+
+// selectArtifacts returns the artifacts matching, in their original order.
+// nil artifacts are skipped.
+func selectArtifacts(artifacts []*Artifact, matching func(*Artifact) bool) []*Artifact {
+	var selected []*Artifact
+	for _, a := range artifacts {
+		if a != nil && matching(a) {
+			selected = append(selected, a)
+		}
+	}
+	return selected
+}
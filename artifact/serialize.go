@@ -0,0 +1,58 @@
+package artifact
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// serialized serves the Read calls of a part of the Artifact from its
+// serialized form, which is made on the first call
+type serialized struct {
+	r *bytes.Reader
+}
+
+func (s *serialized) read(b []byte, serialize func() ([]byte, error)) (int, error) {
+	if s.r == nil {
+		data, err := serialize()
+		if err != nil {
+			return 0, err
+		}
+		s.r = bytes.NewReader(data)
+	}
+	return s.r.Read(b)
+}
+
+// Write writes the Artifact as a mender-artifact tar to w. A modified
+// Artifact has to have its manifest recomputed first. Parsing an Artifact,
+// and writing it, gives an Artifact with the same content.
+func (a *Artifact) Write(w io.Writer) error {
+	if err := a.writeTar(w); err != nil {
+		return errors.Wrap(err, "Write")
+	}
+	return nil
+}
+
+// WriteTo writes the Artifact as a mender-artifact tar to w, one section at a
+// time, and returns the number of bytes written. It makes io.Copy write the
+// Artifact directly, ie, to an http.ResponseWriter.
+func (a *Artifact) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	if err := a.writeTar(cw); err != nil {
+		return cw.n, errors.Wrap(err, "WriteTo")
+	}
+	return cw.n, nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
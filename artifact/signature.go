@@ -0,0 +1,501 @@
+package artifact
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// certificate returns the X.509 certificate embedded in the signature, or
+// nil if the signature is made with a raw key. The certificate is embedded
+// as a PEM block following the signature.
+func (m *ManifestSig) certificate() (*x509.Certificate, error) {
+	rest := m.sig
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "ManifestSig: Failed to parse the embedded certificate")
+		}
+		return cert, nil
+	}
+}
+
+// ExpiresAt returns the expiry of the signature, as given by the NotAfter
+// field of the embedded certificate. Signatures made with a raw key have no
+// expiry, and nil is returned.
+func (m *ManifestSig) ExpiresAt() (*time.Time, error) {
+	cert, err := m.certificate()
+	if err != nil || cert == nil {
+		return nil, err
+	}
+	expiry := cert.NotAfter
+	return &expiry, nil
+}
+
+// The algorithms reported by ManifestSig.Algorithm
+const (
+	SignatureRSA     = "RSA"
+	SignatureECDSA   = "ECDSA"
+	SignatureUnknown = "unknown"
+)
+
+// Algorithm returns the algorithm the manifest is signed with, either
+// SignatureRSA, SignatureECDSA, or SignatureUnknown. The algorithm of the
+// embedded certificate is used, if there is one. Otherwise, an ECDSA
+// signature is told apart by being a DER encoded SEQUENCE of the two integers
+// r and s, while an RSA signature is a bare integer the size of the key.
+func (m *ManifestSig) Algorithm() (string, error) {
+	cert, err := m.certificate()
+	if err != nil {
+		return "", err
+	}
+	if cert != nil {
+		switch cert.PublicKeyAlgorithm {
+		case x509.RSA:
+			return SignatureRSA, nil
+		case x509.ECDSA:
+			return SignatureECDSA, nil
+		default:
+			return SignatureUnknown, nil
+		}
+	}
+	sig, err := m.signature()
+	if err != nil {
+		return "", err
+	}
+	var ecdsaSig struct{ R, S *big.Int }
+	if rest, err := asn1.Unmarshal(sig, &ecdsaSig); err == nil && len(rest) == 0 {
+		return SignatureECDSA, nil
+	}
+	// RSA signatures are as long as the modulus, ie, 2048 bits or more
+	if len(sig) >= 128 && len(sig)%64 == 0 {
+		return SignatureRSA, nil
+	}
+	return SignatureUnknown, nil
+}
+
+// PublicKeyHint returns the subject key ID of the embedded certificate, or
+// its authority key ID, if it has none, to help picking the key to verify
+// the signature with. nil is returned if the signature has no hint, ie, if
+// it is made with a raw key.
+func (m *ManifestSig) PublicKeyHint() ([]byte, error) {
+	cert, err := m.certificate()
+	if err != nil || cert == nil {
+		return nil, err
+	}
+	if len(cert.SubjectKeyId) > 0 {
+		return cert.SubjectKeyId, nil
+	}
+	if len(cert.AuthorityKeyId) > 0 {
+		return cert.AuthorityKeyId, nil
+	}
+	return nil, nil
+}
+
+// signature returns the decoded signature, without any embedded certificate
+func (m *ManifestSig) signature() ([]byte, error) {
+	sig := m.sig
+	if wrapped, ok := m.timestamped(); ok {
+		sig = []byte(wrapped.Sig)
+	}
+	if i := bytes.Index(sig, []byte("-----BEGIN")); i >= 0 {
+		sig = sig[:i]
+	}
+	dec, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig)))
+	if err != nil {
+		return nil, errors.Wrap(err, "ManifestSig: Failed to decode the signature")
+	}
+	return dec, nil
+}
+
+// pemSignatureType is the type of the PEM block of PEMEncode
+const pemSignatureType = "SIGNATURE"
+
+// PEMEncode returns the decoded signature in a PEM block of type SIGNATURE,
+// ie, for converting it to the binary signature openssl dgst -verify takes.
+// The timestamp, and certificate, of the signature, if any, are not included.
+// Signatures made with a raw key, and a timestamp, cover the timestamp as
+// well, and do not verify without it.
+func (m *ManifestSig) PEMEncode() ([]byte, error) {
+	sig, err := m.signature()
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pemSignatureType, Bytes: sig}), nil
+}
+
+// PEMDecode sets the signature from the SIGNATURE PEM block in pemData, as
+// created by PEMEncode
+func (m *ManifestSig) PEMDecode(pemData []byte) error {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return errors.New("ManifestSig: PEMDecode: No PEM block")
+	}
+	if block.Type != pemSignatureType {
+		return fmt.Errorf("ManifestSig: PEMDecode: Unexpected PEM block %s, expected %s", block.Type, pemSignatureType)
+	}
+	m.sig = []byte(base64.StdEncoding.EncodeToString(block.Bytes))
+	m.rd = serialized{}
+	atomic.StoreInt32(&m.verified, 0)
+	return nil
+}
+
+// WriteToFile writes the signature to path, PEM encoded
+func (m *ManifestSig) WriteToFile(path string) error {
+	data, err := m.PEMEncode()
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(ioutil.WriteFile(path, data, 0644), "ManifestSig: WriteToFile")
+}
+
+// ReadFromFile sets the signature from the PEM encoded file path, as written
+// by WriteToFile
+func (m *ManifestSig) ReadFromFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "ManifestSig: ReadFromFile")
+	}
+	return m.PEMDecode(data)
+}
+
+// ErrSignatureInvalid is returned when the signature of an Artifact can not
+// be verified
+var ErrSignatureInvalid = errors.New("Invalid signature")
+
+// invalidSignature wraps err, a failed verification, so that its cause is
+// ErrSignatureInvalid
+func invalidSignature(err error, msg string) error {
+	if errors.Cause(err) == ErrSignatureInvalid {
+		return errors.Wrap(err, msg)
+	}
+	return errors.Wrapf(ErrSignatureInvalid, "%s: %v", msg, err)
+}
+
+// Verify checks the signature of the manifest it was parsed, or made, with
+// against pubKey, which is either an *rsa.PublicKey, or an *ecdsa.PublicKey.
+// The cause of the error is ErrSignatureInvalid if the signature does not
+// match.
+func (m *ManifestSig) Verify(pubKey crypto.PublicKey) error {
+	if m.manifest == nil {
+		return errors.New("ManifestSig: No manifest to verify")
+	}
+	if err := m.verify(m.manifest, pubKey); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&m.verified, 1)
+	return nil
+}
+
+// Verify checks that the signed header is the one listed in the manifest,
+// and that the manifest is signed with pubKey. ErrSignatureInvalid is returned
+// if the signature does not match, and a ChecksumMismatchError if the header
+// does not.
+func (h *HeaderSigned) Verify(pubKey crypto.PublicKey) error {
+	if h.sig == nil {
+		return errors.Wrap(ErrSignatureInvalid, "HeaderSigned: The Artifact is not signed")
+	}
+	if err := h.sig.Verify(pubKey); err != nil {
+		return invalidSignature(err, "HeaderSigned")
+	}
+	if h.manifest == nil {
+		return errors.New("HeaderSigned: No manifest")
+	}
+	expected, ok := h.manifest.Lookup("header-signed.tar.gz")
+	if !ok {
+		return &MissingSectionError{Section: "header-signed.tar.gz"}
+	}
+	if actual := manifestEntry("header-signed.tar.gz", h.data).Signature; actual != expected {
+		return &ChecksumMismatchError{Filename: "header-signed.tar.gz", Expected: expected, Actual: actual}
+	}
+	return nil
+}
+
+// verify checks the signature of the manifest against key. Both RSA
+// (PKCS #1 v1.5), and ECDSA (ASN.1) signatures of the SHA256 of the manifest
+// are supported. The timestamp of a signature made by SignWithTimestamp is
+// verified along with it.
+func (m *ManifestSig) verify(manifest []byte, key crypto.PublicKey) error {
+	sig, err := m.signature()
+	if err != nil {
+		return invalidSignature(err, "ManifestSig")
+	}
+	sum := sha256.Sum256(manifest)
+	digest := sum[:]
+	if wrapped, ok := m.timestamped(); ok {
+		digest = timestampDigest(manifest, wrapped.Ts)
+	}
+	if err = verifyDigest(key, digest, sig); err != nil {
+		return errors.Wrap(err, "ManifestSig")
+	}
+	signedData, err := m.cms()
+	if err != nil {
+		return invalidSignature(err, "ManifestSig")
+	}
+	if signedData != nil {
+		return errors.Wrap(signedData.verify(manifest, key), "ManifestSig")
+	}
+	return nil
+}
+
+// timestampDigest returns the digest raw keys sign, when signing with a
+// timestamp. It is the SHA256 of the manifest followed by the timestamp, so
+// that the signature covers both.
+func timestampDigest(manifest []byte, ts string) []byte {
+	h := sha256.New()
+	h.Write(manifest)
+	h.Write([]byte(ts))
+	return h.Sum(nil)
+}
+
+// verifyDigest verifies the RSA (PKCS #1 v1.5), or ECDSA (ASN.1), signature
+// sig of the SHA256 digest against key. A mismatch is an ErrSignatureInvalid.
+func verifyDigest(key crypto.PublicKey, digest, sig []byte) error {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest, sig); err != nil {
+			return errors.Wrap(ErrSignatureInvalid, "RSA")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		var ecdsaSig struct{ R, S *big.Int }
+		rest, err := asn1.Unmarshal(sig, &ecdsaSig)
+		if err != nil || len(rest) > 0 || !ecdsa.Verify(k, digest, ecdsaSig.R, ecdsaSig.S) {
+			return errors.Wrap(ErrSignatureInvalid, "ECDSA")
+		}
+		return nil
+	default:
+		return fmt.Errorf("Unsupported key type: %T", key)
+	}
+}
+
+// cms returns the CMS SignedData embedded in the signature, or nil if there
+// is none
+func (m *ManifestSig) cms() (*cmsSignature, error) {
+	rest := m.sig
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, nil
+		}
+		if block.Type == pemCMSType {
+			return parseCMS(block.Bytes)
+		}
+	}
+}
+
+// TimestampedManifest is the manifest, and its signature, along with the
+// time of signing, in RFC3339 format
+type TimestampedManifest struct {
+	Manifest    *Manifest
+	ManifestSig *ManifestSig
+	SignedAt    string
+}
+
+// TimestampedManifest returns the signed manifest of the Artifact, along
+// with the time it was signed. SignedAt is only taken from the signature if
+// the time is authenticated. That is, if the signature has been verified,
+// by ManifestSig.Verify, or by parsing the Artifact WithVerification, or if
+// the signature carries a CMS SignedData which verifies against the key of
+// its embedded certificate. The certificate itself is not verified. Otherwise
+// SignedAt is the current time. A signing time which fails the verification
+// is an error.
+func (a *Artifact) TimestampedManifest() (*TimestampedManifest, error) {
+	if a.Manifest == nil {
+		return nil, errors.New("TimestampedManifest: The Artifact has not been parsed")
+	}
+	if a.ManifestSig == nil {
+		return nil, errors.New("TimestampedManifest: The Artifact is not signed")
+	}
+	signedAt := time.Now()
+	t, err := a.ManifestSig.authenticatedSigningTime()
+	if err != nil {
+		return nil, errors.Wrap(err, "TimestampedManifest")
+	}
+	if t != nil {
+		signedAt = *t
+	}
+	return &TimestampedManifest{
+		Manifest:    a.Manifest,
+		ManifestSig: a.ManifestSig,
+		SignedAt:    signedAt.UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// authenticatedSigningTime returns the signing time, as TimestampedManifest
+// takes it, or nil if it is not authenticated
+func (m *ManifestSig) authenticatedSigningTime() (*time.Time, error) {
+	if atomic.LoadInt32(&m.verified) == 1 {
+		return m.SigningTime()
+	}
+	if m.manifest == nil {
+		return nil, nil
+	}
+	cert, err := m.certificate()
+	if err != nil || cert == nil {
+		return nil, err
+	}
+	signedData, err := m.cms()
+	if err != nil || signedData == nil {
+		return nil, err
+	}
+	if err = signedData.verify(m.manifest, cert.PublicKey); err != nil {
+		return nil, err
+	}
+	return signedData.signingTime, nil
+}
+
+// Signer signs the manifest of an Artifact. It is the method set of
+// crypto.Signer, so any private key, ie, an *rsa.PrivateKey, is a Signer, as
+// are keys kept in an HSM, like the ones of the signing/pkcs11 package. The
+// digest is a SHA256, and RSA signatures are expected to be PKCS #1 v1.5,
+// and ECDSA signatures ASN.1 encoded, like those of crypto/rsa and
+// crypto/ecdsa.
+type Signer interface {
+	Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error)
+	Public() crypto.PublicKey
+}
+
+// SigningKey is the key an Artifact is signed with. If Certificate is set,
+// it is embedded in the signature.
+type SigningKey struct {
+	Signer      Signer
+	Certificate *x509.Certificate
+}
+
+// timestampedSig is the signature format of raw keys signing with a
+// timestamp
+type timestampedSig struct {
+	Sig string `json:"sig"`
+	Ts  string `json:"ts"`
+}
+
+// timestamped returns the signature as a timestampedSig, if it is one
+func (m *ManifestSig) timestamped() (timestampedSig, bool) {
+	var wrapped timestampedSig
+	if !bytes.HasPrefix(bytes.TrimSpace(m.sig), []byte("{")) {
+		return wrapped, false
+	}
+	if err := json.Unmarshal(m.sig, &wrapped); err != nil {
+		return wrapped, false
+	}
+	return wrapped, true
+}
+
+// SigningTime returns the time the manifest was signed, or nil if the
+// signature has no timestamp. The time is covered by the signature, but is
+// only to be trusted once Verify has checked it.
+func (m *ManifestSig) SigningTime() (*time.Time, error) {
+	if wrapped, ok := m.timestamped(); ok {
+		t, err := time.Parse(time.RFC3339, wrapped.Ts)
+		if err != nil {
+			return nil, errors.Wrap(err, "ManifestSig: Invalid signing time")
+		}
+		return &t, nil
+	}
+	signedData, err := m.cms()
+	if err != nil || signedData == nil {
+		return nil, errors.Wrap(err, "ManifestSig")
+	}
+	return signedData.signingTime, nil
+}
+
+// SignWithTimestamp signs the manifest of the Artifact with key, and records
+// ts as the signing time, so that the signature covers it. Signatures made
+// with a certificate carry a detached CMS SignedData of the manifest, with the
+// time as its signingTime attribute, following the certificate. Those made
+// with a raw key sign the manifest followed by the time, and are wrapped in a
+// JSON object:
+//
+//	{"sig": "<base64>", "ts": "<RFC3339>"}
+//
+// Any existing signature is replaced.
+func (a *Artifact) SignWithTimestamp(key SigningKey, ts time.Time) error {
+	if a.Manifest == nil {
+		return errors.New("SignWithTimestamp: The Artifact has not been parsed")
+	}
+	if key.Signer == nil {
+		return errors.New("SignWithTimestamp: No signer")
+	}
+	if a.HeaderTar != nil && a.HeaderTar.dirty {
+		return errors.New("SignWithTimestamp: The manifest has to be recomputed before signing")
+	}
+	manifest := a.Manifest.bytes()
+	ts = ts.UTC().Truncate(time.Second)
+
+	if key.Certificate == nil {
+		stamp := ts.Format(time.RFC3339)
+		sig, err := key.Signer.Sign(rand.Reader, timestampDigest(manifest, stamp), crypto.SHA256)
+		if err != nil {
+			return errors.Wrap(err, "SignWithTimestamp: Failed to sign the manifest")
+		}
+		wrapped, err := json.Marshal(timestampedSig{Sig: base64.StdEncoding.EncodeToString(sig), Ts: stamp})
+		if err != nil {
+			return errors.Wrap(err, "SignWithTimestamp")
+		}
+		a.ManifestSig = &ManifestSig{sig: wrapped, manifest: manifest}
+		return nil
+	}
+	// The plain signature of the manifest keeps the Artifact verifiable by
+	// tools which know nothing of the SignedData
+	sum := sha256.Sum256(manifest)
+	sig, err := key.Signer.Sign(rand.Reader, sum[:], crypto.SHA256)
+	if err != nil {
+		return errors.Wrap(err, "SignWithTimestamp: Failed to sign the manifest")
+	}
+	signedData, err := cmsSign(key.Signer, key.Certificate, manifest, ts)
+	if err != nil {
+		return errors.Wrap(err, "SignWithTimestamp: Failed to sign the signing time")
+	}
+	buf := bytes.NewBufferString(base64.StdEncoding.EncodeToString(sig) + "\n")
+	pem.Encode(buf, &pem.Block{Type: "CERTIFICATE", Bytes: key.Certificate.Raw})
+	pem.Encode(buf, &pem.Block{Type: pemCMSType, Bytes: signedData})
+	a.ManifestSig = &ManifestSig{sig: buf.Bytes(), manifest: manifest}
+	return nil
+}
+
+// LoadPublicKey reads a PEM encoded public key, in either PKIX, or PKCS #1,
+// form, from the file path
+func LoadPublicKey(path string) (crypto.PublicKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to read the public key")
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("No PEM encoded key in %s", path)
+	}
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse the public key in %s", path)
+	}
+	return key, nil
+}
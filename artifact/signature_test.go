@@ -0,0 +1,472 @@
+package artifact_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+	"github.com/pkg/errors"
+)
+
+var (
+	rsaKeyOnce sync.Once
+	rsaKey     *rsa.PrivateKey
+)
+
+// testRSAKey returns a 2048 bit RSA key, generated once, as it is slow
+func testRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	rsaKeyOnce.Do(func() {
+		rsaKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+	})
+	if rsaKey == nil {
+		t.Fatal("Failed to generate an RSA key")
+	}
+	return rsaKey
+}
+
+func testECDSAKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// testKeys returns an RSA, and an ECDSA, key, by name
+func testKeys(t *testing.T) map[string]crypto.Signer {
+	return map[string]crypto.Signer{"RSA": testRSAKey(t), "ECDSA": testECDSAKey(t)}
+}
+
+// writePublicKey writes the PEM encoded public key of key to a file in dir
+func writePublicKey(t *testing.T, dir string, key crypto.Signer) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "public.pem")
+	if err = ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestManifestSigVerify(t *testing.T) {
+	for name, key := range testKeys(t) {
+		a := parse(t, testutil.MakeArtifact(t, testutil.ArtifactOptions{Signed: true, Key: key}))
+		if err := a.ManifestSig.Verify(key.Public()); err != nil {
+			t.Errorf("%s: Verify: %v", name, err)
+		}
+		err := a.ManifestSig.Verify(testECDSAKey(t).Public())
+		if errors.Cause(err) != artifact.ErrSignatureInvalid {
+			t.Errorf("%s: Verify with another key = %v, want ErrSignatureInvalid", name, err)
+		}
+		a.Close()
+	}
+}
+
+func TestParseWithVerification(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signature-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key := testECDSAKey(t)
+	signed := testutil.MakeArtifact(t, testutil.ArtifactOptions{Signed: true, Key: key})
+	unsigned := testutil.MakeArtifact(t, testutil.ArtifactOptions{})
+
+	tests := map[string]struct {
+		artifact []byte
+		opt      artifact.ParseOption
+		valid    bool
+	}{
+		"valid":             {signed, artifact.WithVerification(key.Public()), true},
+		"valid from file":   {signed, artifact.WithVerificationFromPEMFile(writePublicKey(t, dir, key)), true},
+		"other key":         {signed, artifact.WithVerification(testECDSAKey(t).Public()), false},
+		"other key, RSA":    {signed, artifact.WithVerification(testRSAKey(t).Public()), false},
+		"unsigned Artifact": {unsigned, artifact.WithVerification(key.Public()), false},
+	}
+	for name, test := range tests {
+		a, err := artifact.NewParser().Parse(bytes.NewReader(test.artifact), test.opt)
+		if test.valid && err != nil {
+			t.Errorf("%s: Parse: %v", name, err)
+		} else if !test.valid && errors.Cause(err) != artifact.ErrSignatureInvalid {
+			t.Errorf("%s: Parse = %v, want ErrSignatureInvalid", name, err)
+		}
+		if a != nil {
+			a.Close()
+		}
+	}
+}
+
+// testCertificate returns a self-signed certificate of key, expiring at
+// notAfter
+func testCertificate(t *testing.T, key crypto.Signer, notAfter time.Time) *x509.Certificate {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    notAfter.Add(-24 * time.Hour),
+		NotAfter:     notAfter,
+		SubjectKeyId: []byte{1, 2, 3, 4},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestSignWithTimestamp(t *testing.T) {
+	ts := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	for name, key := range testKeys(t) {
+		for _, cert := range []*x509.Certificate{nil, testCertificate(t, key, ts.Add(time.Hour))} {
+			if cert != nil {
+				name += " certificate"
+			}
+			a := parse(t, testutil.MakeArtifact(t, testutil.ArtifactOptions{}))
+			if err := a.SignWithTimestamp(artifact.SigningKey{Signer: key, Certificate: cert}, ts); err != nil {
+				t.Fatalf("%s: SignWithTimestamp: %v", name, err)
+			}
+			b := serialize(t, a)
+			a.Close()
+
+			signed := parse(t, b, artifact.WithVerification(key.Public()))
+			if signedAt, err := signed.ManifestSig.SigningTime(); err != nil || signedAt == nil || !signedAt.Equal(ts) {
+				t.Errorf("%s: SigningTime() = %v, %v, want %v", name, signedAt, err, ts)
+			}
+			signed.Close()
+
+			// The time is signed, and can not be changed
+			tampered := rewriteEntry(t, b, "manifest.sig", func(sig []byte) []byte {
+				return replaceTime(t, sig, ts, ts.Add(-time.Hour))
+			})
+			_, err := artifact.NewParser().Parse(bytes.NewReader(tampered), artifact.WithVerification(key.Public()))
+			if errors.Cause(err) != artifact.ErrSignatureInvalid {
+				t.Errorf("%s: Parse of a changed signing time = %v, want ErrSignatureInvalid", name, err)
+			}
+		}
+	}
+}
+
+// replaceTime replaces the time from with to in the signature sig, be it in
+// the JSON of a raw key, or in the CMS SignedData
+func replaceTime(t *testing.T, sig []byte, from, to time.Time) []byte {
+	t.Helper()
+	if fromJSON := from.Format(time.RFC3339); bytes.Contains(sig, []byte(fromJSON)) {
+		return bytes.Replace(sig, []byte(fromJSON), []byte(to.Format(time.RFC3339)), 1)
+	}
+	i := strings.Index(string(sig), "-----BEGIN CMS-----")
+	if i < 0 {
+		t.Fatal("The signature has no timestamp")
+	}
+	block, _ := pem.Decode(sig[i:])
+	fromDER, _ := asn1.Marshal(from)
+	toDER, _ := asn1.Marshal(to)
+	if !bytes.Contains(block.Bytes, fromDER) {
+		t.Fatal("The SignedData has no signing time")
+	}
+	block.Bytes = bytes.Replace(block.Bytes, fromDER, toDER, 1)
+	return append(sig[:i:i], pem.EncodeToMemory(block)...)
+}
+
+func TestTimestampedManifest(t *testing.T) {
+	key := testECDSAKey(t)
+	start := time.Now().Add(-time.Second)
+	a := parse(t, testutil.MakeArtifact(t, testutil.ArtifactOptions{Signed: true, Key: key}))
+	defer a.Close()
+	tm, err := a.TimestampedManifest()
+	if err != nil {
+		t.Fatalf("TimestampedManifest: %v", err)
+	}
+	signedAt, err := time.Parse(time.RFC3339, tm.SignedAt)
+	if err != nil {
+		t.Fatalf("Invalid SignedAt: %v", err)
+	}
+	if signedAt.Before(start.Truncate(time.Second)) || signedAt.After(time.Now()) {
+		t.Errorf("SignedAt = %s, not within the test", tm.SignedAt)
+	}
+	if tm.Manifest != a.Manifest || tm.ManifestSig != a.ManifestSig {
+		t.Error("TimestampedManifest does not hold the manifest, and signature, of the Artifact")
+	}
+}
+
+func TestTimestampedManifestAuthenticated(t *testing.T) {
+	key := testECDSAKey(t)
+	ts := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	sign := func(cert *x509.Certificate) []byte {
+		a := parse(t, testutil.MakeArtifact(t, testutil.ArtifactOptions{}))
+		defer a.Close()
+		if err := a.SignWithTimestamp(artifact.SigningKey{Signer: key, Certificate: cert}, ts); err != nil {
+			t.Fatalf("SignWithTimestamp: %v", err)
+		}
+		return serialize(t, a)
+	}
+	signedAt := func(b []byte, opts ...artifact.ParseOption) (string, error) {
+		a := parse(t, b, opts...)
+		defer a.Close()
+		tm, err := a.TimestampedManifest()
+		if err != nil {
+			return "", err
+		}
+		return tm.SignedAt, nil
+	}
+	want := ts.Format(time.RFC3339)
+
+	raw := sign(nil)
+	if got, err := signedAt(raw); err != nil || got == want {
+		t.Errorf("Unverified raw key: SignedAt = %s, %v, want the current time", got, err)
+	}
+	if got, err := signedAt(raw, artifact.WithVerification(key.Public())); err != nil || got != want {
+		t.Errorf("Verified raw key: SignedAt = %s, %v, want %s", got, err, want)
+	}
+
+	withCert := sign(testCertificate(t, key, ts.Add(time.Hour)))
+	if got, err := signedAt(withCert); err != nil || got != want {
+		t.Errorf("Certificate: SignedAt = %s, %v, want %s", got, err, want)
+	}
+	forged := rewriteEntry(t, withCert, "manifest.sig", func(sig []byte) []byte {
+		return replaceTime(t, sig, ts, ts.Add(-time.Hour))
+	})
+	if got, err := signedAt(forged); errors.Cause(err) != artifact.ErrSignatureInvalid {
+		t.Errorf("Forged certificate time: SignedAt = %s, %v, want ErrSignatureInvalid", got, err)
+	}
+}
+
+func TestExpiresAt(t *testing.T) {
+	key := testECDSAKey(t)
+	notAfter := time.Date(2030, 6, 30, 23, 59, 59, 0, time.UTC)
+	for _, cert := range []*x509.Certificate{nil, testCertificate(t, key, notAfter)} {
+		a := parse(t, testutil.MakeArtifact(t, testutil.ArtifactOptions{}))
+		if err := a.SignWithTimestamp(artifact.SigningKey{Signer: key, Certificate: cert}, time.Now()); err != nil {
+			t.Fatalf("SignWithTimestamp: %v", err)
+		}
+		signed := parse(t, serialize(t, a))
+		a.Close()
+		expiresAt, err := signed.ManifestSig.ExpiresAt()
+		signed.Close()
+		if err != nil {
+			t.Errorf("ExpiresAt: %v", err)
+		} else if cert == nil && expiresAt != nil {
+			t.Errorf("Raw key: ExpiresAt() = %v, want nil", expiresAt)
+		} else if cert != nil && (expiresAt == nil || !expiresAt.Equal(notAfter)) {
+			t.Errorf("Certificate: ExpiresAt() = %v, want %v", expiresAt, notAfter)
+		}
+	}
+}
+
+func TestAlgorithm(t *testing.T) {
+	ts := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	for name, key := range testKeys(t) {
+		for _, cert := range []*x509.Certificate{nil, testCertificate(t, key, ts.Add(time.Hour))} {
+			a := parse(t, testutil.MakeArtifact(t, testutil.ArtifactOptions{Signed: cert == nil, Key: key}))
+			if cert != nil {
+				if err := a.SignWithTimestamp(artifact.SigningKey{Signer: key, Certificate: cert}, ts); err != nil {
+					t.Fatalf("%s: SignWithTimestamp: %v", name, err)
+				}
+			}
+			if algorithm, err := a.ManifestSig.Algorithm(); err != nil || algorithm != name {
+				t.Errorf("%s, certificate %t: Algorithm() = %s, %v, want %s", name, cert != nil, algorithm, err, name)
+			}
+
+			hint, err := a.ManifestSig.PublicKeyHint()
+			if err != nil {
+				t.Errorf("%s: PublicKeyHint: %v", name, err)
+			} else if cert == nil && hint != nil {
+				t.Errorf("%s, raw key: PublicKeyHint() = %x, want nil", name, hint)
+			} else if cert != nil && !bytes.Equal(hint, cert.SubjectKeyId) {
+				t.Errorf("%s, certificate: PublicKeyHint() = %x, want %x", name, hint, cert.SubjectKeyId)
+			}
+			a.Close()
+		}
+	}
+}
+
+func TestManifestSigPEM(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signature-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, key := range testKeys(t) {
+		b := testutil.MakeArtifact(t, testutil.ArtifactOptions{Signed: true, Key: key})
+		original := readEntry(t, b, "manifest.sig")
+		a := parse(t, b)
+		pemData, err := a.ManifestSig.PEMEncode()
+		a.Close()
+		if err != nil {
+			t.Fatalf("%s: PEMEncode: %v", name, err)
+		}
+
+		decoded := &artifact.ManifestSig{}
+		if err = decoded.PEMDecode(pemData); err != nil {
+			t.Fatalf("%s: PEMDecode: %v", name, err)
+		}
+		if got, _ := ioutil.ReadAll(decoded); !bytes.Equal(got, original) {
+			t.Errorf("%s: PEMDecode gave %q, want %q", name, got, original)
+		}
+
+		path := filepath.Join(dir, name+".pem")
+		if err = decoded.WriteToFile(path); err != nil {
+			t.Fatalf("%s: WriteToFile: %v", name, err)
+		}
+		a = parse(t, b)
+		if err = a.ManifestSig.ReadFromFile(path); err != nil {
+			t.Fatalf("%s: ReadFromFile: %v", name, err)
+		}
+		if err = a.ManifestSig.Verify(key.Public()); err != nil {
+			t.Errorf("%s: Verify of the signature read from %s: %v", name, path, err)
+		}
+		a.Close()
+	}
+}
+
+// gzipped returns b gzip compressed
+func gzipped(t *testing.T, b []byte) []byte {
+	t.Helper()
+	buf := bytes.NewBuffer(nil)
+	zw := gzip.NewWriter(buf)
+	if _, err := zw.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// headerInfo returns the header-info of the Artifact b
+func headerInfo(t *testing.T, b []byte) string {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(readEntry(t, b, "header.tar.gz")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("The header has no header-info: %v", err)
+		}
+		if hdr.Name == "header-info" {
+			info, err := ioutil.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return string(info)
+		}
+	}
+}
+
+// withSignedHeader returns the unsigned Artifact b, with a header-signed.tar.gz
+// of its header-info, and script as ArtifactInstall_Enter_00, listed in the
+// manifest, and the manifest signed with key
+func withSignedHeader(t *testing.T, b []byte, script string, key crypto.Signer) []byte {
+	t.Helper()
+	signedHeader := gzipped(t, makeTar(t,
+		"header-info", headerInfo(t, b),
+		"scripts/ArtifactInstall_Enter_00", script,
+	))
+	sum := sha256.Sum256(signedHeader)
+	manifest := string(readEntry(t, b, "manifest")) + hex.EncodeToString(sum[:]) + "  header-signed.tar.gz\n"
+	sum = sha256.Sum256([]byte(manifest))
+	sig, err := key.Sign(rand.Reader, sum[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []string
+	tr := tar.NewReader(bytes.NewReader(b))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch hdr.Name {
+		case "manifest":
+			entries = append(entries, "manifest", manifest,
+				"manifest.sig", base64.StdEncoding.EncodeToString(sig))
+			continue
+		case "header.tar.gz":
+			entries = append(entries, "header-signed.tar.gz", string(signedHeader))
+		}
+		entries = append(entries, hdr.Name, string(content))
+	}
+	return makeTar(t, entries...)
+}
+
+func TestHeaderSignedVerify(t *testing.T) {
+	key := testECDSAKey(t)
+	script := "#!/bin/sh\necho enter\n"
+	unsigned := testutil.MakeArtifact(t, testutil.ArtifactOptions{
+		Scripts: map[string]string{"ArtifactInstall_Enter_00": script},
+	})
+	signed := withSignedHeader(t, unsigned, script, key)
+
+	a := parse(t, signed)
+	if err := a.HeaderSigned.Verify(key.Public()); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if scripts := a.HeaderSigned.Scripts(); len(scripts) != 1 {
+		t.Errorf("The signed header has the scripts %v, want ArtifactInstall_Enter_00", scripts)
+	}
+	if err := a.HeaderSigned.Verify(testECDSAKey(t).Public()); errors.Cause(err) != artifact.ErrSignatureInvalid {
+		t.Errorf("Verify with another key = %v, want ErrSignatureInvalid", err)
+	}
+	a.Close()
+
+	// A changed script does not match the manifest,
+	tampered := withSignedHeader(t, unsigned, "#!/bin/sh\necho tampered\n", testECDSAKey(t))
+	for _, name := range []string{"manifest", "manifest.sig"} {
+		tampered = rewriteEntry(t, tampered, name, func([]byte) []byte {
+			return readEntry(t, signed, name)
+		})
+	}
+	_, err := artifact.NewParser().Parse(bytes.NewReader(tampered))
+	if _, ok := errors.Cause(err).(*artifact.ChecksumMismatchError); !ok {
+		t.Errorf("Parse of a changed script, not in the manifest = %v, want a ChecksumMismatchError", err)
+	}
+	// and a manifest listing it does not match the signature
+	tampered = rewriteEntry(t, withSignedHeader(t, unsigned, "#!/bin/sh\necho tampered\n", testECDSAKey(t)),
+		"manifest.sig", func([]byte) []byte {
+			return readEntry(t, signed, "manifest.sig")
+		})
+	a = parse(t, tampered)
+	defer a.Close()
+	if err := a.HeaderSigned.Verify(key.Public()); errors.Cause(err) != artifact.ErrSignatureInvalid {
+		t.Errorf("Verify of a changed script = %v, want ErrSignatureInvalid", err)
+	}
+}
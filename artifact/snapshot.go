@@ -0,0 +1,84 @@
+package artifact
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ArtifactSnapshot is an immutable view of the metadata of a parsed Artifact.
+// It holds no payload data, and is a value type, so it can be freely copied,
+// stored, and marshaled to JSON.
+type ArtifactSnapshot struct {
+	FormatVersion int               `json:"format_version"`
+	ArtifactName  string            `json:"artifact_name"`
+	DeviceTypes   []string          `json:"device_types"`
+	PayloadTypes  []string          `json:"payload_types"`
+	Checksums     map[string]string `json:"checksums"`
+	HasSignature  bool              `json:"has_signature"`
+	ScriptNames   []string          `json:"script_names"`
+}
+
+// Snapshot captures the metadata of the Artifact. All the returned slices and
+// maps are copies, and modifying them does not affect the Artifact.
+func (a *Artifact) Snapshot() (ArtifactSnapshot, error) {
+	if a.Version == nil || a.Manifest == nil {
+		return ArtifactSnapshot{}, errors.New("Snapshot: The Artifact has not been parsed")
+	}
+	if a.HeaderTar == nil || a.HeaderTar.HeaderInfo == nil {
+		return ArtifactSnapshot{}, errors.New("Snapshot: The Artifact has no header-info")
+	}
+	info := a.HeaderTar.HeaderInfo
+	s := ArtifactSnapshot{
+		FormatVersion: a.Version.Version,
+		ArtifactName:  info.ArtifactProvides.ArtifactName,
+		DeviceTypes:   append([]string{}, info.ArtifactDepends.DeviceType...),
+		PayloadTypes:  []string{},
+		Checksums:     map[string]string{},
+		HasSignature:  a.ManifestSig != nil,
+		ScriptNames:   []string{},
+	}
+	for _, payload := range info.Payloads {
+		s.PayloadTypes = append(s.PayloadTypes, payload.Type)
+	}
+	for _, entry := range a.Manifest.Data {
+		s.Checksums[entry.Name] = entry.Signature
+	}
+	if a.HeaderTar.Scripts != nil {
+		s.ScriptNames = append(s.ScriptNames, a.HeaderTar.Scripts.paths()...)
+	}
+	return s, nil
+}
+
+// Equal reports whether the two snapshots describe the same Artifact metadata
+func (s ArtifactSnapshot) Equal(other ArtifactSnapshot) bool {
+	if s.FormatVersion != other.FormatVersion ||
+		s.ArtifactName != other.ArtifactName ||
+		s.HasSignature != other.HasSignature {
+		return false
+	}
+	if !stringsEqual(s.DeviceTypes, other.DeviceTypes) ||
+		!stringsEqual(s.PayloadTypes, other.PayloadTypes) ||
+		!stringsEqual(s.ScriptNames, other.ScriptNames) {
+		return false
+	}
+	if len(s.Checksums) != len(other.Checksums) {
+		return false
+	}
+	for name, sum := range s.Checksums {
+		if otherSum, ok := other.Checksums[name]; !ok || otherSum != sum {
+			return false
+		}
+	}
+	return true
+}
+
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
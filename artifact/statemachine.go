@@ -0,0 +1,181 @@
+package artifact
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// parseState is the state of the StateMachineParser, named after the last
+// section parsed
+type parseState int
+
+const (
+	stateStart parseState = iota
+	stateVersion
+	stateManifest
+	stateManifestSig
+	stateManifestAugment
+	stateHeaderSigned
+	stateHeader
+	stateHeaderAugment
+	stateData
+	stateExtra
+)
+
+func (s parseState) String() string {
+	switch s {
+	case stateStart:
+		return "start"
+	case stateVersion:
+		return "version"
+	case stateManifest:
+		return "manifest"
+	case stateManifestSig:
+		return "manifest.sig"
+	case stateManifestAugment:
+		return "manifest-augment"
+	case stateHeaderSigned:
+		return "header-signed"
+	case stateHeader:
+		return "header"
+	case stateHeaderAugment:
+		return "header-augment"
+	case stateData:
+		return "data"
+	case stateExtra:
+		return "extra"
+	default:
+		return fmt.Sprintf("parseState(%d)", int(s))
+	}
+}
+
+// transitions are the sections allowed in each state, and the state
+// following them. A new section of the format is added here, and, if need be,
+// in handlers.
+var transitions = map[parseState]map[TokenType]parseState{
+	stateStart:    {TokenVersion: stateVersion},
+	stateVersion:  {TokenManifest: stateManifest},
+	stateManifest: {TokenManifestSig: stateManifestSig, TokenHeaderTar: stateHeader},
+	stateManifestSig: {
+		TokenManifestAugment: stateManifestAugment,
+		TokenHeaderSigned:    stateHeaderSigned,
+		TokenHeaderTar:       stateHeader,
+	},
+	stateManifestAugment: {TokenHeaderSigned: stateHeaderSigned, TokenHeaderTar: stateHeader},
+	stateHeaderSigned:    {TokenHeaderTar: stateHeader},
+	stateHeader:          {TokenHeaderAugment: stateHeaderAugment, TokenData: stateData},
+	stateHeaderAugment:   {TokenData: stateData},
+	stateData:            {TokenData: stateData, TokenExtraFile: stateExtra},
+	stateExtra:           {TokenExtraFile: stateExtra},
+}
+
+// missingInState is the section missing when the Artifact ends in a state
+// which is not final
+var missingInState = map[parseState]string{
+	stateStart:           "version",
+	stateVersion:         "manifest",
+	stateManifest:        "header.tar.gz",
+	stateManifestSig:     "header.tar.gz",
+	stateManifestAugment: "header.tar.gz",
+	stateHeaderSigned:    "header.tar.gz",
+	stateHeader:          "data",
+	stateHeaderAugment:   "data",
+}
+
+// TokenHandler parses the section of the token, read from r, into a
+type TokenHandler func(a *Artifact, token Token, r io.Reader) error
+
+// StateMachineParser parses an Artifact from the tokens of a Lexer, driven by
+// a table of the allowed transitions between the sections. Sections which
+// are unknown to it are logged, and skipped, if they come before the
+// payloads, as they may be a part of a newer format. Known sections out of
+// order give a TokenSequenceError.
+type StateMachineParser struct {
+	handlers map[TokenType]TokenHandler
+}
+
+func NewStateMachineParser() *StateMachineParser {
+	return &StateMachineParser{handlers: map[TokenType]TokenHandler{}}
+}
+
+// Handle parses the sections of the type t with handler, in place of the
+// default, which parses them into the Artifact like Parser.Parse
+func (p *StateMachineParser) Handle(t TokenType, handler TokenHandler) {
+	p.handlers[t] = handler
+}
+
+func parseToken(a *Artifact, token Token, r io.Reader) error {
+	return a.parseSection(token.Header.Name, r)
+}
+
+// Parse parses the whole Artifact read from r. The options are the ones of
+// Parser.Parse.
+func (p *StateMachineParser) Parse(r io.Reader, opts ...ParseOption) (*Artifact, error) {
+	o, err := applyParseOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	a := &Artifact{progress: o.progress, strictParsing: o.strict}
+	if err = p.parse(a, r); err != nil {
+		return nil, err
+	}
+	if err = a.verifyManifest(); err != nil {
+		return nil, errors.Wrap(err, "Parse")
+	}
+	if err = o.verify(a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (p *StateMachineParser) parse(a *Artifact, r io.Reader) error {
+	inputs := make(chan lexerInput)
+	tokens := newLexer(inputs, true).Tokens()
+	// Stop the lexer, and wait for it to finish
+	defer func() {
+		close(inputs)
+		for range tokens {
+		}
+	}()
+	cr := &countingReader{r: r}
+	tr := tar.NewReader(cr)
+	state := stateStart
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "Parse")
+		}
+		inputs <- lexerInput{hdr: *hdr, offset: cr.n}
+		token := <-tokens
+		next, ok := transitions[state][token.Type]
+		switch {
+		case !ok && token.Type == TokenExtraFile:
+			log.Infof("Parse: Skipping the unknown section %s in the state %s", hdr.Name, state)
+			continue
+		case !ok:
+			return &TokenSequenceError{State: state.String(), Token: token.Type, Section: hdr.Name}
+		}
+		handler, ok := p.handlers[token.Type]
+		if !ok {
+			handler = parseToken
+		}
+		a.setSectionSize(hdr.Name, hdr.Size)
+		if err = handler(a, token, tr); err != nil {
+			return err
+		}
+		if a.progress != nil {
+			a.progress(hdr.Name, cr.n, hdr.Size)
+		}
+		state = next
+	}
+	if missing, ok := missingInState[state]; ok {
+		return &MissingSectionError{Section: missing}
+	}
+	return nil
+}
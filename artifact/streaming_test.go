@@ -0,0 +1,117 @@
+package artifact_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/pkg/errors"
+)
+
+// imageReader generates a synthetic image of size bytes, without holding it
+// in memory. Each 4 KiB block holds its own index, so that no two blocks are
+// the same, but the image still compresses.
+type imageReader struct {
+	size, off int64
+}
+
+func (r *imageReader) Read(b []byte) (int, error) {
+	if r.off >= r.size {
+		return 0, io.EOF
+	}
+	if rem := r.size - r.off; int64(len(b)) > rem {
+		b = b[:rem]
+	}
+	for i := range b {
+		block := uint64((r.off + int64(i)) / 4096)
+		b[i] = byte(block >> (8 * uint((r.off+int64(i))%8)))
+	}
+	r.off += int64(len(b))
+	return len(b), nil
+}
+
+func TestAddPayloadStreaming(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Streams a 100 MB payload")
+	}
+	const size = 100 << 20
+	h := sha256.New()
+	if _, err := io.Copy(h, &imageReader{size: size}); err != nil {
+		t.Fatal(err)
+	}
+	checksum := hex.EncodeToString(h.Sum(nil))
+
+	f, err := ioutil.TempFile("", "streaming-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	aw := artifact.NewArtifactWriter(f, artifact.WithVersion(3))
+	aw.SetArtifactName("release-1")
+	aw.SetCompatibleDevices([]string{"beaglebone"})
+	if err = aw.AddPayloadStreaming("rootfs-image", "rootfs.ext4", &imageReader{size: size}, checksum); err != nil {
+		t.Fatalf("AddPayloadStreaming: %v", err)
+	}
+	if err = aw.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	runtime.ReadMemStats(&after)
+	// Not even the compressed payload is held in memory
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > size/4 {
+		t.Errorf("Writing the %d byte payload allocated %d bytes", size, allocated)
+	}
+
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	ar := artifact.NewArtifactReader(f)
+	defer ar.Close()
+	p, err := ar.Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	n, err := io.Copy(ioutil.Discard, p)
+	if err != nil {
+		t.Fatalf("Failed to read the payload: %v", err)
+	}
+	if sum := hex.EncodeToString(p.Checksum()); n != size || sum != checksum {
+		t.Errorf("Read %d bytes, with the checksum %s, want %d bytes with %s", n, sum, size, checksum)
+	}
+	if sum, _ := ar.Artifact.Manifest.Lookup("data/0000/rootfs.ext4"); sum != checksum {
+		t.Errorf("The manifest checksum is %s, want %s", sum, checksum)
+	}
+}
+
+func TestAddPayloadStreamingChecksumMismatch(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	aw := artifact.NewArtifactWriter(buf, artifact.WithVersion(3))
+	aw.SetArtifactName("release-1")
+	aw.SetCompatibleDevices([]string{"beaglebone"})
+	other := sha256.Sum256([]byte("another image"))
+	if err := aw.AddPayloadStreaming("rootfs-image", "rootfs.ext4", &imageReader{size: 1 << 20}, hex.EncodeToString(other[:])); err != nil {
+		t.Fatalf("AddPayloadStreaming: %v", err)
+	}
+	var mismatch *artifact.ChecksumMismatchError
+	if err := aw.Flush(); !errors.As(err, &mismatch) || mismatch.Expected != hex.EncodeToString(other[:]) {
+		t.Errorf("Flush of a payload not matching its checksum returned %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Wrote %d bytes of an Artifact with a payload not matching its checksum", buf.Len())
+	}
+
+	aw = artifact.NewArtifactWriter(ioutil.Discard, artifact.WithVersion(3))
+	if err := aw.AddPayloadStreaming("rootfs-image", "rootfs.ext4", &imageReader{}, "abcd"); err == nil {
+		t.Error("AddPayloadStreaming with an invalid checksum succeeded")
+	}
+}
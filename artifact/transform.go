@@ -0,0 +1,102 @@
+package artifact
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// PayloadTransformer modifies the files of a payload
+type PayloadTransformer interface {
+	// Transform returns the new content of the payload file read from r
+	Transform(r io.Reader) (io.Reader, error)
+}
+
+// TransformPayload applies t to every file in the payload index, and returns a
+// copy of the Artifact holding the transformed payload. The payload is
+// decompressed before it is handed to t, and compressed anew afterwards, so t
+// only deals with the file content. The manifest, and the checksum of a
+// rootfs-image, are updated to match the new content.
+func (a *Artifact) TransformPayload(index int, t PayloadTransformer) (_ *Artifact, err error) {
+	if a.HeaderTar == nil || a.HeaderTar.HeaderInfo == nil || a.Data == nil {
+		return nil, errors.New("TransformPayload: The Artifact has not been parsed")
+	}
+	if index < 0 || index >= len(a.Data.payloads) {
+		return nil, fmt.Errorf("TransformPayload: No payload %d", index)
+	}
+	transformed := a.copyMetadata()
+	defer func() {
+		if err != nil {
+			transformed.closeScripts()
+		}
+	}()
+	transformed.Data = &Data{payloads: append([]PayLoadData{}, a.Data.payloads...)}
+	payload := &transformed.Data.payloads[index]
+
+	compression, err := compressionFromName(payload.Name)
+	if err != nil {
+		return nil, errors.Wrap(err, "TransformPayload")
+	}
+	zr, err := compression.newReader(bytes.NewReader(payload.Data.Bytes()))
+	if err != nil {
+		return nil, errors.Wrap(err, "TransformPayload")
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+
+	buf := bytes.NewBuffer(nil)
+	zw, err := compression.newWriter(buf)
+	if err != nil {
+		return nil, errors.Wrap(err, "TransformPayload")
+	}
+	tw := tar.NewWriter(zw)
+	sums := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "TransformPayload: Failed to read %s", payload.Name)
+		}
+		r, err := t.Transform(tr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "TransformPayload: Failed to transform %s", hdr.Name)
+		}
+		content, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, errors.Wrapf(err, "TransformPayload: Failed to read the transformed %s", hdr.Name)
+		}
+		hdr.Size = int64(len(content))
+		if err = copyTarEntry(tw, hdr, bytes.NewReader(content)); err != nil {
+			return nil, errors.Wrap(err, "TransformPayload")
+		}
+		name := fmt.Sprintf("data/%04d/%s", index, hdr.Name)
+		sums[name] = manifestEntry(name, content).Signature
+	}
+	if err = tw.Close(); err != nil {
+		return nil, errors.Wrap(err, "TransformPayload")
+	}
+	if err = zw.Close(); err != nil {
+		return nil, errors.Wrap(err, "TransformPayload")
+	}
+	*payload = PayLoadData{Name: payload.Name}
+	payload.Data.Write(buf.Bytes())
+
+	changed := transformed.updateManifest(sums)
+	payloads := transformed.HeaderTar.HeaderInfo.Payloads
+	if changed && index < len(payloads) && payloads[index].Type == "rootfs-image" && len(sums) == 1 {
+		for _, sum := range sums {
+			if err = transformed.SetTypeInfoProvides(index, sum); err != nil {
+				return nil, errors.Wrap(err, "TransformPayload")
+			}
+		}
+	}
+	if err = transformed.RecomputeManifest(); err != nil {
+		return nil, errors.Wrap(err, "TransformPayload")
+	}
+	return transformed, nil
+}
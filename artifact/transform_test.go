@@ -0,0 +1,71 @@
+package artifact_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+)
+
+type noopTransformer struct{}
+
+func (noopTransformer) Transform(r io.Reader) (io.Reader, error) { return r, nil }
+
+type upperTransformer struct{}
+
+func (upperTransformer) Transform(r io.Reader) (io.Reader, error) {
+	content, err := ioutil.ReadAll(r)
+	return bytes.NewReader(bytes.ToUpper(content)), err
+}
+
+// checksum returns the manifest checksum of file, or the empty string
+func checksum(a *artifact.Artifact, file string) string {
+	sum, _ := a.Manifest.Lookup(file)
+	return sum
+}
+
+func TestTransformPayload(t *testing.T) {
+	a := parse(t, testutil.MakeArtifact(t, testutil.ArtifactOptions{PayloadContent: []byte("rootfs")}))
+	defer a.Close()
+	const file = "data/0000/rootfs.ext4"
+	sum, ok := a.Manifest.Lookup(file)
+	if !ok {
+		t.Fatalf("The manifest has no entry for %s", file)
+	}
+
+	same, err := a.TransformPayload(0, noopTransformer{})
+	if err != nil {
+		t.Fatalf("TransformPayload: %v", err)
+	}
+	defer same.Close()
+	if got := checksum(same, file); got != sum {
+		t.Errorf("The no-op transform changed the checksum of %s from %s to %s", file, sum, got)
+	}
+	if content := payloadContent(t, same); content != "rootfs" {
+		t.Errorf("The no-op transform changed the payload to %q", content)
+	}
+
+	upper, err := a.TransformPayload(0, upperTransformer{})
+	if err != nil {
+		t.Fatalf("TransformPayload: %v", err)
+	}
+	defer upper.Close()
+	if got, want := checksum(upper, file), fmt.Sprintf("%x", sha256.Sum256([]byte("ROOTFS"))); got != want {
+		t.Errorf("The checksum of the transformed %s is %s, want %s", file, got, want)
+	}
+	if content := payloadContent(t, upper); content != "ROOTFS" {
+		t.Errorf("The transformed payload is %q, want ROOTFS", content)
+	}
+	if got := checksum(a, file); got != sum {
+		t.Errorf("The transform changed the checksum of the original %s to %s", file, got)
+	}
+
+	if _, err := a.TransformPayload(1, noopTransformer{}); err == nil {
+		t.Error("TransformPayload of a missing payload succeeded")
+	}
+}
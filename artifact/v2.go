@@ -0,0 +1,167 @@
+package artifact
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// The format versions of mender-artifact supported
+const (
+	FormatVersion2 = 2
+	FormatVersion3 = 3
+)
+
+// headerInfoV2 is the header-info of a version 2 Artifact
+type headerInfoV2 struct {
+	Updates               []Payload `json:"updates"`
+	DeviceTypesCompatible []string  `json:"device_types_compatible"`
+	ArtifactName          string    `json:"artifact_name"`
+}
+
+// marshalV2 returns the header-info in the version 2 format. Version 2 has no
+// groups, depends on Artifact names, nor additional provides and depends,
+// and these are left out.
+func (h *HeaderInfo) marshalV2() ([]byte, error) {
+	return json.Marshal(headerInfoV2{
+		Updates:               h.Payloads,
+		DeviceTypesCompatible: h.ArtifactDepends.DeviceType,
+		ArtifactName:          h.ArtifactProvides.ArtifactName,
+	})
+}
+
+// parseV2 parses the header of a version 2 Artifact. Its layout is:
+//
+//	header-info
+//	scripts/<scripts>
+//	headers/0000/files
+//	headers/0000/type-info
+//	headers/0000/meta-data
+//	headers/0000/checksums/<file>.sha256sum
+//	headers/0001/...
+//
+// All but header-info are optional. The files and checksums are superseded by
+// the manifest, and are not kept.
+func (h *HeaderTar) parseV2(r io.Reader) error {
+	log.Debug("Parsing version 2 header.tar")
+	sha := sha256.New()
+	zr, err := h.compression.newReader(io.TeeReader(r, sha))
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+	hdr, err := tr.Next()
+	if err != nil {
+		return errors.Wrap(err, "HeaderTar")
+	}
+	if hdr.Name != "header-info" {
+		return fmt.Errorf("Unexpected header: %s", hdr.Name)
+	}
+	// HeaderInfo recognizes the version 2 layout
+	h.HeaderInfo = &HeaderInfo{}
+	if err = json.NewDecoder(tr).Decode(h.HeaderInfo); err != nil {
+		return fmt.Errorf("Failed to parse 'header-info'. Error: %v", err)
+	}
+	if h.Scripts == nil {
+		h.Scripts = &Scripts{}
+	}
+	if hdr, err = h.Scripts.parseArchive(tr); err != nil {
+		return fmt.Errorf("Failed to parse 'scripts'. Error: %v", err)
+	}
+	for ; hdr != nil; hdr, err = tr.Next() {
+		parts := strings.Split(hdr.Name, "/")
+		if len(parts) < 3 || parts[0] != "headers" {
+			return fmt.Errorf("HeaderTar: Unexpected header: %s", hdr.Name)
+		}
+		name := parts[1]
+		if len(h.Headers) == 0 || h.Headers[len(h.Headers)-1].name != name {
+			h.Headers = append(h.Headers, SubHeader{
+				name:     name,
+				typeInfo: &TypeInfo{},
+				metaData: &MetaData{},
+			})
+		}
+		sh := &h.Headers[len(h.Headers)-1]
+		switch filepath.Base(hdr.Name) {
+		case "type-info":
+			err = sh.typeInfo.Parse(tr)
+		case "meta-data":
+			err = sh.metaData.Parse(tr)
+		default:
+			_, err = io.Copy(ioutil.Discard, tr)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "HeaderTar: Failed to parse %s", hdr.Name)
+		}
+	}
+	if err != nil && err != io.EOF {
+		return errors.Wrap(err, "HeaderTar")
+	}
+	h.ShaSum = sha.Sum(nil)
+	return nil
+}
+
+// ErrDowngradeNotSupported is returned by UpgradeTo for a format version older
+// than that of the Artifact
+var ErrDowngradeNotSupported = errors.New("Downgrading the format version is not supported")
+
+// UpgradeTo migrates a parsed Artifact to the format version targetVersion.
+// Only version 2 to 3 is supported. The header is built anew in the version 3
+// layout, the rootfs-image payloads get their rootfs_image_checksum provides,
+// and the manifest is regenerated, which drops any signature.
+func (a *Artifact) UpgradeTo(targetVersion int) error {
+	if a.Version == nil || a.Manifest == nil || a.HeaderTar == nil || a.HeaderTar.HeaderInfo == nil {
+		return errors.New("UpgradeTo: The Artifact has not been parsed")
+	}
+	if targetVersion == a.Version.Version {
+		return nil
+	}
+	from := a.Version.Version
+	if err := a.Version.Upgrade(targetVersion); err != nil {
+		return errors.Wrap(err, "UpgradeTo")
+	}
+	log.Debugf("Upgrading the Artifact from version %d to %d", from, targetVersion)
+	h := a.HeaderTar
+	for i, sh := range h.Headers {
+		if sh.typeInfo == nil || sh.typeInfo.Type != "rootfs-image" ||
+			sh.typeInfo.TypeInfoProvides.RootfsImageChecksum != "" {
+			continue
+		}
+		prefix := fmt.Sprintf("data/%04d/", i)
+		for _, entry := range a.Manifest.Data {
+			if strings.HasPrefix(entry.Name, prefix) {
+				sh.typeInfo.TypeInfoProvides.RootfsImageChecksum = entry.Signature
+				break
+			}
+		}
+	}
+	h.formatVersion = 0
+	h.restructured, h.dirty = true, true
+	return errors.Wrap(a.Manifest.Regenerate(a), "UpgradeTo")
+}
+
+// Upgrade sets the format version of the version section to target. Only
+// version 2 to 3 is supported. The rest of the Artifact is left as is, and
+// has to be migrated with Artifact.UpgradeTo, which calls Upgrade.
+func (v *Version) Upgrade(target int) error {
+	switch {
+	case target < v.Version:
+		return errors.Wrapf(ErrDowngradeNotSupported, "Version: Upgrade: %d to %d", v.Version, target)
+	case target == v.Version:
+		return nil
+	case target != FormatVersion3:
+		return fmt.Errorf("Version: Upgrade: Unsupported format version %d", target)
+	}
+	v.Version = target
+	v.raw, v.rd = nil, serialized{}
+	return nil
+}
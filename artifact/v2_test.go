@@ -0,0 +1,85 @@
+package artifact_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+	"github.com/pkg/errors"
+)
+
+func TestUpgradeTo(t *testing.T) {
+	a := parse(t, testutil.MakeArtifact(t, testutil.ArtifactOptions{Version: 2}))
+	defer a.Close()
+	if err := a.UpgradeTo(artifact.FormatVersion3); err != nil {
+		t.Fatalf("UpgradeTo: %v", err)
+	}
+	upgraded := parse(t, serialize(t, a))
+	defer upgraded.Close()
+	if upgraded.Version.Version != artifact.FormatVersion3 {
+		t.Errorf("The upgraded Artifact is of the version %d, want 3", upgraded.Version.Version)
+	}
+	if err := upgraded.UpgradeTo(artifact.FormatVersion2); errors.Cause(err) != artifact.ErrDowngradeNotSupported {
+		t.Errorf("UpgradeTo(2) = %v, want ErrDowngradeNotSupported", err)
+	}
+}
+
+func TestVersionUpgrade(t *testing.T) {
+	v := &artifact.Version{Format: "mender", Version: artifact.FormatVersion2}
+	if err := v.Upgrade(artifact.FormatVersion3); err != nil || v.Version != artifact.FormatVersion3 {
+		t.Errorf("Upgrade(3) = %v, and the version is %d, want 3", err, v.Version)
+	}
+	if err := v.Upgrade(artifact.FormatVersion2); errors.Cause(err) != artifact.ErrDowngradeNotSupported {
+		t.Errorf("Upgrade(2) = %v, want ErrDowngradeNotSupported", err)
+	}
+	if err := v.Upgrade(4); err == nil {
+		t.Error("Upgrade(4) succeeded")
+	}
+}
+
+func TestParseVersions(t *testing.T) {
+	tests := map[int]struct {
+		headerInfoKey string // Only in the header-info of the version
+	}{
+		artifact.FormatVersion2: {headerInfoKey: `"updates"`},
+		artifact.FormatVersion3: {headerInfoKey: `"artifact_provides"`},
+	}
+	for version, test := range tests {
+		b := testutil.MakeArtifact(t, testutil.ArtifactOptions{
+			Version:        version,
+			ArtifactName:   "release-1",
+			DeviceType:     "beaglebone",
+			PayloadContent: []byte("rootfs"),
+		})
+		if info := headerInfo(t, b); !strings.Contains(info, test.headerInfoKey) {
+			t.Errorf("Version %d: The header-info %s has no %s", version, info, test.headerInfoKey)
+		}
+
+		parsed := artifact.New(artifact.WithVersion(version))
+		if err := parsed.Parse(bytes.NewReader(b)); err != nil {
+			t.Fatalf("Version %d: Parse: %v", version, err)
+		}
+		info := parsed.Info()
+		parsed.Close()
+		if info.Version != version || info.Format != "mender" {
+			t.Errorf("Version %d: Parsed the format %s %d", version, info.Format, info.Version)
+		}
+		if info.Name != "release-1" || !reflect.DeepEqual(info.CompatibleDevices, []string{"beaglebone"}) {
+			t.Errorf("Version %d: Parsed %s, for %v", version, info.Name, info.CompatibleDevices)
+		}
+		if !reflect.DeepEqual(info.PayloadTypes, []string{"rootfs-image"}) {
+			t.Errorf("Version %d: Parsed the payload types %v", version, info.PayloadTypes)
+		}
+
+		other := artifact.FormatVersion2 + artifact.FormatVersion3 - version
+		wrong := artifact.New(artifact.WithVersion(other))
+		var versionErr *artifact.UnsupportedVersionError
+		if err := wrong.Parse(bytes.NewReader(b)); !errors.As(err, &versionErr) {
+			t.Errorf("Version %d: Parse with WithVersion(%d) = %v, want an UnsupportedVersionError", version, other, err)
+		}
+		wrong.Close()
+	}
+}
@@ -0,0 +1,106 @@
+package artifact
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidationError is a single violation found by Validate
+type ValidationError struct {
+	// Field is the part of the Artifact violating the rule, ie, manifest
+	Field string
+	// Rule is the name of the violated rule
+	Rule        string
+	Description string
+}
+
+func (v ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", v.Field, v.Rule, v.Description)
+}
+
+// The rules checked by Validate
+const (
+	RuleManifestEntryExists = "manifest-entry-exists"
+	RuleVersionFormat       = "version-format"
+	RuleArtifactName        = "artifact-name"
+	RulePayloadTypeListed   = "payload-type-listed"
+	RuleAugmentNoOverlap    = "augment-no-overlap"
+	RuleSectionsParsed      = "sections-parsed"
+)
+
+// Validate checks that the parsed sections of the Artifact are consistent with
+// each other, and returns all the violations found. The Artifact is valid if
+// none are returned.
+//
+// Only the sections kept by Parse are checked against the manifest. Extra
+// files listed in the manifest are not.
+//
+// With RequireSBOM, payloads without an SBOM are warned about in the log,
+// and not returned as violations.
+func (a *Artifact) Validate() []ValidationError {
+	var violations []ValidationError
+	add := func(field, rule, format string, args ...interface{}) {
+		violations = append(violations, ValidationError{
+			Field:       field,
+			Rule:        rule,
+			Description: fmt.Sprintf(format, args...),
+		})
+	}
+	if a.Version == nil || a.Manifest == nil || a.HeaderTar == nil || a.HeaderTar.HeaderInfo == nil {
+		add("artifact", RuleSectionsParsed, "The Artifact has not been parsed")
+		return violations
+	}
+
+	sums, err := a.parsedChecksums()
+	if err != nil {
+		add("manifest", RuleManifestEntryExists, "Failed to read the payloads: %v", err)
+	} else {
+		for _, entry := range a.Manifest.Data {
+			if a.HeaderOnly && strings.HasPrefix(entry.Name, "data/") {
+				continue
+			}
+			if _, ok := sums[entry.Name]; !ok && isParsedSection(entry.Name) {
+				add("manifest", RuleManifestEntryExists, "%s is not in the Artifact", entry.Name)
+			}
+		}
+	}
+
+	if a.Version.Format != "mender" {
+		add("version", RuleVersionFormat, "Expected the format mender, got %q", a.Version.Format)
+	}
+
+	info := a.HeaderTar.HeaderInfo
+	if info.ArtifactProvides.ArtifactName == "" {
+		add("header-info", RuleArtifactName, "The artifact_name is empty")
+	}
+
+	listed := payloadTypes(info.Payloads)
+	for i, sh := range a.HeaderTar.Headers {
+		if sh.typeInfo == nil {
+			continue
+		}
+		if !containsString(listed, sh.PayloadType()) {
+			add("headers", RulePayloadTypeListed,
+				"The payload type %q of headers/%04d is not in the header-info", sh.PayloadType(), i)
+		}
+	}
+
+	if a.requireSBOM {
+		for i, sh := range a.HeaderTar.Headers {
+			if _, _, ok := sh.metaData.SBOM(); !ok {
+				a.logger().Warnf("The payload %04d has no SBOM in its meta-data", i)
+			}
+		}
+	}
+
+	if a.ManifestAugment != nil {
+		for _, augmented := range a.ManifestAugment.augData {
+			for _, entry := range a.Manifest.Data {
+				if entry.Name == augmented.Name {
+					add("manifest-augment", RuleAugmentNoOverlap, "%s is in both the manifest, and the manifest-augment", entry.Name)
+				}
+			}
+		}
+	}
+	return violations
+}
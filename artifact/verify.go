@@ -0,0 +1,276 @@
+package artifact
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ChecksumMismatchError is returned when a file of the Artifact does not
+// match its checksum in the manifest
+type ChecksumMismatchError struct {
+	Filename string
+	Expected string
+	Actual   string
+}
+
+func (c *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("Checksum mismatch for %s: expected %s, got %s", c.Filename, c.Expected, c.Actual)
+}
+
+// verifyManifest checks the checksums of all the files listed in the manifest,
+// and the manifest-augment, against the parsed Artifact
+func (a *Artifact) verifyManifest() error {
+	if a.Manifest == nil {
+		return errors.New("The Artifact has no manifest")
+	}
+	actual, err := a.parsedChecksums()
+	if err != nil {
+		return err
+	}
+	entries := a.Manifest.Data
+	if a.ManifestAugment != nil {
+		entries = append(append([]ManifestData{}, entries...), a.ManifestAugment.augData...)
+	}
+	for _, entry := range entries {
+		sum, ok := actual[entry.Name]
+		if !ok {
+			// Neither the payloads, nor the extra files following them,
+			// are read by ParseHeader
+			if a.HeaderOnly && (strings.HasPrefix(entry.Name, "data/") || !isParsedSection(entry.Name)) {
+				continue
+			}
+			if isParsedSection(entry.Name) {
+				return &MissingSectionError{Section: entry.Name}
+			}
+			// An extra file, as it was read from the Artifact tar
+			read, ok := a.checksums[entry.Name]
+			if !ok {
+				return &MissingSectionError{Section: entry.Name}
+			}
+			sum = hex.EncodeToString(read)
+		}
+		if sum != entry.Signature {
+			return &ChecksumMismatchError{Filename: entry.Name, Expected: entry.Signature, Actual: sum}
+		}
+	}
+	return nil
+}
+
+// ErrManifestEntryMissing is returned for a file of the Artifact which is not
+// in the manifest
+var ErrManifestEntryMissing = errors.New("The file is not in the manifest")
+
+// manifestChecksum returns the checksum of name in the manifest, or in the
+// manifest-augment
+func (a *Artifact) manifestChecksum(name string) (string, bool) {
+	if a.Manifest != nil {
+		if sum, ok := a.Manifest.Lookup(name); ok {
+			return sum, true
+		}
+	}
+	return a.ManifestAugment.Lookup(name)
+}
+
+// isParsedSection reports whether the manifest entry name is one of the
+// sections kept by Parse, as opposed to an extra file
+func isParsedSection(name string) bool {
+	return strings.HasPrefix(filepath.Dir(name), "data/") || name == "version" ||
+		strings.HasPrefix(name, "header")
+}
+
+// parsedChecksums returns the checksums of the parsed sections, and the
+// payload files, by their manifest name
+func (a *Artifact) parsedChecksums() (map[string]string, error) {
+	actual := map[string]string{}
+	if a.Version != nil && a.Version.raw != nil {
+		actual["version"] = manifestEntry("version", a.Version.raw).Signature
+	}
+	if a.HeaderSigned != nil && a.HeaderSigned.data != nil {
+		actual["header-signed.tar.gz"] = manifestEntry("header-signed.tar.gz", a.HeaderSigned.data).Signature
+	}
+	if a.HeaderTar != nil && a.HeaderTar.raw != nil {
+		name := "header.tar" + a.HeaderTar.compression.Extension()
+		actual[name] = manifestEntry(name, a.HeaderTar.raw).Signature
+	}
+	if a.HeaderAugment != nil && a.HeaderAugment.raw != nil {
+		actual["header-augment.tar.gz"] = manifestEntry("header-augment.tar.gz", a.HeaderAugment.raw).Signature
+	}
+	if a.Data != nil {
+		for _, sums := range a.payloadsChecksums() {
+			if sums.err != nil {
+				return nil, sums.err
+			}
+			for name, sum := range sums.sums {
+				actual[name] = sum
+			}
+		}
+	}
+	return actual, nil
+}
+
+type payloadSums struct {
+	sums map[string]string
+	err  error
+}
+
+// payloadsChecksums returns the checksums of the files of every payload, in
+// the order of the payloads. The payloads are decompressed on
+// a.payloadWorkers goroutines, if more than one, each reading its payloads
+// from a.payloadSource.
+func (a *Artifact) payloadsChecksums() []payloadSums {
+	payloads := a.Data.payloads
+	results := make([]payloadSums, len(payloads))
+	workers := a.payloadWorkers
+	if workers > len(payloads) {
+		workers = len(payloads)
+	}
+	if workers <= 1 {
+		for i, payload := range payloads {
+			results[i].sums, results[i].err = payloadChecksums(payload)
+			if results[i].err != nil {
+				break
+			}
+		}
+		return results
+	}
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				results[i].sums, results[i].err = a.sourcePayloadChecksums(payloads[i])
+			}
+		}()
+	}
+	for i := range payloads {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+	return results
+}
+
+// sourcePayloadChecksums returns the manifest checksums of the files in the
+// payload, read from a.payloadSource, if its offset there is known, or from
+// memory otherwise
+func (a *Artifact) sourcePayloadChecksums(payload PayLoadData) (map[string]string, error) {
+	offset, ok := a.payloadOffsets[payload.Name]
+	if !ok {
+		return payloadChecksums(payload)
+	}
+	r := io.NewSectionReader(a.payloadSource, offset, int64(payload.Data.Len()))
+	return readPayloadChecksums(payload.Name, r)
+}
+
+// SelfTest checks that the Artifact read is complete, from the sections
+// recorded while parsing it. Every file in the manifest has to have been read,
+// the sections preceding the payloads have to be a part of the format, and the
+// version has to be a supported mender version. A *SelfTestError is returned
+// for missing, or unexpected, sections.
+//
+// The payloads are only checked for Artifacts parsed whole, and not for the
+// ones parsed by ParseHeader.
+func (a *Artifact) SelfTest() error {
+	if len(a.sectionNames) == 0 || a.Version == nil || a.Manifest == nil {
+		return errors.New("SelfTest: The Artifact has not been parsed")
+	}
+	if a.Version.Format != "mender" {
+		return fmt.Errorf("SelfTest: Unexpected format: %q, expected mender", a.Version.Format)
+	}
+	if a.Version.Version != FormatVersion2 && a.Version.Version != FormatVersion3 {
+		return &UnsupportedVersionError{Section: "version", Expected: "2 or 3", Actual: a.Version.Version}
+	}
+	sums, err := a.parsedChecksums()
+	if err != nil {
+		return errors.Wrap(err, "SelfTest")
+	}
+	testErr := &SelfTestError{}
+	for _, entry := range a.Manifest.Data {
+		if a.HeaderOnly && strings.HasPrefix(entry.Name, "data/") {
+			continue
+		}
+		_, parsed := sums[entry.Name]
+		if _, read := a.sectionSizes[entry.Name]; !parsed && !read {
+			testErr.Missing = append(testErr.Missing, entry.Name)
+		}
+	}
+	for _, name := range a.sectionNames {
+		if filepath.Dir(name) == "data" {
+			break
+		}
+		switch {
+		case name == "version", name == "manifest", name == "manifest.sig", name == "manifest-augment",
+			name == "header-signed.tar.gz":
+		case isHeader(name), strings.HasPrefix(name, "header-augment.tar"):
+		default:
+			testErr.Unexpected = append(testErr.Unexpected, name)
+		}
+	}
+	if len(testErr.Missing) > 0 || len(testErr.Unexpected) > 0 {
+		return testErr
+	}
+	return nil
+}
+
+// ErrAugmentConflict is returned by ValidateAugment for files listed in both
+// the manifest, and the manifest-augment
+var ErrAugmentConflict = errors.New("Files in both the manifest and the manifest-augment")
+
+// ValidateAugment checks that the manifest-augment does not make it ambiguous
+// which checksum of a file holds. No file, but header-augment.tar.gz, may be
+// listed in both the manifest, and the manifest-augment, or
+// ErrAugmentConflict is returned, listing the files. Every file only in the
+// manifest-augment has to have been read from the Artifact, or a
+// *MissingSectionError is returned.
+func (a *Artifact) ValidateAugment() error {
+	if a.Manifest == nil {
+		return errors.New("ValidateAugment: The Artifact has not been parsed")
+	}
+	if a.ManifestAugment == nil {
+		return nil
+	}
+	var conflicts, augmentOnly []string
+	for _, entry := range a.ManifestAugment.augData {
+		if _, ok := a.Manifest.Lookup(entry.Name); !ok {
+			augmentOnly = append(augmentOnly, entry.Name)
+		} else if entry.Name != "header-augment.tar.gz" {
+			conflicts = append(conflicts, entry.Name)
+		}
+	}
+	if len(conflicts) > 0 {
+		return errors.Wrapf(ErrAugmentConflict, "ValidateAugment: %s", strings.Join(conflicts, ", "))
+	}
+	sums, err := a.parsedChecksums()
+	if err != nil {
+		return errors.Wrap(err, "ValidateAugment")
+	}
+	for _, name := range augmentOnly {
+		if a.HeaderOnly && strings.HasPrefix(name, "data/") {
+			continue
+		}
+		_, parsed := sums[name]
+		if _, read := a.sectionSizes[name]; !parsed && !read {
+			return errors.Wrap(&MissingSectionError{Section: name}, "ValidateAugment")
+		}
+	}
+	return nil
+}
+
+// SectionChecksum returns the SHA256 of the section of the Artifact tar, ie,
+// header.tar.gz, as it was read by Parse. ErrUnknownSection is returned for a
+// section which has not been read.
+func (a *Artifact) SectionChecksum(section string) ([]byte, error) {
+	sum, ok := a.checksums[section]
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownSection, "SectionChecksum: %s", section)
+	}
+	return append([]byte(nil), sum...), nil
+}
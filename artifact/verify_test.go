@@ -0,0 +1,73 @@
+package artifact_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/pkg/errors"
+)
+
+const releaseNotes = "Fixes the boot loop\n"
+
+// releaseNotesArtifact returns an Artifact with the custom section
+// release-notes following the payload, listed in the manifest
+func releaseNotesArtifact(t *testing.T) []byte {
+	t.Helper()
+	buf := bytes.NewBuffer(nil)
+	err := artifact.NewArtifactBuilder().
+		WithArtifactName("release-1").
+		WithDeviceTypes("beaglebone").
+		WithPayload("rootfs-image", "rootfs.ext4", strings.NewReader("payload")).
+		WithCustomSection("release-notes", strings.NewReader(releaseNotes), true).
+		Build(buf)
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// withoutEntry returns the Artifact tar b, without the entry name
+func withoutEntry(t *testing.T, b []byte, name string) []byte {
+	t.Helper()
+	var entries []string
+	tr := tar.NewReader(bytes.NewReader(b))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name != name {
+			entries = append(entries, hdr.Name, string(content))
+		}
+	}
+	return makeTar(t, entries...)
+}
+
+func TestVerifyManifestExtraFiles(t *testing.T) {
+	b := releaseNotesArtifact(t)
+	parseInfo(t, b)
+
+	tampered := rewriteEntry(t, b, "release-notes", func([]byte) []byte {
+		return []byte("Adds a back door\n")
+	})
+	_, err := artifact.NewParser().Parse(bytes.NewReader(tampered))
+	if mismatch, ok := errors.Cause(err).(*artifact.ChecksumMismatchError); !ok || mismatch.Filename != "release-notes" {
+		t.Errorf("Parse of a changed release-notes = %v, want a ChecksumMismatchError", err)
+	}
+
+	_, err = artifact.NewParser().Parse(bytes.NewReader(withoutEntry(t, b, "release-notes")))
+	if missing, ok := errors.Cause(err).(*artifact.MissingSectionError); !ok || missing.Section != "release-notes" {
+		t.Errorf("Parse without release-notes = %v, want a MissingSectionError", err)
+	}
+}
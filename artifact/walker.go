@@ -0,0 +1,138 @@
+package artifact
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// SectionVisitor is called by Walker.Walk for the sections of an Artifact, in
+// the order they are read. Embed BaseVisitor to only implement some of the
+// methods.
+type SectionVisitor interface {
+	VisitVersion(*Version) error
+	VisitManifest(*Manifest) error
+	VisitHeader(*HeaderTar) error
+	// VisitPayload is called for every file of every payload. The reader is
+	// only valid until VisitPayload returns.
+	VisitPayload(index int, r *PayloadReader) error
+}
+
+// BaseVisitor is a SectionVisitor which does nothing
+type BaseVisitor struct{}
+
+func (BaseVisitor) VisitVersion(*Version) error            { return nil }
+func (BaseVisitor) VisitManifest(*Manifest) error          { return nil }
+func (BaseVisitor) VisitHeader(*HeaderTar) error           { return nil }
+func (BaseVisitor) VisitPayload(int, *PayloadReader) error { return nil }
+
+// ErrStopWalk can be returned by a SectionVisitor to stop the walk early,
+// without Walk returning an error, ie, once the version has been read
+var ErrStopWalk = errors.New("Stop the walk")
+
+// Walker streams an Artifact, and hands each section to a SectionVisitor, as
+// an alternative to Parse, and Next. Like for ArtifactReader.Next, the
+// payloads are not kept.
+type Walker struct {
+	opts []Option
+}
+
+// NewWalker returns a Walker which parses the Artifacts with opts, ie,
+// WithScriptDir
+func NewWalker(opts ...Option) *Walker {
+	return &Walker{opts: opts}
+}
+
+// Walk reads the Artifact from r, and calls the visitor for every section, as
+// it is read. The first error returned by the visitor stops the walk, and is
+// returned, except for ErrStopWalk. The sections are only valid during Walk,
+// as the scripts are removed once it returns. A payload file read to EOF
+// through its reader is verified against the manifest.
+func (w *Walker) Walk(r io.Reader, v SectionVisitor) error {
+	a := New(w.opts...)
+	defer a.Close()
+	err := w.walk(a, r, v)
+	if errors.Is(err, ErrStopWalk) {
+		return nil
+	}
+	return err
+}
+
+func (w *Walker) walk(a *Artifact, r io.Reader, v SectionVisitor) error {
+	tr := tar.NewReader(r)
+	var order sectionOrder
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return order.done()
+		} else if err != nil {
+			return errors.Wrap(err, "Walker")
+		}
+		if err = order.next(hdr.Name); err != nil {
+			return err
+		}
+		a.setSectionSize(hdr.Name, hdr.Size)
+		if filepath.Dir(hdr.Name) == "data" {
+			if err = w.walkPayload(a, hdr, tr, v); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = a.parseSection(hdr.Name, tr); err != nil {
+			return err
+		}
+		switch tokenType(hdr.Name) {
+		case TokenVersion:
+			err = v.VisitVersion(a.Version)
+		case TokenManifest:
+			err = v.VisitManifest(a.Manifest)
+		case TokenHeaderTar:
+			err = v.VisitHeader(a.HeaderTar)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// walkPayload visits the files of the payload, ie, data/0000.tar.gz
+func (w *Walker) walkPayload(a *Artifact, payload *tar.Header, r io.Reader, v SectionVisitor) error {
+	name := payload.Name
+	var index int
+	if _, err := fmt.Sscanf(filepath.Base(name), "%04d", &index); err != nil {
+		return errors.Wrapf(err, "Walker: Invalid payload name %s", name)
+	}
+	compression, err := compressionFromName(name)
+	if err != nil {
+		return errors.Wrap(err, "Walker")
+	}
+	zr, err := compression.newReader(r)
+	if err != nil {
+		return errors.Wrapf(err, "Walker: Failed to decompress %s", name)
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrapf(err, "Walker: Failed to read the payload %d", index)
+		}
+		expected, _ := a.manifestChecksum(fmt.Sprintf("data/%04d/%s", index, hdr.Name))
+		err = v.VisitPayload(index, &PayloadReader{
+			name:           hdr.Name,
+			index:          index,
+			size:           hdr.Size,
+			h:              NewHashingReader(tr),
+			expected:       expected,
+			compressedSize: payload.Size,
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
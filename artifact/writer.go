@@ -0,0 +1,231 @@
+package artifact
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// ArtifactWriter creates an Artifact from scratch, and writes it to w on
+// Flush. The parts are added one at a time, and the errors reported as they
+// are added, unlike for the ArtifactBuilder, which it wraps.
+type ArtifactWriter struct {
+	w       io.Writer
+	b       *ArtifactBuilder
+	flushed bool
+}
+
+// NewArtifactWriter returns a writer for a version 3 Artifact. Only the
+// WithVersion, WithGzipLevel, WithCompression and WithMetricsCollector
+// options apply.
+func NewArtifactWriter(w io.Writer, opts ...Option) *ArtifactWriter {
+	a := &Artifact{}
+	for _, opt := range opts {
+		opt(a)
+	}
+	b := NewArtifactBuilder()
+	if a.version != 0 {
+		b.WithVersion(a.version)
+	}
+	if a.HeaderTar != nil && a.HeaderTar.gzipLevel != nil {
+		b.WithGzipLevel(*a.HeaderTar.gzipLevel)
+	}
+	if a.HeaderTar != nil {
+		b.WithCompression(a.HeaderTar.compression)
+	}
+	if a.metrics != nil {
+		b.WithMetricsCollector(a.metrics)
+	}
+	return &ArtifactWriter{w: w, b: b}
+}
+
+// NewMinimal creates a version 3 Artifact named name, for the device type
+// deviceType, with the single payload read from payload, and nothing else. The
+// payload type defaults to rootfs-image, and the file is named payload in the
+// Artifact. The checksums, in the manifest, and the type-info, are computed as
+// the payload is written.
+func NewMinimal(name, deviceType, payloadType string, payload io.Reader) (*Artifact, error) {
+	if name == "" || deviceType == "" {
+		return nil, errors.New("NewMinimal: The Artifact needs a name, and a device type")
+	}
+	if payloadType == "" {
+		payloadType = "rootfs-image"
+	}
+	buf := bytes.NewBuffer(nil)
+	aw := NewArtifactWriter(buf)
+	aw.SetArtifactName(name)
+	aw.SetCompatibleDevices([]string{deviceType})
+	if err := aw.AddPayload(payloadType, "payload", payload); err != nil {
+		return nil, errors.Wrap(err, "NewMinimal")
+	}
+	if err := aw.Flush(); err != nil {
+		return nil, errors.Wrap(err, "NewMinimal")
+	}
+	a, err := NewParser().Parse(buf)
+	if err != nil {
+		return nil, errors.Wrap(err, "NewMinimal")
+	}
+	return a, nil
+}
+
+// SetVersion sets the format version of the Artifact, either 2 or 3
+func (aw *ArtifactWriter) SetVersion(v int) {
+	aw.b.WithVersion(v)
+}
+
+func (aw *ArtifactWriter) SetArtifactName(name string) {
+	aw.b.WithArtifactName(name)
+}
+
+// SetCompatibleDevices sets the device types the Artifact is compatible with
+func (aw *ArtifactWriter) SetCompatibleDevices(devs []string) {
+	aw.b.deviceTypes = append([]string(nil), devs...)
+}
+
+// ErrInvalidScriptName is returned by AddScript for a script not named after
+// one of the Mender states, ie, ArtifactInstall_Enter_01
+var ErrInvalidScriptName = errors.New("Invalid state script name")
+
+// stateScriptPattern is the stricter version of scriptNamePattern, only
+// matching the states known to the Mender client
+var stateScriptPattern = regexp.MustCompile(`^(Idle|Sync|Download|ArtifactInstall|ArtifactReboot|ArtifactCommit|ArtifactRollback|ArtifactRollbackReboot|ArtifactFailure)_(Enter|Leave|Error)(_\d{2})?$`)
+
+// stateScriptKey returns the state, transition, and priority of the script,
+// ie, ArtifactInstall_Enter_01, with the priority defaulting to 00
+func stateScriptKey(name string) string {
+	m := stateScriptPattern.FindStringSubmatch(name)
+	if m[3] == "" {
+		return name + "_00"
+	}
+	return name
+}
+
+// AddScript adds the state script name, read from r, to the header. The
+// name must follow the Mender naming, <State>_<Enter|Leave|Error>, with an
+// optional two digit priority, or ErrInvalidScriptName is returned. This is
+// also returned for a script running at the same state, transition and
+// priority as one already added. The scripts are written to the header
+// sorted by name, which is the order the client runs them in.
+func (aw *ArtifactWriter) AddScript(name string, r io.Reader) error {
+	if aw.flushed {
+		return errors.New("ArtifactWriter: AddScript: The Artifact has already been written")
+	}
+	if !stateScriptPattern.MatchString(name) {
+		return errors.Wrapf(ErrInvalidScriptName, "ArtifactWriter: AddScript: %q", name)
+	}
+	for _, script := range aw.b.scripts {
+		if stateScriptPattern.MatchString(script.name) &&
+			stateScriptKey(script.name) == stateScriptKey(name) {
+			return errors.Wrapf(ErrInvalidScriptName,
+				"ArtifactWriter: AddScript: %s runs at the same state, transition, and priority as %s", name, script.name)
+		}
+	}
+	aw.b.WithScript(name, r)
+	return nil
+}
+
+// AddPayload adds a payload of payloadType, holding the file filename read
+// from r. The payloads are numbered in the order they are added. Any payload
+// type can be used, ie, docker-compose, and its type-info set with
+// SetTypeInfo.
+func (aw *ArtifactWriter) AddPayload(payloadType string, filename string, r io.Reader) error {
+	if aw.flushed {
+		return errors.New("ArtifactWriter: AddPayload: The Artifact has already been written")
+	}
+	if payloadType == "" || filename == "" {
+		return errors.New("ArtifactWriter: AddPayload: The payload needs a type, and a filename")
+	}
+	aw.b.WithPayload(payloadType, filename, r)
+	return nil
+}
+
+// AddPayloadStreaming adds a payload like AddPayload, but without holding the
+// file in memory, for large images. expectedChecksum is the hex encoded SHA256
+// of the file, ie, as computed by the build system, and Flush fails with a
+// ChecksumMismatchError if the file does not match it. Nothing is written if
+// it does not.
+func (aw *ArtifactWriter) AddPayloadStreaming(payloadType, filename string, r io.Reader, expectedChecksum string) error {
+	if aw.flushed {
+		return errors.New("ArtifactWriter: AddPayloadStreaming: The Artifact has already been written")
+	}
+	if payloadType == "" || filename == "" {
+		return errors.New("ArtifactWriter: AddPayloadStreaming: The payload needs a type, and a filename")
+	}
+	if sum, err := hex.DecodeString(expectedChecksum); err != nil || len(sum) != sha256.Size {
+		return fmt.Errorf("ArtifactWriter: AddPayloadStreaming: Invalid checksum: %q", expectedChecksum)
+	}
+	aw.b.WithPayloadStreaming(payloadType, filename, r, expectedChecksum)
+	return nil
+}
+
+// SetMetaData sets the meta-data of the payload payloadIndex
+func (aw *ArtifactWriter) SetMetaData(payloadIndex int, data map[string]interface{}) error {
+	if payloadIndex < 0 || payloadIndex >= len(aw.b.payloads) {
+		return fmt.Errorf("ArtifactWriter: SetMetaData: No payload %d", payloadIndex)
+	}
+	aw.b.WithPayloadMetaData(payloadIndex, data)
+	return nil
+}
+
+// SetTypeInfo sets the artifact_provides, and artifact_depends, written to
+// the type-info of the payload payloadIndex, ie, for payload types other than
+// rootfs-image. The maps are written as they are.
+func (aw *ArtifactWriter) SetTypeInfo(payloadIndex int, provides, depends map[string]interface{}) error {
+	if payloadIndex < 0 || payloadIndex >= len(aw.b.payloads) {
+		return fmt.Errorf("ArtifactWriter: SetTypeInfo: No payload %d", payloadIndex)
+	}
+	aw.b.WithPayloadTypeInfo(payloadIndex, provides, depends)
+	return errors.Wrap(aw.b.err, "ArtifactWriter: SetTypeInfo")
+}
+
+// Sign signs the manifest with privKey, an RSA, or ECDSA, private key, or a
+// key in an HSM, and writes the signature as manifest.sig on Flush
+func (aw *ArtifactWriter) Sign(privKey Signer) error {
+	if aw.flushed {
+		return errors.New("ArtifactWriter: Sign: The Artifact has already been written")
+	}
+	if privKey == nil {
+		return errors.New("ArtifactWriter: Sign: No key")
+	}
+	aw.b.WithSigner(privKey)
+	return nil
+}
+
+// Flush writes the Artifact. It can only be written once.
+func (aw *ArtifactWriter) Flush() error {
+	if aw.flushed {
+		return errors.New("ArtifactWriter: Flush: The Artifact has already been written")
+	}
+	aw.flushed = true
+	return aw.b.Build(aw.w)
+}
+
+// SigningWriter is an ArtifactWriter which signs the manifest as it writes
+// it. The manifest is hashed while it is streamed to the Artifact, and the
+// signature written as manifest.sig right after it.
+type SigningWriter struct {
+	*ArtifactWriter
+}
+
+// NewSigningWriter returns a writer for a version 3 Artifact, signed with
+// signer, an RSA, or ECDSA, private key, or a key in an HSM, on Flush
+func NewSigningWriter(w io.Writer, signer Signer) (*SigningWriter, error) {
+	if signer == nil {
+		return nil, errors.New("NewSigningWriter: No key")
+	}
+	switch signer.Public().(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+	default:
+		return nil, fmt.Errorf("NewSigningWriter: Unsupported key type %T", signer.Public())
+	}
+	aw := NewArtifactWriter(w)
+	aw.b.WithSigner(signer)
+	return &SigningWriter{ArtifactWriter: aw}, nil
+}
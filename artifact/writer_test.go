@@ -0,0 +1,141 @@
+package artifact_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+	"github.com/pkg/errors"
+)
+
+// entryNames returns the names of the entries of the Artifact tar b
+func entryNames(t *testing.T, b []byte) []string {
+	t.Helper()
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(b))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+}
+
+// MakeArtifact signs with ArtifactWriter.Sign
+func TestArtifactWriterSign(t *testing.T) {
+	for name, key := range testKeys(t) {
+		b := testutil.MakeArtifact(t, testutil.ArtifactOptions{Signed: true, Key: key})
+		want := []string{"version", "manifest", "manifest.sig", "header.tar.gz", "data/0000.tar.gz"}
+		if names := entryNames(t, b); !reflect.DeepEqual(names, want) {
+			t.Errorf("%s: The entries are %v, want %v", name, names, want)
+		}
+		a := parse(t, b)
+		if err := a.ManifestSig.Verify(key.Public()); err != nil {
+			t.Errorf("%s: Verify: %v", name, err)
+		}
+		a.Close()
+	}
+}
+
+func TestSigningWriter(t *testing.T) {
+	for name, key := range testKeys(t) {
+		buf := bytes.NewBuffer(nil)
+		sw, err := artifact.NewSigningWriter(buf, key)
+		if err != nil {
+			t.Fatalf("%s: NewSigningWriter: %v", name, err)
+		}
+		sw.SetArtifactName("release-1")
+		sw.SetCompatibleDevices([]string{"beaglebone"})
+		if err = sw.AddPayload("rootfs-image", "rootfs.ext4", strings.NewReader("payload")); err != nil {
+			t.Fatalf("%s: AddPayload: %v", name, err)
+		}
+		if err = sw.Flush(); err != nil {
+			t.Fatalf("%s: Flush: %v", name, err)
+		}
+
+		a := parse(t, buf.Bytes())
+		if err = a.ManifestSig.Verify(key.Public()); err != nil {
+			t.Errorf("%s: Verify: %v", name, err)
+		}
+		a.Close()
+	}
+	if _, err := artifact.NewSigningWriter(bytes.NewBuffer(nil), nil); err == nil {
+		t.Error("NewSigningWriter without a key succeeded")
+	}
+}
+
+func TestNewMinimal(t *testing.T) {
+	a, err := artifact.NewMinimal("release-1", "beaglebone", "", strings.NewReader("rootfs"))
+	if err != nil {
+		t.Fatalf("NewMinimal: %v", err)
+	}
+	b := serialize(t, a)
+	a.Close()
+
+	p := artifact.NewParser()
+	parsed, err := p.Parse(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	defer parsed.Close()
+	info := parsed.Info()
+	if info.Name != "release-1" || len(info.CompatibleDevices) != 1 || info.CompatibleDevices[0] != "beaglebone" {
+		t.Errorf("Parsed %s, for %v, want release-1, for beaglebone", info.Name, info.CompatibleDevices)
+	}
+	if !reflect.DeepEqual(info.PayloadTypes, []string{"rootfs-image"}) || len(info.Scripts) != 0 {
+		t.Errorf("Parsed the payload types %v, and the scripts %v", info.PayloadTypes, info.Scripts)
+	}
+	r, err := p.Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if content, err := ioutil.ReadAll(r); err != nil || string(content) != "rootfs" {
+		t.Errorf("Read %q, %v from the payload, want rootfs", content, err)
+	}
+
+	if _, err = artifact.NewMinimal("", "beaglebone", "", strings.NewReader("rootfs")); err == nil {
+		t.Error("NewMinimal created an Artifact without a name")
+	}
+}
+
+func TestAddScript(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	aw := artifact.NewArtifactWriter(buf)
+	aw.SetArtifactName("release-1")
+	aw.SetCompatibleDevices([]string{"beaglebone"})
+	for _, name := range []string{"ArtifactInstall_Leave_01", "ArtifactInstall_Enter_00"} {
+		if err := aw.AddScript(name, strings.NewReader("#!/bin/sh\n")); err != nil {
+			t.Errorf("AddScript(%s): %v", name, err)
+		}
+	}
+	invalid := map[string]string{
+		"ArtifactInstall_Start":    "an invalid name",
+		"ArtifactInstall_Enter_00": "the same state and transition as another script",
+	}
+	for name, reason := range invalid {
+		if err := aw.AddScript(name, strings.NewReader("#!/bin/sh\n")); errors.Cause(err) != artifact.ErrInvalidScriptName {
+			t.Errorf("AddScript(%s), of %s, returned %v, want ErrInvalidScriptName", name, reason, err)
+		}
+	}
+	if err := aw.AddPayload("rootfs-image", "rootfs.ext4", strings.NewReader("rootfs")); err != nil {
+		t.Fatalf("AddPayload: %v", err)
+	}
+	if err := aw.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	// The scripts are written in the order they run in
+	want := []string{"ArtifactInstall_Enter_00", "ArtifactInstall_Leave_01"}
+	if scripts := parseInfo(t, buf.Bytes()).Scripts; !reflect.DeepEqual(scripts, want) {
+		t.Errorf("The header holds the scripts %v, want %v", scripts, want)
+	}
+}
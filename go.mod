@@ -3,6 +3,10 @@ module github.com/olepor/mender-artifact-refac
 go 1.13
 
 require (
-	github.com/pkg/errors v0.8.1
+	github.com/dsnet/compress v0.0.1
+	github.com/klauspost/compress v1.11.13
+	github.com/miekg/pkcs11 v1.1.1
+	github.com/pkg/errors v0.9.1
 	github.com/sirupsen/logrus v1.4.2
+	github.com/ulikunitz/xz v0.5.10
 )
@@ -0,0 +1,102 @@
+// Package testutil creates mender Artifacts in memory, for tests which would
+// otherwise need Artifact files checked in.
+package testutil
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+)
+
+// ArtifactOptions describes the Artifact created by MakeArtifact. Unset
+// fields get a default value.
+type ArtifactOptions struct {
+	// Version is the format version, 2 or 3. The default is 3.
+	Version int
+	// ArtifactName defaults to test-artifact
+	ArtifactName string
+	// DeviceType defaults to test-device
+	DeviceType string
+	// Scripts are the state scripts, by name, ie, ArtifactInstall_Enter_00
+	Scripts map[string]string
+	// PayloadContent is the file of the single rootfs-image payload. The
+	// default is the string payload.
+	PayloadContent []byte
+	// Payloads, if set, are the files of the rootfs-image payloads, one per
+	// payload, in place of PayloadContent
+	Payloads []Payload
+	// Signed signs the manifest, with Key, or a new ECDSA P-256 key if
+	// Key is nil
+	Signed bool
+	Key    crypto.Signer
+}
+
+// Payload is a rootfs-image payload holding the single file Filename
+type Payload struct {
+	Filename string
+	Content  []byte
+}
+
+// MakeArtifact returns a valid Artifact, as described by opts, and fails the
+// test if it can not be created
+func MakeArtifact(t testing.TB, opts ArtifactOptions) []byte {
+	t.Helper()
+	if opts.Version == 0 {
+		opts.Version = 3
+	}
+	if opts.ArtifactName == "" {
+		opts.ArtifactName = "test-artifact"
+	}
+	if opts.DeviceType == "" {
+		opts.DeviceType = "test-device"
+	}
+	if opts.PayloadContent == nil {
+		opts.PayloadContent = []byte("payload")
+	}
+	if opts.Payloads == nil {
+		opts.Payloads = []Payload{{Filename: "rootfs.ext4", Content: opts.PayloadContent}}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	aw := artifact.NewArtifactWriter(buf, artifact.WithVersion(opts.Version))
+	aw.SetArtifactName(opts.ArtifactName)
+	aw.SetCompatibleDevices([]string{opts.DeviceType})
+	names := make([]string, 0, len(opts.Scripts))
+	for name := range opts.Scripts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := aw.AddScript(name, strings.NewReader(opts.Scripts[name])); err != nil {
+			t.Fatalf("MakeArtifact: %v", err)
+		}
+	}
+	for _, payload := range opts.Payloads {
+		if err := aw.AddPayload("rootfs-image", payload.Filename, bytes.NewReader(payload.Content)); err != nil {
+			t.Fatalf("MakeArtifact: %v", err)
+		}
+	}
+	if opts.Signed {
+		key := opts.Key
+		if key == nil {
+			var err error
+			if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+				t.Fatalf("MakeArtifact: Failed to generate a key: %v", err)
+			}
+		}
+		if err := aw.Sign(key); err != nil {
+			t.Fatalf("MakeArtifact: %v", err)
+		}
+	}
+	if err := aw.Flush(); err != nil {
+		t.Fatalf("MakeArtifact: %v", err)
+	}
+	return buf.Bytes()
+}
@@ -1,33 +1,148 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/pkg/errors"
 )
 
+const usage = `Usage: %s <command> [flags] <artifact>
+
+Commands:
+  parse    parse the Artifact, and report any error
+  inspect  print the metadata of the Artifact
+  verify   verify the signature of the Artifact
+`
+
 func main() {
-	if len(os.Args) != 2 {
-		fmt.Println("Need a mender-artifact")
-		return
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs the command in args, and returns the exit code
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintf(stderr, usage, os.Args[0])
+		return 1
+	}
+	var err error
+	switch args[0] {
+	case "parse":
+		err = parseCmd(args[1:], stdout, stderr)
+	case "inspect":
+		err = inspectCmd(args[1:], stdout, stderr)
+	case "verify":
+		err = verifyCmd(args[1:], stdout, stderr)
+	case "-h", "-help", "--help", "help":
+		fmt.Fprintf(stdout, usage, os.Args[0])
+		return 0
+	default:
+		// A single Artifact, as before the commands were added
+		err = parseCmd(args, stdout, stderr)
+	}
+	if err == flag.ErrHelp {
+		return 0
+	} else if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// artifactArg parses the flags in args, and returns the Artifact file which
+// has to follow them
+func artifactArg(fs *flag.FlagSet, args []string) (string, error) {
+	if err := fs.Parse(args); err != nil {
+		return "", err
 	}
-	f, err := os.Open(os.Args[1])
+	if fs.NArg() != 1 {
+		return "", fmt.Errorf("%s: Need a mender-artifact", fs.Name())
+	}
+	return fs.Arg(0), nil
+}
+
+// readArtifact parses the whole Artifact in the file path
+func readArtifact(path string) (*artifact.ArtifactReader, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		fmt.Println("Failed to open the mender-artifact file")
-		return
+		return nil, errors.Wrap(err, "Failed to open the mender-artifact file")
 	}
-	ar := artifact.New()
-	err = ar.Parse(f)
+	defer f.Close()
+	ar := artifact.NewArtifactReader(f)
+	if err = ar.Parse(); err != nil {
+		ar.Close()
+		return nil, errors.Wrap(err, "Failed to parse the artifact")
+	}
+	return ar, nil
+}
+
+func parseCmd(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("parse", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	path, err := artifactArg(fs, args)
+	if err != nil {
+		return err
+	}
+	ar, err := readArtifact(path)
 	if err != nil {
-		fmt.Println("Failed to parse the artifact")
-		fmt.Println(err)
-		return
+		return err
+	}
+	return ar.Close()
+}
+
+func inspectCmd(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	asJSON := fs.Bool("json", false, "print the metadata as JSON")
+	path, err := artifactArg(fs, args)
+	if err != nil {
+		return err
+	}
+	ar, err := readArtifact(path)
+	if err != nil {
+		return err
+	}
+	defer ar.Close()
+	info := ar.Artifact.Info()
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(info)
+	}
+	_, err = fmt.Fprint(stdout, info)
+	return err
+}
+
+func verifyCmd(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	keyPath := fs.String("key", "", "the PEM encoded public key to verify the signature with")
+	path, err := artifactArg(fs, args)
+	if err != nil {
+		return err
+	}
+	if *keyPath == "" {
+		return errors.New("verify: Need a public key, given with -key")
+	}
+	key, err := artifact.LoadPublicKey(*keyPath)
+	if err != nil {
+		return errors.Wrap(err, "verify")
+	}
+	ar, err := readArtifact(path)
+	if err != nil {
+		return err
+	}
+	defer ar.Close()
+	if ar.Artifact.ManifestSig == nil {
+		return errors.New("verify: The Artifact is not signed")
+	}
+	if err = ar.Artifact.ManifestSig.Verify(key); err != nil {
+		return errors.Wrap(err, "verify")
 	}
-	// _, err = ar.Next()
-	// if err != nil {
-	// 	fmt.Println("Failed to get the payload")
-	// 	os.Exit(1)
-	// }
-	// io.Copy(os.Stdout, r)
+	_, err = fmt.Fprintln(stdout, "Signature verified")
+	return err
 }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+)
+
+// artifactFile writes an Artifact to a temporary file, which the caller has
+// to remove
+func artifactFile(t *testing.T) string {
+	t.Helper()
+	f, err := ioutil.TempFile("", "inspect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b := testutil.MakeArtifact(t, testutil.ArtifactOptions{ArtifactName: "release-1", DeviceType: "beaglebone"})
+	if _, err = f.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestInspect(t *testing.T) {
+	path := artifactFile(t)
+	defer os.Remove(path)
+
+	stdout, stderr := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+	if code := run([]string{"inspect", path}, stdout, stderr); code != 0 {
+		t.Fatalf("inspect exited with %d: %s", code, stderr)
+	}
+	for _, want := range []string{"Name: release-1", "Compatible devices: beaglebone", "Payload types: rootfs-image"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("inspect printed %q, without %q", stdout, want)
+		}
+	}
+
+	stdout.Reset()
+	if code := run([]string{"inspect", "-json", path}, stdout, stderr); code != 0 {
+		t.Fatalf("inspect -json exited with %d: %s", code, stderr)
+	}
+	var info artifact.ArtifactInfo
+	if err := json.Unmarshal(stdout.Bytes(), &info); err != nil {
+		t.Fatalf("inspect -json printed %q: %v", stdout, err)
+	}
+	if info.Name != "release-1" || !reflect.DeepEqual(info.CompatibleDevices, []string{"beaglebone"}) {
+		t.Errorf("inspect -json printed %s, for %v", info.Name, info.CompatibleDevices)
+	}
+}
+
+func TestInspectErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{"inspect"},
+		{"inspect", "/nonexistent.mender"},
+		{"inspect", "-yaml", "/nonexistent.mender"},
+	} {
+		stdout, stderr := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+		if code := run(args, stdout, stderr); code != 1 || stderr.Len() == 0 || stdout.Len() != 0 {
+			t.Errorf("%v exited with %d, printing %q, and %q to stderr", args, code, stdout, stderr)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+//go:build cgo
+// +build cgo
+
+// Package pkcs11 signs Artifacts with keys kept in an HSM, or any other
+// PKCS #11 token, ie, SoftHSM.
+package pkcs11
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/asn1"
+	"io"
+	"math/big"
+	"sync"
+
+	p11 "github.com/miekg/pkcs11"
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/pkg/errors"
+)
+
+// Signer is an artifact.Signer signing with a private key in a PKCS #11
+// token. The key never leaves the token. RSA keys sign with CKM_RSA_PKCS,
+// and EC keys with CKM_ECDSA.
+type Signer struct {
+	ctx     *p11.Ctx
+	session p11.SessionHandle
+	key     p11.ObjectHandle
+	public  crypto.PublicKey
+
+	mu sync.Mutex // A session can only run one operation at a time
+}
+
+var _ artifact.Signer = (*Signer)(nil)
+
+// NewPKCS11Signer loads the PKCS #11 module libraryPath, ie,
+// /usr/lib/softhsm/libsofthsm2.so, and returns a Signer for the private key
+// labelled keyLabel, in the first token which it can log in to with pin,
+// and which holds the key. The public key has to be in the token as well,
+// under the same label. The Signer has to be closed when done.
+func NewPKCS11Signer(libraryPath, pin, keyLabel string) (*Signer, error) {
+	ctx := p11.New(libraryPath)
+	if ctx == nil {
+		return nil, errors.Errorf("NewPKCS11Signer: Failed to load the module %s", libraryPath)
+	}
+	if err := ctx.Initialize(); err != nil {
+		ctx.Destroy()
+		return nil, errors.Wrap(err, "NewPKCS11Signer: Failed to initialize the module")
+	}
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		ctx.Finalize()
+		ctx.Destroy()
+		return nil, errors.Wrap(err, "NewPKCS11Signer: Failed to list the slots")
+	}
+	err = errors.New("No token present")
+	for _, slot := range slots {
+		var s *Signer
+		if s, err = openKey(ctx, slot, pin, keyLabel); err == nil {
+			return s, nil
+		}
+	}
+	ctx.Finalize()
+	ctx.Destroy()
+	return nil, errors.Wrapf(err, "NewPKCS11Signer: No token holds the key %q", keyLabel)
+}
+
+// openKey logs in to the token in slot, and looks up the key label in it
+func openKey(ctx *p11.Ctx, slot uint, pin, label string) (*Signer, error) {
+	session, err := ctx.OpenSession(slot, p11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.Login(session, p11.CKU_USER, pin)
+	if err != nil && err != p11.Error(p11.CKR_USER_ALREADY_LOGGED_IN) {
+		ctx.CloseSession(session)
+		return nil, err
+	}
+	s := &Signer{ctx: ctx, session: session}
+	if s.key, err = s.findObject(p11.CKO_PRIVATE_KEY, label); err == nil {
+		s.public, err = s.publicKey(label)
+	}
+	if err != nil {
+		ctx.Logout(session)
+		ctx.CloseSession(session)
+		return nil, err
+	}
+	return s, nil
+}
+
+// findObject returns the object of class labelled label
+func (s *Signer) findObject(class uint, label string) (p11.ObjectHandle, error) {
+	template := []*p11.Attribute{
+		p11.NewAttribute(p11.CKA_CLASS, class),
+		p11.NewAttribute(p11.CKA_LABEL, label),
+	}
+	if err := s.ctx.FindObjectsInit(s.session, template); err != nil {
+		return 0, err
+	}
+	objects, _, err := s.ctx.FindObjects(s.session, 1)
+	s.ctx.FindObjectsFinal(s.session)
+	if err != nil {
+		return 0, err
+	}
+	if len(objects) == 0 {
+		return 0, errors.Errorf("No object %q", label)
+	}
+	return objects[0], nil
+}
+
+// publicKey reads the public key labelled label from the token
+func (s *Signer) publicKey(label string) (crypto.PublicKey, error) {
+	pub, err := s.findObject(p11.CKO_PUBLIC_KEY, label)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := s.ctx.GetAttributeValue(s.session, pub, []*p11.Attribute{
+		p11.NewAttribute(p11.CKA_KEY_TYPE, nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+	// CK_ULONGs are in the byte order of the host, like NewAttribute encodes
+	// them
+	keyType := attrs[0].Value
+	switch {
+	case bytes.Equal(keyType, p11.NewAttribute(p11.CKA_KEY_TYPE, p11.CKK_RSA).Value):
+		attrs, err = s.ctx.GetAttributeValue(s.session, pub, []*p11.Attribute{
+			p11.NewAttribute(p11.CKA_MODULUS, nil),
+			p11.NewAttribute(p11.CKA_PUBLIC_EXPONENT, nil),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(attrs[0].Value),
+			E: int(new(big.Int).SetBytes(attrs[1].Value).Int64()),
+		}, nil
+	case bytes.Equal(keyType, p11.NewAttribute(p11.CKA_KEY_TYPE, p11.CKK_EC).Value):
+		attrs, err = s.ctx.GetAttributeValue(s.session, pub, []*p11.Attribute{
+			p11.NewAttribute(p11.CKA_EC_PARAMS, nil),
+			p11.NewAttribute(p11.CKA_EC_POINT, nil),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return ecPublicKey(attrs[0].Value, attrs[1].Value)
+	}
+	return nil, errors.Errorf("Unsupported key type of %q", label)
+}
+
+var curves = []struct {
+	oid   asn1.ObjectIdentifier
+	curve elliptic.Curve
+}{
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}, elliptic.P256()},
+	{asn1.ObjectIdentifier{1, 3, 132, 0, 34}, elliptic.P384()},
+	{asn1.ObjectIdentifier{1, 3, 132, 0, 35}, elliptic.P521()},
+}
+
+// ecPublicKey decodes CKA_EC_PARAMS, the OID of a named curve, and
+// CKA_EC_POINT, the DER encoded uncompressed point
+func ecPublicKey(params, point []byte) (*ecdsa.PublicKey, error) {
+	var oid asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(params, &oid); err != nil {
+		return nil, errors.Wrap(err, "Invalid EC parameters")
+	}
+	var curve elliptic.Curve
+	for _, c := range curves {
+		if c.oid.Equal(oid) {
+			curve = c.curve
+		}
+	}
+	if curve == nil {
+		return nil, errors.Errorf("Unsupported curve %s", oid)
+	}
+	// Some tokens leave out the DER encoding of the point
+	var raw []byte
+	if rest, err := asn1.Unmarshal(point, &raw); err != nil || len(rest) > 0 {
+		raw = point
+	}
+	x, y := elliptic.Unmarshal(curve, raw)
+	if x == nil {
+		return nil, errors.New("Invalid EC point")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// Public returns the public key of the signing key
+func (s *Signer) Public() crypto.PublicKey {
+	return s.public
+}
+
+// The DER encoded DigestInfo prefixes of the hashes, for CKM_RSA_PKCS,
+// which signs the DigestInfo as is
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// Sign signs digest in the token. RSA signatures are PKCS #1 v1.5, and
+// ECDSA signatures ASN.1 encoded, like those of crypto/rsa, and
+// crypto/ecdsa. rand is not used, the token has its own source.
+func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var mechanism uint
+	data := digest
+	switch s.public.(type) {
+	case *rsa.PublicKey:
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			return nil, errors.New("PKCS11Signer: Sign: RSA PSS is not supported")
+		}
+		prefix, ok := digestInfoPrefixes[opts.HashFunc()]
+		if !ok {
+			return nil, errors.Errorf("PKCS11Signer: Sign: Unsupported hash %v", opts.HashFunc())
+		}
+		mechanism, data = p11.CKM_RSA_PKCS, append(append([]byte{}, prefix...), digest...)
+	case *ecdsa.PublicKey:
+		mechanism = p11.CKM_ECDSA
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.ctx.SignInit(s.session, []*p11.Mechanism{p11.NewMechanism(mechanism, nil)}, s.key)
+	if err != nil {
+		return nil, errors.Wrap(err, "PKCS11Signer: Sign")
+	}
+	sig, err := s.ctx.Sign(s.session, data)
+	if err != nil {
+		return nil, errors.Wrap(err, "PKCS11Signer: Sign")
+	}
+	if mechanism == p11.CKM_ECDSA {
+		// The token returns r || s
+		half := len(sig) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{
+			new(big.Int).SetBytes(sig[:half]),
+			new(big.Int).SetBytes(sig[half:]),
+		})
+	}
+	return sig, nil
+}
+
+// Close logs out of the token, and unloads the module
+func (s *Signer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ctx.Logout(s.session)
+	err := s.ctx.CloseSession(s.session)
+	s.ctx.Finalize()
+	s.ctx.Destroy()
+	return errors.Wrap(err, "PKCS11Signer: Close")
+}
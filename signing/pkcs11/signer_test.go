@@ -0,0 +1,69 @@
+//go:build cgo
+// +build cgo
+
+package pkcs11
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"os"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+	"github.com/pkg/errors"
+)
+
+// softHSMSigner returns a Signer for the key in the SoftHSM2 token given by
+// the environment, and skips the test if there is none:
+//
+//	SOFTHSM2_MODULE     the module, ie, /usr/lib/softhsm/libsofthsm2.so
+//	SOFTHSM2_PIN        the user PIN of the token
+//	SOFTHSM2_KEY_LABEL  the label of the private, and public, key
+//
+// A token holding an EC key is set up with, ie,
+//
+//	softhsm2-util --init-token --free --label artifact --pin 1234 --so-pin 4321
+//	pkcs11-tool --module $SOFTHSM2_MODULE --login --pin 1234 \
+//		--keypairgen --key-type EC:prime256v1 --label signing-key
+func softHSMSigner(t *testing.T) *Signer {
+	t.Helper()
+	module, pin, label := os.Getenv("SOFTHSM2_MODULE"), os.Getenv("SOFTHSM2_PIN"), os.Getenv("SOFTHSM2_KEY_LABEL")
+	if module == "" || pin == "" || label == "" {
+		t.Skip("SOFTHSM2_MODULE, SOFTHSM2_PIN and SOFTHSM2_KEY_LABEL are not set")
+	}
+	s, err := NewPKCS11Signer(module, pin, label)
+	if err != nil {
+		t.Fatalf("NewPKCS11Signer: %v", err)
+	}
+	return s
+}
+
+func TestPKCS11Signer(t *testing.T) {
+	s := softHSMSigner(t)
+	defer s.Close()
+	b := testutil.MakeArtifact(t, testutil.ArtifactOptions{Signed: true, Key: s})
+
+	a, err := artifact.NewParser().Parse(bytes.NewReader(b), artifact.WithVerification(s.Public()))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	a.Close()
+
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = artifact.NewParser().Parse(bytes.NewReader(b), artifact.WithVerification(other.Public()))
+	if errors.Cause(err) != artifact.ErrSignatureInvalid {
+		t.Errorf("Parse with another key returned %v, want ErrSignatureInvalid", err)
+	}
+}
+
+func TestNewPKCS11SignerInvalidModule(t *testing.T) {
+	if _, err := NewPKCS11Signer("/nonexistent/libsofthsm2.so", "1234", "signing-key"); err == nil {
+		t.Error("NewPKCS11Signer loaded a nonexistent module")
+	}
+}
@@ -0,0 +1,135 @@
+// Package store holds Artifacts for the ArtifactHandler, and other server
+// tooling.
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/pkg/errors"
+)
+
+const fileExtension = ".mender"
+
+// FileStore is an artifact.ArtifactStore keeping every Artifact in its own
+// file, <root>/<artifact name>.mender
+type FileStore struct {
+	root string
+}
+
+var _ artifact.ArtifactStore = (*FileStore)(nil)
+
+// NewFileStore returns a store of the Artifacts in the directory root,
+// which is created if need be
+func NewFileStore(root string) (*FileStore, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, errors.Wrap(err, "NewFileStore")
+	}
+	return &FileStore{root: root}, nil
+}
+
+// path returns the file of the Artifact name, which can not point outside
+// of the root
+func (s *FileStore) path(name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", errors.Errorf("Invalid Artifact name: %q", name)
+	}
+	return filepath.Join(s.root, name+fileExtension), nil
+}
+
+// Get parses the Artifact name, or returns artifact.ErrArtifactNotFound. The
+// caller has to close the Artifact, to remove its temporary scripts.
+func (s *FileStore) Get(name string) (*artifact.Artifact, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return nil, errors.Wrapf(artifact.ErrArtifactNotFound, "FileStore: Get: %v", err)
+	}
+	return s.parse(path)
+}
+
+func (s *FileStore) parse(path string) (*artifact.Artifact, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, errors.Wrapf(artifact.ErrArtifactNotFound, "FileStore: %s", filepath.Base(path))
+	} else if err != nil {
+		return nil, errors.Wrap(err, "FileStore")
+	}
+	defer f.Close()
+	a, err := artifact.NewParser().Parse(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "FileStore: Failed to parse %s", filepath.Base(path))
+	}
+	return a, nil
+}
+
+// List parses all the Artifacts in the store, in the order of their names.
+// The caller has to close every one of them, like those of Get.
+func (s *FileStore) List() ([]*artifact.Artifact, error) {
+	paths, err := filepath.Glob(filepath.Join(s.root, "*"+fileExtension))
+	if err != nil {
+		return nil, errors.Wrap(err, "FileStore: List")
+	}
+	sort.Strings(paths)
+	artifacts := make([]*artifact.Artifact, 0, len(paths))
+	for _, path := range paths {
+		a, err := s.parse(path)
+		if err != nil {
+			for _, a := range artifacts {
+				a.Close()
+			}
+			return nil, errors.Wrap(err, "List")
+		}
+		artifacts = append(artifacts, a)
+	}
+	return artifacts, nil
+}
+
+// Save writes the Artifact to the store, by its name, replacing any
+// Artifact of the same name. The Artifact is written to a temporary file
+// first, so that it is either replaced as a whole, or not at all.
+func (s *FileStore) Save(a *artifact.Artifact) error {
+	path, err := s.path(a.Info().Name)
+	if err != nil {
+		return errors.Wrap(err, "FileStore: Save")
+	}
+	f, err := ioutil.TempFile(s.root, ".tmp-"+filepath.Base(path)+"-")
+	if err != nil {
+		return errors.Wrap(err, "FileStore: Save")
+	}
+	err = func() error {
+		if _, err := a.WriteTo(f); err != nil {
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			return err
+		}
+		return f.Close()
+	}()
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return errors.Wrapf(err, "FileStore: Save: Failed to write %s", filepath.Base(path))
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return errors.Wrap(err, "FileStore: Save")
+	}
+	return nil
+}
+
+// Delete removes the Artifact name from the store, or returns
+// artifact.ErrArtifactNotFound
+func (s *FileStore) Delete(name string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return errors.Wrapf(artifact.ErrArtifactNotFound, "FileStore: Delete: %v", err)
+	}
+	if err = os.Remove(path); os.IsNotExist(err) {
+		return errors.Wrapf(artifact.ErrArtifactNotFound, "FileStore: Delete: %s", name)
+	}
+	return errors.Wrap(err, "FileStore: Delete")
+}
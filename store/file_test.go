@@ -0,0 +1,78 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/olepor/mender-artifact-refac/artifact"
+	"github.com/olepor/mender-artifact-refac/internal/testutil"
+	"github.com/pkg/errors"
+)
+
+func TestFileStore(t *testing.T) {
+	root, err := ioutil.TempDir("", "store-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	s, err := NewFileStore(root)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+
+	var names []string
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("release-%d", i)
+		names = append(names, name)
+		b := testutil.MakeArtifact(t, testutil.ArtifactOptions{
+			ArtifactName: name,
+			Scripts:      map[string]string{"ArtifactInstall_Enter_00": "#!/bin/sh\n"},
+		})
+		a, err := artifact.NewParser().Parse(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		err = s.Save(a)
+		a.Close()
+		if err != nil {
+			t.Fatalf("Save(%s): %v", name, err)
+		}
+	}
+
+	artifacts, err := s.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(artifacts) != len(names) {
+		t.Errorf("List returned %d Artifacts, want %d", len(artifacts), len(names))
+	}
+	for i, a := range artifacts {
+		if i < len(names) && a.Info().Name != names[i] {
+			t.Errorf("List()[%d] = %s, want %s", i, a.Info().Name, names[i])
+		}
+		a.Close()
+	}
+
+	for _, name := range names {
+		a, err := s.Get(name)
+		if err != nil {
+			t.Fatalf("Get(%s): %v", name, err)
+		}
+		if a.Info().Name != name {
+			t.Errorf("Get(%s) returned %s", name, a.Info().Name)
+		}
+		a.Close()
+		if err = s.Delete(name); err != nil {
+			t.Errorf("Delete(%s): %v", name, err)
+		}
+		if _, err = s.Get(name); errors.Cause(err) != artifact.ErrArtifactNotFound {
+			t.Errorf("Get(%s) after Delete = %v, want ErrArtifactNotFound", name, err)
+		}
+	}
+	if artifacts, err = s.List(); err != nil || len(artifacts) != 0 {
+		t.Errorf("List() after Delete = %d Artifacts, %v, want none", len(artifacts), err)
+	}
+}